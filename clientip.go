@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// clientIP resolves the address of the client behind trusted proxies. The
+// peer address is used unless it is a trusted proxy; then the Forwarded
+// header, or else X-Forwarded-For, is read from the right, skipping trusted
+// proxies, as only the entries appended by trusted proxies can be relied on.
+// If every entry is trusted the leftmost one is the client.
+func clientIP(r *http.Request, trusted []*net.IPNet) net.IP {
+	peer := parseHostIP(r.RemoteAddr)
+	if peer == nil || len(trusted) == 0 || !ipAllowed(trusted, peer) {
+		return peer
+	}
+	hops := forwardedFor(r.Header)
+	if hops == nil {
+		hops = xForwardedFor(r.Header)
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseHostIP(hops[i])
+		if ip == nil {
+			// An obfuscated or unknown hop: the proxy that added it is the
+			// last address known.
+			break
+		}
+		client = ip
+		if !ipAllowed(trusted, ip) {
+			break
+		}
+	}
+	return client
+}
+
+// forwardedFor returns the for= parameters of the RFC 7239 Forwarded
+// headers, or nil if there are none.
+func forwardedFor(header http.Header) []string {
+	var hops []string
+	for _, value := range header.Values("Forwarded") {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					hops = append(hops, strings.Trim(v, `"`))
+				}
+			}
+		}
+	}
+	return hops
+}
+
+func xForwardedFor(header http.Header) []string {
+	var hops []string
+	for _, value := range header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// parseHostIP parses an address with or without a port, such as
+// "192.0.2.1", "192.0.2.1:443", "2001:db8::1" or "[2001:db8::1]:443".
+func parseHostIP(addr string) net.IP {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return net.ParseIP(strings.Trim(addr, "[]"))
+}
+
+// requireClientIP rejects clients outside the route's allowed networks and,
+// on Fastly routes with FASTLY_IP_RANGES_FILE, outside Fastly's ranges.
+func requireClientIP(w http.ResponseWriter, r *http.Request, cfg config) bool {
+	if len(cfg.allowedCIDRs) == 0 && len(cfg.fastlyRanges) == 0 {
+		return true
+	}
+	ip := parseHostIP(r.RemoteAddr)
+	if ip == nil || !ipAllowed(cfg.allowedCIDRs, ip) || !ipAllowed(cfg.fastlyRanges, ip) {
+		w.WriteHeader(http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIPResolvesTrustedProxies(t *testing.T) {
+	var trusted []*net.IPNet
+	for _, cidr := range []string{"10.0.0.0/8", "2001:db8:ffff::/48"} {
+		network, err := parseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trusted = append(trusted, network)
+	}
+
+	for name, tc := range map[string]struct {
+		remote string
+		header http.Header
+		want   string
+	}{
+		"untrusted peer": {"198.51.100.7:5100", http.Header{"X-Forwarded-For": {"203.0.113.9"}}, "198.51.100.7"},
+		"xff":            {"10.0.0.2:5100", http.Header{"X-Forwarded-For": {"203.0.113.9, 10.1.2.3"}}, "203.0.113.9"},
+		"spoofed xff":    {"10.0.0.2:5100", http.Header{"X-Forwarded-For": {"1.2.3.4, 203.0.113.9", "10.1.2.3"}}, "203.0.113.9"},
+		"all trusted":    {"10.0.0.2:5100", http.Header{"X-Forwarded-For": {"10.9.9.9, 10.1.2.3"}}, "10.9.9.9"},
+		"no header":      {"10.0.0.2:5100", http.Header{}, "10.0.0.2"},
+		"forwarded":      {"10.0.0.2:5100", http.Header{"Forwarded": {`for=192.0.2.60;proto=https, for="[2001:db8:cafe::17]:4711";by=10.0.0.2`}, "X-Forwarded-For": {"1.2.3.4"}}, "2001:db8:cafe::17"},
+		"obfuscated":     {"[2001:db8:ffff::1]:443", http.Header{"Forwarded": {"for=192.0.2.60, for=_hidden, for=10.0.0.5"}}, "10.0.0.5"},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/ingest", nil)
+		req.RemoteAddr = tc.remote
+		req.Header = tc.header
+		if got := clientIP(req, trusted); got.String() != tc.want {
+			t.Fatalf("%s: client = %s, want %s", name, got, tc.want)
+		}
+	}
+}
+
+func TestRequireClientIP(t *testing.T) {
+	office, _ := parseCIDR("192.0.2.0/24")
+	fastlyEdge, _ := parseCIDR("151.101.0.0/16")
+	for name, tc := range map[string]struct {
+		cfg    config
+		remote string
+		want   bool
+	}{
+		"open":          {config{}, "203.0.113.9:1", true},
+		"allowed":       {config{allowedCIDRs: []*net.IPNet{office}}, "192.0.2.10:1", true},
+		"not allowed":   {config{allowedCIDRs: []*net.IPNet{office}}, "203.0.113.9:1", false},
+		"fastly":        {config{fastlyRanges: []*net.IPNet{fastlyEdge}}, "151.101.2.3", true},
+		"not fastly":    {config{fastlyRanges: []*net.IPNet{fastlyEdge}}, "192.0.2.10:1", false},
+		"both required": {config{allowedCIDRs: []*net.IPNet{office}, fastlyRanges: []*net.IPNet{fastlyEdge}}, "151.101.2.3:1", false},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/fastly", nil)
+		req.RemoteAddr = tc.remote
+		rw := httptest.NewRecorder()
+		if got := requireClientIP(rw, req, tc.cfg); got != tc.want {
+			t.Fatalf("%s: allowed = %t, want %t", name, got, tc.want)
+		}
+		if !tc.want && rw.Code != http.StatusForbidden {
+			t.Fatalf("%s: status = %d, want 403", name, rw.Code)
+		}
+	}
+}
@@ -0,0 +1,435 @@
+// Package cloudflare implements a Cloudflare Logpush HTTP destination for
+// the http_requests, firewall_events and workers_trace_events datasets.
+package cloudflare
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+	"http-to-sentry-go/internal/ingest"
+	"http-to-sentry-go/internal/logevent"
+	"http-to-sentry-go/reqbody"
+	"http-to-sentry-go/timestamp"
+)
+
+// Record is one Logpush line. It holds the fields of all supported
+// datasets; Dataset tells them apart.
+type Record struct {
+	// http_requests and firewall_events
+	RayID                  string          `json:"RayID"`
+	ClientIP               string          `json:"ClientIP"`
+	ClientCountry          string          `json:"ClientCountry"`
+	ClientASN              int             `json:"ClientASN"`
+	ClientRequestHost      string          `json:"ClientRequestHost"`
+	ClientRequestMethod    string          `json:"ClientRequestMethod"`
+	ClientRequestURI       string          `json:"ClientRequestURI"`
+	ClientRequestPath      string          `json:"ClientRequestPath"`
+	ClientRequestQuery     string          `json:"ClientRequestQuery"`
+	ClientRequestProtocol  string          `json:"ClientRequestProtocol"`
+	ClientRequestUserAgent string          `json:"ClientRequestUserAgent"`
+	ClientRequestReferer   string          `json:"ClientRequestReferer"`
+	EdgeResponseStatus     int             `json:"EdgeResponseStatus"`
+	OriginResponseStatus   int             `json:"OriginResponseStatus"`
+	EdgeStartTimestamp     timestamp.Value `json:"EdgeStartTimestamp"`
+	EdgeColoCode           string          `json:"EdgeColoCode"`
+	CacheCacheStatus       string          `json:"CacheCacheStatus"`
+	SecurityAction         string          `json:"SecurityAction"`
+	WAFAction              string          `json:"WAFAction"`
+	WAFRuleID              string          `json:"WAFRuleID"`
+	WAFRuleMessage         string          `json:"WAFRuleMessage"`
+	ZoneName               string          `json:"ZoneName"`
+
+	// firewall_events
+	Action      string          `json:"Action"`
+	Datetime    timestamp.Value `json:"Datetime"`
+	RuleID      string          `json:"RuleID"`
+	Source      string          `json:"Source"`
+	Description string          `json:"Description"`
+
+	// workers_trace_events
+	ScriptName       string             `json:"ScriptName"`
+	Outcome          string             `json:"Outcome"`
+	EventType        string             `json:"EventType"`
+	EventTimestampMs timestamp.Value    `json:"EventTimestampMs"`
+	Event            *WorkerEvent       `json:"Event"`
+	Exceptions       []WorkerException  `json:"Exceptions"`
+	Logs             []WorkerLogMessage `json:"Logs"`
+}
+
+// WorkerEvent is the trigger of a Workers invocation.
+type WorkerEvent struct {
+	RayID   string `json:"RayID"`
+	Request *struct {
+		URL    string `json:"URL"`
+		Method string `json:"Method"`
+	} `json:"Request"`
+	Response *struct {
+		Status int `json:"Status"`
+	} `json:"Response"`
+}
+
+type WorkerException struct {
+	Name        string `json:"Name"`
+	Message     string `json:"Message"`
+	TimestampMs int64  `json:"TimestampMs"`
+}
+
+type WorkerLogMessage struct {
+	Level       string        `json:"Level"`
+	Message     []interface{} `json:"Message"`
+	TimestampMs int64         `json:"TimestampMs"`
+}
+
+// Dataset returns the Logpush dataset the record belongs to.
+func (rec Record) Dataset() string {
+	switch {
+	case rec.ScriptName != "" || rec.Outcome != "" || rec.EventType != "":
+		return "workers_trace_events"
+	case rec.Action != "" || rec.Datetime != "":
+		return "firewall_events"
+	default:
+		return "http_requests"
+	}
+}
+
+type Handler struct {
+	ingest.Options
+}
+
+// HandleLogpush serves Logpush batches: gzipped newline delimited JSON,
+// with or without Content-Encoding. The test file Logpush posts when a job
+// is created, a single object with a "content" field, is acknowledged
+// without capturing anything.
+func (h Handler) HandleLogpush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	maxBytes := h.BodyLimit(1048576)
+	body, err := reqbody.Read(w, r, maxBytes)
+	if err == nil && logevent.IsGzip(body) {
+		body, err = reqbody.Decode("gzip", body, maxBytes)
+	}
+	if err != nil {
+		status := reqbody.Status(err)
+		if status == http.StatusBadRequest {
+			h.ReportInvalid(1)
+		}
+		w.WriteHeader(status)
+		return
+	}
+
+	if isValidationFile(body) {
+		writeResult(w, nil, 0)
+		return
+	}
+
+	records, invalid := decodeRecords(body)
+	if len(records) == 0 {
+		h.ReportInvalid(max(invalid, 1))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	eventIDs := make([]string, 0, len(records))
+	for _, rec := range records {
+		event, err := buildSentryEvent(rec, r, h.Timestamps)
+		if err != nil {
+			invalid++
+			continue
+		}
+		eventID, err := h.CaptureEvent(r.Context(), event)
+		if err != nil {
+			h.ReportInvalid(invalid)
+			ingest.WriteError(w, err)
+			return
+		}
+		if eventID == nil {
+			continue
+		}
+		if id := string(*eventID); id != "" {
+			eventIDs = append(eventIDs, id)
+		}
+	}
+	h.ReportInvalid(invalid)
+
+	writeResult(w, eventIDs, invalid)
+}
+
+func writeResult(w http.ResponseWriter, eventIDs []string, invalid int) {
+	if eventIDs == nil {
+		eventIDs = []string{}
+	}
+	resp, err := json.Marshal(map[string]interface{}{
+		"event_ids": eventIDs,
+		"invalid":   invalid,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(resp)
+}
+
+func isValidationFile(body []byte) bool {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(bytes.TrimSpace(body), &doc); err != nil {
+		return false
+	}
+	_, ok := doc["content"]
+	return ok && len(doc) == 1
+}
+
+// decodeRecords reads newline delimited records, or a JSON array when the
+// job uses array output options. Lines that are not records are counted.
+func decodeRecords(body []byte) ([]Record, int) {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var records []Record
+		if err := json.Unmarshal(body, &records); err != nil {
+			return nil, 1
+		}
+		return records, 0
+	}
+
+	var records []Record
+	invalid := 0
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(body)+1)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var rec Record
+		if line[0] != '{' || json.Unmarshal(line, &rec) != nil {
+			invalid++
+			continue
+		}
+		records = append(records, rec)
+	}
+	return records, invalid
+}
+
+// buildSentryEvent maps a Logpush record. It fails only for timestamps the
+// policy rejects.
+func buildSentryEvent(rec Record, r *http.Request, policy timestamp.Policy) (*sentry.Event, error) {
+	event := logevent.New("cloudflare", r)
+
+	dataset := rec.Dataset()
+	event.Tags["dataset"] = dataset
+	logevent.AddTag(event.Tags, "ray_id", rec.RayID)
+	logevent.AddTag(event.Tags, "host", rec.ClientRequestHost)
+	logevent.AddTag(event.Tags, "zone", rec.ZoneName)
+	logevent.AddTag(event.Tags, "colo", rec.EdgeColoCode)
+	logevent.AddTag(event.Tags, "client_country", strings.ToLower(rec.ClientCountry))
+	if rec.ClientASN != 0 {
+		event.Tags["client_asn"] = strconv.Itoa(rec.ClientASN)
+	}
+	if rec.EdgeResponseStatus != 0 {
+		event.Tags["edge_response_status"] = strconv.Itoa(rec.EdgeResponseStatus)
+	}
+	if rec.OriginResponseStatus != 0 {
+		event.Tags["origin_response_status"] = strconv.Itoa(rec.OriginResponseStatus)
+	}
+	if rec.ClientIP != "" {
+		event.User = sentry.User{IPAddress: rec.ClientIP}
+	}
+	event.Extra["cloudflare"] = rec
+
+	var raw timestamp.Value
+	switch dataset {
+	case "workers_trace_events":
+		raw = rec.EventTimestampMs
+		mapWorkers(event, rec)
+	case "firewall_events":
+		raw = rec.Datetime
+		mapFirewall(event, rec)
+	default:
+		raw = rec.EdgeStartTimestamp
+		mapHTTPRequest(event, rec)
+	}
+
+	if raw != "" {
+		event.Extra["cloudflare_timestamp"] = string(raw)
+	}
+	ts, clamped, err := policy.Resolve(string(raw), event.Timestamp)
+	if errors.Is(err, timestamp.ErrOutOfRange) {
+		return nil, err
+	}
+	if err == nil {
+		event.Timestamp = ts
+	}
+	if clamped {
+		event.Extra["cloudflare_timestamp_clamped"] = true
+	}
+	return event, nil
+}
+
+// mapHTTPRequest maps an http_requests record. Edge 5xx responses are
+// errors, 4xx responses and requests the WAF acted on are warnings.
+func mapHTTPRequest(event *sentry.Event, rec Record) {
+	action := rec.SecurityAction
+	if action == "" {
+		action = rec.WAFAction
+	}
+	if action == "unknown" {
+		action = ""
+	}
+	logevent.AddTag(event.Tags, "cache_status", rec.CacheCacheStatus)
+	logevent.AddTag(event.Tags, "waf_action", action)
+	logevent.AddTag(event.Tags, "waf_rule_id", rec.WAFRuleID)
+
+	switch {
+	case rec.EdgeResponseStatus >= 500:
+		event.Level = sentry.LevelError
+	case rec.EdgeResponseStatus >= 400 || blockingAction(action):
+		event.Level = sentry.LevelWarning
+	default:
+		event.Level = sentry.LevelInfo
+	}
+
+	event.Request = buildRequest(rec, rec.ClientRequestURI)
+	message := strings.TrimSpace(rec.ClientRequestMethod + " " + requestURL(rec.ClientRequestHost, rec.ClientRequestURI))
+	if rec.EdgeResponseStatus != 0 {
+		message += " " + strconv.Itoa(rec.EdgeResponseStatus)
+	}
+	if action != "" {
+		message += " (WAF " + action + ")"
+	}
+	event.Message = strings.TrimSpace(message)
+	if rec.WAFRuleMessage != "" {
+		event.Extra["waf_rule_message"] = rec.WAFRuleMessage
+	}
+}
+
+// mapFirewall maps a firewall_events record. Blocked requests are
+// warnings; logged, allowed and challenged requests are informational.
+func mapFirewall(event *sentry.Event, rec Record) {
+	logevent.AddTag(event.Tags, "waf_action", rec.Action)
+	logevent.AddTag(event.Tags, "waf_source", rec.Source)
+	logevent.AddTag(event.Tags, "waf_rule_id", rec.RuleID)
+
+	event.Level = sentry.LevelInfo
+	if blockingAction(rec.Action) {
+		event.Level = sentry.LevelWarning
+	}
+
+	uri := rec.ClientRequestPath + rec.ClientRequestQuery
+	event.Request = buildRequest(rec, uri)
+	message := "WAF " + rec.Action
+	if rec.Source != "" {
+		message += " by " + rec.Source
+	}
+	if rec.Description != "" {
+		message += ": " + rec.Description
+	} else if rec.ClientRequestHost != "" {
+		message += ": " + strings.TrimSpace(rec.ClientRequestMethod+" "+requestURL(rec.ClientRequestHost, uri))
+	}
+	event.Message = message
+}
+
+// mapWorkers maps a workers_trace_events record. Uncaught exceptions become
+// the event's exceptions; invocations that did not finish ok are errors,
+// except canceled ones, which are warnings.
+func mapWorkers(event *sentry.Event, rec Record) {
+	logevent.AddTag(event.Tags, "script", rec.ScriptName)
+	logevent.AddTag(event.Tags, "outcome", rec.Outcome)
+	logevent.AddTag(event.Tags, "event_type", rec.EventType)
+
+	switch {
+	case len(rec.Exceptions) > 0:
+		event.Level = sentry.LevelError
+	case rec.Outcome == "" || rec.Outcome == "ok":
+		event.Level = sentry.LevelInfo
+		for _, l := range rec.Logs {
+			if strings.EqualFold(l.Level, "error") {
+				event.Level = sentry.LevelError
+				break
+			}
+			if strings.EqualFold(l.Level, "warn") {
+				event.Level = sentry.LevelWarning
+			}
+		}
+	case rec.Outcome == "canceled":
+		event.Level = sentry.LevelWarning
+	default:
+		event.Level = sentry.LevelError
+	}
+
+	for _, exc := range rec.Exceptions {
+		event.Exception = append(event.Exception, sentry.Exception{
+			Type:  exc.Name,
+			Value: exc.Message,
+		})
+	}
+	if len(rec.Logs) > 0 {
+		event.Extra["worker_logs"] = rec.Logs
+	}
+
+	if ev := rec.Event; ev != nil {
+		if ev.RayID != "" {
+			event.Tags["ray_id"] = ev.RayID
+		}
+		if ev.Response != nil && ev.Response.Status != 0 {
+			event.Tags["response_status"] = strconv.Itoa(ev.Response.Status)
+		}
+		if ev.Request != nil && ev.Request.URL != "" {
+			event.Request = &sentry.Request{
+				URL:         ev.Request.URL,
+				Method:      ev.Request.Method,
+				QueryString: logevent.QueryString(ev.Request.URL),
+			}
+		}
+	}
+
+	switch {
+	case len(rec.Exceptions) > 0:
+		exc := rec.Exceptions[0]
+		event.Message = strings.TrimPrefix(exc.Name+": "+exc.Message, ": ")
+	default:
+		event.Message = strings.TrimSpace("Worker " + rec.ScriptName + " " + rec.Outcome)
+	}
+}
+
+// blockingAction reports whether a WAF or security action stopped the
+// request.
+func blockingAction(action string) bool {
+	switch strings.ToLower(action) {
+	case "block", "drop", "connectionclose", "connection_close", "challengefailed", "jschallengefailed", "managedchallengefailed":
+		return true
+	}
+	return false
+}
+
+func buildRequest(rec Record, uri string) *sentry.Request {
+	if rec.ClientRequestHost == "" && uri == "" {
+		return nil
+	}
+	reqURL := requestURL(rec.ClientRequestHost, uri)
+	return &sentry.Request{
+		URL:         reqURL,
+		Method:      rec.ClientRequestMethod,
+		Headers:     map[string]string{"User-Agent": rec.ClientRequestUserAgent, "Referer": rec.ClientRequestReferer},
+		QueryString: logevent.QueryString(reqURL),
+	}
+}
+
+func requestURL(host, uri string) string {
+	if uri == "" {
+		uri = "/"
+	}
+	if !strings.HasPrefix(uri, "/") {
+		uri = "/" + uri
+	}
+	return "https://" + host + uri
+}
@@ -0,0 +1,157 @@
+package cloudflare
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"http-to-sentry-go/internal/ingest"
+	"http-to-sentry-go/timestamp"
+)
+
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestHandleLogpush(t *testing.T) {
+	var captured []*sentry.Event
+	invalid := 0
+	h := Handler{
+		Options: ingest.Options{
+			Capture: func(_ context.Context, event *sentry.Event) (*sentry.EventID, error) {
+				captured = append(captured, event)
+				id := sentry.EventID("id")
+				return &id, nil
+			},
+			Timestamps: timestamp.Policy{MaxPast: 100 * 365 * 24 * time.Hour},
+			Invalid:    func(count int) { invalid += count },
+		},
+	}
+
+	body := `{"RayID":"7f1a","ClientIP":"203.0.113.9","ClientCountry":"DE","ClientRequestHost":"shop.example.com","ClientRequestMethod":"POST","ClientRequestURI":"/cart?id=1","ClientRequestUserAgent":"curl/8.0","EdgeResponseStatus":502,"OriginResponseStatus":502,"EdgeStartTimestamp":1769686872500000000,"EdgeColoCode":"FRA","CacheCacheStatus":"dynamic"}
+{"RayID":"7f1b","ClientIP":"198.51.100.4","ClientRequestHost":"shop.example.com","ClientRequestMethod":"GET","ClientRequestURI":"/login","EdgeResponseStatus":403,"SecurityAction":"block","EdgeStartTimestamp":"2026-01-29T11:41:13Z"}
+{"Action":"block","ClientIP":"198.51.100.4","ClientRequestHost":"shop.example.com","ClientRequestMethod":"GET","ClientRequestPath":"/wp-login.php","ClientRequestQuery":"?x=1","Datetime":"2026-01-29T11:41:14Z","RayID":"7f1c","RuleID":"100015","Source":"firewallManaged","Description":"Wordpress - Dangerous File Upload"}
+{"ScriptName":"api-worker","Outcome":"exception","EventType":"fetch","EventTimestampMs":1769686875000,"Event":{"RayID":"7f1d","Request":{"URL":"https://api.example.com/v1/items?page=2","Method":"GET"},"Response":{"Status":500}},"Exceptions":[{"Name":"TypeError","Message":"Cannot read properties of undefined","TimestampMs":1769686875001}],"Logs":[{"Level":"log","Message":["loading"],"TimestampMs":1769686875000}]}
+not json
+`
+	req := httptest.NewRequest(http.MethodPost, "/cloudflare", bytes.NewReader(gzipped(t, body)))
+	rw := httptest.NewRecorder()
+	h.HandleLogpush(rw, req)
+
+	if rw.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 (%s)", rw.Code, rw.Body.String())
+	}
+	var resp struct {
+		EventIDs []string `json:"event_ids"`
+		Invalid  int      `json:"invalid"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(resp.EventIDs) != 4 || resp.Invalid != 1 || invalid != 1 {
+		t.Fatalf("response = %+v, invalid = %d", resp, invalid)
+	}
+	if len(captured) != 4 {
+		t.Fatalf("captured %d events, want 4", len(captured))
+	}
+
+	origin := captured[0]
+	if origin.Logger != "cloudflare" || origin.Level != sentry.LevelError || origin.Message != "POST https://shop.example.com/cart?id=1 502" {
+		t.Fatalf("http event = %s %s %q", origin.Logger, origin.Level, origin.Message)
+	}
+	if origin.Tags["dataset"] != "http_requests" || origin.Tags["ray_id"] != "7f1a" || origin.Tags["colo"] != "FRA" || origin.Tags["edge_response_status"] != "502" {
+		t.Fatalf("http tags = %v", origin.Tags)
+	}
+	if origin.User.IPAddress != "203.0.113.9" || origin.Request.QueryString != "id=1" {
+		t.Fatalf("http user/request = %q %+v", origin.User.IPAddress, origin.Request)
+	}
+	if !origin.Timestamp.Equal(time.Date(2026, 1, 29, 11, 41, 12, 500000000, time.UTC)) {
+		t.Fatalf("http timestamp = %s", origin.Timestamp)
+	}
+
+	blocked := captured[1]
+	if blocked.Level != sentry.LevelWarning || blocked.Tags["waf_action"] != "block" || blocked.Message != "GET https://shop.example.com/login 403 (WAF block)" {
+		t.Fatalf("blocked event = %s %v %q", blocked.Level, blocked.Tags, blocked.Message)
+	}
+
+	firewall := captured[2]
+	if firewall.Tags["dataset"] != "firewall_events" || firewall.Level != sentry.LevelWarning || firewall.Tags["waf_rule_id"] != "100015" {
+		t.Fatalf("firewall event = %s %v", firewall.Level, firewall.Tags)
+	}
+	if firewall.Message != "WAF block by firewallManaged: Wordpress - Dangerous File Upload" || firewall.Request.URL != "https://shop.example.com/wp-login.php?x=1" {
+		t.Fatalf("firewall message/request = %q %+v", firewall.Message, firewall.Request)
+	}
+
+	worker := captured[3]
+	if worker.Tags["dataset"] != "workers_trace_events" || worker.Level != sentry.LevelError || worker.Tags["script"] != "api-worker" || worker.Tags["ray_id"] != "7f1d" {
+		t.Fatalf("worker event = %s %v", worker.Level, worker.Tags)
+	}
+	if len(worker.Exception) != 1 || worker.Exception[0].Type != "TypeError" || worker.Message != "TypeError: Cannot read properties of undefined" {
+		t.Fatalf("worker exception = %+v %q", worker.Exception, worker.Message)
+	}
+	if worker.Request == nil || worker.Request.URL != "https://api.example.com/v1/items?page=2" || worker.Tags["response_status"] != "500" {
+		t.Fatalf("worker request = %+v %v", worker.Request, worker.Tags)
+	}
+}
+
+func TestHandleLogpushValidationFile(t *testing.T) {
+	captured := 0
+	h := Handler{
+		Options: ingest.Options{
+			Capture: func(_ context.Context, event *sentry.Event) (*sentry.EventID, error) {
+				captured++
+				return &event.EventID, nil
+			},
+		},
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/cloudflare", bytes.NewReader(gzipped(t, `{"content":"tests"}`)))
+	req.Header.Set("Content-Encoding", "gzip")
+	rw := httptest.NewRecorder()
+	h.HandleLogpush(rw, req)
+	if rw.Code != http.StatusOK || captured != 0 {
+		t.Fatalf("status = %d, captured = %d", rw.Code, captured)
+	}
+}
+
+func TestHandleLogpushRejectsEmptyBatch(t *testing.T) {
+	invalid := 0
+	h := Handler{Options: ingest.Options{Invalid: func(count int) { invalid += count }}}
+	req := httptest.NewRequest(http.MethodPost, "/cloudflare", bytes.NewReader(gzipped(t, "garbage\n")))
+	rw := httptest.NewRecorder()
+	h.HandleLogpush(rw, req)
+	if rw.Code != http.StatusBadRequest || invalid != 1 {
+		t.Fatalf("status = %d, invalid = %d", rw.Code, invalid)
+	}
+}
+
+func TestWorkersOutcomeLevels(t *testing.T) {
+	for outcome, want := range map[string]sentry.Level{
+		"ok":          sentry.LevelInfo,
+		"canceled":    sentry.LevelWarning,
+		"exceededCpu": sentry.LevelError,
+	} {
+		event, err := buildSentryEvent(Record{ScriptName: "w", Outcome: outcome}, httptest.NewRequest(http.MethodPost, "/", nil), timestamp.Policy{})
+		if err != nil {
+			t.Fatalf("%s: %v", outcome, err)
+		}
+		if event.Level != want {
+			t.Fatalf("%s: level = %s, want %s", outcome, event.Level, want)
+		}
+	}
+}
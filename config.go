@@ -0,0 +1,668 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/getsentry/sentry-go"
+	"gopkg.in/yaml.v3"
+	"http-to-sentry-go/drain"
+	"http-to-sentry-go/elastic"
+	"http-to-sentry-go/fastly"
+	"http-to-sentry-go/jwt"
+	"http-to-sentry-go/mapping"
+	"http-to-sentry-go/mtls"
+	"http-to-sentry-go/timestamp"
+	"http-to-sentry-go/tokens"
+)
+
+// fileConfig is the configuration file schema. Environment variables
+// override the values read from the file.
+type fileConfig struct {
+	Sentry struct {
+		DSN            string `json:"dsn"`
+		Environment    string `json:"environment"`
+		Release        string `json:"release"`
+		FlushTimeoutMS int    `json:"flush_timeout_ms"`
+		QueueSize      int    `json:"queue_size"`
+	} `json:"sentry"`
+	HTTP struct {
+		Addr              string   `json:"addr"`
+		Path              string   `json:"path"`
+		FastlyPath        string   `json:"fastly_path"`
+		OTLPPath          string   `json:"otlp_path"`
+		LokiPath          string   `json:"loki_path"`
+		HECPath           string   `json:"hec_path"`
+		ElasticPath       string   `json:"elastic_path"`
+		FirehosePath      string   `json:"firehose_path"`
+		HerokuPath        string   `json:"heroku_path"`
+		VercelPath        string   `json:"vercel_path"`
+		NetlifyPath       string   `json:"netlify_path"`
+		CloudflarePath    string   `json:"cloudflare_path"`
+		ReportsPath       string   `json:"reports_path"`
+		MetricsPath       string   `json:"metrics_path"`
+		AuthToken         string   `json:"auth_token"`
+		MaxBodyBytes      int      `json:"max_body_bytes"`
+		ShutdownTimeoutMS int      `json:"shutdown_timeout_ms"`
+		MappingFile       string   `json:"mapping_file"`
+		RoutesFile        string   `json:"routes_file"`
+		TokensFile        string   `json:"tokens_file"`
+		AllowedCIDRs      []string `json:"allowed_cidrs"`
+		TrustedProxies    []string `json:"trusted_proxies"`
+	} `json:"http"`
+	HTTPS struct {
+		Addr         string `json:"addr"`
+		CertFile     string `json:"cert_file"`
+		KeyFile      string `json:"key_file"`
+		ClientCAFile string `json:"client_ca_file"`
+		ClientAuth   string `json:"client_auth"`
+	} `json:"https"`
+	Fastly struct {
+		ServiceID    string `json:"service_id"`
+		IPRangesFile string `json:"ip_ranges_file"`
+	} `json:"fastly"`
+	Loki struct {
+		JSONLines bool `json:"json_lines"`
+	} `json:"loki"`
+	Elastic struct {
+		Version string `json:"version"`
+	} `json:"elastic"`
+	Drain struct {
+		MinLevel          string   `json:"min_level"`
+		Match             string   `json:"match"`
+		VercelSecrets     []string `json:"vercel_secrets"`
+		VercelVerifyToken string   `json:"vercel_verify_token"`
+	} `json:"drain"`
+	Reports struct {
+		AllowedOrigins []string `json:"allowed_origins"`
+	} `json:"reports"`
+	JWT struct {
+		JWKSFile       string   `json:"jwks_file"`
+		Issuer         string   `json:"issuer"`
+		Audience       []string `json:"audience"`
+		LeewayMS       *int64   `json:"leeway_ms"`
+		ClaimTags      []string `json:"claim_tags"`
+		UserIDClaim    string   `json:"user_id_claim"`
+		UserEmailClaim string   `json:"user_email_claim"`
+		UsernameClaim  string   `json:"username_claim"`
+	} `json:"jwt"`
+	Syslog struct {
+		UDPAddr         string   `json:"udp_addr"`
+		TCPAddr         string   `json:"tcp_addr"`
+		TLSAddr         string   `json:"tls_addr"`
+		CertFile        string   `json:"cert_file"`
+		KeyFile         string   `json:"key_file"`
+		AllowedCIDRs    []string `json:"allowed_cidrs"`
+		MaxMessageBytes int      `json:"max_message_bytes"`
+		// Route names the route whose Sentry settings messages are
+		// captured with; defaults to the HTTP_PATH route.
+		Route string `json:"route"`
+	} `json:"syslog"`
+	Forward struct {
+		Addr          string `json:"addr"`
+		SharedKey     string `json:"shared_key"`
+		Hostname      string `json:"hostname"`
+		MaxChunkBytes int    `json:"max_chunk_bytes"`
+		IdleTimeoutMS int    `json:"idle_timeout_ms"`
+		// Route names the route whose Sentry settings records are
+		// captured with; defaults to the HTTP_PATH route.
+		Route string `json:"route"`
+	} `json:"forward"`
+	Spool struct {
+		Dir          string `json:"dir"`
+		SegmentBytes int64  `json:"segment_bytes"`
+	} `json:"spool"`
+	Timestamps struct {
+		MaxPastMS   *int64 `json:"max_past_ms"`
+		MaxFutureMS *int64 `json:"max_future_ms"`
+		SkewAction  string `json:"skew_action"`
+	} `json:"timestamps"`
+	Mapping *mapping.Rules `json:"mapping"`
+	Routes  []routeConfig  `json:"routes"`
+}
+
+// loadConfig reads the optional configuration file, applies environment
+// variable overrides and validates the result. All problems found are
+// reported together.
+func loadConfig(filename string) (config, error) {
+	var fc fileConfig
+	if filename != "" {
+		if err := readConfigFile(filename, &fc); err != nil {
+			return config{}, err
+		}
+	}
+
+	var errs []error
+	applyEnv(&fc, &errs)
+	cfg := fc.resolve(&errs)
+	if len(errs) > 0 {
+		return config{}, errors.Join(errs...)
+	}
+	return cfg, nil
+}
+
+// readConfigFile decodes a TOML file (.toml) or a YAML file (anything else,
+// including JSON). Unknown keys are rejected.
+func readConfigFile(filename string, fc *fileConfig) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	// Decode generically and re-encode as JSON so the schema, the route and
+	// the mapping types only need json tags.
+	var doc interface{}
+	if strings.EqualFold(filepath.Ext(filename), ".toml") {
+		var table map[string]interface{}
+		err = toml.Unmarshal(data, &table)
+		doc = table
+	} else {
+		err = yaml.Unmarshal(data, &doc)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	if doc == nil {
+		return nil
+	}
+	encoded, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(encoded))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(fc); err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	return nil
+}
+
+func applyEnv(fc *fileConfig, errs *[]error) {
+	envString("SENTRY_DSN", &fc.Sentry.DSN)
+	envString("SENTRY_ENVIRONMENT", &fc.Sentry.Environment)
+	envString("SENTRY_RELEASE", &fc.Sentry.Release)
+	envInt("SENTRY_FLUSH_TIMEOUT_MS", &fc.Sentry.FlushTimeoutMS, errs)
+	envInt("SENTRY_QUEUE_SIZE", &fc.Sentry.QueueSize, errs)
+	envString("HTTP_ADDR", &fc.HTTP.Addr)
+	envString("HTTP_PATH", &fc.HTTP.Path)
+	envString("HTTP_FASTLY_PATH", &fc.HTTP.FastlyPath)
+	envString("HTTP_OTLP_PATH", &fc.HTTP.OTLPPath)
+	envString("HTTP_LOKI_PATH", &fc.HTTP.LokiPath)
+	envString("HTTP_HEC_PATH", &fc.HTTP.HECPath)
+	envBool("LOKI_JSON_LINES", &fc.Loki.JSONLines, errs)
+	envString("HTTP_ELASTIC_PATH", &fc.HTTP.ElasticPath)
+	envString("ELASTIC_VERSION", &fc.Elastic.Version)
+	envString("HTTP_FIREHOSE_PATH", &fc.HTTP.FirehosePath)
+	envString("HTTP_HEROKU_PATH", &fc.HTTP.HerokuPath)
+	envString("HTTP_VERCEL_PATH", &fc.HTTP.VercelPath)
+	envString("HTTP_NETLIFY_PATH", &fc.HTTP.NetlifyPath)
+	envString("HTTP_CLOUDFLARE_PATH", &fc.HTTP.CloudflarePath)
+	envString("HTTP_REPORTS_PATH", &fc.HTTP.ReportsPath)
+	envList("REPORTS_ALLOWED_ORIGINS", &fc.Reports.AllowedOrigins)
+	envString("DRAIN_MIN_LEVEL", &fc.Drain.MinLevel)
+	envString("DRAIN_MATCH", &fc.Drain.Match)
+	envList("DRAIN_VERCEL_SECRETS", &fc.Drain.VercelSecrets)
+	envString("DRAIN_VERCEL_VERIFY_TOKEN", &fc.Drain.VercelVerifyToken)
+	envString("JWT_JWKS_FILE", &fc.JWT.JWKSFile)
+	envString("JWT_ISSUER", &fc.JWT.Issuer)
+	envList("JWT_AUDIENCE", &fc.JWT.Audience)
+	envInt64Ptr("JWT_LEEWAY_MS", &fc.JWT.LeewayMS, errs)
+	envList("JWT_CLAIM_TAGS", &fc.JWT.ClaimTags)
+	envString("JWT_USER_ID_CLAIM", &fc.JWT.UserIDClaim)
+	envString("JWT_USER_EMAIL_CLAIM", &fc.JWT.UserEmailClaim)
+	envString("JWT_USERNAME_CLAIM", &fc.JWT.UsernameClaim)
+	envString("HTTP_METRICS_PATH", &fc.HTTP.MetricsPath)
+	envString("HTTP_AUTH_TOKEN", &fc.HTTP.AuthToken)
+	envInt("HTTP_MAX_BODY_BYTES", &fc.HTTP.MaxBodyBytes, errs)
+	envInt("HTTP_SHUTDOWN_TIMEOUT_MS", &fc.HTTP.ShutdownTimeoutMS, errs)
+	envString("HTTP_MAPPING_FILE", &fc.HTTP.MappingFile)
+	envString("HTTP_ROUTES_FILE", &fc.HTTP.RoutesFile)
+	envString("HTTP_TOKENS_FILE", &fc.HTTP.TokensFile)
+	envList("HTTP_ALLOWED_CIDRS", &fc.HTTP.AllowedCIDRs)
+	envList("HTTP_TRUSTED_PROXIES", &fc.HTTP.TrustedProxies)
+	envString("HTTPS_ADDR", &fc.HTTPS.Addr)
+	envString("HTTPS_CERT_FILE", &fc.HTTPS.CertFile)
+	envString("HTTPS_KEY_FILE", &fc.HTTPS.KeyFile)
+	envString("HTTPS_CLIENT_CA_FILE", &fc.HTTPS.ClientCAFile)
+	envString("HTTPS_CLIENT_AUTH", &fc.HTTPS.ClientAuth)
+	envString("FASTLY_SERVICE_ID", &fc.Fastly.ServiceID)
+	envString("FASTLY_IP_RANGES_FILE", &fc.Fastly.IPRangesFile)
+	envString("SYSLOG_UDP_ADDR", &fc.Syslog.UDPAddr)
+	envString("SYSLOG_TCP_ADDR", &fc.Syslog.TCPAddr)
+	envString("SYSLOG_TLS_ADDR", &fc.Syslog.TLSAddr)
+	envString("SYSLOG_TLS_CERT_FILE", &fc.Syslog.CertFile)
+	envString("SYSLOG_TLS_KEY_FILE", &fc.Syslog.KeyFile)
+	envList("SYSLOG_ALLOWED_CIDRS", &fc.Syslog.AllowedCIDRs)
+	envInt("SYSLOG_MAX_MESSAGE_BYTES", &fc.Syslog.MaxMessageBytes, errs)
+	envString("SYSLOG_ROUTE", &fc.Syslog.Route)
+	envString("FORWARD_ADDR", &fc.Forward.Addr)
+	envString("FORWARD_SHARED_KEY", &fc.Forward.SharedKey)
+	envString("FORWARD_HOSTNAME", &fc.Forward.Hostname)
+	envInt("FORWARD_MAX_CHUNK_BYTES", &fc.Forward.MaxChunkBytes, errs)
+	envInt("FORWARD_IDLE_TIMEOUT_MS", &fc.Forward.IdleTimeoutMS, errs)
+	envString("FORWARD_ROUTE", &fc.Forward.Route)
+	envString("SPOOL_DIR", &fc.Spool.Dir)
+	envInt64("SPOOL_SEGMENT_BYTES", &fc.Spool.SegmentBytes, errs)
+	envInt64Ptr("TIMESTAMP_MAX_PAST_MS", &fc.Timestamps.MaxPastMS, errs)
+	envInt64Ptr("TIMESTAMP_MAX_FUTURE_MS", &fc.Timestamps.MaxFutureMS, errs)
+	envString("TIMESTAMP_SKEW_ACTION", &fc.Timestamps.SkewAction)
+}
+
+// resolve applies defaults and validates fc, appending problems to errs.
+func (fc *fileConfig) resolve(errs *[]error) config {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, fmt.Errorf(format, args...))
+	}
+
+	cfg := config{
+		sentryDSN:         fc.Sentry.DSN,
+		sentryEnvironment: orDefault(fc.Sentry.Environment, "development"),
+		sentryRelease:     fc.Sentry.Release,
+		sentryQueueSize:   fc.Sentry.QueueSize,
+		httpAddr:          orDefault(fc.HTTP.Addr, "0.0.0.0:8080"),
+		httpsAddr:         fc.HTTPS.Addr,
+		httpsCertFile:     fc.HTTPS.CertFile,
+		httpsKeyFile:      fc.HTTPS.KeyFile,
+		httpsClientCAFile: fc.HTTPS.ClientCAFile,
+		httpPath:          orDefault(fc.HTTP.Path, "/ingest"),
+		fastlyPath:        orDefault(fc.HTTP.FastlyPath, "/fastly"),
+		otlpPath:          fc.HTTP.OTLPPath,
+		lokiPath:          fc.HTTP.LokiPath,
+		lokiJSONLines:     fc.Loki.JSONLines,
+		hecPath:           fc.HTTP.HECPath,
+		elasticPath:       fc.HTTP.ElasticPath,
+		elasticVersion:    orDefault(fc.Elastic.Version, elastic.DefaultVersion),
+		firehosePath:      fc.HTTP.FirehosePath,
+		herokuPath:        fc.HTTP.HerokuPath,
+		vercelPath:        fc.HTTP.VercelPath,
+		netlifyPath:       fc.HTTP.NetlifyPath,
+		cloudflarePath:    fc.HTTP.CloudflarePath,
+		reportsPath:       fc.HTTP.ReportsPath,
+		reportsOrigins:    fc.Reports.AllowedOrigins,
+		vercelSecrets:     fc.Drain.VercelSecrets,
+		vercelVerifyToken: fc.Drain.VercelVerifyToken,
+		metricsPath:       orDefault(fc.HTTP.MetricsPath, "/metrics"),
+		fastlyServiceID:   fc.Fastly.ServiceID,
+		authToken:         fc.HTTP.AuthToken,
+		maxBodyBytes:      fc.HTTP.MaxBodyBytes,
+		flushTimeout:      time.Duration(fc.Sentry.FlushTimeoutMS) * time.Millisecond,
+		shutdownGrace:     time.Duration(fc.HTTP.ShutdownTimeoutMS) * time.Millisecond,
+		syslogUDPAddr:     fc.Syslog.UDPAddr,
+		syslogTCPAddr:     fc.Syslog.TCPAddr,
+		syslogTLSAddr:     fc.Syslog.TLSAddr,
+		syslogCertFile:    fc.Syslog.CertFile,
+		syslogKeyFile:     fc.Syslog.KeyFile,
+		syslogMaxBytes:    fc.Syslog.MaxMessageBytes,
+		syslogRoute:       fc.Syslog.Route,
+		forwardAddr:       fc.Forward.Addr,
+		forwardSharedKey:  fc.Forward.SharedKey,
+		forwardHostname:   fc.Forward.Hostname,
+		forwardMaxBytes:   fc.Forward.MaxChunkBytes,
+		forwardIdle:       time.Duration(fc.Forward.IdleTimeoutMS) * time.Millisecond,
+		forwardRoute:      fc.Forward.Route,
+		spoolDir:          fc.Spool.Dir,
+		spoolSegmentBytes: fc.Spool.SegmentBytes,
+		routes:            fc.Routes,
+	}
+
+	if !strings.HasPrefix(cfg.httpPath, "/") {
+		cfg.httpPath = "/" + cfg.httpPath
+	}
+	if !strings.HasPrefix(cfg.fastlyPath, "/") {
+		cfg.fastlyPath = "/" + cfg.fastlyPath
+	}
+	// The protocol paths are opt-in: an empty path disables the endpoint.
+	for _, path := range []*string{
+		&cfg.otlpPath, &cfg.lokiPath, &cfg.hecPath, &cfg.elasticPath, &cfg.firehosePath,
+		&cfg.herokuPath, &cfg.vercelPath, &cfg.netlifyPath, &cfg.cloudflarePath, &cfg.reportsPath,
+	} {
+		if *path != "" && !strings.HasPrefix(*path, "/") {
+			*path = "/" + *path
+		}
+	}
+	if !strings.HasPrefix(cfg.metricsPath, "/") {
+		cfg.metricsPath = "/" + cfg.metricsPath
+	}
+
+	switch {
+	case cfg.maxBodyBytes == 0:
+		cfg.maxBodyBytes = 1048576
+	case cfg.maxBodyBytes < 1024:
+		fail("http.max_body_bytes (HTTP_MAX_BODY_BYTES): must be at least 1024, got %d", cfg.maxBodyBytes)
+	}
+	switch {
+	case cfg.sentryQueueSize == 0:
+		cfg.sentryQueueSize = 1000
+	case cfg.sentryQueueSize < 0:
+		fail("sentry.queue_size (SENTRY_QUEUE_SIZE): must be positive, got %d", cfg.sentryQueueSize)
+	}
+	switch {
+	case cfg.flushTimeout == 0:
+		cfg.flushTimeout = 2 * time.Second
+	case cfg.flushTimeout < 0:
+		fail("sentry.flush_timeout_ms (SENTRY_FLUSH_TIMEOUT_MS): must be positive, got %d", fc.Sentry.FlushTimeoutMS)
+	}
+	switch {
+	case cfg.shutdownGrace == 0:
+		cfg.shutdownGrace = 5 * time.Second
+	case cfg.shutdownGrace < 0:
+		fail("http.shutdown_timeout_ms (HTTP_SHUTDOWN_TIMEOUT_MS): must be positive, got %d", fc.HTTP.ShutdownTimeoutMS)
+	}
+	switch {
+	case cfg.spoolSegmentBytes == 0:
+		cfg.spoolSegmentBytes = 8388608
+	case cfg.spoolSegmentBytes < 65536:
+		fail("spool.segment_bytes (SPOOL_SEGMENT_BYTES): must be at least 65536, got %d", cfg.spoolSegmentBytes)
+	}
+
+	if cfg.sentryDSN != "" {
+		if _, err := sentry.NewDsn(cfg.sentryDSN); err != nil {
+			fail("sentry.dsn (SENTRY_DSN): %v", err)
+		}
+	}
+
+	if cfg.httpsAddr != "" && (cfg.httpsCertFile == "" || cfg.httpsKeyFile == "") {
+		fail("https.addr (HTTPS_ADDR) is set but https.cert_file (HTTPS_CERT_FILE) and https.key_file (HTTPS_KEY_FILE) are both required")
+	}
+	if cfg.httpsAddr == "" && (cfg.httpsCertFile != "" || cfg.httpsKeyFile != "") {
+		fail("https.cert_file/https.key_file are set but https.addr (HTTPS_ADDR) is empty")
+	}
+	for _, file := range []string{cfg.httpsCertFile, cfg.httpsKeyFile} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			fail("https: %v", err)
+		}
+	}
+	clientAuth := fc.HTTPS.ClientAuth
+	if clientAuth == "" && cfg.httpsClientCAFile != "" {
+		clientAuth = "require"
+	}
+	if mode, err := mtls.ParseClientAuth(clientAuth); err != nil {
+		fail("https.client_auth (HTTPS_CLIENT_AUTH): %v", err)
+	} else {
+		cfg.httpsClientAuth = mode
+	}
+	switch {
+	case cfg.httpsClientAuth != tls.NoClientCert && cfg.httpsClientCAFile == "":
+		fail("https.client_auth (HTTPS_CLIENT_AUTH) is %s but https.client_ca_file (HTTPS_CLIENT_CA_FILE) is empty", clientAuth)
+	case cfg.httpsClientCAFile != "" && cfg.httpsAddr == "":
+		fail("https.client_ca_file (HTTPS_CLIENT_CA_FILE) is set but https.addr (HTTPS_ADDR) is empty")
+	case cfg.httpsClientCAFile != "":
+		if _, err := mtls.LoadCAs(cfg.httpsClientCAFile); err != nil {
+			fail("https.client_ca_file (HTTPS_CLIENT_CA_FILE): %v", err)
+		}
+	}
+
+	if cfg.syslogTLSAddr != "" && (cfg.syslogCertFile == "" || cfg.syslogKeyFile == "") {
+		fail("syslog.tls_addr (SYSLOG_TLS_ADDR) is set but syslog.cert_file (SYSLOG_TLS_CERT_FILE) and syslog.key_file (SYSLOG_TLS_KEY_FILE) are both required")
+	}
+	for _, file := range []string{cfg.syslogCertFile, cfg.syslogKeyFile} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			fail("syslog: %v", err)
+		}
+	}
+	switch {
+	case cfg.syslogMaxBytes == 0:
+		cfg.syslogMaxBytes = 65536
+	case cfg.syslogMaxBytes < 480:
+		fail("syslog.max_message_bytes (SYSLOG_MAX_MESSAGE_BYTES): must be at least 480, got %d", cfg.syslogMaxBytes)
+	}
+	for _, cidr := range fc.Syslog.AllowedCIDRs {
+		network, err := parseCIDR(cidr)
+		if err != nil {
+			fail("syslog.allowed_cidrs (SYSLOG_ALLOWED_CIDRS): %v", err)
+			continue
+		}
+		cfg.syslogAllowed = append(cfg.syslogAllowed, network)
+	}
+	for _, cidr := range fc.HTTP.AllowedCIDRs {
+		network, err := parseCIDR(cidr)
+		if err != nil {
+			fail("http.allowed_cidrs (HTTP_ALLOWED_CIDRS): %v", err)
+			continue
+		}
+		cfg.allowedCIDRs = append(cfg.allowedCIDRs, network)
+	}
+	for _, cidr := range fc.HTTP.TrustedProxies {
+		network, err := parseCIDR(cidr)
+		if err != nil {
+			fail("http.trusted_proxies (HTTP_TRUSTED_PROXIES): %v", err)
+			continue
+		}
+		cfg.trustedProxies = append(cfg.trustedProxies, network)
+	}
+	if fc.Fastly.IPRangesFile != "" {
+		ranges, err := fastly.LoadIPRanges(fc.Fastly.IPRangesFile)
+		if err != nil {
+			fail("fastly.ip_ranges_file (FASTLY_IP_RANGES_FILE): %v", err)
+		}
+		cfg.fastlyRanges = ranges
+	}
+
+	switch {
+	case cfg.forwardMaxBytes == 0:
+		cfg.forwardMaxBytes = 8388608
+	case cfg.forwardMaxBytes < 1024:
+		fail("forward.max_chunk_bytes (FORWARD_MAX_CHUNK_BYTES): must be at least 1024, got %d", cfg.forwardMaxBytes)
+	}
+	switch {
+	case cfg.forwardIdle == 0:
+		cfg.forwardIdle = 5 * time.Minute
+	case cfg.forwardIdle < 0:
+		fail("forward.idle_timeout_ms (FORWARD_IDLE_TIMEOUT_MS): must be positive, got %d", fc.Forward.IdleTimeoutMS)
+	}
+	if cfg.forwardAddr != "" && cfg.forwardHostname == "" {
+		cfg.forwardHostname, _ = os.Hostname()
+	}
+
+	level, err := drain.ParseLevel(orDefault(fc.Drain.MinLevel, "error"))
+	if err != nil {
+		fail("drain.min_level (DRAIN_MIN_LEVEL): %v", err)
+	}
+	cfg.drainFilter.MinLevel = level
+	if fc.Drain.Match != "" {
+		re, err := regexp.Compile(fc.Drain.Match)
+		if err != nil {
+			fail("drain.match (DRAIN_MATCH): %v", err)
+		}
+		cfg.drainFilter.Match = re
+	}
+
+	cfg.timestamps = timestamp.Policy{
+		MaxPast:   30 * 24 * time.Hour,
+		MaxFuture: time.Minute,
+	}
+	if fc.Timestamps.MaxPastMS != nil {
+		if *fc.Timestamps.MaxPastMS < 0 {
+			fail("timestamps.max_past_ms (TIMESTAMP_MAX_PAST_MS): must not be negative")
+		}
+		cfg.timestamps.MaxPast = time.Duration(*fc.Timestamps.MaxPastMS) * time.Millisecond
+	}
+	if fc.Timestamps.MaxFutureMS != nil {
+		if *fc.Timestamps.MaxFutureMS < 0 {
+			fail("timestamps.max_future_ms (TIMESTAMP_MAX_FUTURE_MS): must not be negative")
+		}
+		cfg.timestamps.MaxFuture = time.Duration(*fc.Timestamps.MaxFutureMS) * time.Millisecond
+	}
+	switch strings.ToLower(fc.Timestamps.SkewAction) {
+	case "", "clamp":
+	case "reject":
+		cfg.timestamps.Reject = true
+	default:
+		fail("timestamps.skew_action (TIMESTAMP_SKEW_ACTION): must be clamp or reject, got %q", fc.Timestamps.SkewAction)
+	}
+
+	switch {
+	case fc.Mapping != nil && fc.HTTP.MappingFile != "":
+		fail("mapping and http.mapping_file (HTTP_MAPPING_FILE) are mutually exclusive")
+	case fc.Mapping != nil:
+		m, err := mapping.Compile(*fc.Mapping)
+		if err != nil {
+			fail("mapping: %v", err)
+		}
+		cfg.mapping = m
+	case fc.HTTP.MappingFile != "":
+		m, err := mapping.Load(fc.HTTP.MappingFile)
+		if err != nil {
+			fail("http.mapping_file (HTTP_MAPPING_FILE): %v", err)
+		}
+		cfg.mapping = m
+	}
+
+	if fc.HTTP.RoutesFile != "" {
+		routes, err := loadRoutes(fc.HTTP.RoutesFile)
+		if err != nil {
+			fail("http.routes_file (HTTP_ROUTES_FILE): %v", err)
+		}
+		cfg.routes = append(cfg.routes, routes...)
+	}
+
+	if fc.HTTP.TokensFile != "" {
+		store, err := tokens.Load(fc.HTTP.TokensFile)
+		if err != nil {
+			fail("http.tokens_file (HTTP_TOKENS_FILE): %v", err)
+		}
+		cfg.tokenStore = store
+	}
+
+	cfg.jwtClaims = jwtClaims{
+		tags:     fc.JWT.ClaimTags,
+		userID:   fc.JWT.UserIDClaim,
+		email:    fc.JWT.UserEmailClaim,
+		username: fc.JWT.UsernameClaim,
+	}
+	leeway := int64(60000)
+	if fc.JWT.LeewayMS != nil {
+		leeway = *fc.JWT.LeewayMS
+	}
+	switch {
+	case leeway < 0:
+		fail("jwt.leeway_ms (JWT_LEEWAY_MS): must not be negative")
+	case fc.JWT.JWKSFile != "":
+		v, err := jwt.New(fc.JWT.JWKSFile, fc.JWT.Issuer, fc.JWT.Audience, time.Duration(leeway)*time.Millisecond)
+		if err != nil {
+			fail("jwt.jwks_file (JWT_JWKS_FILE): %v", err)
+		}
+		cfg.jwt = v
+	case fc.JWT.Issuer != "" || len(fc.JWT.Audience) > 0 || !cfg.jwtClaims.empty():
+		fail("jwt settings are set but jwt.jwks_file (JWT_JWKS_FILE) is empty")
+	}
+
+	return cfg
+}
+
+// parseCIDR parses a CIDR block or a single IP address.
+func parseCIDR(value string) (*net.IPNet, error) {
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", value)
+		}
+		bits := 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q", value)
+	}
+	return network, nil
+}
+
+func orDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+func envString(key string, dst *string) {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		*dst = value
+	}
+}
+
+// envList reads a comma separated list.
+func envList(key string, dst *[]string) {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return
+	}
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	*dst = list
+}
+
+func envBool(key string, dst *bool, errs *[]error) {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s: %q is not a boolean", key, value))
+		return
+	}
+	*dst = parsed
+}
+
+func envInt(key string, dst *int, errs *[]error) {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s: %q is not an integer", key, value))
+		return
+	}
+	*dst = parsed
+}
+
+func envInt64(key string, dst *int64, errs *[]error) {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s: %q is not an integer", key, value))
+		return
+	}
+	*dst = parsed
+}
+
+func envInt64Ptr(key string, dst **int64, errs *[]error) {
+	var parsed int64
+	before := len(*errs)
+	if strings.TrimSpace(os.Getenv(key)) == "" {
+		return
+	}
+	envInt64(key, &parsed, errs)
+	if len(*errs) == before {
+		*dst = &parsed
+	}
+}
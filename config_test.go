@@ -0,0 +1,263 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filename, []byte(content), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	return filename
+}
+
+func TestLoadConfigFileWithEnvOverrides(t *testing.T) {
+	filename := writeConfig(t, "config.yaml", `
+sentry:
+  dsn: http://public@127.0.0.1:1/1
+  environment: staging
+http:
+  path: logs
+  max_body_bytes: 4096
+timestamps:
+  max_future_ms: 0
+  skew_action: reject
+mapping:
+  message: [msg, message]
+routes:
+  - path: /billing
+    auth_tokens: [secret]
+`)
+	t.Setenv("SENTRY_ENVIRONMENT", "production")
+
+	cfg, err := loadConfig(filename)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if cfg.sentryEnvironment != "production" {
+		t.Fatalf("expected env override, got %q", cfg.sentryEnvironment)
+	}
+	if cfg.httpPath != "/logs" || cfg.maxBodyBytes != 4096 {
+		t.Fatalf("unexpected http settings: %q %d", cfg.httpPath, cfg.maxBodyBytes)
+	}
+	if cfg.timestamps.MaxFuture != 0 || cfg.timestamps.MaxPast != 30*24*time.Hour || !cfg.timestamps.Reject {
+		t.Fatalf("unexpected timestamp policy: %+v", cfg.timestamps)
+	}
+	if cfg.mapping == nil || len(cfg.routes) != 1 || cfg.routes[0].AuthTokens[0] != "secret" {
+		t.Fatalf("expected mapping and routes from file")
+	}
+}
+
+func TestLoadConfigTOML(t *testing.T) {
+	filename := writeConfig(t, "config.toml", `
+[sentry]
+queue_size = 50
+
+[[routes]]
+path = "/edge"
+parser = "fastly"
+`)
+
+	cfg, err := loadConfig(filename)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if cfg.sentryQueueSize != 50 || len(cfg.routes) != 1 || cfg.routes[0].Parser != "fastly" {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+}
+
+func TestLoadConfigReportsAllErrors(t *testing.T) {
+	filename := writeConfig(t, "config.yaml", `
+http:
+  max_body_bytes: 10
+https:
+  addr: 0.0.0.0:8443
+  client_auth: sometimes
+timestamps:
+  skew_action: ignore
+drain:
+  match: "("
+`)
+	t.Setenv("SENTRY_QUEUE_SIZE", "lots")
+	t.Setenv("DRAIN_MIN_LEVEL", "loud")
+	t.Setenv("JWT_ISSUER", "https://gateway.internal")
+	t.Setenv("HTTP_TRUSTED_PROXIES", "10.0.0.0/99")
+
+	_, err := loadConfig(filename)
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	for _, want := range []string{"SENTRY_QUEUE_SIZE", "max_body_bytes", "cert_file", "skew_action", "DRAIN_MIN_LEVEL", "DRAIN_MATCH", "HTTPS_CLIENT_AUTH", "JWT_JWKS_FILE", "HTTP_TRUSTED_PROXIES"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected %q in error, got: %v", want, err)
+		}
+	}
+
+	filename = writeConfig(t, "typo.yaml", "sentry:\n  dns: http://public@127.0.0.1:1/1\n")
+	if _, err := loadConfig(filename); err == nil || !strings.Contains(err.Error(), "dns") {
+		t.Fatalf("expected unknown field error, got %v", err)
+	}
+}
+
+func TestServerReloadSwapsRoutesAndKeepsSinks(t *testing.T) {
+	filename := writeConfig(t, "config.yaml", "sentry:\n  dsn: http://public@127.0.0.1:1/1\n")
+	cfg, err := loadConfig(filename)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	srv, err := newServer(filename, cfg)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	defer srv.close()
+	before := srv.current.routes[0].sink
+
+	rw := httptest.NewRecorder()
+	srv.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/billing", strings.NewReader("hello")))
+	if rw.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 before reload, got %d", rw.Code)
+	}
+
+	if err := os.WriteFile(filename, []byte("sentry:\n  dsn: http://public@127.0.0.1:1/1\nroutes:\n  - path: /billing\n"), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	if err := srv.reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if srv.current.routes[0].sink != before {
+		t.Fatalf("expected the unchanged sink to be reused")
+	}
+
+	rw = httptest.NewRecorder()
+	srv.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/billing", strings.NewReader("hello")))
+	if rw.Code != http.StatusAccepted {
+		t.Fatalf("expected 202 after reload, got %d", rw.Code)
+	}
+
+	active := srv.current
+	if err := os.WriteFile(filename, []byte("routes: [{path: /x, parser: nope}]\n"), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	if err := srv.reload(); err == nil {
+		t.Fatalf("expected invalid reload to fail")
+	}
+	if srv.current != active {
+		t.Fatalf("expected previous configuration to stay active")
+	}
+}
+
+func TestServerReloadDeliversEventsSpooledForOldDSN(t *testing.T) {
+	sentryServer := func(rateLimitFirst bool) (*httptest.Server, chan string) {
+		received := make(chan string, 10)
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if rateLimitFirst && calls.Add(1) == 1 {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			data, _ := io.ReadAll(r.Body)
+			received <- string(data)
+		}))
+		t.Cleanup(srv.Close)
+		return srv, received
+	}
+	dsn := func(srv *httptest.Server, project string) string {
+		return strings.Replace(srv.URL, "http://", "http://public@", 1) + "/" + project
+	}
+	// The old project rate limits the first delivery, so its event is still
+	// spooled when the reload changes the DSN.
+	oldSentry, oldReceived := sentryServer(true)
+	newSentry, newReceived := sentryServer(false)
+
+	dir := t.TempDir()
+	config := func(dsn string) string {
+		return "sentry:\n  flush_timeout_ms: 5000\nspool:\n  dir: " + dir + "\nroutes:\n  - path: /billing\n    sentry_dsn: " + dsn + "\n"
+	}
+	filename := writeConfig(t, "config.yaml", config(dsn(oldSentry, "1")))
+	cfg, err := loadConfig(filename)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	srv, err := newServer(filename, cfg)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	defer srv.close()
+	post := func(message string) {
+		t.Helper()
+		rw := httptest.NewRecorder()
+		srv.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/billing", strings.NewReader(message)))
+		if rw.Code != http.StatusAccepted {
+			t.Fatalf("expected 202, got %d", rw.Code)
+		}
+	}
+	post("pending for the old project")
+	oldDir := listenerRoute(srv.current.routes, "billing").key.spoolDir
+
+	if err := os.WriteFile(filename, []byte(config(dsn(newSentry, "2"))), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	if err := srv.reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if newDir := listenerRoute(srv.current.routes, "billing").key.spoolDir; newDir == oldDir {
+		t.Fatalf("expected the new DSN to spool to its own directory, got %s", newDir)
+	}
+	// The reload retired the old sink after draining its spool.
+	select {
+	case body := <-oldReceived:
+		if !strings.Contains(body, "pending for the old project") {
+			t.Fatalf("unexpected event at the old DSN: %q", body)
+		}
+	default:
+		t.Fatalf("expected the spooled event to reach the old DSN before the reload returned")
+	}
+
+	post("sent to the new project")
+	select {
+	case body := <-newReceived:
+		if !strings.Contains(body, "sent to the new project") {
+			t.Fatalf("expected only the new event at the new DSN, got %q", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for the new DSN")
+	}
+}
+
+func TestProtocolPathsAreOptIn(t *testing.T) {
+	filename := writeConfig(t, "config.yaml", "sentry:\n  dsn: http://public@127.0.0.1:1/1\n")
+	cfg, err := loadConfig(filename)
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	routes, err := planRoutes(cfg)
+	if err != nil {
+		t.Fatalf("plan routes: %v", err)
+	}
+	if len(routes) != 1 || routes[0].path != "/ingest" {
+		t.Fatalf("expected only the generic route by default, got %d routes", len(routes))
+	}
+
+	filename = writeConfig(t, "config.yaml", "sentry:\n  dsn: http://public@127.0.0.1:1/1\nhttp:\n  hec_path: services/collector\n")
+	if cfg, err = loadConfig(filename); err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	if routes, err = planRoutes(cfg); err != nil {
+		t.Fatalf("plan routes: %v", err)
+	}
+	if len(routes) != 2 || routes[1].path != "/services/collector" || routes[1].parser != "hec" {
+		t.Fatalf("expected the hec route to be added, got %d routes", len(routes))
+	}
+}
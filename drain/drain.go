@@ -0,0 +1,105 @@
+// Package drain implements the log drain endpoints of PaaS providers:
+// Heroku Logplex, Vercel and Netlify. Drains forward every line an app
+// writes, so each handler captures only the lines its Filter keeps.
+package drain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+	"http-to-sentry-go/internal/logevent"
+)
+
+// Filter selects the drained lines that become events.
+type Filter struct {
+	// MinLevel is the lowest level captured. Empty captures every level.
+	MinLevel sentry.Level
+	// Match, if set, also captures lines below MinLevel whose message
+	// matches.
+	Match *regexp.Regexp
+}
+
+var levelRank = map[sentry.Level]int{
+	sentry.LevelDebug:   0,
+	sentry.LevelInfo:    1,
+	sentry.LevelWarning: 2,
+	sentry.LevelError:   3,
+	sentry.LevelFatal:   4,
+}
+
+// Keep reports whether event passes the filter. Events with an exception,
+// such as lines holding a stack trace, are always kept.
+func (f Filter) Keep(event *sentry.Event) bool {
+	if f.MinLevel == "" || levelRank[event.Level] >= levelRank[f.MinLevel] || len(event.Exception) > 0 {
+		return true
+	}
+	return f.Match != nil && f.Match.MatchString(event.Message)
+}
+
+// ParseLevel parses a Filter level: debug, info, warning, error or fatal.
+func ParseLevel(level string) (sentry.Level, error) {
+	l := sentry.Level(strings.ToLower(strings.TrimSpace(level)))
+	if _, ok := levelRank[l]; !ok {
+		return "", fmt.Errorf("unknown level %q, expected debug, info, warning, error or fatal", level)
+	}
+	return l, nil
+}
+
+// logfmtLevel matches the level of logfmt lines, including the "at" key of
+// Heroku router and platform lines.
+var logfmtLevel = regexp.MustCompile(`(?:^|\s)(?:level|lvl|severity|at)=["']?([A-Za-z]+)`)
+
+// lineLevel detects the level of an application log line: a logfmt level
+// key or the level field of a JSON line.
+func lineLevel(line string) (sentry.Level, bool) {
+	if m := logfmtLevel.FindStringSubmatch(line); m != nil {
+		return logevent.ParseLevel(m[1])
+	}
+	if strings.HasPrefix(line, "{") {
+		var fields struct {
+			Level    string `json:"level"`
+			Severity string `json:"severity"`
+		}
+		if json.Unmarshal([]byte(line), &fields) == nil {
+			if level, ok := logevent.ParseLevel(fields.Level); ok {
+				return level, true
+			}
+			return logevent.ParseLevel(fields.Severity)
+		}
+	}
+	return "", false
+}
+
+// decodeEntries splits a JSON array or newline delimited JSON body into its
+// entries.
+func decodeEntries(body []byte) ([]json.RawMessage, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil, errors.New("request body is required")
+	}
+	if body[0] == '[' {
+		var entries []json.RawMessage
+		if err := json.Unmarshal(body, &entries); err != nil {
+			return nil, err
+		}
+		return entries, nil
+	}
+
+	var entries []json.RawMessage
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(body)+1)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		entries = append(entries, json.RawMessage(append([]byte(nil), line...)))
+	}
+	return entries, scanner.Err()
+}
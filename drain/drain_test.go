@@ -0,0 +1,280 @@
+package drain
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"http-to-sentry-go/internal/ingest"
+	"http-to-sentry-go/timestamp"
+)
+
+type recorder struct {
+	events  []*sentry.Event
+	invalid int
+}
+
+func (r *recorder) capture(_ context.Context, event *sentry.Event) (*sentry.EventID, error) {
+	r.events = append(r.events, event)
+	return &event.EventID, nil
+}
+
+func (r *recorder) report(count int) { r.invalid += count }
+
+var anyTime = timestamp.Policy{MaxPast: 100 * 365 * 24 * time.Hour}
+
+func frame(msg string) string {
+	return strconv.Itoa(len(msg)) + " " + msg
+}
+
+func TestHerokuHandleLogplex(t *testing.T) {
+	rec := &recorder{}
+	h := Heroku{
+		Options: ingest.Options{
+			Capture:    rec.capture,
+			Timestamps: anyTime,
+			Invalid:    rec.report,
+		},
+		Filter: Filter{MinLevel: sentry.LevelError, Match: regexp.MustCompile(`code=H\d+`)},
+	}
+
+	body := frame("<190>1 2026-01-29T11:41:12.500000+00:00 host app web.1 - level=error msg=\"charge failed\"") +
+		frame("<158>1 2026-01-29T11:41:13+00:00 host heroku router - at=error code=H12 desc=\"Request timeout\"") +
+		frame("<190>1 2026-01-29T11:41:14+00:00 host app web.1 - GET /health 200") +
+		frame("<158>1 2026-01-29T11:41:15+00:00 host heroku router - at=info code=H18 desc=\"Server Request Interrupted\"")
+	req := httptest.NewRequest(http.MethodPost, "/heroku", strings.NewReader(body))
+	req.Header.Set("Logplex-Msg-Count", "4")
+	req.Header.Set("Logplex-Drain-Token", "d.8f2c")
+	rw := httptest.NewRecorder()
+	h.HandleLogplex(rw, req)
+
+	if rw.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want 204 (%s)", rw.Code, rw.Body.String())
+	}
+	if rec.invalid != 0 {
+		t.Fatalf("invalid = %d, want 0", rec.invalid)
+	}
+	if len(rec.events) != 3 {
+		t.Fatalf("captured %d events, want 3", len(rec.events))
+	}
+
+	app := rec.events[0]
+	if app.Logger != "heroku" || app.Level != sentry.LevelError {
+		t.Fatalf("logger/level = %s/%s", app.Logger, app.Level)
+	}
+	if app.Tags["source"] != "app" || app.Tags["dyno"] != "web.1" || app.Tags["drain_token"] != "d.8f2c" {
+		t.Fatalf("tags = %v", app.Tags)
+	}
+	if _, ok := app.Tags["host"]; ok {
+		t.Fatalf("placeholder host tag should be dropped: %v", app.Tags)
+	}
+	if !app.Timestamp.Equal(time.Date(2026, 1, 29, 11, 41, 12, 500000000, time.UTC)) {
+		t.Fatalf("timestamp = %s", app.Timestamp)
+	}
+
+	router := rec.events[1]
+	if router.Tags["source"] != "heroku" || router.Tags["dyno"] != "router" || router.Level != sentry.LevelError {
+		t.Fatalf("router event = %s %v", router.Level, router.Tags)
+	}
+	if matched := rec.events[2]; matched.Level != sentry.LevelInfo || !strings.Contains(matched.Message, "H18") {
+		t.Fatalf("matched event = %s %q", matched.Level, matched.Message)
+	}
+}
+
+func TestHerokuRejectsBadFraming(t *testing.T) {
+	for name, tc := range map[string]struct {
+		body  string
+		count string
+	}{
+		"count mismatch": {frame("<190>1 2026-01-29T11:41:12+00:00 host app web.1 - hi"), "2"},
+		"bad length":     {"999 <190>1 - host app web.1 - hi", ""},
+		"no length":      {"<190>1 - host app web.1 - hi", ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rec := &recorder{}
+			h := Heroku{Options: ingest.Options{Capture: rec.capture, Invalid: rec.report}}
+			req := httptest.NewRequest(http.MethodPost, "/heroku", strings.NewReader(tc.body))
+			if tc.count != "" {
+				req.Header.Set("Logplex-Msg-Count", tc.count)
+			}
+			rw := httptest.NewRecorder()
+			h.HandleLogplex(rw, req)
+			if rw.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400", rw.Code)
+			}
+			if len(rec.events) != 0 || rec.invalid != 1 {
+				t.Fatalf("events = %d, invalid = %d", len(rec.events), rec.invalid)
+			}
+		})
+	}
+}
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVercelHandleLogs(t *testing.T) {
+	rec := &recorder{}
+	h := Vercel{
+		Options: ingest.Options{
+			Capture:    rec.capture,
+			Timestamps: anyTime,
+			Invalid:    rec.report,
+		},
+		Filter:      Filter{MinLevel: sentry.LevelWarning},
+		Secrets:     []string{"old", "current"},
+		VerifyToken: "verify-me",
+	}
+
+	body := `[
+{"id":"1","message":"TypeError: cannot read properties of undefined","timestamp":1769686872500,"type":"stderr","source":"lambda","projectId":"prj_1","projectName":"shop","deploymentId":"dpl_9","host":"shop.vercel.app","path":"/api/cart","requestId":"req-1","environment":"production","branch":"main"},
+{"id":"2","timestamp":1769686873000,"type":"request","source":"edge","projectId":"prj_1","deploymentId":"dpl_9","host":"shop.vercel.app","proxy":{"method":"POST","host":"shop.example.com","path":"/checkout","statusCode":502,"clientIp":"203.0.113.9","region":"fra1","userAgent":["curl/8.0"]}},
+{"id":"3","message":"rendered page","timestamp":1769686874000,"type":"stdout","source":"lambda","deploymentId":"dpl_9"},
+"not an object"
+]`
+	req := httptest.NewRequest(http.MethodPost, "/vercel", strings.NewReader(body))
+	req.Header.Set("x-vercel-signature", sign("current", body))
+	rw := httptest.NewRecorder()
+	h.HandleLogs(rw, req)
+
+	if rw.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 (%s)", rw.Code, rw.Body.String())
+	}
+	if rw.Header().Get("x-vercel-verify") != "verify-me" {
+		t.Fatalf("x-vercel-verify = %q", rw.Header().Get("x-vercel-verify"))
+	}
+	if rec.invalid != 1 {
+		t.Fatalf("invalid = %d, want 1", rec.invalid)
+	}
+	if len(rec.events) != 2 {
+		t.Fatalf("captured %d events, want 2", len(rec.events))
+	}
+
+	fn := rec.events[0]
+	if fn.Logger != "vercel" || fn.Level != sentry.LevelError || fn.Environment != "production" {
+		t.Fatalf("logger/level/env = %s/%s/%s", fn.Logger, fn.Level, fn.Environment)
+	}
+	want := map[string]string{"source": "lambda", "project": "shop", "deployment_id": "dpl_9", "host": "shop.vercel.app", "request_id": "req-1", "branch": "main"}
+	for key, value := range want {
+		if fn.Tags[key] != value {
+			t.Fatalf("tag %s = %q, want %q (%v)", key, fn.Tags[key], value, fn.Tags)
+		}
+	}
+	if fn.Request == nil || fn.Request.URL != "https://shop.vercel.app/api/cart" {
+		t.Fatalf("request = %+v", fn.Request)
+	}
+	if !fn.Timestamp.Equal(time.UnixMilli(1769686872500)) {
+		t.Fatalf("timestamp = %s", fn.Timestamp)
+	}
+
+	proxy := rec.events[1]
+	if proxy.Level != sentry.LevelError || proxy.Tags["status_code"] != "502" || proxy.Tags["region"] != "fra1" {
+		t.Fatalf("proxy event = %s %v", proxy.Level, proxy.Tags)
+	}
+	if proxy.Message != "POST /checkout 502" || proxy.Request.URL != "https://shop.example.com/checkout" || proxy.User.IPAddress != "203.0.113.9" {
+		t.Fatalf("proxy message/request/ip = %q %+v %q", proxy.Message, proxy.Request, proxy.User.IPAddress)
+	}
+}
+
+func TestVercelRejectsBadSignature(t *testing.T) {
+	rec := &recorder{}
+	h := Vercel{Options: ingest.Options{Capture: rec.capture}, Secrets: []string{"current"}}
+	body := `[{"message":"boom","type":"stderr"}]`
+	for _, signature := range []string{"", "zz", sign("other", body)} {
+		req := httptest.NewRequest(http.MethodPost, "/vercel", strings.NewReader(body))
+		req.Header.Set("x-vercel-signature", signature)
+		rw := httptest.NewRecorder()
+		h.HandleLogs(rw, req)
+		if rw.Code != http.StatusForbidden {
+			t.Fatalf("signature %q: status = %d, want 403", signature, rw.Code)
+		}
+	}
+	if len(rec.events) != 0 {
+		t.Fatalf("captured %d events, want 0", len(rec.events))
+	}
+}
+
+func TestNetlifyHandleLogs(t *testing.T) {
+	rec := &recorder{}
+	h := Netlify{
+		Options: ingest.Options{
+			Capture:    rec.capture,
+			Timestamps: anyTime,
+			Invalid:    rec.report,
+		},
+		Filter: Filter{MinLevel: sentry.LevelError},
+	}
+
+	body := `{"type":"functions","level":"error","message":"unhandled rejection","timestamp":"2026-01-29T11:41:12.5Z","deploy_id":"d1","site_name":"shop","function_name":"cart","request_id":"01H"}
+{"log_type":"traffic","method":"GET","url":"https://shop.netlify.app/api","status_code":503,"client_ip":"203.0.113.9","timestamp":"2026-01-29T11:41:13Z","deploy_id":"d1"}
+{"type":"functions","level":"info","message":"ok","timestamp":"2026-01-29T11:41:14Z"}
+{broken
+`
+	req := httptest.NewRequest(http.MethodPost, "/netlify", strings.NewReader(body))
+	rw := httptest.NewRecorder()
+	h.HandleLogs(rw, req)
+
+	if rw.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 (%s)", rw.Code, rw.Body.String())
+	}
+	if rec.invalid != 1 {
+		t.Fatalf("invalid = %d, want 1", rec.invalid)
+	}
+	if len(rec.events) != 2 {
+		t.Fatalf("captured %d events, want 2", len(rec.events))
+	}
+
+	fn := rec.events[0]
+	if fn.Logger != "netlify" || fn.Level != sentry.LevelError || fn.Message != "unhandled rejection" {
+		t.Fatalf("function event = %s %s %q", fn.Logger, fn.Level, fn.Message)
+	}
+	if fn.Tags["source"] != "functions" || fn.Tags["deploy_id"] != "d1" || fn.Tags["site"] != "shop" || fn.Tags["function"] != "cart" || fn.Tags["request_id"] != "01H" {
+		t.Fatalf("tags = %v", fn.Tags)
+	}
+	if !fn.Timestamp.Equal(time.Date(2026, 1, 29, 11, 41, 12, 500000000, time.UTC)) {
+		t.Fatalf("timestamp = %s", fn.Timestamp)
+	}
+
+	traffic := rec.events[1]
+	if traffic.Message != "GET https://shop.netlify.app/api 503" || traffic.Tags["host"] != "shop.netlify.app" || traffic.Tags["status_code"] != "503" {
+		t.Fatalf("traffic event = %q %v", traffic.Message, traffic.Tags)
+	}
+	if traffic.Request == nil || traffic.Request.Method != "GET" || traffic.User.IPAddress != "203.0.113.9" {
+		t.Fatalf("traffic request/ip = %+v %q", traffic.Request, traffic.User.IPAddress)
+	}
+}
+
+func TestFilterKeep(t *testing.T) {
+	f := Filter{MinLevel: sentry.LevelWarning, Match: regexp.MustCompile(`(?i)timeout`)}
+	for _, tc := range []struct {
+		event *sentry.Event
+		want  bool
+	}{
+		{&sentry.Event{Level: sentry.LevelError}, true},
+		{&sentry.Event{Level: sentry.LevelWarning}, true},
+		{&sentry.Event{Level: sentry.LevelInfo, Message: "ok"}, false},
+		{&sentry.Event{Level: sentry.LevelInfo, Message: "upstream Timeout"}, true},
+		{&sentry.Event{Level: sentry.LevelInfo, Exception: []sentry.Exception{{Type: "Error"}}}, true},
+	} {
+		if got := f.Keep(tc.event); got != tc.want {
+			t.Fatalf("Keep(%s %q) = %v, want %v", tc.event.Level, tc.event.Message, got, tc.want)
+		}
+	}
+	if !(Filter{}).Keep(&sentry.Event{Level: sentry.LevelDebug}) {
+		t.Fatal("empty filter should keep everything")
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Fatal("ParseLevel accepted an unknown level")
+	}
+}
@@ -0,0 +1,143 @@
+package drain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"http-to-sentry-go/internal/ingest"
+	"http-to-sentry-go/internal/logevent"
+	"http-to-sentry-go/reqbody"
+	"http-to-sentry-go/stacktrace"
+	"http-to-sentry-go/syslog"
+	"http-to-sentry-go/timestamp"
+)
+
+type Heroku struct {
+	ingest.Options
+	Filter Filter
+}
+
+// HandleLogplex serves a Heroku HTTPS log drain. Logplex posts batches of
+// octet-counted syslog messages ("LEN MSG") and announces their number in
+// the Logplex-Msg-Count header.
+func (h Heroku) HandleLogplex(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	maxBytes := h.BodyLimit(1048576)
+	body, err := reqbody.Read(w, r, maxBytes)
+	if err != nil {
+		status := reqbody.Status(err)
+		if status == http.StatusBadRequest {
+			h.ReportInvalid(1)
+		}
+		w.WriteHeader(status)
+		return
+	}
+
+	frames, err := splitFrames(body)
+	if err == nil {
+		if count := r.Header.Get("Logplex-Msg-Count"); count != "" && count != strconv.Itoa(len(frames)) {
+			err = fmt.Errorf("Logplex-Msg-Count is %s but the body has %d messages", count, len(frames))
+		}
+	}
+	if err != nil {
+		h.ReportInvalid(1)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	invalid := 0
+	for _, frame := range frames {
+		msg, err := syslog.ParseLogplex(frame)
+		if err != nil {
+			invalid++
+			continue
+		}
+		event, err := buildHerokuEvent(msg, r, h.Timestamps)
+		if err != nil {
+			invalid++
+			continue
+		}
+		if !h.Filter.Keep(event) {
+			continue
+		}
+		if _, err := h.CaptureEvent(r.Context(), event); err != nil {
+			h.ReportInvalid(invalid)
+			ingest.WriteError(w, err)
+			return
+		}
+	}
+	h.ReportInvalid(invalid)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// splitFrames splits an octet-counted body into its messages.
+func splitFrames(body []byte) ([][]byte, error) {
+	var frames [][]byte
+	for {
+		body = bytes.TrimLeft(body, " \r\n")
+		if len(body) == 0 {
+			return frames, nil
+		}
+		space := bytes.IndexByte(body, ' ')
+		if space < 1 {
+			return nil, errors.New("invalid octet count")
+		}
+		n, err := strconv.Atoi(string(body[:space]))
+		if err != nil || n < 0 || n > len(body)-space-1 {
+			return nil, fmt.Errorf("invalid octet count %q", body[:space])
+		}
+		body = body[space+1:]
+		frames = append(frames, body[:n])
+		body = body[n:]
+	}
+}
+
+// buildHerokuEvent maps a Logplex message. The app name is the source
+// ("app" or "heroku") and the process ID the dyno. It fails only for
+// timestamps the policy rejects.
+func buildHerokuEvent(msg *syslog.Message, r *http.Request, policy timestamp.Policy) (*sentry.Event, error) {
+	event := logevent.New("heroku", r)
+	event.Message = msg.Message
+	if event.Message == "" {
+		event.Message = "(empty message)"
+	}
+
+	// Logplex sends most lines with one priority, so a level in the line
+	// wins over the severity.
+	event.Level = logevent.SeverityLevel(msg.Severity)
+	if level, ok := lineLevel(msg.Message); ok {
+		event.Level = level
+	}
+
+	logevent.AddTag(event.Tags, "source", msg.AppName)
+	logevent.AddTag(event.Tags, "dyno", msg.ProcID)
+	logevent.AddTag(event.Tags, "drain_token", r.Header.Get("Logplex-Drain-Token"))
+	if msg.Hostname != "host" {
+		logevent.AddTag(event.Tags, "host", msg.Hostname)
+	}
+
+	if !msg.Timestamp.IsZero() {
+		event.Extra["logplex_timestamp"] = msg.Timestamp.Format(time.RFC3339Nano)
+		ts, clamped, err := policy.Apply(msg.Timestamp, event.Timestamp)
+		if errors.Is(err, timestamp.ErrOutOfRange) {
+			return nil, err
+		}
+		event.Timestamp = ts
+		if clamped {
+			event.Extra["logplex_timestamp_clamped"] = true
+		}
+	}
+
+	stacktrace.Attach(event)
+	return event, nil
+}
@@ -0,0 +1,172 @@
+package drain
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+	"http-to-sentry-go/internal/ingest"
+	"http-to-sentry-go/internal/logevent"
+	"http-to-sentry-go/reqbody"
+	"http-to-sentry-go/stacktrace"
+	"http-to-sentry-go/timestamp"
+)
+
+// netlifyTags are the Netlify log fields kept as tags; the others become
+// extras.
+var netlifyTags = map[string]string{
+	"type":          "source",
+	"log_type":      "source",
+	"deploy_id":     "deploy_id",
+	"site_id":       "site_id",
+	"site_name":     "site",
+	"function_name": "function",
+	"function_type": "function_type",
+	"request_id":    "request_id",
+	"country":       "country",
+	"branch":        "branch",
+	"context":       "deploy_context",
+}
+
+type Netlify struct {
+	ingest.Options
+	Filter Filter
+}
+
+// HandleLogs serves a Netlify log drain delivering NDJSON, or a JSON array.
+func (h Netlify) HandleLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	maxBytes := h.BodyLimit(1048576)
+	body, err := reqbody.Read(w, r, maxBytes)
+	if err != nil {
+		status := reqbody.Status(err)
+		if status == http.StatusBadRequest {
+			h.ReportInvalid(1)
+		}
+		w.WriteHeader(status)
+		return
+	}
+	entries, err := decodeEntries(body)
+	if err != nil {
+		h.ReportInvalid(1)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	invalid := 0
+	for _, raw := range entries {
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		var entry map[string]interface{}
+		if err := dec.Decode(&entry); err != nil || entry == nil {
+			invalid++
+			continue
+		}
+		event, err := buildNetlifyEvent(entry, r, h.Timestamps)
+		if err != nil {
+			invalid++
+			continue
+		}
+		if !h.Filter.Keep(event) {
+			continue
+		}
+		if _, err := h.CaptureEvent(r.Context(), event); err != nil {
+			h.ReportInvalid(invalid)
+			ingest.WriteError(w, err)
+			return
+		}
+	}
+	h.ReportInvalid(invalid)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// buildNetlifyEvent maps a Netlify log entry: function, edge function,
+// deploy and traffic logs. It fails only for timestamps the policy rejects.
+func buildNetlifyEvent(entry map[string]interface{}, r *http.Request, policy timestamp.Policy) (*sentry.Event, error) {
+	event := logevent.New("netlify", r)
+	event.Level = sentry.LevelInfo
+
+	str := func(key string) string {
+		s, _ := entry[key].(string)
+		return s
+	}
+	event.Message = strings.TrimRight(str("message"), "\r\n")
+	status, _ := strconv.Atoi(jsonString(entry["status_code"]))
+
+	for key, value := range entry {
+		switch key {
+		case "message", "level", "timestamp":
+			continue
+		}
+		if tag, ok := netlifyTags[key]; ok {
+			logevent.AddTag(event.Tags, tag, jsonString(value))
+			continue
+		}
+		event.Extra[key] = value
+	}
+	if status != 0 {
+		event.Tags["status_code"] = strconv.Itoa(status)
+	}
+
+	if rawURL := str("url"); rawURL != "" {
+		event.Request = &sentry.Request{Method: str("method"), URL: rawURL}
+		if u, err := url.Parse(rawURL); err == nil {
+			logevent.AddTag(event.Tags, "host", u.Host)
+		}
+		if event.Message == "" {
+			event.Message = strings.TrimSpace(str("method") + " " + rawURL + " " + jsonString(entry["status_code"]))
+		}
+	}
+	if ip := str("client_ip"); ip != "" {
+		event.User.IPAddress = ip
+	}
+
+	if level, ok := logevent.ParseLevel(str("level")); ok {
+		event.Level = level
+	} else if status >= 500 {
+		event.Level = sentry.LevelError
+	} else if level, ok := lineLevel(event.Message); ok {
+		event.Level = level
+	}
+
+	if raw := jsonString(entry["timestamp"]); raw != "" {
+		event.Extra["netlify_timestamp"] = raw
+		ts, clamped, err := policy.Resolve(raw, event.Timestamp)
+		if errors.Is(err, timestamp.ErrOutOfRange) {
+			return nil, err
+		}
+		if err == nil {
+			event.Timestamp = ts
+		}
+		if clamped {
+			event.Extra["netlify_timestamp_clamped"] = true
+		}
+	}
+
+	if event.Message == "" {
+		event.Message = "(empty message)"
+	}
+	stacktrace.Attach(event)
+	return event, nil
+}
+
+// jsonString formats a decoded JSON string or number.
+func jsonString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	return ""
+}
@@ -0,0 +1,237 @@
+package drain
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"http-to-sentry-go/internal/ingest"
+	"http-to-sentry-go/internal/logevent"
+	"http-to-sentry-go/reqbody"
+	"http-to-sentry-go/stacktrace"
+	"http-to-sentry-go/timestamp"
+)
+
+// VercelLog is one entry of a Vercel log drain delivery.
+type VercelLog struct {
+	ID           string       `json:"id"`
+	Message      string       `json:"message"`
+	Timestamp    int64        `json:"timestamp"`
+	Type         string       `json:"type"`
+	Source       string       `json:"source"`
+	Level        string       `json:"level"`
+	ProjectID    string       `json:"projectId"`
+	ProjectName  string       `json:"projectName"`
+	DeploymentID string       `json:"deploymentId"`
+	BuildID      string       `json:"buildId"`
+	Host         string       `json:"host"`
+	Path         string       `json:"path"`
+	Entrypoint   string       `json:"entrypoint"`
+	RequestID    string       `json:"requestId"`
+	StatusCode   int          `json:"statusCode"`
+	Environment  string       `json:"environment"`
+	Branch       string       `json:"branch"`
+	Proxy        *VercelProxy `json:"proxy"`
+}
+
+// VercelProxy describes the request of a proxy (edge network) entry.
+type VercelProxy struct {
+	Method     string   `json:"method"`
+	Host       string   `json:"host"`
+	Path       string   `json:"path"`
+	StatusCode int      `json:"statusCode"`
+	ClientIP   string   `json:"clientIp"`
+	Region     string   `json:"region"`
+	UserAgent  []string `json:"userAgent"`
+	Referer    string   `json:"referer"`
+}
+
+type Vercel struct {
+	ingest.Options
+	Filter Filter
+	// Secrets, if set, are the accepted drain secrets. Requests must carry
+	// the hex HMAC-SHA1 of the body in x-vercel-signature.
+	Secrets []string
+	// VerifyToken, if set, is sent in the x-vercel-verify response header,
+	// which Vercel checks when the drain is created.
+	VerifyToken string
+}
+
+// HandleLogs serves a Vercel log drain delivering JSON or NDJSON.
+func (h Vercel) HandleLogs(w http.ResponseWriter, r *http.Request) {
+	if h.VerifyToken != "" {
+		w.Header().Set("x-vercel-verify", h.VerifyToken)
+	}
+	switch r.Method {
+	case http.MethodPost:
+	case http.MethodGet, http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+		return
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	maxBytes := h.BodyLimit(1048576)
+	body, err := reqbody.Read(w, r, maxBytes)
+	if err != nil {
+		status := reqbody.Status(err)
+		if status == http.StatusBadRequest {
+			h.ReportInvalid(1)
+		}
+		w.WriteHeader(status)
+		return
+	}
+	if len(h.Secrets) > 0 && !validSignature(r.Header.Get("x-vercel-signature"), body, h.Secrets) {
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+
+	entries, err := decodeEntries(body)
+	if err != nil {
+		h.ReportInvalid(1)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	invalid := 0
+	for _, raw := range entries {
+		var entry VercelLog
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			invalid++
+			continue
+		}
+		event, err := buildVercelEvent(entry, r, h.Timestamps)
+		if err != nil {
+			invalid++
+			continue
+		}
+		if !h.Filter.Keep(event) {
+			continue
+		}
+		if _, err := h.CaptureEvent(r.Context(), event); err != nil {
+			h.ReportInvalid(invalid)
+			ingest.WriteError(w, err)
+			return
+		}
+	}
+	h.ReportInvalid(invalid)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// validSignature compares the hex HMAC-SHA1 of body under each secret with
+// signature in constant time.
+func validSignature(signature string, body []byte, secrets []string) bool {
+	got, err := hex.DecodeString(strings.TrimSpace(signature))
+	if err != nil || len(got) == 0 {
+		return false
+	}
+	for _, secret := range secrets {
+		mac := hmac.New(sha1.New, []byte(secret))
+		mac.Write(body)
+		if hmac.Equal(got, mac.Sum(nil)) {
+			return true
+		}
+	}
+	return false
+}
+
+// buildVercelEvent maps a Vercel log entry. It fails only for timestamps the
+// policy rejects.
+func buildVercelEvent(entry VercelLog, r *http.Request, policy timestamp.Policy) (*sentry.Event, error) {
+	event := logevent.New("vercel", r)
+	event.Message = strings.TrimRight(entry.Message, "\r\n")
+	event.Environment = entry.Environment
+
+	status := entry.StatusCode
+	if entry.Proxy != nil && entry.Proxy.StatusCode != 0 {
+		status = entry.Proxy.StatusCode
+	}
+	event.Level = vercelLevel(entry, status)
+
+	logevent.AddTag(event.Tags, "source", entry.Source)
+	logevent.AddTag(event.Tags, "type", entry.Type)
+	logevent.AddTag(event.Tags, "project", logevent.FirstNonEmpty(entry.ProjectName, entry.ProjectID))
+	logevent.AddTag(event.Tags, "deployment_id", entry.DeploymentID)
+	logevent.AddTag(event.Tags, "host", entry.Host)
+	logevent.AddTag(event.Tags, "branch", entry.Branch)
+	logevent.AddTag(event.Tags, "request_id", entry.RequestID)
+	logevent.AddTag(event.Tags, "entrypoint", entry.Entrypoint)
+	if status != 0 {
+		event.Tags["status_code"] = strconv.Itoa(status)
+	}
+	if entry.BuildID != "" {
+		event.Extra["build_id"] = entry.BuildID
+	}
+	if entry.ID != "" {
+		event.Extra["vercel_id"] = entry.ID
+	}
+
+	if proxy := entry.Proxy; proxy != nil {
+		logevent.AddTag(event.Tags, "region", proxy.Region)
+		event.Request = &sentry.Request{
+			Method:  proxy.Method,
+			URL:     "https://" + logevent.FirstNonEmpty(proxy.Host, entry.Host) + proxy.Path,
+			Headers: map[string]string{},
+		}
+		if len(proxy.UserAgent) > 0 {
+			event.Request.Headers["User-Agent"] = proxy.UserAgent[0]
+		}
+		if proxy.Referer != "" {
+			event.Request.Headers["Referer"] = proxy.Referer
+		}
+		if proxy.ClientIP != "" {
+			event.User.IPAddress = proxy.ClientIP
+		}
+		if event.Message == "" {
+			event.Message = strings.TrimSpace(proxy.Method + " " + proxy.Path + " " + strconv.Itoa(status))
+		}
+	} else if entry.Path != "" {
+		event.Request = &sentry.Request{URL: "https://" + entry.Host + entry.Path}
+	}
+
+	if entry.Timestamp != 0 {
+		ts := time.UnixMilli(entry.Timestamp).UTC()
+		event.Extra["vercel_timestamp"] = ts.Format(time.RFC3339Nano)
+		ts, clamped, err := policy.Apply(ts, event.Timestamp)
+		if errors.Is(err, timestamp.ErrOutOfRange) {
+			return nil, err
+		}
+		event.Timestamp = ts
+		if clamped {
+			event.Extra["vercel_timestamp_clamped"] = true
+		}
+	}
+
+	if event.Message == "" {
+		event.Message = "(empty message)"
+	}
+	stacktrace.Attach(event)
+	return event, nil
+}
+
+// vercelLevel uses the entry's level, then the response status, then the
+// output stream.
+func vercelLevel(entry VercelLog, status int) sentry.Level {
+	if level, ok := logevent.ParseLevel(entry.Level); ok {
+		return level
+	}
+	switch {
+	case status >= 500:
+		return sentry.LevelError
+	case entry.Type == "stderr":
+		return sentry.LevelError
+	}
+	if level, ok := lineLevel(entry.Message); ok {
+		return level
+	}
+	return sentry.LevelInfo
+}
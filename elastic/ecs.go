@@ -0,0 +1,122 @@
+package elastic
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+	"http-to-sentry-go/internal/logevent"
+	"http-to-sentry-go/mapping"
+	"http-to-sentry-go/stacktrace"
+	"http-to-sentry-go/timestamp"
+)
+
+// ecs maps Elastic Common Schema fields, nested or dotted, to event fields.
+var ecs = mustCompile(mapping.Rules{
+	Message:     ecsPaths("message", "log.original", "event.original"),
+	Level:       ecsPaths("log.level", "level"),
+	Timestamp:   ecsPaths("@timestamp", "timestamp"),
+	Release:     ecsPaths("service.version"),
+	Environment: ecsPaths("service.environment"),
+	Tags: map[string]mapping.Paths{
+		"host.name":            ecsPaths("host.name", "host.hostname"),
+		"service.name":         ecsPaths("service.name"),
+		"event.dataset":        ecsPaths("event.dataset"),
+		"log.logger":           ecsPaths("log.logger"),
+		"container.name":       ecsPaths("container.name"),
+		"kubernetes.namespace": ecsPaths("kubernetes.namespace"),
+		"cloud.region":         ecsPaths("cloud.region"),
+	},
+	User: map[string]mapping.Paths{
+		"id":         ecsPaths("user.id"),
+		"email":      ecsPaths("user.email"),
+		"username":   ecsPaths("user.name"),
+		"ip_address": ecsPaths("client.ip", "source.ip"),
+	},
+	Request: map[string]mapping.Paths{
+		"url":    ecsPaths("url.full", "url.original"),
+		"method": ecsPaths("http.request.method"),
+	},
+	Contexts: map[string]map[string]mapping.Paths{
+		"trace": {
+			"trace_id": ecsPaths("trace.id"),
+			"span_id":  ecsPaths("span.id"),
+		},
+	},
+})
+
+// ecsError reads the ECS error fields.
+var ecsError = mustCompile(mapping.Rules{
+	Message: ecsPaths("error.message"),
+	Extra: map[string]mapping.Paths{
+		"type":        ecsPaths("error.type"),
+		"stack_trace": ecsPaths("error.stack_trace"),
+	},
+})
+
+// ecsPaths returns the paths of ECS fields both as nested objects and as
+// dotted keys, as Beats and Logstash send either.
+func ecsPaths(fields ...string) mapping.Paths {
+	var paths mapping.Paths
+	for _, field := range fields {
+		paths = append(paths, `$["`+field+`"]`)
+		if strings.Contains(field, ".") {
+			paths = append(paths, field)
+		}
+	}
+	return paths
+}
+
+func mustCompile(rules mapping.Rules) *mapping.Mapping {
+	m, err := mapping.Compile(rules)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// buildSentryEvent maps a bulk document. It fails only for timestamps the
+// policy rejects.
+func buildSentryEvent(doc map[string]interface{}, index string, r *http.Request, policy timestamp.Policy) (*sentry.Event, error) {
+	fields := ecs.Extract(doc)
+
+	event := logevent.New("elastic", r)
+	event.Level = logevent.Level(fields.Level)
+	fields.Apply(event)
+	if event.Message == "" {
+		event.Message = "(empty message)"
+	}
+	event.ServerName = event.Tags["host.name"]
+	logevent.AddTag(event.Tags, "index", index)
+
+	if fields.Timestamp != "" {
+		event.Extra["payload_timestamp"] = fields.Timestamp
+		ts, clamped, err := policy.Resolve(fields.Timestamp, event.Timestamp)
+		if errors.Is(err, timestamp.ErrOutOfRange) {
+			return nil, err
+		}
+		if err == nil {
+			event.Timestamp = ts
+		}
+		if clamped {
+			event.Extra["payload_timestamp_clamped"] = true
+		}
+	}
+
+	errFields := ecsError.Extract(doc)
+	typ, _ := errFields.Extra["type"].(string)
+	trace, _ := errFields.Extra["stack_trace"].(string)
+	if exceptions, ok := stacktrace.Extract(trace); ok {
+		event.Exception = exceptions
+	} else if typ != "" || errFields.Message != "" {
+		exc := sentry.Exception{Type: typ, Value: errFields.Message}
+		if exc.Type == "" {
+			exc.Type = "Error"
+		}
+		event.Exception = []sentry.Exception{exc}
+	} else {
+		stacktrace.Attach(event)
+	}
+	return event, nil
+}
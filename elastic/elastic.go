@@ -0,0 +1,258 @@
+// Package elastic implements the parts of the Elasticsearch API that log
+// shippers such as Filebeat, Logstash and Fluent Bit need to deliver
+// documents: the root version probe and the _bulk endpoint. Each indexed
+// document becomes one Sentry event.
+package elastic
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"http-to-sentry-go/internal/ingest"
+	"http-to-sentry-go/reqbody"
+)
+
+// DefaultVersion is the Elasticsearch version reported to clients. Beats
+// refuse to connect to versions older than their own.
+const DefaultVersion = "8.17.0"
+
+type Handler struct {
+	ingest.Options
+	// Version is the Elasticsearch version reported by the root endpoint.
+	Version string
+}
+
+// item is one action of a bulk request.
+type item struct {
+	action string
+	index  string
+	id     string
+	doc    json.RawMessage
+}
+
+// itemResult is the per-item entry of a bulk response.
+type itemResult struct {
+	Index       string       `json:"_index"`
+	ID          string       `json:"_id"`
+	Version     int          `json:"_version,omitempty"`
+	Result      string       `json:"result,omitempty"`
+	Shards      *shards      `json:"_shards,omitempty"`
+	SeqNo       *int         `json:"_seq_no,omitempty"`
+	PrimaryTerm int          `json:"_primary_term,omitempty"`
+	Status      int          `json:"status"`
+	Error       *errorDetail `json:"error,omitempty"`
+}
+
+type shards struct {
+	Total      int `json:"total"`
+	Successful int `json:"successful"`
+	Failed     int `json:"failed"`
+}
+
+type errorDetail struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// Handle serves the bulk endpoint for paths ending in /_bulk and the version
+// probe otherwise.
+func (h Handler) Handle(w http.ResponseWriter, r *http.Request) {
+	// Elasticsearch clients refuse servers without the product header.
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	if strings.HasSuffix(r.URL.Path, "/_bulk") {
+		h.handleBulk(w, r)
+		return
+	}
+	h.handleInfo(w, r)
+}
+
+func (h Handler) handleInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "illegal_argument_exception", fmt.Sprintf("method [%s] is not allowed", r.Method))
+		return
+	}
+	version := h.Version
+	if version == "" {
+		version = DefaultVersion
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"name":         "http-to-sentry",
+		"cluster_name": "http-to-sentry",
+		"cluster_uuid": "http-to-sentry",
+		"version": map[string]interface{}{
+			"number":                              version,
+			"build_flavor":                        "default",
+			"build_type":                          "docker",
+			"minimum_wire_compatibility_version":  "7.17.0",
+			"minimum_index_compatibility_version": "7.0.0",
+		},
+		"tagline": "You Know, for Search",
+	})
+}
+
+func (h Handler) handleBulk(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		writeError(w, http.StatusMethodNotAllowed, "illegal_argument_exception", fmt.Sprintf("method [%s] is not allowed", r.Method))
+		return
+	}
+	started := time.Now()
+
+	maxBytes := h.BodyLimit(1048576)
+	body, err := reqbody.Read(w, r, maxBytes)
+	if err != nil {
+		status := reqbody.Status(err)
+		if status == http.StatusBadRequest {
+			h.ReportInvalid(1)
+		}
+		writeError(w, status, "parse_exception", err.Error())
+		return
+	}
+
+	items, err := parseBulk(body, r.PathValue("index"))
+	if err != nil {
+		h.ReportInvalid(1)
+		writeError(w, http.StatusBadRequest, "illegal_argument_exception", err.Error())
+		return
+	}
+
+	results := make([]map[string]itemResult, 0, len(items))
+	failed, invalid := 0, 0
+	for i, it := range items {
+		res := h.indexItem(it, r, i)
+		if res.Error != nil {
+			failed++
+		}
+		if res.Status == http.StatusBadRequest {
+			invalid++
+		}
+		results = append(results, map[string]itemResult{it.action: res})
+	}
+	h.ReportInvalid(invalid)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"took":   time.Since(started).Milliseconds(),
+		"errors": failed > 0,
+		"items":  results,
+	})
+}
+
+// indexItem captures one bulk item and returns its result.
+func (h Handler) indexItem(it item, r *http.Request, seqNo int) itemResult {
+	res := itemResult{Index: it.index, ID: it.id}
+	fail := func(status int, typ, reason string) itemResult {
+		res.Status = status
+		res.Error = &errorDetail{Type: typ, Reason: reason}
+		return res
+	}
+	if it.action != "index" && it.action != "create" {
+		return fail(http.StatusBadRequest, "illegal_argument_exception", fmt.Sprintf("bulk action [%s] is not supported", it.action))
+	}
+	if it.index == "" {
+		return fail(http.StatusBadRequest, "action_request_validation_exception", "index is missing")
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(it.doc))
+	dec.UseNumber()
+	var doc map[string]interface{}
+	if err := dec.Decode(&doc); err != nil || doc == nil {
+		return fail(http.StatusBadRequest, "document_parsing_exception", "failed to parse document: expected a JSON object")
+	}
+	event, err := buildSentryEvent(doc, it.index, r, h.Timestamps)
+	if err != nil {
+		return fail(http.StatusBadRequest, "illegal_argument_exception", err.Error())
+	}
+
+	eventID, err := h.CaptureEvent(r.Context(), event)
+	if err != nil {
+		status, _ := ingest.Status(err)
+		if status == http.StatusTooManyRequests {
+			return fail(status, "es_rejected_execution_exception", err.Error())
+		}
+		return fail(status, "unavailable_shards_exception", err.Error())
+	}
+	if res.ID == "" {
+		if eventID != nil && *eventID != "" {
+			res.ID = string(*eventID)
+		} else {
+			res.ID = randomID()
+		}
+	}
+	res.Version = 1
+	res.Result = "created"
+	res.Shards = &shards{Total: 1, Successful: 1}
+	res.SeqNo = &seqNo
+	res.PrimaryTerm = 1
+	res.Status = http.StatusCreated
+	return res
+}
+
+// parseBulk splits a bulk body into action/document pairs. Delete actions
+// have no document line.
+func parseBulk(body []byte, defaultIndex string) ([]item, error) {
+	var lines [][]byte
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return nil, errors.New("request body is required")
+	}
+
+	var items []item
+	for i := 0; i < len(lines); i++ {
+		var action map[string]struct {
+			Index string `json:"_index"`
+			ID    string `json:"_id"`
+		}
+		if err := json.Unmarshal(lines[i], &action); err != nil || len(action) != 1 {
+			return nil, fmt.Errorf("malformed action/metadata line [%d], expected a single action object", i+1)
+		}
+		for name, meta := range action {
+			it := item{action: name, index: meta.Index, id: meta.ID}
+			if it.index == "" {
+				it.index = defaultIndex
+			}
+			if name != "delete" {
+				i++
+				if i == len(lines) {
+					return nil, fmt.Errorf("action [%s] is missing its document line", name)
+				}
+				it.doc = lines[i]
+			}
+			items = append(items, it)
+		}
+	}
+	return items, nil
+}
+
+func randomID() string {
+	var b [10]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func writeError(w http.ResponseWriter, status int, typ, reason string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error":  map[string]interface{}{"type": typ, "reason": reason, "root_cause": []errorDetail{{Type: typ, Reason: reason}}},
+		"status": status,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,157 @@
+package elastic
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"http-to-sentry-go/internal/ingest"
+	"http-to-sentry-go/timestamp"
+)
+
+type bulkResponse struct {
+	Errors bool                    `json:"errors"`
+	Items  []map[string]itemResult `json:"items"`
+}
+
+func serve(t *testing.T, h Handler, req *http.Request) (*httptest.ResponseRecorder, bulkResponse) {
+	t.Helper()
+	rw := httptest.NewRecorder()
+	h.Handle(rw, req)
+	var resp bulkResponse
+	if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response %q: %v", rw.Body.String(), err)
+	}
+	return rw, resp
+}
+
+func TestHandleBulk(t *testing.T) {
+	var captured []*sentry.Event
+	h := Handler{
+		Options: ingest.Options{
+			Capture: func(_ context.Context, event *sentry.Event) (*sentry.EventID, error) {
+				captured = append(captured, event)
+				return &event.EventID, nil
+			},
+			Timestamps: timestamp.Policy{MaxPast: 100 * 365 * 24 * time.Hour},
+		},
+	}
+
+	body := `{"index":{"_index":"logs-app","_id":"doc-1"}}
+{"@timestamp":"2026-01-29T11:41:12.5Z","message":"charge failed","log":{"level":"ERROR","logger":"billing"},"host":{"name":"web-1"},"service.version":"1.4.2","user":{"id":"42"},"trace":{"id":"4bf92f3577b34da6a3ce929d0e0e4736"},"error":{"type":"PaymentError","message":"card declined"}}
+{"delete":{"_index":"logs-app","_id":"doc-0"}}
+{"create":{}}
+{"message":"plain"}
+`
+	rw, resp := serve(t, h, httptest.NewRequest(http.MethodPost, "/_bulk", strings.NewReader(body)))
+	if rw.Code != http.StatusOK || rw.Header().Get("X-Elastic-Product") != "Elasticsearch" {
+		t.Fatalf("unexpected response %d %v", rw.Code, rw.Header())
+	}
+	if !resp.Errors || len(resp.Items) != 3 || len(captured) != 1 {
+		t.Fatalf("unexpected response %+v, %d captured", resp, len(captured))
+	}
+	if res := resp.Items[0]["index"]; res.Status != http.StatusCreated || res.ID != "doc-1" || res.Index != "logs-app" || res.Result != "created" {
+		t.Fatalf("unexpected index result %+v", res)
+	}
+	if res := resp.Items[1]["delete"]; res.Status != http.StatusBadRequest || res.Error == nil {
+		t.Fatalf("expected delete to fail, got %+v", res)
+	}
+	if res := resp.Items[2]["create"]; res.Status != http.StatusBadRequest || res.Error == nil || res.Error.Type != "action_request_validation_exception" {
+		t.Fatalf("expected a missing index to fail, got %+v", res)
+	}
+
+	event := captured[0]
+	if event.Logger != "elastic" || event.Message != "charge failed" || event.Level != sentry.LevelError || event.ServerName != "web-1" || event.Release != "1.4.2" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	if event.Tags["index"] != "logs-app" || event.Tags["log.logger"] != "billing" || event.User.ID != "42" {
+		t.Fatalf("unexpected tags %+v or user %+v", event.Tags, event.User)
+	}
+	if want := time.Date(2026, 1, 29, 11, 41, 12, 500000000, time.UTC); !event.Timestamp.Equal(want) {
+		t.Fatalf("expected %s, got %s", want, event.Timestamp)
+	}
+	if event.Contexts["trace"]["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("unexpected contexts %+v", event.Contexts)
+	}
+	if len(event.Exception) != 1 || event.Exception[0].Type != "PaymentError" || event.Exception[0].Value != "card declined" {
+		t.Fatalf("unexpected exception %+v", event.Exception)
+	}
+}
+
+func TestHandleBulkIndexFromPathAndStackTrace(t *testing.T) {
+	var captured []*sentry.Event
+	h := Handler{
+		Options: ingest.Options{
+			Capture: func(_ context.Context, event *sentry.Event) (*sentry.EventID, error) {
+				captured = append(captured, event)
+				id := sentry.EventID("0b5c4f8ea9bd4b7e9d5a0f0d6f1b3c2a")
+				return &id, nil
+			},
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/{index}/_bulk", h.Handle)
+	body := `{"create":{}}` + "\n" + `{"message":"boom","error.stack_trace":"java.lang.IllegalStateException: boom\n\tat com.example.Worker.run(Worker.java:42)"}` + "\n"
+	rw := httptest.NewRecorder()
+	mux.ServeHTTP(rw, httptest.NewRequest(http.MethodPut, "/filebeat-8/_bulk", strings.NewReader(body)))
+	if rw.Code != http.StatusOK || len(captured) != 1 {
+		t.Fatalf("unexpected response %d %s, %d captured", rw.Code, rw.Body.String(), len(captured))
+	}
+	event := captured[0]
+	if event.Tags["index"] != "filebeat-8" {
+		t.Fatalf("unexpected tags %+v", event.Tags)
+	}
+	if len(event.Exception) != 1 || event.Exception[0].Type != "IllegalStateException" || event.Exception[0].Stacktrace == nil {
+		t.Fatalf("unexpected exception %+v", event.Exception)
+	}
+	if !strings.Contains(rw.Body.String(), `"_id":"0b5c4f8ea9bd4b7e9d5a0f0d6f1b3c2a"`) {
+		t.Fatalf("expected the event ID as document ID, got %s", rw.Body.String())
+	}
+}
+
+func TestHandleBulkErrors(t *testing.T) {
+	h := Handler{
+		Options: ingest.Options{
+			Capture: func(_ context.Context, event *sentry.Event) (*sentry.EventID, error) {
+				t.Fatalf("unexpected capture of %+v", event)
+				return nil, nil
+			},
+		},
+	}
+
+	for _, body := range []string{"", `{"index":{}}`, "not json\n{}\n", `{"index":{},"create":{}}` + "\n{}\n"} {
+		rw := httptest.NewRecorder()
+		h.Handle(rw, httptest.NewRequest(http.MethodPost, "/_bulk", strings.NewReader(body)))
+		if rw.Code != http.StatusBadRequest || !strings.Contains(rw.Body.String(), `"root_cause"`) {
+			t.Fatalf("%q: unexpected response %d %s", body, rw.Code, rw.Body.String())
+		}
+	}
+
+	rw := httptest.NewRecorder()
+	h.Handle(rw, httptest.NewRequest(http.MethodGet, "/_bulk", nil))
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", rw.Code)
+	}
+}
+
+func TestHandleInfo(t *testing.T) {
+	rw := httptest.NewRecorder()
+	Handler{Version: "8.15.1"}.Handle(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+	var info struct {
+		Version struct {
+			Number string `json:"number"`
+		} `json:"version"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &info); err != nil || rw.Code != http.StatusOK || info.Version.Number != "8.15.1" {
+		t.Fatalf("unexpected response %d %s", rw.Code, rw.Body.String())
+	}
+	if rw.Header().Get("X-Elastic-Product") != "Elasticsearch" {
+		t.Fatalf("missing product header")
+	}
+}
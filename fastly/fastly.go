@@ -1,17 +1,21 @@
 package fastly
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
-	"net/url"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/getsentry/sentry-go"
+	"http-to-sentry-go/internal/ingest"
+	"http-to-sentry-go/internal/logevent"
+	"http-to-sentry-go/timestamp"
 )
 
 type Event struct {
@@ -36,8 +40,7 @@ type Event struct {
 }
 
 type Handler struct {
-	MaxBodyBytes int
-	Capture      func(*sentry.Event) *sentry.EventID
+	ingest.Options
 }
 
 func (h Handler) HandleEvents(w http.ResponseWriter, r *http.Request) {
@@ -46,40 +49,38 @@ func (h Handler) HandleEvents(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	maxBytes := h.MaxBodyBytes
-	if maxBytes <= 0 {
-		maxBytes = 262144
-	}
+	maxBytes := h.BodyLimit(262144)
 
-	body, tooLarge, err := readLimitedBody(r.Body, maxBytes)
+	events, invalid, err := decodeEvents(http.MaxBytesReader(w, r.Body, int64(maxBytes)))
 	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		h.ReportInvalid(1)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	if tooLarge {
-		w.WriteHeader(http.StatusRequestEntityTooLarge)
-		return
-	}
-	if len(body) == 0 {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
-	events, ok := parseEvents(body)
-	if !ok || len(events) == 0 {
+	if len(events) == 0 {
+		h.ReportInvalid(invalid)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	capture := h.Capture
-	if capture == nil {
-		capture = sentry.CaptureEvent
-	}
-
 	eventIDs := make([]string, 0, len(events))
 	for _, fe := range events {
-		event := buildSentryEvent(fe, r)
-		eventID := capture(event)
+		event, err := buildSentryEvent(fe, r, h.Timestamps)
+		if err != nil {
+			invalid++
+			continue
+		}
+		eventID, err := h.CaptureEvent(r.Context(), event)
+		if err != nil {
+			h.ReportInvalid(invalid)
+			ingest.WriteError(w, err)
+			return
+		}
 		if eventID == nil {
 			continue
 		}
@@ -88,8 +89,11 @@ func (h Handler) HandleEvents(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	h.ReportInvalid(invalid)
+
 	resp, err := json.Marshal(map[string]interface{}{
 		"event_ids": eventIDs,
+		"invalid":   invalid,
 	})
 	if err != nil {
 		w.WriteHeader(http.StatusAccepted)
@@ -120,12 +124,20 @@ func ChallengeHandler(serviceID string) http.HandlerFunc {
 	}
 }
 
-func buildSentryEvent(fe Event, r *http.Request) *sentry.Event {
-	event := sentry.NewEvent()
-	event.Logger = "fastly"
-	event.Timestamp = time.Now()
+// buildSentryEvent maps a Fastly event. It fails only for timestamps the
+// policy rejects.
+func buildSentryEvent(fe Event, r *http.Request, policy timestamp.Policy) (*sentry.Event, error) {
+	event := logevent.New("fastly", r)
 	event.Level = mapLevel(fe)
 
+	ts, clamped, err := policy.Resolve(fe.Timestamp, event.Timestamp)
+	if errors.Is(err, timestamp.ErrOutOfRange) {
+		return nil, err
+	}
+	if err == nil {
+		event.Timestamp = ts
+	}
+
 	message := buildMessage(fe)
 	if message == "" {
 		message = "fastly event"
@@ -139,9 +151,9 @@ func buildSentryEvent(fe Event, r *http.Request) *sentry.Event {
 		"request_protocol": fe.RequestProtocol,
 		"fastly_server":    fe.FastlyServer,
 	}
-	addTag(event.Tags, "geo_country", fe.GeoCountry)
-	addTag(event.Tags, "geo_city", fe.GeoCity)
-	addTag(event.Tags, "tls_client_ja3_md5", fe.TLSClientJA3MD5)
+	logevent.AddTag(event.Tags, "geo_country", fe.GeoCountry)
+	logevent.AddTag(event.Tags, "geo_city", fe.GeoCity)
+	logevent.AddTag(event.Tags, "tls_client_ja3_md5", fe.TLSClientJA3MD5)
 	if fe.FastlyIsEdge {
 		event.Tags["fastly_is_edge"] = "true"
 	}
@@ -150,6 +162,9 @@ func buildSentryEvent(fe Event, r *http.Request) *sentry.Event {
 		"fastly":           fe,
 		"fastly_timestamp": fe.Timestamp,
 	}
+	if clamped {
+		event.Extra["fastly_timestamp_clamped"] = true
+	}
 
 	reqURL := buildURL(fe)
 	if reqURL != "" {
@@ -157,7 +172,7 @@ func buildSentryEvent(fe Event, r *http.Request) *sentry.Event {
 			URL:         reqURL,
 			Method:      fe.RequestMethod,
 			Headers:     map[string]string{"User-Agent": fe.RequestUserAgent, "Referer": fe.RequestReferer},
-			QueryString: queryStringFromURL(reqURL),
+			QueryString: logevent.QueryString(reqURL),
 		}
 	}
 
@@ -165,8 +180,7 @@ func buildSentryEvent(fe Event, r *http.Request) *sentry.Event {
 		event.User = sentry.User{IPAddress: fe.ClientIP}
 	}
 
-	addTag(event.Tags, "remote_addr", r.RemoteAddr)
-	return event
+	return event, nil
 }
 
 func buildMessage(fe Event) string {
@@ -219,46 +233,93 @@ func buildURL(fe Event) string {
 	return "https://" + fe.Host + path
 }
 
-func queryStringFromURL(raw string) string {
-	parsed, err := url.Parse(raw)
-	if err != nil {
-		return ""
-	}
-	return parsed.RawQuery
-}
+// decodeEvents stream-decodes a body of newline-delimited or concatenated JSON
+// events, as sent by Fastly HTTPS logging endpoints. Top-level arrays are
+// flattened. Entries that are not valid events are skipped and counted; after
+// a syntax error decoding resumes at the next line.
+func decodeEvents(body io.Reader) ([]Event, int, error) {
+	var events []Event
+	invalid := 0
+
+	src := body
+	dec := json.NewDecoder(src)
+	for {
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if errors.Is(err, io.EOF) {
+			return events, invalid, nil
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return events, invalid + 1, nil
+		}
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			invalid++
+			rest := bufio.NewReader(io.MultiReader(dec.Buffered(), src))
+			if err := skipLine(rest); err != nil {
+				if errors.Is(err, io.EOF) {
+					return events, invalid, nil
+				}
+				return events, invalid, err
+			}
+			src = rest
+			dec = json.NewDecoder(src)
+			continue
+		}
+		if err != nil {
+			return events, invalid, err
+		}
 
-func parseEvents(body []byte) ([]Event, bool) {
-	var single Event
-	if err := json.Unmarshal(body, &single); err == nil {
-		return []Event{single}, true
+		parsed, bad := parseValue(raw)
+		events = append(events, parsed...)
+		invalid += bad
 	}
+}
 
-	var multiple []Event
-	if err := json.Unmarshal(body, &multiple); err == nil {
-		return multiple, true
+func parseValue(raw json.RawMessage) ([]Event, int) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '[' {
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, 1
+		}
+		events := make([]Event, 0, len(items))
+		invalid := 0
+		for _, item := range items {
+			parsed, bad := parseValue(item)
+			events = append(events, parsed...)
+			invalid += bad
+		}
+		return events, invalid
 	}
 
-	return nil, false
-}
-
-func readLimitedBody(body io.ReadCloser, maxBytes int) ([]byte, bool, error) {
-	defer body.Close()
-	limit := int64(maxBytes)
-	data, err := io.ReadAll(io.LimitReader(body, limit+1))
-	if err != nil {
-		return nil, false, err
+	if len(raw) == 0 || raw[0] != '{' {
+		return nil, 1
 	}
-	if int64(len(data)) > limit {
-		return data[:limit], true, nil
+	var fe Event
+	if err := json.Unmarshal(raw, &fe); err != nil {
+		return nil, 1
 	}
-	return data, false, nil
+	return []Event{fe}, 0
 }
 
-func addTag(tags map[string]string, key, value string) {
-	if value == "" {
-		return
+// skipLine discards the line holding the next non-whitespace byte.
+func skipLine(r *bufio.Reader) error {
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			break
+		}
+	}
+	for {
+		_, err := r.ReadSlice('\n')
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return err
+		}
 	}
-	tags[key] = value
 }
 
 func titleCaseSimple(value string) string {
@@ -1,14 +1,19 @@
 package fastly
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/getsentry/sentry-go"
+	"http-to-sentry-go/internal/ingest"
 )
 
 func TestChallengeHandler(t *testing.T) {
@@ -33,11 +38,13 @@ func TestHandleEventsAcceptsJSON(t *testing.T) {
 
 	var captured []*sentry.Event
 	h := Handler{
-		MaxBodyBytes: 1024,
-		Capture: func(evt *sentry.Event) *sentry.EventID {
-			captured = append(captured, evt)
-			id := sentry.EventID("test")
-			return &id
+		Options: ingest.Options{
+			MaxBodyBytes: 1024,
+			Capture: func(_ context.Context, evt *sentry.Event) (*sentry.EventID, error) {
+				captured = append(captured, evt)
+				id := sentry.EventID("test")
+				return &id, nil
+			},
 		},
 	}
 
@@ -55,3 +62,85 @@ func TestHandleEventsAcceptsJSON(t *testing.T) {
 		t.Fatalf("expected message to be set")
 	}
 }
+
+func TestHandleEventsAcceptsNewlineDelimitedBatch(t *testing.T) {
+	payload := strings.Join([]string{
+		`{"host":"example.com","response_status":200}`,
+		``,
+		`not json at all`,
+		`{"host":"example.com","response_status":`,
+		`{"host":"example.com","response_status":503}`,
+		`[{"host":"example.com","response_status":404}]`,
+		``,
+	}, "\n")
+
+	var captured []*sentry.Event
+	h := Handler{
+		Options: ingest.Options{
+			MaxBodyBytes: 4096,
+			Capture: func(_ context.Context, evt *sentry.Event) (*sentry.EventID, error) {
+				captured = append(captured, evt)
+				id := sentry.EventID("test")
+				return &id, nil
+			},
+		},
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/fastly", strings.NewReader(payload))
+	w := httptest.NewRecorder()
+
+	h.HandleEvents(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", w.Code)
+	}
+	if len(captured) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(captured))
+	}
+	if captured[1].Level != sentry.LevelError || captured[2].Level != sentry.LevelWarning {
+		t.Fatalf("unexpected levels: %s, %s", captured[1].Level, captured[2].Level)
+	}
+	if !strings.Contains(w.Body.String(), `"invalid":2`) {
+		t.Fatalf("expected 2 invalid lines, got %s", w.Body.String())
+	}
+}
+
+func TestHandleEventsRejectsOversizedBody(t *testing.T) {
+	h := Handler{Options: ingest.Options{MaxBodyBytes: 32}}
+	payload := `{"host":"example.com","response_reason":"` + strings.Repeat("x", 64) + `"}`
+
+	req := httptest.NewRequest(http.MethodPost, "/fastly", strings.NewReader(payload))
+	w := httptest.NewRecorder()
+
+	h.HandleEvents(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d", w.Code)
+	}
+}
+
+func TestLoadIPRanges(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		name := filepath.Join(dir, "public-ip-list.json")
+		if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+
+	ranges, err := LoadIPRanges(write(`{"addresses":["151.101.0.0/16","199.232.0.0/16"],"ipv6_addresses":["2a04:4e40::/32"]}`))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(ranges) != 3 || !ranges[0].Contains(net.ParseIP("151.101.2.3")) || !ranges[2].Contains(net.ParseIP("2a04:4e40::1")) {
+		t.Fatalf("ranges = %v", ranges)
+	}
+	for name, content := range map[string]string{
+		"empty":   `{"addresses":[]}`,
+		"invalid": `{"addresses":["151.101.0.0"]}`,
+		"syntax":  `{"addresses":`,
+	} {
+		if _, err := LoadIPRanges(write(content)); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package fastly
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+)
+
+// LoadIPRanges reads Fastly's published address ranges, saved from
+// https://api.fastly.com/public-ip-list:
+// {"addresses": [...], "ipv6_addresses": [...]}.
+func LoadIPRanges(filename string) ([]*net.IPNet, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		Addresses     []string `json:"addresses"`
+		IPv6Addresses []string `json:"ipv6_addresses"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	var ranges []*net.IPNet
+	for _, cidr := range append(list.Addresses, list.IPv6Addresses...) {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid CIDR %q", filename, cidr)
+		}
+		ranges = append(ranges, network)
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("%s: no address ranges", filename)
+	}
+	return ranges, nil
+}
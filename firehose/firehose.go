@@ -0,0 +1,271 @@
+// Package firehose implements the Amazon Data Firehose HTTP endpoint
+// delivery contract. Records holding CloudWatch Logs subscription data
+// become one Sentry event per log event; other records become one event
+// each.
+package firehose
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"http-to-sentry-go/internal/ingest"
+	"http-to-sentry-go/internal/logevent"
+	"http-to-sentry-go/reqbody"
+	"http-to-sentry-go/stacktrace"
+	"http-to-sentry-go/timestamp"
+)
+
+// Request is the body Firehose delivers.
+type Request struct {
+	RequestID string `json:"requestId"`
+	Timestamp int64  `json:"timestamp"`
+	Records   []struct {
+		Data string `json:"data"`
+	} `json:"records"`
+}
+
+// Response is the body Firehose expects back.
+type Response struct {
+	RequestID    string `json:"requestId"`
+	Timestamp    int64  `json:"timestamp"`
+	ErrorMessage string `json:"errorMessage,omitempty"`
+}
+
+// Subscription is the gzipped CloudWatch Logs subscription envelope.
+type Subscription struct {
+	MessageType         string     `json:"messageType"`
+	Owner               string     `json:"owner"`
+	LogGroup            string     `json:"logGroup"`
+	LogStream           string     `json:"logStream"`
+	SubscriptionFilters []string   `json:"subscriptionFilters"`
+	LogEvents           []LogEvent `json:"logEvents"`
+}
+
+type LogEvent struct {
+	ID        string `json:"id"`
+	Timestamp int64  `json:"timestamp"`
+	Message   string `json:"message"`
+}
+
+// Handler serves Firehose deliveries. Options.Authorize checks the
+// X-Amz-Firehose-Access-Key header.
+type Handler struct {
+	ingest.Options
+	// MaxDecodedBytes limits the decompressed size of all the gzipped
+	// records of a delivery together. Defaults to ten times the body limit.
+	MaxDecodedBytes int
+}
+
+// HandleRecords serves Firehose deliveries. Every response echoes the
+// request ID; Firehose retries deliveries answered with an error.
+func (h Handler) HandleRecords(w http.ResponseWriter, r *http.Request) {
+	requestID := r.Header.Get("X-Amz-Firehose-Request-Id")
+	if r.Method != http.MethodPost {
+		writeResponse(w, http.StatusMethodNotAllowed, requestID, "method not allowed")
+		return
+	}
+	r, err := h.Admit(r)
+	if err != nil {
+		status, wait := ingest.Status(err)
+		ingest.SetRetryAfter(w, wait)
+		writeResponse(w, status, requestID, err.Error())
+		return
+	}
+
+	maxBytes := h.BodyLimit(1048576)
+	body, err := reqbody.Read(w, r, maxBytes)
+	if err != nil {
+		status := reqbody.Status(err)
+		if status == http.StatusBadRequest {
+			h.ReportInvalid(1)
+		}
+		writeResponse(w, status, requestID, err.Error())
+		return
+	}
+
+	var req Request
+	if err := json.Unmarshal(body, &req); err != nil {
+		h.ReportInvalid(1)
+		writeResponse(w, http.StatusBadRequest, requestID, "invalid request body")
+		return
+	}
+	if requestID == "" {
+		requestID = req.RequestID
+	}
+
+	// Retrying a delivery does not make its records valid, so invalid
+	// records, including those inflating past the budget, are counted and
+	// the delivery is still acknowledged.
+	budget := h.MaxDecodedBytes
+	if budget <= 0 {
+		budget = 10 * maxBytes
+	}
+	invalid := 0
+	for _, record := range req.Records {
+		events, n := h.recordEvents(record.Data, r, &budget)
+		invalid += n
+		for _, event := range events {
+			if _, err := h.CaptureEvent(r.Context(), event); err != nil {
+				h.ReportInvalid(invalid)
+				status, wait := ingest.Status(err)
+				ingest.SetRetryAfter(w, wait)
+				writeResponse(w, status, requestID, err.Error())
+				return
+			}
+		}
+	}
+	h.ReportInvalid(invalid)
+
+	writeResponse(w, http.StatusOK, requestID, "")
+}
+
+func writeResponse(w http.ResponseWriter, status int, requestID, errorMessage string) {
+	data, err := json.Marshal(Response{
+		RequestID:    requestID,
+		Timestamp:    time.Now().UnixMilli(),
+		ErrorMessage: errorMessage,
+	})
+	if err != nil {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
+
+// recordEvents decodes one base64 record. Gzipped records are read as
+// CloudWatch Logs subscription data; anything else is one plain text
+// event. Decompressing takes from budget, the bytes the delivery may still
+// inflate to; a record inflating past it is invalid. It returns the events
+// and the number of invalid entries.
+func (h Handler) recordEvents(encoded string, r *http.Request, budget *int) ([]*sentry.Event, int) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, 1
+	}
+	if !logevent.IsGzip(data) {
+		event, err := buildSentryEvent(Subscription{}, LogEvent{Message: string(data)}, r, h.Timestamps)
+		if err != nil {
+			return nil, 1
+		}
+		return []*sentry.Event{event}, 0
+	}
+
+	sub, err := decodeSubscription(data, budget)
+	if err != nil {
+		return nil, 1
+	}
+	if sub.MessageType != "DATA_MESSAGE" {
+		// CONTROL_MESSAGE records only check that the destination is
+		// reachable.
+		return nil, 0
+	}
+
+	var events []*sentry.Event
+	invalid := 0
+	for _, logEvent := range sub.LogEvents {
+		event, err := buildSentryEvent(sub, logEvent, r, h.Timestamps)
+		if err != nil {
+			invalid++
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, invalid
+}
+
+// decodeSubscription inflates data and subtracts its size from budget.
+func decodeSubscription(data []byte, budget *int) (Subscription, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return Subscription{}, err
+	}
+	decoded, err := io.ReadAll(io.LimitReader(zr, int64(*budget)+1))
+	*budget -= len(decoded)
+	if *budget < 0 {
+		return Subscription{}, reqbody.ErrTooLarge
+	}
+	if err != nil {
+		return Subscription{}, err
+	}
+	var sub Subscription
+	if err := json.Unmarshal(decoded, &sub); err != nil {
+		return Subscription{}, err
+	}
+	return sub, nil
+}
+
+// buildSentryEvent maps one log event. It fails only for timestamps the
+// policy rejects.
+func buildSentryEvent(sub Subscription, logEvent LogEvent, r *http.Request, policy timestamp.Policy) (*sentry.Event, error) {
+	event := logevent.New("firehose", r)
+	event.Level = sentry.LevelInfo
+	event.Message = strings.TrimRight(logEvent.Message, "\r\n")
+
+	logevent.AddTag(event.Tags, "logGroup", sub.LogGroup)
+	logevent.AddTag(event.Tags, "logStream", sub.LogStream)
+	logevent.AddTag(event.Tags, "owner", sub.Owner)
+	if len(sub.SubscriptionFilters) > 0 {
+		event.Extra["subscription_filters"] = sub.SubscriptionFilters
+	}
+	if logEvent.ID != "" {
+		event.Extra["log_event_id"] = logEvent.ID
+	}
+
+	if function, ok := strings.CutPrefix(sub.LogGroup, "/aws/lambda/"); ok {
+		logevent.AddTag(event.Tags, "function", function)
+		parseLambdaLine(event)
+	}
+
+	if logEvent.Timestamp != 0 {
+		ts := time.UnixMilli(logEvent.Timestamp).UTC()
+		event.Extra["cloudwatch_timestamp"] = ts.Format(time.RFC3339Nano)
+		ts, clamped, err := policy.Apply(ts, event.Timestamp)
+		if errors.Is(err, timestamp.ErrOutOfRange) {
+			return nil, err
+		}
+		event.Timestamp = ts
+		if clamped {
+			event.Extra["cloudwatch_timestamp_clamped"] = true
+		}
+	}
+
+	if event.Message == "" {
+		event.Message = "(empty message)"
+	}
+	stacktrace.Attach(event)
+	return event, nil
+}
+
+// parseLambdaLine reads the level and request ID from the tab separated
+// lines of the Lambda runtimes: "TIME\tREQUEST_ID\tLEVEL\tMESSAGE" (Node.js)
+// and "[LEVEL]\tTIME\tREQUEST_ID\tMESSAGE" (Python). Platform lines such as
+// "REPORT RequestId: ..." are left as they are.
+func parseLambdaLine(event *sentry.Event) {
+	parts := strings.SplitN(event.Message, "\t", 4)
+	if len(parts) != 4 {
+		return
+	}
+	var level, requestID string
+	if strings.HasPrefix(parts[0], "[") && strings.HasSuffix(parts[0], "]") {
+		level, requestID = strings.Trim(parts[0], "[]"), parts[2]
+	} else {
+		level, requestID = parts[2], parts[1]
+	}
+	sentryLevel, ok := logevent.ParseLevel(level)
+	if !ok {
+		return
+	}
+	event.Level = sentryLevel
+	logevent.AddTag(event.Tags, "request_id", requestID)
+	event.Message = parts[3]
+}
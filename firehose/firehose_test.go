@@ -0,0 +1,176 @@
+package firehose
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"http-to-sentry-go/internal/ingest"
+	"http-to-sentry-go/timestamp"
+)
+
+func gzipRecord(t *testing.T, sub Subscription) string {
+	t.Helper()
+	data, err := json.Marshal(sub)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write(data)
+	_ = zw.Close()
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func deliver(t *testing.T, h Handler, key string, records ...string) (*httptest.ResponseRecorder, Response) {
+	t.Helper()
+	var body strings.Builder
+	body.WriteString(`{"requestId":"body-id","timestamp":1769686872000,"records":[`)
+	for i, data := range records {
+		if i > 0 {
+			body.WriteString(",")
+		}
+		body.WriteString(`{"data":"` + data + `"}`)
+	}
+	body.WriteString("]}")
+
+	req := httptest.NewRequest(http.MethodPost, "/firehose", strings.NewReader(body.String()))
+	req.Header.Set("X-Amz-Firehose-Request-Id", "ed4acda5-034f-9f42-bba1-f29aea6d7d8f")
+	req.Header.Set("X-Amz-Firehose-Access-Key", key)
+	rw := httptest.NewRecorder()
+	h.HandleRecords(rw, req)
+	var resp Response
+	if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response %q: %v", rw.Body.String(), err)
+	}
+	return rw, resp
+}
+
+func TestHandleRecords(t *testing.T) {
+	var captured []*sentry.Event
+	invalid := 0
+	h := Handler{
+		Options: ingest.Options{
+			Capture: func(_ context.Context, event *sentry.Event) (*sentry.EventID, error) {
+				captured = append(captured, event)
+				return &event.EventID, nil
+			},
+			Timestamps: timestamp.Policy{MaxPast: 100 * 365 * 24 * time.Hour},
+			Invalid:    func(count int) { invalid += count },
+		},
+	}
+
+	lambda := gzipRecord(t, Subscription{
+		MessageType:         "DATA_MESSAGE",
+		Owner:               "123456789012",
+		LogGroup:            "/aws/lambda/checkout",
+		LogStream:           "2026/01/29/[$LATEST]abc",
+		SubscriptionFilters: []string{"errors"},
+		LogEvents: []LogEvent{
+			{ID: "1", Timestamp: 1769686872500, Message: "2026-01-29T11:41:12.500Z\tc6af9ac6-7b61\tERROR\tcharge failed\n"},
+			{ID: "2", Timestamp: 1769686873000, Message: "[WARNING]\t2026-01-29T11:41:13.000Z\t8f5c1d2e-1a2b\tslow response"},
+			{ID: "3", Timestamp: 1769686874000, Message: "REPORT RequestId: c6af9ac6-7b61\tDuration: 12.3 ms\tBilled Duration: 13 ms"},
+		},
+	})
+	control := gzipRecord(t, Subscription{MessageType: "CONTROL_MESSAGE"})
+	plain := base64.StdEncoding.EncodeToString([]byte("direct put record"))
+
+	rw, resp := deliver(t, h, "secret", lambda, control, plain, "!!not base64")
+	if rw.Code != http.StatusOK || resp.RequestID != "ed4acda5-034f-9f42-bba1-f29aea6d7d8f" || resp.Timestamp == 0 || resp.ErrorMessage != "" {
+		t.Fatalf("unexpected response %d %+v", rw.Code, resp)
+	}
+	if len(captured) != 4 || invalid != 1 {
+		t.Fatalf("expected 4 events and 1 invalid record, got %d and %d", len(captured), invalid)
+	}
+
+	event := captured[0]
+	if event.Logger != "firehose" || event.Message != "charge failed" || event.Level != sentry.LevelError {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	if event.Tags["logGroup"] != "/aws/lambda/checkout" || event.Tags["logStream"] != "2026/01/29/[$LATEST]abc" || event.Tags["function"] != "checkout" || event.Tags["request_id"] != "c6af9ac6-7b61" || event.Tags["owner"] != "123456789012" {
+		t.Fatalf("unexpected tags %+v", event.Tags)
+	}
+	if want := time.Date(2026, 1, 29, 11, 41, 12, 500000000, time.UTC); !event.Timestamp.Equal(want) {
+		t.Fatalf("expected %s, got %s", want, event.Timestamp)
+	}
+	if event := captured[1]; event.Message != "slow response" || event.Level != sentry.LevelWarning || event.Tags["request_id"] != "8f5c1d2e-1a2b" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	if event := captured[2]; event.Level != sentry.LevelInfo || !strings.HasPrefix(event.Message, "REPORT RequestId") {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	if event := captured[3]; event.Message != "direct put record" || event.Tags["logGroup"] != "" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+}
+
+func TestHandleRecordsErrors(t *testing.T) {
+	h := Handler{
+		Options: ingest.Options{
+			Capture: func(_ context.Context, event *sentry.Event) (*sentry.EventID, error) {
+				t.Fatalf("unexpected capture of %+v", event)
+				return nil, nil
+			},
+		},
+	}
+	h.Authorize = func(r *http.Request) (*http.Request, error) {
+		if r.Header.Get("X-Amz-Firehose-Access-Key") != "secret" {
+			return r, &ingest.Error{Status: http.StatusUnauthorized, Err: errors.New("missing or invalid token")}
+		}
+		return r, nil
+	}
+
+	rw, resp := deliver(t, h, "wrong")
+	if rw.Code != http.StatusUnauthorized || resp.RequestID != "ed4acda5-034f-9f42-bba1-f29aea6d7d8f" || resp.ErrorMessage == "" {
+		t.Fatalf("unexpected response %d %+v", rw.Code, resp)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/firehose", strings.NewReader("{"))
+	req.Header.Set("X-Amz-Firehose-Access-Key", "secret")
+	rw = httptest.NewRecorder()
+	h.HandleRecords(rw, req)
+	if rw.Code != http.StatusBadRequest || !strings.Contains(rw.Body.String(), "errorMessage") {
+		t.Fatalf("unexpected response %d %s", rw.Code, rw.Body.String())
+	}
+}
+
+func TestHandleRecordsLimitsDecompressedDelivery(t *testing.T) {
+	captured, invalid := 0, 0
+	h := Handler{
+		Options: ingest.Options{
+			Capture: func(_ context.Context, event *sentry.Event) (*sentry.EventID, error) {
+				captured++
+				return &event.EventID, nil
+			},
+			Invalid: func(count int) { invalid += count },
+		},
+	}
+	record := gzipRecord(t, Subscription{
+		MessageType: "DATA_MESSAGE",
+		LogEvents:   []LogEvent{{ID: "1", Message: strings.Repeat("x", 600)}},
+	})
+
+	h.MaxDecodedBytes = 1000
+	rw, resp := deliver(t, h, "", record)
+	if rw.Code != http.StatusOK || captured != 1 {
+		t.Fatalf("expected one record within the budget, got %d %+v and %d events", rw.Code, resp, captured)
+	}
+
+	// Each record fits on its own, but not all together. The records past
+	// the budget are invalid and the delivery is still acknowledged, so
+	// Firehose does not deliver the first record again.
+	captured = 0
+	rw, resp = deliver(t, h, "", record, record, record)
+	if rw.Code != http.StatusOK || captured != 1 || invalid != 2 {
+		t.Fatalf("unexpected result %d %+v, %d captured and %d invalid", rw.Code, resp, captured, invalid)
+	}
+}
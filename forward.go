@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"http-to-sentry-go/forward"
+	"http-to-sentry-go/metrics"
+	"http-to-sentry-go/timestamp"
+)
+
+// startForward opens the Fluent forward listener and serves it until ctx is
+// done. Records are captured through the forward route of the active
+// configuration generation.
+func startForward(ctx context.Context, cfg config, srv *server, wg *sync.WaitGroup) error {
+	if cfg.forwardAddr == "" {
+		return nil
+	}
+
+	s := &forward.Server{
+		Handle:        srv.captureForward,
+		Busy:          srv.forwardBusy,
+		SharedKey:     cfg.forwardSharedKey,
+		Hostname:      cfg.forwardHostname,
+		MaxChunkBytes: cfg.forwardMaxBytes,
+		IdleTimeout:   cfg.forwardIdle,
+		Invalid: func(remote net.Addr, err error) {
+			metrics.ParseFailures.WithLabelValues("forward").Inc()
+			log.Printf("forward %s: %v", remote, err)
+		},
+	}
+	ln, err := net.Listen("tcp", cfg.forwardAddr)
+	if err != nil {
+		return err
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := s.Serve(ctx, ln); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("forward server error: %v", err)
+		}
+	}()
+	return nil
+}
+
+// captureForward captures one forwarded record with the active
+// configuration. Records the policy rejects are dropped; it fails only if
+// the sink did not accept the event, so the chunk is not acknowledged.
+func (s *server) captureForward(rec forward.Record, remote net.Addr) error {
+	g, release := s.acquire()
+	defer release()
+
+	rt := g.forward
+	event, err := buildForwardEvent(rt.cfg, rec, remote)
+	if err != nil {
+		metrics.ParseFailures.WithLabelValues("forward").Inc()
+		log.Printf("forward %s: %v", remote, err)
+		return nil
+	}
+	_, err = rt.capture(context.Background(), event)
+	return err
+}
+
+// forwardBusy returns how long to wait before reading the next forward
+// message while the sink pushes back, like checkCapacity does for HTTP
+// requests.
+func (s *server) forwardBusy() time.Duration {
+	g, release := s.acquire()
+	defer release()
+
+	if status, retryAfter := g.forward.sink.transport.Backpressure(); status != 0 {
+		return retryAfter
+	}
+	return 0
+}
+
+// buildForwardEvent maps a record like a generic ingest payload. The
+// record's "log" field, as sent by Fluent Bit tail and Docker inputs, is the
+// message if the payload has none, and the record time is the timestamp if
+// the payload has none. It fails only for timestamps the configured policy
+// rejects.
+func buildForwardEvent(cfg config, rec forward.Record, remote net.Addr) (*sentry.Event, error) {
+	body, err := json.Marshal(rec.Fields)
+	if err != nil {
+		return nil, err
+	}
+	fields, parsed := decodeFields(body, cfg.mapping)
+	if line, ok := rec.Fields["log"].(string); ok && parsed && fields.Message == "" {
+		fields.Message = strings.TrimRight(line, "\r\n")
+	}
+
+	event, err := buildPayloadEvent(cfg, "forward", map[string]string{
+		"remote_addr": remote.String(),
+		"fluent_tag":  rec.Tag,
+	}, body, fields, parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	if fields.Timestamp == "" && !rec.Time.IsZero() {
+		event.Extra["fluent_time"] = rec.Time.Format(time.RFC3339Nano)
+		ts, clamped, err := cfg.timestamps.Apply(rec.Time, event.Timestamp)
+		if errors.Is(err, timestamp.ErrOutOfRange) {
+			return nil, err
+		}
+		event.Timestamp = ts
+		if clamped {
+			event.Extra["fluent_time_clamped"] = true
+		}
+	}
+	return event, nil
+}
@@ -0,0 +1,513 @@
+// Package forward implements the server side of the Fluentd forward
+// protocol, so Fluentd and Fluent Bit can forward records over TCP. It
+// accepts the Message, Forward, PackedForward and CompressedPackedForward
+// modes, acknowledges chunks and optionally requires the shared key
+// handshake.
+package forward
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/vmihailenco/msgpack/v5/msgpcode"
+)
+
+const (
+	defaultMaxChunkBytes = 8 << 20
+	defaultIdleTimeout   = 5 * time.Minute
+)
+
+var (
+	errTooLarge = errors.New("forward: chunk too large")
+	errAuth     = errors.New("forward: shared key mismatch")
+)
+
+func init() {
+	msgpack.RegisterExt(0, (*EventTime)(nil))
+}
+
+// EventTime is the EventTime extension type (ext type 0): seconds and
+// nanoseconds as big-endian 32-bit integers.
+type EventTime struct {
+	time.Time
+}
+
+func (t *EventTime) MarshalMsgpack() ([]byte, error) {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint32(b[:4], uint32(t.Unix()))
+	binary.BigEndian.PutUint32(b[4:], uint32(t.Nanosecond()))
+	return b, nil
+}
+
+func (t *EventTime) UnmarshalMsgpack(b []byte) error {
+	if len(b) != 8 {
+		return fmt.Errorf("forward: invalid EventTime length %d", len(b))
+	}
+	t.Time = time.Unix(int64(binary.BigEndian.Uint32(b[:4])), int64(binary.BigEndian.Uint32(b[4:]))).UTC()
+	return nil
+}
+
+// Record is one forwarded log record.
+type Record struct {
+	Tag  string
+	Time time.Time
+	// Fields is the record with binary values converted to strings.
+	Fields map[string]interface{}
+}
+
+// Server receives forward protocol connections.
+type Server struct {
+	// Handle is called for every record. If it fails, the rest of the
+	// message is dropped without an acknowledgement and the connection is
+	// closed, so the client sends the chunk again.
+	Handle func(rec Record, remote net.Addr) error
+	// Busy, if set, is called before each message is read. While it returns
+	// a positive delay the server waits that long instead of reading, so TCP
+	// flow control pushes back on the client.
+	Busy func() time.Duration
+	// Invalid, if set, is called for every message or entry that could not
+	// be decoded, and for failed handshakes.
+	Invalid func(remote net.Addr, err error)
+	// SharedKey, if set, requires clients to authenticate with the shared
+	// key handshake.
+	SharedKey string
+	// Hostname is the server hostname sent in the handshake.
+	Hostname string
+	// MaxChunkBytes bounds one message, and a compressed chunk after
+	// decompression. Defaults to 8 MiB.
+	MaxChunkBytes int
+	// IdleTimeout bounds the wait for each message; idle connections are
+	// closed after it. Defaults to 5 minutes.
+	IdleTimeout time.Duration
+}
+
+func (s *Server) maxBytes() int {
+	if s.MaxChunkBytes > 0 {
+		return s.MaxChunkBytes
+	}
+	return defaultMaxChunkBytes
+}
+
+func (s *Server) idleTimeout() time.Duration {
+	if s.IdleTimeout > 0 {
+		return s.IdleTimeout
+	}
+	return defaultIdleTimeout
+}
+
+// wait blocks while Busy reports a delay. It reports false if ctx is done
+// first.
+func (s *Server) wait(ctx context.Context) bool {
+	if s.Busy == nil {
+		return true
+	}
+	for {
+		d := s.Busy()
+		if d <= 0 {
+			return true
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return false
+		case <-t.C:
+		}
+	}
+}
+
+func (s *Server) invalid(remote net.Addr, err error) {
+	if s.Invalid != nil {
+		s.Invalid(remote, err)
+	}
+}
+
+// Serve accepts connections from ln until ctx is done. On shutdown, open
+// connections are closed once the messages already received are handled.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	var mu sync.Mutex
+	conns := map[net.Conn]bool{}
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for conn := range conns {
+			_ = conn.SetReadDeadline(time.Now())
+		}
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(50 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		mu.Lock()
+		if ctx.Err() != nil {
+			mu.Unlock()
+			_ = conn.Close()
+			return nil
+		}
+		conns[conn] = true
+		mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				mu.Lock()
+				delete(conns, conn)
+				mu.Unlock()
+				_ = conn.Close()
+			}()
+			s.serveConn(ctx, conn)
+		}()
+	}
+}
+
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+	remote := conn.RemoteAddr()
+	r := &limitedReader{r: bufio.NewReader(conn)}
+	dec := msgpack.NewDecoder(r)
+	dec.UseLooseInterfaceDecoding(true)
+	enc := msgpack.NewEncoder(conn)
+
+	if s.SharedKey != "" {
+		r.n = 65536
+		s.setDeadline(ctx, conn)
+		if err := s.handshake(dec, enc); err != nil {
+			s.invalid(remote, err)
+			return
+		}
+	}
+
+	for {
+		if !s.wait(ctx) {
+			return
+		}
+		s.setDeadline(ctx, conn)
+		r.n = s.maxBytes()
+		if _, err := dec.PeekCode(); err != nil {
+			if !closed(err) {
+				log.Printf("forward %s: %v", remote, err)
+			}
+			return
+		}
+		msg, err := s.decodeMessage(dec)
+		if err != nil {
+			// The stream cannot be resynchronized after a malformed message.
+			s.invalid(remote, err)
+			return
+		}
+		for _, entry := range msg.entries {
+			rec, err := entry.decode(msg.tag)
+			if err != nil {
+				s.invalid(remote, err)
+				continue
+			}
+			if err := s.Handle(rec, remote); err != nil {
+				log.Printf("forward %s: closing connection: %v", remote, err)
+				return
+			}
+		}
+		if msg.chunk != "" {
+			if err := enc.Encode(map[string]string{"ack": msg.chunk}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// setDeadline bounds the wait for the next message by the idle timeout.
+// Serve sets a past deadline on shutdown, so ctx is checked after the
+// deadline is extended, to keep it from undoing that.
+func (s *Server) setDeadline(ctx context.Context, conn net.Conn) {
+	_ = conn.SetReadDeadline(time.Now().Add(s.idleTimeout()))
+	if ctx.Err() != nil {
+		_ = conn.SetReadDeadline(time.Now())
+	}
+}
+
+func closed(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// handshake sends HELO, checks the client's PING and answers PONG.
+func (s *Server) handshake(dec *msgpack.Decoder, enc *msgpack.Encoder) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	helo := []interface{}{"HELO", map[string]interface{}{"nonce": nonce, "auth": "", "keepalive": true}}
+	if err := enc.Encode(helo); err != nil {
+		return err
+	}
+
+	ping, err := dec.DecodeSlice()
+	if err != nil {
+		return err
+	}
+	if len(ping) < 4 || asString(ping[0]) != "PING" {
+		return errors.New("forward: expected PING")
+	}
+	hostname, salt, digest := asString(ping[1]), asString(ping[2]), asString(ping[3])
+	if subtle.ConstantTimeCompare([]byte(digest), []byte(s.digest(salt, hostname, nonce))) != 1 {
+		_ = enc.Encode([]interface{}{"PONG", false, "shared_key mismatch", s.Hostname, ""})
+		return errAuth
+	}
+	return enc.Encode([]interface{}{"PONG", true, "", s.Hostname, s.digest(salt, s.Hostname, nonce)})
+}
+
+func (s *Server) digest(salt, hostname string, nonce []byte) string {
+	h := sha512.New()
+	h.Write([]byte(salt))
+	h.Write([]byte(hostname))
+	h.Write(nonce)
+	h.Write([]byte(s.SharedKey))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// message is one decoded forward protocol message.
+type message struct {
+	tag     string
+	entries []entry
+	chunk   string
+}
+
+// entry is an undecoded [time, record] pair.
+type entry struct {
+	time   interface{}
+	record interface{}
+}
+
+// decodeMessage decodes [tag, time, record, option] (Message mode),
+// [tag, [[time, record], ...], option] (Forward mode) or [tag, entries,
+// option] with the entries packed in a binary or string value
+// (PackedForward mode, gzip compressed in CompressedPackedForward mode).
+func (s *Server) decodeMessage(dec *msgpack.Decoder) (*message, error) {
+	n, err := dec.DecodeArrayLen()
+	if err != nil {
+		return nil, err
+	}
+	if n < 2 || n > 4 {
+		return nil, fmt.Errorf("forward: message has %d elements", n)
+	}
+	msg := &message{}
+	if msg.tag, err = dec.DecodeString(); err != nil {
+		return nil, err
+	}
+
+	code, err := dec.PeekCode()
+	if err != nil {
+		return nil, err
+	}
+	var packed []byte
+	rest := n - 2
+	switch {
+	case msgpcode.IsFixedArray(code) || code == msgpcode.Array16 || code == msgpcode.Array32:
+		values, err := dec.DecodeSlice()
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range values {
+			pair, ok := value.([]interface{})
+			if !ok || len(pair) != 2 {
+				return nil, errors.New("forward: entry is not a [time, record] pair")
+			}
+			msg.entries = append(msg.entries, entry{time: pair[0], record: pair[1]})
+		}
+	case msgpcode.IsString(code) || msgpcode.IsBin(code):
+		if packed, err = dec.DecodeBytes(); err != nil {
+			return nil, err
+		}
+	default:
+		if n < 3 {
+			return nil, errors.New("forward: message mode without a record")
+		}
+		var e entry
+		if e.time, err = dec.DecodeInterfaceLoose(); err != nil {
+			return nil, err
+		}
+		if e.record, err = dec.DecodeInterfaceLoose(); err != nil {
+			return nil, err
+		}
+		msg.entries = append(msg.entries, e)
+		rest--
+	}
+
+	var option map[string]interface{}
+	if rest > 0 {
+		if err := dec.Decode(&option); err != nil {
+			return nil, fmt.Errorf("forward: option: %w", err)
+		}
+	}
+	msg.chunk = asString(option["chunk"])
+
+	if packed != nil {
+		switch compressed := asString(option["compressed"]); compressed {
+		case "", "text":
+		case "gzip":
+			if packed, err = gunzip(packed, s.maxBytes()); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("forward: unsupported compression %q", compressed)
+		}
+		if msg.entries, err = unpack(packed); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// unpack decodes the concatenated [time, record] entries of a packed
+// message.
+func unpack(data []byte) ([]entry, error) {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.UseLooseInterfaceDecoding(true)
+	var entries []entry
+	for {
+		values, err := dec.DecodeSlice()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("forward: packed entries: %w", err)
+		}
+		if len(values) != 2 {
+			return nil, errors.New("forward: entry is not a [time, record] pair")
+		}
+		entries = append(entries, entry{time: values[0], record: values[1]})
+	}
+}
+
+func gunzip(data []byte, limit int) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("forward: gzip: %w", err)
+	}
+	out, err := io.ReadAll(io.LimitReader(zr, int64(limit)+1))
+	if err != nil {
+		return nil, fmt.Errorf("forward: gzip: %w", err)
+	}
+	if len(out) > limit {
+		return nil, errTooLarge
+	}
+	return out, nil
+}
+
+func (e entry) decode(tag string) (Record, error) {
+	fields, ok := e.record.(map[string]interface{})
+	if !ok {
+		return Record{}, errors.New("forward: record is not a map")
+	}
+	rec := Record{Tag: tag, Fields: normalize(fields).(map[string]interface{})}
+	switch t := e.time.(type) {
+	case *EventTime:
+		rec.Time = t.Time
+	case int64:
+		rec.Time = time.Unix(t, 0).UTC()
+	case uint64:
+		rec.Time = time.Unix(int64(t), 0).UTC()
+	case float64:
+		rec.Time = time.Unix(0, int64(t*float64(time.Second))).UTC()
+	default:
+		return Record{}, fmt.Errorf("forward: invalid time %v", e.time)
+	}
+	return rec, nil
+}
+
+// normalize converts binary values, which Fluentd uses for strings, to
+// strings.
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = normalize(v[i])
+		}
+	case map[string]interface{}:
+		for key := range v {
+			v[key] = normalize(v[key])
+		}
+	}
+	return value
+}
+
+func asString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return ""
+}
+
+// limitedReader fails reads once n bytes were read, bounding a message
+// without knowing its size up front.
+type limitedReader struct {
+	r *bufio.Reader
+	n int
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		return 0, errTooLarge
+	}
+	if len(p) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= n
+	return n, err
+}
+
+func (l *limitedReader) ReadByte() (byte, error) {
+	if l.n <= 0 {
+		return 0, errTooLarge
+	}
+	b, err := l.r.ReadByte()
+	if err == nil {
+		l.n--
+	}
+	return b, err
+}
+
+func (l *limitedReader) UnreadByte() error {
+	err := l.r.UnreadByte()
+	if err == nil {
+		l.n++
+	}
+	return err
+}
@@ -0,0 +1,287 @@
+package forward
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type collector struct {
+	mu      sync.Mutex
+	records []Record
+	invalid []error
+	// err fails every record.
+	err error
+}
+
+func (c *collector) handle(rec Record, remote net.Addr) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	c.records = append(c.records, rec)
+	return nil
+}
+
+func (c *collector) fail(remote net.Addr, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.invalid = append(c.invalid, err)
+}
+
+// waitInvalid waits until n failures were reported.
+func (c *collector) waitInvalid(t *testing.T, n int) []error {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		c.mu.Lock()
+		got := append([]error(nil), c.invalid...)
+		c.mu.Unlock()
+		if len(got) >= n {
+			return got
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d failures, got %d", n, len(got))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (c *collector) wait(t *testing.T, records int) []Record {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		c.mu.Lock()
+		got := append([]Record(nil), c.records...)
+		c.mu.Unlock()
+		if len(got) >= records {
+			return got
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d records, got %d", records, len(got))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func start(t *testing.T, s *Server) (net.Conn, *msgpack.Encoder, *msgpack.Decoder) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = s.Serve(ctx, ln)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+	return conn, msgpack.NewEncoder(conn), msgpack.NewDecoder(conn)
+}
+
+func packEntries(t *testing.T, entries ...[]interface{}) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			t.Fatalf("encode: %v", err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestServeModes(t *testing.T) {
+	c := &collector{}
+	_, enc, dec := start(t, &Server{Handle: c.handle, Invalid: c.fail})
+
+	ts := time.Date(2026, 1, 29, 11, 41, 12, 500000000, time.UTC)
+	et := &EventTime{ts}
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, _ = zw.Write(packEntries(t, []interface{}{et, map[string]interface{}{"log": "compressed"}}))
+	_ = zw.Close()
+
+	messages := [][]interface{}{
+		{"app.message", ts.Unix(), map[string]interface{}{"message": "message mode", "nested": map[string]interface{}{"raw": []byte("bytes")}}, map[string]interface{}{"chunk": "c1"}},
+		{"app.forward", []interface{}{[]interface{}{et, map[string]interface{}{"log": "forward 1"}}, []interface{}{ts.Unix(), map[string]interface{}{"log": "forward 2"}}}},
+		{"app.packed", packEntries(t, []interface{}{et, map[string]interface{}{"log": "packed"}}), map[string]interface{}{"size": 1}},
+		{"app.compressed", gz.Bytes(), map[string]interface{}{"compressed": "gzip", "chunk": "c2"}},
+	}
+	var acks []string
+	for _, msg := range messages {
+		if err := enc.Encode(msg); err != nil {
+			t.Fatalf("encode: %v", err)
+		}
+		if opt, ok := msg[len(msg)-1].(map[string]interface{}); ok && opt["chunk"] != nil {
+			var ack map[string]string
+			if err := dec.Decode(&ack); err != nil {
+				t.Fatalf("read ack: %v", err)
+			}
+			acks = append(acks, ack["ack"])
+		}
+	}
+	if len(acks) != 2 || acks[0] != "c1" || acks[1] != "c2" {
+		t.Fatalf("unexpected acks %v", acks)
+	}
+
+	records := c.wait(t, 5)
+	if records[0].Tag != "app.message" || records[0].Fields["message"] != "message mode" || !records[0].Time.Equal(ts.Truncate(time.Second)) {
+		t.Fatalf("unexpected record %+v", records[0])
+	}
+	if nested := records[0].Fields["nested"].(map[string]interface{}); nested["raw"] != "bytes" {
+		t.Fatalf("expected binary values as strings, got %+v", nested)
+	}
+	for i, want := range []string{"forward 1", "forward 2", "packed", "compressed"} {
+		if records[i+1].Fields["log"] != want {
+			t.Fatalf("record %d: expected %q, got %+v", i+1, want, records[i+1])
+		}
+	}
+	if !records[1].Time.Equal(ts) || !records[4].Time.Equal(ts) || records[4].Tag != "app.compressed" {
+		t.Fatalf("unexpected records %+v %+v", records[1], records[4])
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.invalid) != 0 {
+		t.Fatalf("unexpected invalid messages %v", c.invalid)
+	}
+}
+
+func TestServeSharedKeyHandshake(t *testing.T) {
+	for _, key := range []string{"secret", "wrong"} {
+		c := &collector{}
+		s := &Server{Handle: c.handle, Invalid: c.fail, SharedKey: "secret", Hostname: "sentry-relay"}
+		_, enc, dec := start(t, s)
+
+		helo, err := dec.DecodeSlice()
+		if err != nil || len(helo) != 2 || helo[0] != "HELO" {
+			t.Fatalf("unexpected HELO %v: %v", helo, err)
+		}
+		nonce := helo[1].(map[string]interface{})["nonce"].([]byte)
+		client := &Server{SharedKey: key}
+		ping := []interface{}{"PING", "fluent-bit", "salt", client.digest("salt", "fluent-bit", nonce), "", ""}
+		if err := enc.Encode(ping); err != nil {
+			t.Fatalf("encode: %v", err)
+		}
+		pong, err := dec.DecodeSlice()
+		if err != nil || len(pong) != 5 || pong[0] != "PONG" {
+			t.Fatalf("unexpected PONG %v: %v", pong, err)
+		}
+
+		if key != "secret" {
+			if pong[1] != false {
+				t.Fatalf("expected the wrong key to be refused, got %v", pong)
+			}
+			if invalid := c.waitInvalid(t, 1); len(invalid) != 1 {
+				t.Fatalf("expected one failure reported, got %v", invalid)
+			}
+			continue
+		}
+		if pong[1] != true || pong[3] != "sentry-relay" || pong[4] != s.digest("salt", "sentry-relay", nonce) {
+			t.Fatalf("unexpected PONG %v", pong)
+		}
+		if err := enc.Encode([]interface{}{"app", time.Now().Unix(), map[string]interface{}{"log": "hi"}}); err != nil {
+			t.Fatalf("encode: %v", err)
+		}
+		c.wait(t, 1)
+	}
+}
+
+func TestServeClosesOnMalformedMessage(t *testing.T) {
+	c := &collector{}
+	conn, enc, _ := start(t, &Server{Handle: c.handle, Invalid: c.fail, MaxChunkBytes: 1024})
+
+	if err := enc.Encode([]interface{}{"app", "not a time or entries", map[string]interface{}{"log": "x"}}); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	// A string second element is read as packed entries, which fail to decode.
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("expected the connection to be closed")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.invalid) != 1 || len(c.records) != 0 {
+		t.Fatalf("expected one invalid message, got %v and %d records", c.invalid, len(c.records))
+	}
+}
+
+func TestServeWithholdsAckWhenHandleFails(t *testing.T) {
+	c := &collector{err: errors.New("spool: disk full")}
+	conn, enc, _ := start(t, &Server{Handle: c.handle, Invalid: c.fail})
+
+	msg := []interface{}{"app", time.Now().Unix(), map[string]interface{}{"log": "x"}, map[string]interface{}{"chunk": "c1"}}
+	if err := enc.Encode(msg); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if n, err := conn.Read(make([]byte, 64)); err == nil {
+		t.Fatalf("expected the connection to be closed without an ack, read %d bytes", n)
+	}
+}
+
+func TestServeClosesIdleConnections(t *testing.T) {
+	c := &collector{}
+	conn, _, _ := start(t, &Server{Handle: c.handle, Invalid: c.fail, IdleTimeout: 50 * time.Millisecond})
+
+	started := time.Now()
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("expected the idle connection to be closed")
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Fatalf("expected the connection to be closed after the idle timeout, took %v", elapsed)
+	}
+}
+
+func TestServeWaitsWhileBusy(t *testing.T) {
+	c := &collector{}
+	var mu sync.Mutex
+	busy := true
+	s := &Server{
+		Handle:  c.handle,
+		Invalid: c.fail,
+		Busy: func() time.Duration {
+			mu.Lock()
+			defer mu.Unlock()
+			if busy {
+				return 10 * time.Millisecond
+			}
+			return 0
+		},
+	}
+	_, enc, _ := start(t, s)
+
+	if err := enc.Encode([]interface{}{"app", time.Now().Unix(), map[string]interface{}{"log": "x"}}); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	c.mu.Lock()
+	n := len(c.records)
+	c.mu.Unlock()
+	if n != 0 {
+		t.Fatalf("expected no records to be read while busy, got %d", n)
+	}
+
+	mu.Lock()
+	busy = false
+	mu.Unlock()
+	c.wait(t, 1)
+}
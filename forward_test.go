@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"http-to-sentry-go/forward"
+	"http-to-sentry-go/timestamp"
+)
+
+func TestBuildForwardEvent(t *testing.T) {
+	cfg := config{timestamps: timestamp.Policy{MaxPast: 100 * 365 * 24 * time.Hour}}
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.7"), Port: 24224}
+	recTime := time.Date(2026, 1, 29, 11, 41, 12, 500000000, time.UTC)
+
+	event, err := buildForwardEvent(cfg, forward.Record{
+		Tag:    "kube.var.log.containers.billing",
+		Time:   recTime,
+		Fields: map[string]interface{}{"log": "charge failed\n", "stream": "stderr"},
+	}, remote)
+	if err != nil {
+		t.Fatalf("build event: %v", err)
+	}
+	if event.Logger != "forward" || event.Message != "charge failed" || event.Level != sentry.LevelInfo {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	if event.Tags["fluent_tag"] != "kube.var.log.containers.billing" || event.Tags["remote_addr"] != "192.0.2.7:24224" {
+		t.Fatalf("unexpected tags %+v", event.Tags)
+	}
+	if !event.Timestamp.Equal(recTime) {
+		t.Fatalf("expected %s, got %s", recTime, event.Timestamp)
+	}
+
+	event, err = buildForwardEvent(cfg, forward.Record{
+		Tag:    "app",
+		Time:   recTime,
+		Fields: map[string]interface{}{"message": "payload", "level": "error", "timestamp": "2026-01-29T10:00:00Z", "tags": map[string]interface{}{"team": "payments"}},
+	}, remote)
+	if err != nil {
+		t.Fatalf("build event: %v", err)
+	}
+	if event.Message != "payload" || event.Level != sentry.LevelError || event.Tags["team"] != "payments" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	if want := time.Date(2026, 1, 29, 10, 0, 0, 0, time.UTC); !event.Timestamp.Equal(want) {
+		t.Fatalf("expected the payload timestamp %s, got %s", want, event.Timestamp)
+	}
+
+	cfg.timestamps = timestamp.Policy{MaxPast: time.Hour, Reject: true}
+	if _, err := buildForwardEvent(cfg, forward.Record{Tag: "app", Time: recTime.Add(-24 * time.Hour), Fields: map[string]interface{}{"log": "old"}}, remote); err == nil {
+		t.Fatalf("expected an out of range record time to be rejected")
+	}
+}
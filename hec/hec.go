@@ -0,0 +1,264 @@
+// Package hec implements the Splunk HTTP Event Collector endpoints, so tools
+// that only offer a Splunk HEC output can ship to Sentry.
+package hec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+	"http-to-sentry-go/internal/ingest"
+	"http-to-sentry-go/internal/logevent"
+	"http-to-sentry-go/reqbody"
+	"http-to-sentry-go/stacktrace"
+	"http-to-sentry-go/timestamp"
+)
+
+// Event is one entry of an event endpoint request.
+type Event struct {
+	Time       timestamp.Value        `json:"time"`
+	Host       string                 `json:"host"`
+	Source     string                 `json:"source"`
+	Sourcetype string                 `json:"sourcetype"`
+	Index      string                 `json:"index"`
+	Event      json.RawMessage        `json:"event"`
+	Fields     map[string]interface{} `json:"fields"`
+}
+
+// Response is the HEC acknowledgement body.
+type Response struct {
+	Text               string `json:"text"`
+	Code               int    `json:"code"`
+	InvalidEventNumber *int   `json:"invalid-event-number,omitempty"`
+}
+
+// HEC status codes, see the Splunk HTTP Event Collector documentation.
+const (
+	codeSuccess       = 0
+	codeNoData        = 5
+	codeInvalidFormat = 6
+	codeServerBusy    = 9
+	codeEventRequired = 12
+	codeEventBlank    = 13
+	codeHealthy       = 17
+)
+
+// messageKeys and levelKeys are looked up in object events and fields.
+var (
+	messageKeys = []string{"message", "msg", "log"}
+	levelKeys   = []string{"level", "severity", "log_level"}
+)
+
+type Handler struct {
+	ingest.Options
+}
+
+// HandleCollector serves the collector endpoints below the route path:
+// /event (also the route path itself), /raw and /health, each optionally
+// followed by /1.0.
+func (h Handler) HandleCollector(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(strings.TrimSuffix(r.URL.Path, "/"), "/1.0")
+	switch {
+	case strings.HasSuffix(path, "/health"):
+		writeResponse(w, http.StatusOK, Response{Text: "HEC is healthy", Code: codeHealthy})
+		return
+	case r.Method != http.MethodPost:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	maxBytes := h.BodyLimit(1048576)
+	body, err := reqbody.Read(w, r, maxBytes)
+	if err != nil {
+		status := reqbody.Status(err)
+		if status == http.StatusBadRequest {
+			h.ReportInvalid(1)
+		}
+		w.WriteHeader(status)
+		return
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		writeResponse(w, http.StatusBadRequest, Response{Text: "No data", Code: codeNoData})
+		return
+	}
+
+	defaults := Event{
+		Host:       r.URL.Query().Get("host"),
+		Source:     r.URL.Query().Get("source"),
+		Sourcetype: r.URL.Query().Get("sourcetype"),
+		Index:      r.URL.Query().Get("index"),
+	}
+	var events []Event
+	if strings.HasSuffix(path, "/raw") {
+		events = rawEvents(body, defaults)
+	} else {
+		var resp *Response
+		events, resp = decodeEvents(body, defaults)
+		if resp != nil {
+			h.ReportInvalid(1)
+			writeResponse(w, http.StatusBadRequest, *resp)
+			return
+		}
+	}
+
+	invalid := 0
+	for _, he := range events {
+		event, err := buildSentryEvent(he, r, h.Timestamps)
+		if err != nil {
+			invalid++
+			continue
+		}
+		if _, err := h.CaptureEvent(r.Context(), event); err != nil {
+			h.ReportInvalid(invalid)
+			status, wait := ingest.Status(err)
+			ingest.SetRetryAfter(w, wait)
+			writeResponse(w, status, Response{Text: "Server is busy", Code: codeServerBusy})
+			return
+		}
+	}
+	h.ReportInvalid(invalid)
+
+	writeResponse(w, http.StatusOK, Response{Text: "Success", Code: codeSuccess})
+}
+
+func writeResponse(w http.ResponseWriter, status int, resp Response) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
+
+// decodeEvents decodes concatenated JSON events. Nothing is captured from a
+// request with an invalid event; the returned response names the first one.
+func decodeEvents(body []byte, defaults Event) ([]Event, *Response) {
+	var events []Event
+	dec := json.NewDecoder(bytes.NewReader(body))
+	for n := 0; ; n++ {
+		he := defaults
+		err := dec.Decode(&he)
+		if errors.Is(err, io.EOF) {
+			return events, nil
+		}
+		invalidAt := func(text string, code int) *Response {
+			return &Response{Text: text, Code: code, InvalidEventNumber: &n}
+		}
+		if err != nil {
+			return nil, invalidAt("Invalid data format", codeInvalidFormat)
+		}
+		raw := bytes.TrimSpace(he.Event)
+		switch {
+		case len(raw) == 0 || string(raw) == "null":
+			return nil, invalidAt("Event field is required", codeEventRequired)
+		case string(raw) == `""`:
+			return nil, invalidAt("Event field cannot be blank", codeEventBlank)
+		}
+		events = append(events, he)
+	}
+}
+
+// rawEvents splits a raw endpoint body into one event per non-empty line.
+func rawEvents(body []byte, defaults Event) []Event {
+	var events []Event
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		line = bytes.TrimRight(line, "\r")
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		he := defaults
+		he.Event, _ = json.Marshal(string(line))
+		events = append(events, he)
+	}
+	return events
+}
+
+// buildSentryEvent maps a HEC event. It fails only for timestamps the policy
+// rejects.
+func buildSentryEvent(he Event, r *http.Request, policy timestamp.Policy) (*sentry.Event, error) {
+	event := logevent.New("hec", r)
+	event.Level = sentry.LevelInfo
+
+	if he.Time != "" {
+		event.Extra["hec_time"] = string(he.Time)
+		ts, clamped, err := policy.Resolve(string(he.Time), event.Timestamp)
+		if errors.Is(err, timestamp.ErrOutOfRange) {
+			return nil, err
+		}
+		if err == nil {
+			event.Timestamp = ts
+		}
+		if clamped {
+			event.Extra["hec_time_clamped"] = true
+		}
+	}
+
+	var level string
+	var payload interface{}
+	dec := json.NewDecoder(bytes.NewReader(he.Event))
+	dec.UseNumber()
+	_ = dec.Decode(&payload)
+	switch v := payload.(type) {
+	case string:
+		event.Message = v
+	case map[string]interface{}:
+		event.Message = firstString(v, messageKeys)
+		level = firstString(v, levelKeys)
+		event.Extra["event"] = v
+	default:
+		event.Extra["event"] = v
+	}
+	if event.Message == "" {
+		event.Message = string(he.Event)
+	}
+
+	for key, value := range he.Fields {
+		switch v := value.(type) {
+		case string:
+			logevent.AddTag(event.Tags, key, v)
+		case []interface{}:
+			values := make([]string, 0, len(v))
+			for _, item := range v {
+				values = append(values, fmt.Sprint(item))
+			}
+			sort.Strings(values)
+			logevent.AddTag(event.Tags, key, strings.Join(values, ","))
+		default:
+			event.Extra[key] = v
+		}
+	}
+	// The envelope and the connection win over fields of the same name.
+	logevent.AddTag(event.Tags, "host", he.Host)
+	logevent.AddTag(event.Tags, "source", he.Source)
+	logevent.AddTag(event.Tags, "sourcetype", he.Sourcetype)
+	logevent.AddTag(event.Tags, "index", he.Index)
+	logevent.AddTag(event.Tags, "remote_addr", r.RemoteAddr)
+	event.ServerName = he.Host
+
+	if level == "" {
+		level = firstString(he.Fields, levelKeys)
+	}
+	if level != "" {
+		event.Level = logevent.Level(level)
+	}
+
+	stacktrace.Attach(event)
+	return event, nil
+}
+
+func firstString(values map[string]interface{}, keys []string) string {
+	for _, key := range keys {
+		if s, ok := values[key].(string); ok && s != "" {
+			return s
+		}
+	}
+	return ""
+}
@@ -0,0 +1,153 @@
+package hec
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"http-to-sentry-go/internal/ingest"
+	"http-to-sentry-go/timestamp"
+)
+
+func serve(t *testing.T, h Handler, method, target, body string) (*httptest.ResponseRecorder, Response) {
+	t.Helper()
+	rw := httptest.NewRecorder()
+	h.HandleCollector(rw, httptest.NewRequest(method, target, strings.NewReader(body)))
+	var resp Response
+	if rw.Body.Len() > 0 {
+		if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode response %q: %v", rw.Body.String(), err)
+		}
+	}
+	return rw, resp
+}
+
+func TestHandleEvents(t *testing.T) {
+	var captured []*sentry.Event
+	h := Handler{
+		Options: ingest.Options{
+			Capture: func(_ context.Context, event *sentry.Event) (*sentry.EventID, error) {
+				captured = append(captured, event)
+				return &event.EventID, nil
+			},
+			Timestamps: timestamp.Policy{MaxPast: 100 * 365 * 24 * time.Hour},
+		},
+	}
+
+	body := `{"time": 1769686872.5, "host": "web-1", "source": "app.log", "sourcetype": "json", "index": "main",
+		"event": {"message": "charge failed", "level": "error", "order": 42}, "fields": {"region": "eu", "zones": ["b", "a"], "shard": 3, "remote_addr": "10.0.0.1", "source": "spoofed.log"}}
+		{"event": "plain line", "fields": {"severity": "warning"}}`
+	rw, resp := serve(t, h, http.MethodPost, "/services/collector/event?source=default.log", body)
+	if rw.Code != http.StatusOK || resp.Text != "Success" || resp.Code != 0 || len(captured) != 2 {
+		t.Fatalf("unexpected result %d %+v, %d captured", rw.Code, resp, len(captured))
+	}
+
+	event := captured[0]
+	if event.Logger != "hec" || event.Message != "charge failed" || event.Level != sentry.LevelError || event.ServerName != "web-1" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	if event.Tags["source"] != "app.log" || event.Tags["sourcetype"] != "json" || event.Tags["index"] != "main" || event.Tags["region"] != "eu" || event.Tags["zones"] != "a,b" {
+		t.Fatalf("unexpected tags %+v", event.Tags)
+	}
+	if event.Tags["remote_addr"] != "192.0.2.1:1234" {
+		t.Fatalf("fields overwrote remote_addr: %+v", event.Tags)
+	}
+	if want := time.Date(2026, 1, 29, 11, 41, 12, 500000000, time.UTC); !event.Timestamp.Equal(want) {
+		t.Fatalf("expected %s, got %s", want, event.Timestamp)
+	}
+	if event.Extra["shard"] == nil || event.Extra["event"] == nil {
+		t.Fatalf("unexpected extra %+v", event.Extra)
+	}
+	if event := captured[1]; event.Message != "plain line" || event.Level != sentry.LevelWarning || event.Tags["source"] != "default.log" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+}
+
+func TestHandleRaw(t *testing.T) {
+	var captured []*sentry.Event
+	h := Handler{
+		Options: ingest.Options{
+			Capture: func(_ context.Context, event *sentry.Event) (*sentry.EventID, error) {
+				captured = append(captured, event)
+				return &event.EventID, nil
+			},
+		},
+	}
+
+	rw, resp := serve(t, h, http.MethodPost, "/services/collector/raw/1.0?host=web-2&sourcetype=syslog", "first line\r\n\nsecond line\n")
+	if rw.Code != http.StatusOK || resp.Code != 0 || len(captured) != 2 {
+		t.Fatalf("unexpected result %d %+v, %d captured", rw.Code, resp, len(captured))
+	}
+	if captured[0].Message != "first line" || captured[1].Message != "second line" || captured[1].Tags["host"] != "web-2" || captured[1].Tags["sourcetype"] != "syslog" {
+		t.Fatalf("unexpected events %+v %+v", captured[0], captured[1])
+	}
+}
+
+func TestHandleCollectorErrors(t *testing.T) {
+	captured := 0
+	h := Handler{
+		Options: ingest.Options{
+			Capture: func(_ context.Context, event *sentry.Event) (*sentry.EventID, error) {
+				captured++
+				return &event.EventID, nil
+			},
+		},
+	}
+
+	cases := []struct {
+		body string
+		code int
+		n    int
+	}{
+		{"  ", codeNoData, -1},
+		{`{"event": "ok"} {"host": "web-1"}`, codeEventRequired, 1},
+		{`{"event": ""}`, codeEventBlank, 0},
+		{`{"event": "ok"} {"event": `, codeInvalidFormat, 1},
+	}
+	for _, tc := range cases {
+		rw, resp := serve(t, h, http.MethodPost, "/services/collector", tc.body)
+		if rw.Code != http.StatusBadRequest || resp.Code != tc.code {
+			t.Fatalf("%q: unexpected result %d %+v", tc.body, rw.Code, resp)
+		}
+		if tc.n >= 0 && (resp.InvalidEventNumber == nil || *resp.InvalidEventNumber != tc.n) {
+			t.Fatalf("%q: expected invalid event number %d, got %+v", tc.body, tc.n, resp)
+		}
+	}
+	if captured != 0 {
+		t.Fatalf("expected nothing to be captured from invalid requests, got %d", captured)
+	}
+
+	rw, resp := serve(t, h, http.MethodGet, "/services/collector/health/1.0", "")
+	if rw.Code != http.StatusOK || resp.Code != codeHealthy {
+		t.Fatalf("unexpected health response %d %+v", rw.Code, resp)
+	}
+}
+
+func TestHandleCollectorBusy(t *testing.T) {
+	captured := 0
+	h := Handler{
+		Options: ingest.Options{
+			Capture: func(_ context.Context, event *sentry.Event) (*sentry.EventID, error) {
+				captured++
+				return nil, &ingest.Error{Status: http.StatusServiceUnavailable, RetryAfter: 5 * time.Second, Err: errors.New("spool: disk full")}
+			},
+		},
+	}
+
+	rw, resp := serve(t, h, http.MethodPost, "/services/collector", `{"event": "one"} {"event": "two"}`)
+	if rw.Code != http.StatusServiceUnavailable || resp.Code != codeServerBusy {
+		t.Fatalf("unexpected result %d %+v", rw.Code, resp)
+	}
+	if got := rw.Header().Get("Retry-After"); got != "5" {
+		t.Fatalf("expected Retry-After 5, got %q", got)
+	}
+	if captured != 1 {
+		t.Fatalf("expected capture to stop at the first failure, got %d", captured)
+	}
+}
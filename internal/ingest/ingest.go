@@ -0,0 +1,119 @@
+// Package ingest holds what the protocol handlers share: their options and
+// how they answer a capture that failed.
+package ingest
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"http-to-sentry-go/timestamp"
+)
+
+// CaptureFunc sends an event. ctx is the context of the request the event
+// came with, which carries what the request proved about its sender. An
+// error means the event was not accepted; handlers stop at the first one
+// and answer with Status, so the sender retries.
+type CaptureFunc func(ctx context.Context, event *sentry.Event) (*sentry.EventID, error)
+
+// Options are the settings every protocol handler takes.
+type Options struct {
+	// MaxBodyBytes limits the request body; each handler has its own
+	// default.
+	MaxBodyBytes int
+	// Capture sends an event. Defaults to sentry.CaptureEvent.
+	Capture    CaptureFunc
+	Timestamps timestamp.Policy
+	// Invalid, if set, is called with the number of entries in a request
+	// that could not be decoded or were rejected.
+	Invalid func(count int)
+	// Authorize, if set, admits a request to handlers that check its
+	// credentials themselves, and returns it with what it proved about its
+	// sender. The error says how to answer.
+	Authorize func(r *http.Request) (*http.Request, error)
+}
+
+// BodyLimit returns MaxBodyBytes, or def if it is not set.
+func (o Options) BodyLimit(def int) int {
+	if o.MaxBodyBytes <= 0 {
+		return def
+	}
+	return o.MaxBodyBytes
+}
+
+// CaptureEvent sends event with Capture.
+func (o Options) CaptureEvent(ctx context.Context, event *sentry.Event) (*sentry.EventID, error) {
+	if o.Capture == nil {
+		return sentry.CaptureEvent(event), nil
+	}
+	return o.Capture(ctx, event)
+}
+
+// Admit calls Authorize, if set.
+func (o Options) Admit(r *http.Request) (*http.Request, error) {
+	if o.Authorize == nil {
+		return r, nil
+	}
+	return o.Authorize(r)
+}
+
+// ReportInvalid calls Invalid for a positive count.
+func (o Options) ReportInvalid(count int) {
+	if o.Invalid != nil && count > 0 {
+		o.Invalid(count)
+	}
+}
+
+// Error is a capture failure that tells the handler how to answer.
+type Error struct {
+	// Status is the HTTP status to answer with.
+	Status int
+	// RetryAfter, if set, is sent in the Retry-After header.
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Status returns the HTTP status and retry delay to answer a failed capture
+// with: those of an *Error, or 503.
+func Status(err error) (int, time.Duration) {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Status, e.RetryAfter
+	}
+	return http.StatusServiceUnavailable, 0
+}
+
+// SetRetryAfter sets the Retry-After header for a positive delay.
+func SetRetryAfter(w http.ResponseWriter, d time.Duration) {
+	if d > 0 {
+		w.Header().Set("Retry-After", RetryAfterSeconds(d))
+	}
+}
+
+// WriteError answers a failed capture in plain text.
+func WriteError(w http.ResponseWriter, err error) {
+	status, wait := Status(err)
+	SetRetryAfter(w, wait)
+	http.Error(w, err.Error(), status)
+}
+
+// RetryAfterSeconds formats d for a Retry-After header, rounding up.
+func RetryAfterSeconds(d time.Duration) string {
+	seconds := int(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
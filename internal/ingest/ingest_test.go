@@ -0,0 +1,32 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWriteError(t *testing.T) {
+	rw := httptest.NewRecorder()
+	err := fmt.Errorf("capture: %w", &Error{
+		Status:     http.StatusTooManyRequests,
+		RetryAfter: 1500 * time.Millisecond,
+		Err:        errors.New("quota exceeded"),
+	})
+	WriteError(rw, err)
+	if rw.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rw.Code)
+	}
+	if got := rw.Header().Get("Retry-After"); got != "2" {
+		t.Fatalf("expected Retry-After 2, got %q", got)
+	}
+
+	rw = httptest.NewRecorder()
+	WriteError(rw, errors.New("unknown"))
+	if rw.Code != http.StatusServiceUnavailable || rw.Header().Get("Retry-After") != "" {
+		t.Fatalf("expected a bare 503, got %d %q", rw.Code, rw.Header().Get("Retry-After"))
+	}
+}
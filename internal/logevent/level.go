@@ -0,0 +1,49 @@
+package logevent
+
+import (
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// ParseLevel maps the level names loggers and platforms use to a Sentry
+// level. It reports false for names it does not know.
+func ParseLevel(level string) (sentry.Level, bool) {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "trace", "debug", "dbug":
+		return sentry.LevelDebug, true
+	case "info", "notice":
+		return sentry.LevelInfo, true
+	case "warn", "warning":
+		return sentry.LevelWarning, true
+	case "error", "err", "eror":
+		return sentry.LevelError, true
+	case "fatal", "critical", "crit", "emergency", "emerg", "alert", "panic":
+		return sentry.LevelFatal, true
+	}
+	return "", false
+}
+
+// Level is ParseLevel with unknown names mapped to info.
+func Level(level string) sentry.Level {
+	if l, ok := ParseLevel(level); ok {
+		return l
+	}
+	return sentry.LevelInfo
+}
+
+// SeverityLevel maps an RFC 5424 severity, 0 (emergency) to 7 (debug), to a
+// Sentry level.
+func SeverityLevel(severity int) sentry.Level {
+	switch {
+	case severity <= 2:
+		return sentry.LevelFatal
+	case severity == 3:
+		return sentry.LevelError
+	case severity == 4:
+		return sentry.LevelWarning
+	case severity == 7:
+		return sentry.LevelDebug
+	}
+	return sentry.LevelInfo
+}
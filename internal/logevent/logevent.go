@@ -0,0 +1,53 @@
+// Package logevent holds the helpers the protocol handlers share to build
+// Sentry events from log lines and reports.
+package logevent
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// New returns an event for logger, received now, tagged with the address of
+// the client that sent it.
+func New(logger string, r *http.Request) *sentry.Event {
+	event := sentry.NewEvent()
+	event.Logger = logger
+	event.Timestamp = time.Now()
+	AddTag(event.Tags, "remote_addr", r.RemoteAddr)
+	return event
+}
+
+// AddTag sets a tag unless value is empty.
+func AddTag(tags map[string]string, key, value string) {
+	if value == "" {
+		return
+	}
+	tags[key] = value
+}
+
+// FirstNonEmpty returns the first value that is not empty.
+func FirstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// QueryString returns the raw query of a URL, or "" if it does not parse.
+func QueryString(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return parsed.RawQuery
+}
+
+// IsGzip reports whether data starts with the gzip magic number.
+func IsGzip(data []byte) bool {
+	return len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b
+}
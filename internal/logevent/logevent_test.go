@@ -0,0 +1,63 @@
+package logevent
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getsentry/sentry-go"
+)
+
+func TestNewTagsRemoteAddr(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	event := New("loki", r)
+	if event.Logger != "loki" || event.Timestamp.IsZero() || event.Tags["remote_addr"] != "192.0.2.1:1234" {
+		t.Fatalf("event = %+v", event)
+	}
+
+	AddTag(event.Tags, "host", "")
+	if _, ok := event.Tags["host"]; ok {
+		t.Fatalf("empty tag was set")
+	}
+	if got := FirstNonEmpty("", "b", "c"); got != "b" {
+		t.Fatalf("FirstNonEmpty = %q", got)
+	}
+	if got := QueryString("https://example.com/a?b=c"); got != "b=c" {
+		t.Fatalf("QueryString = %q", got)
+	}
+	if !IsGzip([]byte{0x1f, 0x8b, 8}) || IsGzip([]byte("{}")) {
+		t.Fatalf("IsGzip")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	cases := map[string]sentry.Level{
+		"DEBUG":    sentry.LevelDebug,
+		"dbug":     sentry.LevelDebug,
+		"notice":   sentry.LevelInfo,
+		" Warn ":   sentry.LevelWarning,
+		"eror":     sentry.LevelError,
+		"emerg":    sentry.LevelFatal,
+		"CRITICAL": sentry.LevelFatal,
+	}
+	for name, want := range cases {
+		if got, ok := ParseLevel(name); !ok || got != want {
+			t.Errorf("ParseLevel(%q) = %q, %v, want %q", name, got, ok, want)
+		}
+	}
+	if _, ok := ParseLevel("verbose"); ok {
+		t.Errorf("ParseLevel accepted an unknown level")
+	}
+	if got := Level("verbose"); got != sentry.LevelInfo {
+		t.Errorf("Level(unknown) = %q", got)
+	}
+
+	for severity, want := range []sentry.Level{
+		sentry.LevelFatal, sentry.LevelFatal, sentry.LevelFatal, sentry.LevelError,
+		sentry.LevelWarning, sentry.LevelInfo, sentry.LevelInfo, sentry.LevelDebug,
+	} {
+		if got := SeverityLevel(severity); got != want {
+			t.Errorf("SeverityLevel(%d) = %q, want %q", severity, got, want)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+// Package jwt validates RS256, ES256 and EdDSA signed JSON Web Tokens
+// against the keys of a local JWKS file, which is read again when it
+// changes.
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// checkInterval is how often the JWKS file is checked for changes. It is
+// checked when tokens are validated, so an idle service does not poll.
+const checkInterval = 5 * time.Second
+
+var (
+	// ErrMalformed is returned for tokens that are not a signed JWT.
+	ErrMalformed = errors.New("malformed token")
+	// ErrSignature is returned when no key of the JWKS verifies the token.
+	ErrSignature = errors.New("invalid token signature")
+	// ErrExpired is returned for tokens past exp or without exp.
+	ErrExpired = errors.New("token expired")
+	// ErrNotYetValid is returned for tokens before nbf.
+	ErrNotYetValid = errors.New("token not yet valid")
+	// ErrIssuer is returned when iss is not the configured issuer.
+	ErrIssuer = errors.New("token issuer not accepted")
+	// ErrAudience is returned when aud names none of the configured
+	// audiences.
+	ErrAudience = errors.New("token audience not accepted")
+)
+
+// Claims are the claims of a validated token.
+type Claims map[string]any
+
+// String returns a string claim, or a number claim formatted as a string.
+func (c Claims) String(name string) string {
+	switch v := c[name].(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	return ""
+}
+
+// HasScope reports whether the token grants scope, in a space separated
+// "scope" claim or a "scp" list.
+func (c Claims) HasScope(scope string) bool {
+	for _, s := range strings.Fields(c.String("scope")) {
+		if s == scope {
+			return true
+		}
+	}
+	if list, ok := c["scp"].([]any); ok {
+		for _, s := range list {
+			if s == scope {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Looks reports whether credential has the form of a JWT, so other bearer
+// tokens can be told apart without validating them.
+func Looks(credential string) bool {
+	return strings.Count(credential, ".") == 2 && strings.HasPrefix(credential, "eyJ")
+}
+
+// Validator checks tokens.
+type Validator struct {
+	file      string
+	issuer    string
+	audiences []string
+	leeway    time.Duration
+	// now is replaceable in tests.
+	now func() time.Time
+
+	mu      sync.Mutex
+	checked time.Time
+	modTime time.Time
+	keys    []key
+}
+
+// New reads the JWKS file and returns a Validator. Empty issuer or
+// audiences are not checked; leeway is the allowed clock skew for exp and
+// nbf.
+func New(file, issuer string, audiences []string, leeway time.Duration) (*Validator, error) {
+	v := &Validator{file: file, issuer: issuer, audiences: audiences, leeway: leeway, now: time.Now}
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.load(info.ModTime()); err != nil {
+		return nil, err
+	}
+	v.checked = v.now()
+	return v, nil
+}
+
+// Validate verifies the token's signature and time and issuer claims and
+// returns its claims.
+func (v *Validator) Validate(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrMalformed
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	if !v.verify(header.Alg, header.Kid, []byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrSignature
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil || claims == nil {
+		return nil, ErrMalformed
+	}
+	now := v.now()
+	exp, ok := numericDate(claims["exp"])
+	if !ok || !now.Before(exp.Add(v.leeway)) {
+		return nil, ErrExpired
+	}
+	if nbf, ok := numericDate(claims["nbf"]); ok && now.Add(v.leeway).Before(nbf) {
+		return nil, ErrNotYetValid
+	}
+	if v.issuer != "" && claims.String("iss") != v.issuer {
+		return nil, ErrIssuer
+	}
+	if len(v.audiences) > 0 && !v.audienceAccepted(claims["aud"]) {
+		return nil, ErrAudience
+	}
+	return claims, nil
+}
+
+func (v *Validator) audienceAccepted(aud any) bool {
+	var names []any
+	switch a := aud.(type) {
+	case string:
+		names = []any{a}
+	case []any:
+		names = a
+	}
+	for _, name := range names {
+		for _, want := range v.audiences {
+			if name == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (v *Validator) verify(alg, kid string, signed, signature []byte) bool {
+	digest := sha256.Sum256(signed)
+	for _, k := range v.currentKeys(v.now()) {
+		if (kid != "" && k.id != "" && k.id != kid) || (k.alg != "" && k.alg != alg) {
+			continue
+		}
+		switch pub := k.public.(type) {
+		case *rsa.PublicKey:
+			if alg == "RS256" && rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil {
+				return true
+			}
+		case *ecdsa.PublicKey:
+			if alg == "ES256" && len(signature) == 64 {
+				r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+				if ecdsa.Verify(pub, digest[:], r, s) {
+					return true
+				}
+			}
+		case ed25519.PublicKey:
+			if alg == "EdDSA" && ed25519.Verify(pub, signed, signature) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (v *Validator) currentKeys(now time.Time) []key {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if now.Sub(v.checked) < checkInterval {
+		return v.keys
+	}
+	v.checked = now
+	info, err := os.Stat(v.file)
+	if err != nil {
+		log.Printf("jwt: keeping loaded keys: %v", err)
+		return v.keys
+	}
+	if info.ModTime().Equal(v.modTime) {
+		return v.keys
+	}
+	if err := v.load(info.ModTime()); err != nil {
+		log.Printf("jwt: keeping loaded keys: %v", err)
+		return v.keys
+	}
+	log.Printf("jwt: reloaded %s", v.file)
+	return v.keys
+}
+
+func (v *Validator) load(modTime time.Time) error {
+	data, err := os.ReadFile(v.file)
+	if err != nil {
+		return err
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", v.file, err)
+	}
+	v.keys = keys
+	v.modTime = modTime
+	return nil
+}
+
+func decodeSegment(segment string, dst any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(dst)
+}
+
+func numericDate(value any) (time.Time, bool) {
+	n, ok := value.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	seconds, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(seconds*float64(time.Second))), true
+}
@@ -0,0 +1,209 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var b64 = base64.RawURLEncoding
+
+type signer struct {
+	kid, alg string
+	sign     func(payload []byte) []byte
+	jwk      map[string]string
+}
+
+func rsaSigner(t *testing.T, kid string) signer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer{kid, "RS256", func(payload []byte) []byte {
+		digest := sha256.Sum256(payload)
+		sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}, map[string]string{"kty": "RSA", "kid": kid, "n": b64.EncodeToString(key.N.Bytes()), "e": b64.EncodeToString(big.NewInt(int64(key.E)).Bytes())}}
+}
+
+func ecSigner(t *testing.T, kid string) signer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer{kid, "ES256", func(payload []byte) []byte {
+		digest := sha256.Sum256(payload)
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig
+	}, map[string]string{"kty": "EC", "crv": "P-256", "kid": kid, "alg": "ES256", "x": b64.EncodeToString(key.X.FillBytes(make([]byte, 32))), "y": b64.EncodeToString(key.Y.FillBytes(make([]byte, 32)))}}
+}
+
+func edSigner(t *testing.T, kid string) signer {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer{kid, "EdDSA", func(payload []byte) []byte {
+		return ed25519.Sign(private, payload)
+	}, map[string]string{"kty": "OKP", "crv": "Ed25519", "kid": kid, "x": b64.EncodeToString(public)}}
+}
+
+func (s signer) token(t *testing.T, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": s.alg, "kid": s.kid, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	return signed + "." + b64.EncodeToString(s.sign([]byte(signed)))
+}
+
+func writeJWKS(t *testing.T, filename string, modTime time.Time, signers ...signer) {
+	t.Helper()
+	var keys []map[string]string
+	for _, s := range signers {
+		keys = append(keys, s.jwk)
+	}
+	data, _ := json.Marshal(map[string]any{"keys": keys})
+	if err := os.WriteFile(filename, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filename, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateAlgorithmsAndClaims(t *testing.T) {
+	now := time.Date(2026, 5, 4, 9, 30, 0, 0, time.UTC)
+	rs, es, ed := rsaSigner(t, "rs"), ecSigner(t, "es"), edSigner(t, "ed")
+	filename := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, filename, now, rs, es, ed)
+
+	v, err := New(filename, "https://gateway.internal", []string{"http-to-sentry"}, time.Minute)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	v.now = func() time.Time { return now }
+
+	claims := func(change func(map[string]any)) map[string]any {
+		c := map[string]any{
+			"iss":    "https://gateway.internal",
+			"aud":    []string{"other", "http-to-sentry"},
+			"sub":    "svc-billing",
+			"exp":    now.Add(5 * time.Minute).Unix(),
+			"nbf":    now.Add(-time.Minute).Unix(),
+			"scope":  "logs:write metrics:read",
+			"tenant": "acme",
+		}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+	for _, s := range []signer{rs, es, ed} {
+		got, err := v.Validate(s.token(t, claims(nil)))
+		if err != nil {
+			t.Fatalf("%s: %v", s.alg, err)
+		}
+		if got.String("tenant") != "acme" || !got.HasScope("logs:write") || got.HasScope("logs") {
+			t.Fatalf("%s: claims = %v", s.alg, got)
+		}
+	}
+
+	other := edSigner(t, "ed")
+	for name, tc := range map[string]struct {
+		token string
+		want  error
+	}{
+		"expired":       {rs.token(t, claims(func(c map[string]any) { c["exp"] = now.Add(-2 * time.Minute).Unix() })), ErrExpired},
+		"no exp":        {rs.token(t, claims(func(c map[string]any) { delete(c, "exp") })), ErrExpired},
+		"not yet valid": {es.token(t, claims(func(c map[string]any) { c["nbf"] = now.Add(2 * time.Minute).Unix() })), ErrNotYetValid},
+		"issuer":        {ed.token(t, claims(func(c map[string]any) { c["iss"] = "https://evil.example" })), ErrIssuer},
+		"audience":      {ed.token(t, claims(func(c map[string]any) { c["aud"] = "other" })), ErrAudience},
+		"unknown key":   {other.token(t, claims(nil)), ErrSignature},
+		"wrong alg":     {signer{"rs", "ES256", rs.sign, nil}.token(t, claims(nil)), ErrSignature},
+		"alg none":      {b64.EncodeToString([]byte(`{"alg":"none"}`)) + "." + b64.EncodeToString([]byte(`{"exp":9999999999}`)) + ".", ErrSignature},
+		"malformed":     {"not-a-jwt", ErrMalformed},
+	} {
+		if _, err := v.Validate(tc.token); !errors.Is(err, tc.want) {
+			t.Fatalf("%s: got %v, want %v", name, err, tc.want)
+		}
+	}
+
+	// Within the leeway, a just expired token is accepted.
+	if _, err := v.Validate(rs.token(t, claims(func(c map[string]any) { c["exp"] = now.Add(-30 * time.Second).Unix() }))); err != nil {
+		t.Fatalf("leeway: %v", err)
+	}
+	if !Looks(rs.token(t, claims(nil))) || Looks("static-token") {
+		t.Fatalf("Looks should tell JWTs from static tokens")
+	}
+}
+
+func TestJWKSReloadsWhenChanged(t *testing.T) {
+	now := time.Now()
+	first, second := edSigner(t, "2026-01"), edSigner(t, "2026-02")
+	filename := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, filename, now.Add(-time.Hour), first)
+
+	v, err := New(filename, "", nil, 0)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	v.now = func() time.Time { return now }
+	claims := map[string]any{"exp": now.Add(time.Hour).Unix(), "scp": []string{"logs:write"}}
+	if c, err := v.Validate(first.token(t, claims)); err != nil || !c.HasScope("logs:write") {
+		t.Fatalf("first key: %v %v", c, err)
+	}
+
+	writeJWKS(t, filename, now, second)
+	if _, err := v.Validate(second.token(t, claims)); !errors.Is(err, ErrSignature) {
+		t.Fatalf("checked again before the interval: %v", err)
+	}
+	now = now.Add(checkInterval + time.Second)
+	if _, err := v.Validate(second.token(t, claims)); err != nil {
+		t.Fatalf("rotated key: %v", err)
+	}
+	if _, err := v.Validate(first.token(t, claims)); !errors.Is(err, ErrSignature) {
+		t.Fatalf("removed key: %v", err)
+	}
+
+	// A broken file keeps the loaded keys.
+	if err := os.WriteFile(filename, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(checkInterval)
+	if _, err := v.Validate(second.token(t, claims)); err != nil {
+		t.Fatalf("after broken file: %v", err)
+	}
+
+	for name, content := range map[string]string{
+		"no keys":   `{"keys":[{"kty":"oct","k":"c2VjcmV0"}]}`,
+		"small rsa": `{"keys":[{"kty":"RSA","n":"AQAB","e":"AQAB"}]}`,
+		"off curve": `{"keys":[{"kty":"EC","crv":"P-256","x":"AQ","y":"AQ"}]}`,
+	} {
+		if _, err := parseJWKS([]byte(content)); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
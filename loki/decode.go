@@ -0,0 +1,220 @@
+package loki
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"http-to-sentry-go/pbwire"
+)
+
+// Entry is one log line with the labels of its stream.
+type Entry struct {
+	Labels   map[string]string
+	Time     time.Time
+	Line     string
+	Metadata map[string]string
+}
+
+// DecodeProtobuf decodes a PushRequest in the protobuf encoding, after
+// snappy decompression.
+func DecodeProtobuf(data []byte) ([]Entry, error) {
+	var entries []Entry
+	err := pbwire.Each(data, func(f pbwire.Field) error {
+		if f.Num != 1 || f.Type != protowire.BytesType {
+			return nil
+		}
+		decoded, err := decodeStream(f.Bytes)
+		entries = append(entries, decoded...)
+		return err
+	})
+	return entries, err
+}
+
+func decodeStream(b []byte) ([]Entry, error) {
+	var labels map[string]string
+	var raw [][]byte
+	err := pbwire.Each(b, func(f pbwire.Field) error {
+		if f.Type != protowire.BytesType {
+			return nil
+		}
+		switch f.Num {
+		case 1:
+			parsed, err := ParseLabels(f.String())
+			if err != nil {
+				return err
+			}
+			labels = parsed
+		case 2:
+			raw = append(raw, f.Bytes)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(raw))
+	for _, eb := range raw {
+		entry := Entry{Labels: labels}
+		err := pbwire.Each(eb, func(f pbwire.Field) error {
+			if f.Type != protowire.BytesType {
+				return nil
+			}
+			switch f.Num {
+			case 1:
+				entry.Time = decodeTimestamp(f.Bytes)
+			case 2:
+				entry.Line = f.String()
+			case 3:
+				var name, value string
+				err := pbwire.Each(f.Bytes, func(f pbwire.Field) error {
+					switch {
+					case f.Num == 1 && f.Type == protowire.BytesType:
+						name = f.String()
+					case f.Num == 2 && f.Type == protowire.BytesType:
+						value = f.String()
+					}
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+				if entry.Metadata == nil {
+					entry.Metadata = map[string]string{}
+				}
+				entry.Metadata[name] = value
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// decodeTimestamp decodes a google.protobuf.Timestamp, ignoring malformed
+// fields.
+func decodeTimestamp(b []byte) time.Time {
+	var seconds, nanos int64
+	_ = pbwire.Each(b, func(f pbwire.Field) error {
+		switch {
+		case f.Num == 1 && f.Type == protowire.VarintType:
+			seconds = f.Int()
+		case f.Num == 2 && f.Type == protowire.VarintType:
+			nanos = int64(int32(f.Uint))
+		}
+		return nil
+	})
+	if seconds == 0 && nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(seconds, nanos).UTC()
+}
+
+// DecodeJSON decodes a push request in the JSON encoding:
+//
+//	{"streams": [{"stream": {"job": "api"}, "values": [["<unix ns>", "line", {"trace_id": "..."}]]}]}
+func DecodeJSON(data []byte) ([]Entry, error) {
+	var req struct {
+		Streams []struct {
+			Stream map[string]string   `json:"stream"`
+			Values [][]json.RawMessage `json:"values"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for i, stream := range req.Streams {
+		for j, value := range stream.Values {
+			entry, err := decodeJSONValue(value)
+			if err != nil {
+				return nil, fmt.Errorf("streams[%d].values[%d]: %w", i, j, err)
+			}
+			entry.Labels = stream.Stream
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func decodeJSONValue(value []json.RawMessage) (Entry, error) {
+	var entry Entry
+	if len(value) < 2 || len(value) > 3 {
+		return entry, errors.New("expected [timestamp, line] or [timestamp, line, metadata]")
+	}
+	var ts string
+	if err := json.Unmarshal(value[0], &ts); err != nil {
+		return entry, fmt.Errorf("timestamp: %w", err)
+	}
+	ns, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return entry, fmt.Errorf("timestamp: %w", err)
+	}
+	entry.Time = time.Unix(0, ns).UTC()
+	if err := json.Unmarshal(value[1], &entry.Line); err != nil {
+		return entry, fmt.Errorf("line: %w", err)
+	}
+	if len(value) == 3 {
+		if err := json.Unmarshal(value[2], &entry.Metadata); err != nil {
+			return entry, fmt.Errorf("metadata: %w", err)
+		}
+	}
+	return entry, nil
+}
+
+// ParseLabels parses a stream selector such as `{job="api", env="prod"}`.
+func ParseLabels(s string) (map[string]string, error) {
+	rest := strings.TrimSpace(s)
+	if !strings.HasPrefix(rest, "{") {
+		return nil, fmt.Errorf("labels %q: expected {", s)
+	}
+	rest = strings.TrimLeft(rest[1:], " \t")
+
+	labels := map[string]string{}
+	for !strings.HasPrefix(rest, "}") {
+		i := 0
+		for i < len(rest) && isLabelNameByte(rest[i], i) {
+			i++
+		}
+		name := rest[:i]
+		rest = strings.TrimLeft(rest[i:], " \t")
+		if name == "" || !strings.HasPrefix(rest, "=") {
+			return nil, fmt.Errorf("labels %q: expected name=\"value\"", s)
+		}
+		rest = strings.TrimLeft(rest[1:], " \t")
+
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil || !strings.HasPrefix(quoted, `"`) {
+			return nil, fmt.Errorf("labels %q: value of %s must be a quoted string", s, name)
+		}
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, fmt.Errorf("labels %q: %w", s, err)
+		}
+		labels[name] = value
+		rest = strings.TrimLeft(rest[len(quoted):], " \t")
+
+		if strings.HasPrefix(rest, ",") {
+			rest = strings.TrimLeft(rest[1:], " \t")
+		} else if !strings.HasPrefix(rest, "}") {
+			return nil, fmt.Errorf("labels %q: expected , or }", s)
+		}
+	}
+	if strings.TrimSpace(rest[1:]) != "" {
+		return nil, fmt.Errorf("labels %q: unexpected text after }", s)
+	}
+	return labels, nil
+}
+
+func isLabelNameByte(c byte, i int) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || i > 0 && c >= '0' && c <= '9'
+}
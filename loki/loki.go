@@ -0,0 +1,177 @@
+// Package loki implements the Grafana Loki push API, so log agents such as
+// Promtail, Grafana Alloy and Vector can ship to Sentry. Each log line
+// becomes one event tagged with its stream labels.
+package loki
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/golang/snappy"
+	"http-to-sentry-go/internal/ingest"
+	"http-to-sentry-go/internal/logevent"
+	"http-to-sentry-go/mapping"
+	"http-to-sentry-go/reqbody"
+	"http-to-sentry-go/stacktrace"
+	"http-to-sentry-go/timestamp"
+)
+
+// levelLabels are the labels and structured metadata keys holding the log
+// level, in order of preference.
+var levelLabels = []string{"level", "detected_level", "severity", "lvl"}
+
+type Handler struct {
+	ingest.Options
+	// ParseLine, if set, extracts event fields from a log line. ok is false
+	// for lines it cannot parse, which are captured as plain text.
+	ParseLine func(line string) (fields mapping.Fields, ok bool)
+}
+
+// HandlePush serves /loki/api/v1/push. Like Loki, it takes JSON bodies with
+// Content-Type application/json and snappy-compressed protobuf otherwise,
+// and answers 204 on success.
+func (h Handler) HandlePush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	maxBytes := h.BodyLimit(1048576)
+	body, err := reqbody.Read(w, r, maxBytes)
+	if err != nil {
+		status := reqbody.Status(err)
+		if status == http.StatusBadRequest {
+			h.ReportInvalid(1)
+		}
+		w.WriteHeader(status)
+		return
+	}
+
+	var entries []Entry
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		entries, err = DecodeJSON(body)
+	} else {
+		entries, err = decodeSnappyProtobuf(body, maxBytes)
+	}
+	if errors.Is(err, reqbody.ErrTooLarge) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		h.ReportInvalid(1)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rejected := 0
+	for _, entry := range entries {
+		event, err := buildSentryEvent(entry, r, h.Timestamps, h.ParseLine)
+		if err != nil {
+			rejected++
+			continue
+		}
+		if _, err := h.CaptureEvent(r.Context(), event); err != nil {
+			h.ReportInvalid(rejected)
+			ingest.WriteError(w, err)
+			return
+		}
+	}
+	h.ReportInvalid(rejected)
+
+	if rejected > 0 {
+		http.Error(w, fmt.Sprintf("%d entries have timestamps outside the accepted range", rejected), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func decodeSnappyProtobuf(body []byte, maxBytes int) ([]Entry, error) {
+	n, err := snappy.DecodedLen(body)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxBytes {
+		return nil, reqbody.ErrTooLarge
+	}
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeProtobuf(data)
+}
+
+// buildSentryEvent maps a log entry. It fails only for timestamps the policy
+// rejects.
+func buildSentryEvent(entry Entry, r *http.Request, policy timestamp.Policy, parseLine func(string) (mapping.Fields, bool)) (*sentry.Event, error) {
+	event := logevent.New("loki", r)
+	event.Message = entry.Line
+	event.Level = sentry.LevelInfo
+
+	for key, value := range entry.Labels {
+		logevent.AddTag(event.Tags, key, value)
+	}
+	for key, value := range entry.Metadata {
+		event.Extra[key] = value
+	}
+	for _, key := range levelLabels {
+		if level := logevent.FirstNonEmpty(entry.Labels[key], entry.Metadata[key]); level != "" {
+			event.Level = logevent.Level(level)
+			break
+		}
+	}
+
+	if !entry.Time.IsZero() {
+		event.Extra["loki_timestamp"] = entry.Time.Format(time.RFC3339Nano)
+		ts, clamped, err := policy.Apply(entry.Time, event.Timestamp)
+		if errors.Is(err, timestamp.ErrOutOfRange) {
+			return nil, err
+		}
+		event.Timestamp = ts
+		if clamped {
+			event.Extra["loki_timestamp_clamped"] = true
+		}
+	}
+
+	if parseLine != nil {
+		if fields, ok := parseLine(entry.Line); ok {
+			if err := applyFields(event, fields, policy); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if event.Message == "" {
+		event.Message = "(empty message)"
+	}
+	stacktrace.Attach(event)
+	return event, nil
+}
+
+// applyFields overlays the fields parsed from a line on the event. Tags and
+// extras from the line win over labels and structured metadata.
+func applyFields(event *sentry.Event, fields mapping.Fields, policy timestamp.Policy) error {
+	fields.Apply(event)
+	if fields.Level != "" {
+		event.Level = logevent.Level(fields.Level)
+	}
+
+	if fields.Timestamp != "" {
+		event.Extra["payload_timestamp"] = fields.Timestamp
+		ts, clamped, err := policy.Resolve(fields.Timestamp, event.Timestamp)
+		if errors.Is(err, timestamp.ErrOutOfRange) {
+			return err
+		}
+		if err == nil {
+			event.Timestamp = ts
+		}
+		if clamped {
+			event.Extra["payload_timestamp_clamped"] = true
+		}
+	}
+	return nil
+}
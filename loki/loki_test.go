@@ -0,0 +1,149 @@
+package loki
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+	"http-to-sentry-go/internal/ingest"
+	"http-to-sentry-go/mapping"
+	"http-to-sentry-go/timestamp"
+)
+
+func bytesField(num protowire.Number, b []byte) []byte {
+	return protowire.AppendBytes(protowire.AppendTag(nil, num, protowire.BytesType), b)
+}
+
+func varintField(num protowire.Number, v uint64) []byte {
+	return protowire.AppendVarint(protowire.AppendTag(nil, num, protowire.VarintType), v)
+}
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels(`{job="api", filename="/var/log/a \"b\".log",env="prod" }`)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(labels) != 3 || labels["job"] != "api" || labels["filename"] != `/var/log/a "b".log` || labels["env"] != "prod" {
+		t.Fatalf("unexpected labels %+v", labels)
+	}
+	if labels, err := ParseLabels("{}"); err != nil || len(labels) != 0 {
+		t.Fatalf("unexpected empty labels %+v, %v", labels, err)
+	}
+	for _, invalid := range []string{`job="api"`, `{job=api}`, `{job="api"`, `{1job="api"}`, `{job="api"} x`} {
+		if _, err := ParseLabels(invalid); err == nil {
+			t.Fatalf("%s: expected error", invalid)
+		}
+	}
+}
+
+func TestDecodeProtobuf(t *testing.T) {
+	when := time.Date(2026, 1, 29, 11, 41, 12, 5, time.UTC)
+	entry := bytes.Join([][]byte{
+		bytesField(1, append(varintField(1, uint64(when.Unix())), varintField(2, 5)...)),
+		bytesField(2, []byte("charge failed")),
+		bytesField(3, append(bytesField(1, []byte("trace_id")), bytesField(2, []byte("abc"))...)),
+	}, nil)
+	req := bytesField(1, append(bytesField(1, []byte(`{job="billing", level="error"}`)), bytesField(2, entry)...))
+
+	entries, err := DecodeProtobuf(req)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(entries))
+	}
+	got := entries[0]
+	if !got.Time.Equal(when) || got.Line != "charge failed" || got.Labels["job"] != "billing" || got.Metadata["trace_id"] != "abc" {
+		t.Fatalf("unexpected entry %+v", got)
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	entries, err := DecodeJSON([]byte(`{"streams":[{"stream":{"job":"api"},"values":[
+		["1769686872000000000","first"],
+		["1769686873000000000","second",{"user":"42"}]
+	]}]}`))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(entries) != 2 || entries[1].Line != "second" || entries[1].Metadata["user"] != "42" || entries[0].Labels["job"] != "api" {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+	if want := time.Date(2026, 1, 29, 11, 41, 12, 0, time.UTC); !entries[0].Time.Equal(want) {
+		t.Fatalf("expected %s, got %s", want, entries[0].Time)
+	}
+
+	if _, err := DecodeJSON([]byte(`{"streams":[{"stream":{},"values":[["soon","x"]]}]}`)); err == nil {
+		t.Fatalf("expected error for invalid timestamp")
+	}
+}
+
+func TestHandlePush(t *testing.T) {
+	var captured []*sentry.Event
+	invalid := 0
+	h := Handler{
+		Options: ingest.Options{
+			Capture: func(_ context.Context, event *sentry.Event) (*sentry.EventID, error) {
+				captured = append(captured, event)
+				return &event.EventID, nil
+			},
+			Timestamps: timestamp.Policy{MaxPast: 100 * 365 * 24 * time.Hour},
+			Invalid:    func(count int) { invalid += count },
+		},
+		ParseLine: func(line string) (mapping.Fields, bool) {
+			var fields struct {
+				Message string `json:"message"`
+				Level   string `json:"level"`
+			}
+			if err := json.Unmarshal([]byte(line), &fields); err != nil {
+				return mapping.Fields{}, false
+			}
+			return mapping.Fields{Message: fields.Message, Level: fields.Level}, true
+		},
+	}
+
+	entry := bytes.Join([][]byte{
+		bytesField(1, varintField(1, uint64(time.Now().Unix()))),
+		bytesField(2, []byte(`{"message":"charge failed","level":"error"}`)),
+	}, nil)
+	plain := bytesField(2, []byte("plain line"))
+	stream := bytes.Join([][]byte{bytesField(1, []byte(`{job="billing", level="warn"}`)), bytesField(2, entry), bytesField(2, plain)}, nil)
+	req := httptest.NewRequest(http.MethodPost, "/loki/api/v1/push", bytes.NewReader(snappy.Encode(nil, bytesField(1, stream))))
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	rw := httptest.NewRecorder()
+	h.HandlePush(rw, req)
+
+	if rw.Code != http.StatusNoContent || len(captured) != 2 {
+		t.Fatalf("unexpected result %d, %d captured", rw.Code, len(captured))
+	}
+	if event := captured[0]; event.Logger != "loki" || event.Message != "charge failed" || event.Level != sentry.LevelError || event.Tags["job"] != "billing" {
+		t.Fatalf("unexpected parsed event %+v", event)
+	}
+	if event := captured[1]; event.Message != "plain line" || event.Level != sentry.LevelWarning {
+		t.Fatalf("unexpected plain event %+v", event)
+	}
+
+	h.Timestamps = timestamp.Policy{MaxPast: time.Hour, Reject: true}
+	req = httptest.NewRequest(http.MethodPost, "/loki/api/v1/push", strings.NewReader(`{"streams":[{"stream":{"job":"api"},"values":[["1000000000","stale"]]}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	rw = httptest.NewRecorder()
+	h.HandlePush(rw, req)
+	if rw.Code != http.StatusBadRequest || len(captured) != 2 || invalid != 1 {
+		t.Fatalf("expected the stale entry to be rejected, got %d, %d captured, %d invalid", rw.Code, len(captured), invalid)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/loki/api/v1/push", strings.NewReader("not snappy"))
+	rw = httptest.NewRecorder()
+	h.HandlePush(rw, req)
+	if rw.Code != http.StatusBadRequest || invalid != 2 {
+		t.Fatalf("expected 400 for an invalid body, got %d", rw.Code)
+	}
+}
@@ -3,10 +3,17 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"flag"
+	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -17,68 +24,245 @@ import (
 	"time"
 
 	"github.com/getsentry/sentry-go"
-	"http-to-sentry-go/fastly"
+	"http-to-sentry-go/drain"
+	"http-to-sentry-go/internal/ingest"
+	"http-to-sentry-go/internal/logevent"
+	"http-to-sentry-go/jwt"
+	"http-to-sentry-go/mapping"
+	"http-to-sentry-go/metrics"
+	"http-to-sentry-go/mtls"
+	"http-to-sentry-go/signature"
+	"http-to-sentry-go/stacktrace"
+	"http-to-sentry-go/timestamp"
+	"http-to-sentry-go/tokens"
+	"http-to-sentry-go/transport"
 )
 
 type config struct {
-	httpAddr        string
-	httpsAddr       string
-	httpsCertFile   string
-	httpsKeyFile    string
-	httpPath        string
-	fastlyPath      string
-	fastlyServiceID string
-	authToken       string
-	maxBodyBytes    int
-	flushTimeout    time.Duration
-	shutdownGrace   time.Duration
+	sentryDSN         string
+	sentryEnvironment string
+	sentryRelease     string
+	sentryQueueSize   int
+	httpAddr          string
+	httpsAddr         string
+	httpsCertFile     string
+	httpsKeyFile      string
+	httpsClientCAFile string
+	httpsClientAuth   tls.ClientAuthType
+	httpPath          string
+	fastlyPath        string
+	otlpPath          string
+	lokiPath          string
+	lokiJSONLines     bool
+	hecPath           string
+	firehosePath      string
+	elasticPath       string
+	elasticVersion    string
+	herokuPath        string
+	vercelPath        string
+	netlifyPath       string
+	cloudflarePath    string
+	reportsPath       string
+	reportsOrigins    []string
+	drainFilter       drain.Filter
+	vercelSecrets     []string
+	vercelVerifyToken string
+	metricsPath       string
+	fastlyServiceID   string
+	authToken         string
+	maxBodyBytes      int
+	flushTimeout      time.Duration
+	shutdownGrace     time.Duration
+	syslogUDPAddr     string
+	syslogTCPAddr     string
+	syslogTLSAddr     string
+	syslogCertFile    string
+	syslogKeyFile     string
+	syslogAllowed     []*net.IPNet
+	allowedCIDRs      []*net.IPNet
+	trustedProxies    []*net.IPNet
+	fastlyRanges      []*net.IPNet
+	syslogMaxBytes    int
+	syslogRoute       string
+	forwardAddr       string
+	forwardSharedKey  string
+	forwardHostname   string
+	forwardMaxBytes   int
+	forwardIdle       time.Duration
+	forwardRoute      string
+	spoolDir          string
+	spoolSegmentBytes int64
+	timestamps        timestamp.Policy
+	mapping           *mapping.Mapping
+	routes            []routeConfig
+	tokenStore        *tokens.Store
+	jwt               *jwt.Validator
+	jwtClaims         jwtClaims
+	// per-route settings, see buildRoutes
+	route       string
+	authSchemes []string
+	authTokens  []string
+	signature   *signature.Verifier
+	clientCert  *mtls.Allowlist
+	jwtScope    string
+}
+
+// caller is what a request proved about its sender: the scoped token it
+// used and the verified identity stamped onto the events it sends. It is
+// carried in the request context, see withCaller.
+type caller struct {
+	token *routeToken
+	tags  map[string]string
+	user  sentry.User
+}
+
+type callerKey struct{}
+
+// withCaller returns a copy of r whose context carries c.
+func withCaller(r *http.Request, c *caller) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), callerKey{}, c))
+}
+
+// callerFrom returns the caller carried by ctx, or nil.
+func callerFrom(ctx context.Context) *caller {
+	c, _ := ctx.Value(callerKey{}).(*caller)
+	return c
+}
+
+// jwtClaims names the JWT claims copied onto events.
+type jwtClaims struct {
+	tags                    []string
+	userID, email, username string
+}
+
+func (j jwtClaims) empty() bool {
+	return len(j.tags) == 0 && j.userID == "" && j.email == "" && j.username == ""
+}
+
+// newCaller records the scoped token, the client certificate identity as
+// the client_identity tag and the configured claims as tags and the user.
+func newCaller(t *routeToken, id *mtls.Identity, claims jwt.Claims, names jwtClaims) *caller {
+	c := &caller{token: t, tags: map[string]string{}}
+	if id != nil {
+		c.tags["client_identity"] = id.String()
+	}
+	if claims != nil {
+		for _, name := range names.tags {
+			if value := claims.String(name); value != "" {
+				c.tags[name] = value
+			}
+		}
+		c.user = sentry.User{
+			ID:       claims.String(names.userID),
+			Email:    claims.String(names.email),
+			Username: claims.String(names.username),
+		}
+	}
+	return c
+}
+
+// apply sets the caller's tags and fills the user fields the event does
+// not set.
+func (c *caller) apply(event *sentry.Event) {
+	if event.Tags == nil {
+		event.Tags = map[string]string{}
+	}
+	for key, value := range c.tags {
+		event.Tags[key] = value
+	}
+	if event.User.ID == "" {
+		event.User.ID = c.user.ID
+	}
+	if event.User.Email == "" {
+		event.User.Email = c.user.Email
+	}
+	if event.User.Username == "" {
+		event.User.Username = c.user.Username
+	}
 }
 
 type payload struct {
 	Message   string                 `json:"message"`
 	Level     string                 `json:"level"`
-	Timestamp string                 `json:"timestamp"`
+	Timestamp timestamp.Value        `json:"timestamp"`
 	Tags      map[string]string      `json:"tags"`
 	Extra     map[string]interface{} `json:"extra"`
 }
 
+func (p payload) fields() mapping.Fields {
+	return mapping.Fields{
+		Message:   p.Message,
+		Level:     p.Level,
+		Timestamp: string(p.Timestamp),
+		Tags:      p.Tags,
+		Extra:     p.Extra,
+	}
+}
+
+// propagationContext parses the hex trace_id and span_id of a trace context.
+func propagationContext(trace sentry.Context) (sentry.PropagationContext, bool) {
+	traceID, _ := trace["trace_id"].(string)
+	b, err := hex.DecodeString(traceID)
+	if err != nil || len(b) != len(sentry.TraceID{}) {
+		return sentry.PropagationContext{}, false
+	}
+	pc := sentry.NewPropagationContext()
+	copy(pc.TraceID[:], b)
+	spanID, _ := trace["span_id"].(string)
+	if b, err := hex.DecodeString(spanID); err == nil && len(b) == len(sentry.SpanID{}) {
+		copy(pc.SpanID[:], b)
+	}
+	return pc, true
+}
+
 func main() {
-	cfg := loadConfig()
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML configuration file")
 
-	if err := initSentry(); err != nil {
-		log.Fatalf("sentry init: %v", err)
+	args := os.Args[1:]
+	validateOnly := len(args) > 0 && args[0] == "validate-config"
+	if validateOnly {
+		args = args[1:]
 	}
+	_ = flags.Parse(args)
 
-	mux := http.NewServeMux()
-	mux.HandleFunc(cfg.httpPath, func(w http.ResponseWriter, r *http.Request) {
-		if !requireBearer(w, r, cfg) {
-			return
-		}
-		handleIngest(w, r, cfg)
-	})
-	if cfg.fastlyServiceID != "" {
-		fastlyHandler := fastly.Handler{
-			MaxBodyBytes: cfg.maxBodyBytes,
-			Capture:      sentry.CaptureEvent,
+	cfg, err := loadConfig(*configFile)
+	if err == nil {
+		_, err = planRoutes(cfg)
+	}
+	if validateOnly {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+			os.Exit(1)
 		}
-		mux.HandleFunc(cfg.fastlyPath, func(w http.ResponseWriter, r *http.Request) {
-			if !requireBearer(w, r, cfg) {
-				return
-			}
-			fastlyHandler.HandleEvents(w, r)
-		})
-
+		fmt.Println("configuration ok")
+		return
+	}
+	if err != nil {
+		log.Fatalf("config: %v", err)
 	}
 
-	mux.HandleFunc("/health", handleHealth)
-	mux.HandleFunc("/.well-known/fastly/logging/challenge", fastly.ChallengeHandler(cfg.fastlyServiceID))
+	srv, err := newServer(*configFile, cfg)
+	if err != nil {
+		log.Fatalf("routes: %v", err)
+	}
 
-	handler := loggingMiddleware(mux, 4096, 2048)
+	handler := loggingMiddleware(srv, 4096, 2048)
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
 	var wg sync.WaitGroup
+	if err := startSyslog(ctx, cfg, srv, &wg); err != nil {
+		log.Fatalf("syslog: %v", err)
+	}
+	if err := startForward(ctx, cfg, srv, &wg); err != nil {
+		log.Fatalf("forward: %v", err)
+	}
 	if cfg.httpAddr != "" {
 		wg.Add(1)
 		go func() {
@@ -88,40 +272,194 @@ func main() {
 			}
 		}()
 	}
-	if cfg.httpsAddr != "" && cfg.httpsCertFile != "" && cfg.httpsKeyFile != "" {
+	if cfg.httpsAddr != "" {
+		certs, err := mtls.Load(cfg.httpsCertFile, cfg.httpsKeyFile, cfg.httpsClientCAFile, cfg.httpsClientAuth)
+		if err != nil {
+			log.Fatalf("https: %v", err)
+		}
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			if err := runHTTPS(ctx, cfg.httpsAddr, cfg.httpsCertFile, cfg.httpsKeyFile, handler, cfg.shutdownGrace); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			if err := runHTTPS(ctx, cfg.httpsAddr, certs, handler, cfg.shutdownGrace); err != nil && !errors.Is(err, http.ErrServerClosed) {
 				log.Printf("https server error: %v", err)
 			}
 		}()
 	}
 
-	log.Printf("ready: http=%s https=%s ingest=%s fastly=%s enabled=%t", cfg.httpAddr, cfg.httpsAddr, cfg.httpPath, cfg.fastlyPath, cfg.fastlyServiceID != "")
-	<-ctx.Done()
+	log.Printf("ready: http=%s https=%s syslog=%s forward=%s %s fastly=%t spool=%s", cfg.httpAddr, cfg.httpsAddr, syslogSummary(cfg), cfg.forwardAddr, srv.current.summary(), cfg.fastlyServiceID != "", cfg.spoolDir)
+	for done := false; !done; {
+		select {
+		case <-hup:
+			log.Printf("reloading configuration")
+			if err := srv.reload(); err != nil {
+				log.Printf("reload failed, keeping current configuration: %v", err)
+			}
+		case <-ctx.Done():
+			done = true
+		}
+	}
 	log.Printf("shutting down")
 
 	wg.Wait()
-	sentry.Flush(cfg.flushTimeout)
+	srv.close()
 }
 
-func requireBearer(w http.ResponseWriter, r *http.Request, cfg config) bool {
-	if cfg.authToken == "" {
-		return true
+// tokens returns the route's auth tokens, or the global one.
+func (c config) tokens() []string {
+	if len(c.authTokens) == 0 && c.authToken != "" {
+		return []string{c.authToken}
 	}
-	auth := strings.TrimSpace(r.Header.Get("Authorization"))
-	if auth == "Bearer "+cfg.authToken {
-		return true
+	return c.authTokens
+}
+
+// requireClientCert rejects requests without a verified client
+// certificate matching the route's allowlist.
+func requireClientCert(w http.ResponseWriter, id *mtls.Identity, allow *mtls.Allowlist) bool {
+	if id == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+	if !allow.Allows(id) {
+		w.WriteHeader(http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// schemes returns the Authorization schemes accepted on the route.
+func (c config) schemes() []string {
+	if len(c.authSchemes) == 0 {
+		return []string{"Bearer"}
+	}
+	return c.authSchemes
+}
+
+// checkQuota rejects requests while the token's quota is used up.
+func checkQuota(t *tokens.Token) error {
+	exhausted, reset := t.Exhausted(time.Now())
+	if !exhausted {
+		return nil
+	}
+	metrics.TokenEvents.WithLabelValues(t.Name, metrics.QuotaExceeded).Inc()
+	return &ingest.Error{
+		Status:     http.StatusTooManyRequests,
+		RetryAfter: reset,
+		Err:        fmt.Errorf("token %s: event quota exceeded", t.Name),
+	}
+}
+
+var errUnauthorized = &ingest.Error{Status: http.StatusUnauthorized, Err: errors.New("missing or invalid token")}
+
+// checkBearer checks the request presents one of the route's or the global
+// tokens, if any are set.
+func checkBearer(r *http.Request, cfg config) error {
+	tokens := cfg.tokens()
+	if len(tokens) == 0 {
+		return nil
+	}
+	for _, scheme := range cfg.schemes() {
+		credential, ok := authCredential(r, scheme)
+		if !ok {
+			continue
+		}
+		for _, token := range tokens {
+			if subtle.ConstantTimeCompare([]byte(credential), []byte(token)) == 1 {
+				return nil
+			}
+		}
+	}
+	return errUnauthorized
+}
+
+// requireSignature reads the raw body and checks its signature. The body is
+// put back for the parser, behind the counting wrapper of a route so its
+// bytes are counted once.
+func requireSignature(w http.ResponseWriter, r *http.Request, cfg config) bool {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(cfg.maxBodyBytes)))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		return false
+	}
+	if err := cfg.signature.Verify(r.Header, body); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return false
+	}
+	if counted, ok := r.Body.(*countingBody); ok {
+		counted.replay(body)
+	} else {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	return true
+}
+
+// authCredential returns the token a request presents with scheme. For
+// Basic it is the password and for ApiKey the key of the base64 "id:key"
+// pair; the user name and the key ID are ignored. Query reads the token
+// query parameter, for clients that cannot send headers, and Firehose the
+// X-Amz-Firehose-Access-Key header.
+func authCredential(r *http.Request, scheme string) (string, bool) {
+	switch scheme {
+	case "Query":
+		token := r.URL.Query().Get("token")
+		return token, token != ""
+	case "Firehose":
+		key := r.Header.Get("X-Amz-Firehose-Access-Key")
+		return key, key != ""
+	case "Basic":
+		_, password, ok := r.BasicAuth()
+		return password, ok
+	case "ApiKey":
+		value, ok := strings.CutPrefix(strings.TrimSpace(r.Header.Get("Authorization")), "ApiKey ")
+		if !ok {
+			return "", false
+		}
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			return "", false
+		}
+		_, key, ok := strings.Cut(string(decoded), ":")
+		return key, ok
+	}
+	return strings.CutPrefix(strings.TrimSpace(r.Header.Get("Authorization")), scheme+" ")
+}
+
+// checkCapacity rejects requests while the transport cannot accept events
+// (Sentry rate limits, a full send queue or a failing spool), so senders back
+// off and retry instead of having events acknowledged and lost.
+func checkCapacity(tr transport.Transport) error {
+	status, retryAfter := tr.Backpressure()
+	if status == 0 {
+		return nil
+	}
+	metrics.Backpressure.WithLabelValues(strconv.Itoa(status)).Inc()
+	return &ingest.Error{Status: status, RetryAfter: retryAfter, Err: errors.New(http.StatusText(status))}
+}
+
+// requestInfo collects what handlers learn about a request for its log line.
+type requestInfo struct {
+	token string
+}
+
+type requestInfoKey struct{}
+
+// setRequestToken records the name of the scoped token a request used.
+func setRequestToken(r *http.Request, name string) {
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		info.token = name
 	}
-	w.WriteHeader(http.StatusUnauthorized)
-	return false
 }
 
 func loggingMiddleware(next http.Handler, maxLogBytes, maxRespBytes int) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		ww := &statusWriter{ResponseWriter: w, status: http.StatusOK, maxBody: maxRespBytes}
+		info := &requestInfo{}
+		r = r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
 
 		var payload string
 		var bodyLog *bodyCapture
@@ -141,11 +479,15 @@ func loggingMiddleware(next http.Handler, maxLogBytes, maxRespBytes int) http.Ha
 		if ww.truncated {
 			resp += "…"
 		}
+		var token string
+		if info.token != "" {
+			token = " token=" + info.token
+		}
 		if payload != "" || resp != "" {
-			log.Printf("%s %s %d %s %s payload=%q response=%q", r.Method, r.URL.Path, ww.status, time.Since(start).Truncate(time.Millisecond), r.RemoteAddr, payload, resp)
+			log.Printf("%s %s %d %s %s%s payload=%q response=%q", r.Method, r.URL.Path, ww.status, time.Since(start).Truncate(time.Millisecond), r.RemoteAddr, token, payload, resp)
 			return
 		}
-		log.Printf("%s %s %d %s %s", r.Method, r.URL.Path, ww.status, time.Since(start).Truncate(time.Millisecond), r.RemoteAddr)
+		log.Printf("%s %s %d %s %s%s", r.Method, r.URL.Path, ww.status, time.Since(start).Truncate(time.Millisecond), r.RemoteAddr, token)
 	})
 }
 
@@ -225,11 +567,12 @@ func runHTTP(ctx context.Context, addr string, handler http.Handler, shutdownGra
 	return srv.ListenAndServe()
 }
 
-func runHTTPS(ctx context.Context, addr, certFile, keyFile string, handler http.Handler, shutdownGrace time.Duration) error {
+func runHTTPS(ctx context.Context, addr string, certs *mtls.Certificates, handler http.Handler, shutdownGrace time.Duration) error {
 	srv := &http.Server{
 		Addr:              addr,
 		Handler:           handler,
 		ReadHeaderTimeout: 5 * time.Second,
+		TLSConfig:         certs.TLSConfig(),
 	}
 	go func() {
 		<-ctx.Done()
@@ -238,7 +581,7 @@ func runHTTPS(ctx context.Context, addr, certFile, keyFile string, handler http.
 		_ = srv.Shutdown(shutdownCtx)
 	}()
 
-	return srv.ListenAndServeTLS(certFile, keyFile)
+	return srv.ListenAndServeTLS("", "")
 }
 
 func handleHealth(w http.ResponseWriter, r *http.Request) {
@@ -250,126 +593,193 @@ func handleHealth(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write([]byte("ok"))
 }
 
-func loadConfig() config {
-	maxBodyBytes := envInt("HTTP_MAX_BODY_BYTES", 1048576)
-	if maxBodyBytes < 1024 {
-		maxBodyBytes = 1024
+func handleIngest(w http.ResponseWriter, r *http.Request, cfg config, capture ingest.CaptureFunc) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
 	}
 
-	httpAddr := envOrDefault("HTTP_ADDR", "0.0.0.0:8080")
-	httpsAddr := envOrDefault("HTTPS_ADDR", "")
-	httpsCertFile := strings.TrimSpace(os.Getenv("HTTPS_CERT_FILE"))
-	httpsKeyFile := strings.TrimSpace(os.Getenv("HTTPS_KEY_FILE"))
-	httpPath := envOrDefault("HTTP_PATH", "/ingest")
-	fastlyPath := envOrDefault("HTTP_FASTLY_PATH", "/fastly")
-	fastlyServiceID := strings.TrimSpace(os.Getenv("FASTLY_SERVICE_ID"))
-	authToken := strings.TrimSpace(os.Getenv("HTTP_AUTH_TOKEN"))
-	if !strings.HasPrefix(httpPath, "/") {
-		httpPath = "/" + httpPath
+	body, tooLarge, err := readLimitedBody(r.Body, cfg.maxBodyBytes)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
 	}
-	if !strings.HasPrefix(fastlyPath, "/") {
-		fastlyPath = "/" + fastlyPath
+	if tooLarge {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
 	}
-
-	flushTimeout := time.Duration(envInt("SENTRY_FLUSH_TIMEOUT_MS", 2000)) * time.Millisecond
-	if flushTimeout <= 0 {
-		flushTimeout = 2 * time.Second
+	if len(body) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
 	}
 
-	shutdownGrace := time.Duration(envInt("HTTP_SHUTDOWN_TIMEOUT_MS", 5000)) * time.Millisecond
-	if shutdownGrace <= 0 {
-		shutdownGrace = 5 * time.Second
+	contentType := strings.ToLower(r.Header.Get("Content-Type"))
+	if items, ok := splitBatch(contentType, body); ok {
+		handleIngestBatch(w, r, cfg, capture, items)
+		return
 	}
 
-	return config{
-		httpAddr:        httpAddr,
-		httpsAddr:       httpsAddr,
-		httpsCertFile:   httpsCertFile,
-		httpsKeyFile:    httpsKeyFile,
-		httpPath:        httpPath,
-		fastlyPath:      fastlyPath,
-		fastlyServiceID: fastlyServiceID,
-		authToken:       authToken,
-		maxBodyBytes:    maxBodyBytes,
-		flushTimeout:    flushTimeout,
-		shutdownGrace:   shutdownGrace,
+	fields, parsed := parsePayload(contentType, body, cfg.mapping)
+	if !parsed && strings.Contains(contentType, "application/json") {
+		metrics.ParseFailures.WithLabelValues(cfg.route).Inc()
 	}
-}
-
-func initSentry() error {
-	env := strings.TrimSpace(os.Getenv("SENTRY_ENVIRONMENT"))
-	if env == "" {
-		env = "development"
+	event, err := buildIngestEvent(r, cfg, body, fields, parsed)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
 	}
 
-	options := sentry.ClientOptions{
-		Dsn:         strings.TrimSpace(os.Getenv("SENTRY_DSN")),
-		Environment: env,
-		Release:     strings.TrimSpace(os.Getenv("SENTRY_RELEASE")),
+	eventID, err := capture(r.Context(), event)
+	if err != nil {
+		ingest.WriteError(w, err)
+		return
+	}
+	if eventID == nil {
+		w.WriteHeader(http.StatusAccepted)
+		return
 	}
 
-	if options.Dsn == "" {
-		log.Printf("SENTRY_DSN is empty; events will be dropped")
+	eventIDStr := string(*eventID)
+	if eventIDStr == "" {
+		w.WriteHeader(http.StatusAccepted)
+		return
 	}
 
-	return sentry.Init(options)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_, _ = w.Write([]byte("{\"event_id\":\"" + eventIDStr + "\"}"))
 }
 
-func handleIngest(w http.ResponseWriter, r *http.Request, cfg config) {
-	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return
-	}
+type ingestResult struct {
+	EventID string `json:"event_id,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
 
-	body, tooLarge, err := readLimitedBody(r.Body, cfg.maxBodyBytes)
-	if err != nil {
+// handleIngestBatch captures one event per batch entry and reports a result
+// for each entry in request order.
+func handleIngestBatch(w http.ResponseWriter, r *http.Request, cfg config, capture ingest.CaptureFunc, items []json.RawMessage) {
+	if len(items) == 0 {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	if tooLarge {
-		w.WriteHeader(http.StatusRequestEntityTooLarge)
-		return
+
+	results := make([]ingestResult, 0, len(items))
+	invalid := 0
+	var failed error
+	for i, item := range items {
+		fields, ok := decodeFields(item, cfg.mapping)
+		if !ok {
+			results = append(results, ingestResult{Error: "invalid json"})
+			metrics.ParseFailures.WithLabelValues(cfg.route).Inc()
+			invalid++
+			continue
+		}
+
+		event, err := buildIngestEvent(r, cfg, item, fields, true)
+		if err != nil {
+			results = append(results, ingestResult{Error: err.Error()})
+			invalid++
+			continue
+		}
+
+		eventID, err := capture(r.Context(), event)
+		if err != nil {
+			// The entries from here on are rejected, so the sender retries
+			// the request.
+			failed = err
+			for range items[i:] {
+				results = append(results, ingestResult{Error: err.Error()})
+			}
+			break
+		}
+		if eventID == nil || *eventID == "" {
+			results = append(results, ingestResult{Error: "event dropped"})
+			continue
+		}
+		results = append(results, ingestResult{EventID: string(*eventID)})
 	}
-	if len(body) == 0 {
-		w.WriteHeader(http.StatusBadRequest)
-		return
+
+	status := http.StatusAccepted
+	if failed != nil {
+		var wait time.Duration
+		status, wait = ingest.Status(failed)
+		ingest.SetRetryAfter(w, wait)
+	} else if invalid == len(items) {
+		status = http.StatusBadRequest
 	}
 
-	contentType := strings.ToLower(r.Header.Get("Content-Type"))
-	parsedPayload, parsed := parsePayload(contentType, body)
+	resp, err := json.Marshal(map[string]interface{}{
+		"results": results,
+	})
+	if err != nil {
+		w.WriteHeader(status)
+		return
+	}
 
-	event := sentry.NewEvent()
-	event.Logger = "http"
-	event.Level = sentry.LevelInfo
-	event.Timestamp = time.Now()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(resp)
+}
 
-	event.Tags = map[string]string{
+// buildIngestEvent builds the event for one generic payload. It fails only for
+// timestamps the configured policy rejects.
+func buildIngestEvent(r *http.Request, cfg config, body []byte, fields mapping.Fields, parsed bool) (*sentry.Event, error) {
+	return buildPayloadEvent(cfg, "http", map[string]string{
 		"remote_addr": r.RemoteAddr,
 		"method":      r.Method,
 		"path":        r.URL.Path,
-	}
+	}, body, fields, parsed)
+}
+
+// buildPayloadEvent builds the event for one generic payload received by
+// logger, starting from tags.
+func buildPayloadEvent(cfg config, logger string, tags map[string]string, body []byte, fields mapping.Fields, parsed bool) (*sentry.Event, error) {
+	event := sentry.NewEvent()
+	event.Logger = logger
+	event.Level = sentry.LevelInfo
+	event.Timestamp = time.Now()
+	event.Tags = tags
 
 	if parsed {
-		event.Message = parsedPayload.Message
+		event.Message = fields.Message
 		if event.Message == "" {
 			event.Message = string(body)
 		}
-		event.Level = parseLevel(parsedPayload.Level)
-		if parsedPayload.Tags != nil {
-			for key, value := range parsedPayload.Tags {
+		event.Level = logevent.Level(fields.Level)
+		if fields.Tags != nil {
+			for key, value := range fields.Tags {
 				if key != "" && value != "" {
 					event.Tags[key] = value
 				}
 			}
 		}
-		if parsedPayload.Extra != nil {
-			event.Extra = parsedPayload.Extra
+		if fields.Extra != nil {
+			event.Extra = fields.Extra
 		}
-		if parsedPayload.Timestamp != "" {
+		event.Release = fields.Release
+		event.Environment = fields.Environment
+		event.Fingerprint = fields.Fingerprint
+		event.User = fields.User
+		event.Request = fields.Request
+		for name, ctx := range fields.Contexts {
+			event.Contexts[name] = ctx
+		}
+		if fields.Timestamp != "" {
 			if event.Extra == nil {
 				event.Extra = map[string]interface{}{}
 			}
-			event.Extra["payload_timestamp"] = parsedPayload.Timestamp
+			event.Extra["payload_timestamp"] = fields.Timestamp
+
+			ts, clamped, err := cfg.timestamps.Resolve(fields.Timestamp, event.Timestamp)
+			if errors.Is(err, timestamp.ErrOutOfRange) {
+				return nil, err
+			}
+			if err == nil {
+				event.Timestamp = ts
+			}
+			if clamped {
+				event.Extra["payload_timestamp_clamped"] = true
+			}
 		}
 	} else {
 		event.Message = string(body)
@@ -381,22 +791,8 @@ func handleIngest(w http.ResponseWriter, r *http.Request, cfg config) {
 	if event.Message == "" {
 		event.Message = "(empty message)"
 	}
-
-	eventID := sentry.CaptureEvent(event)
-	if eventID == nil {
-		w.WriteHeader(http.StatusAccepted)
-		return
-	}
-
-	eventIDStr := string(*eventID)
-	if eventIDStr == "" {
-		w.WriteHeader(http.StatusAccepted)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusAccepted)
-	_, _ = w.Write([]byte("{\"event_id\":\"" + eventIDStr + "\"}"))
+	stacktrace.Attach(event)
+	return event, nil
 }
 
 func readLimitedBody(body io.ReadCloser, maxBytes int) ([]byte, bool, error) {
@@ -412,52 +808,58 @@ func readLimitedBody(body io.ReadCloser, maxBytes int) ([]byte, bool, error) {
 	return data, false, nil
 }
 
-func parsePayload(contentType string, body []byte) (payload, bool) {
+func parsePayload(contentType string, body []byte, m *mapping.Mapping) (mapping.Fields, bool) {
 	if !strings.Contains(contentType, "application/json") {
-		return payload{}, false
+		return mapping.Fields{}, false
 	}
+	return decodeFields(body, m)
+}
 
-	var parsed payload
-	if err := json.Unmarshal(body, &parsed); err != nil {
-		return payload{}, false
+// decodeFields extracts event fields from one JSON object, using the mapping
+// rules if configured and the fixed payload shape otherwise.
+func decodeFields(body []byte, m *mapping.Mapping) (mapping.Fields, bool) {
+	if m == nil {
+		var parsed payload
+		if err := json.Unmarshal(body, &parsed); err != nil {
+			return mapping.Fields{}, false
+		}
+		return parsed.fields(), true
 	}
 
-	return parsed, true
-}
-
-func parseLevel(level string) sentry.Level {
-	switch strings.ToLower(strings.TrimSpace(level)) {
-	case "fatal":
-		return sentry.LevelFatal
-	case "error":
-		return sentry.LevelError
-	case "warning", "warn":
-		return sentry.LevelWarning
-	case "debug":
-		return sentry.LevelDebug
-	case "info", "":
-		return sentry.LevelInfo
-	default:
-		return sentry.LevelInfo
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc map[string]interface{}
+	if err := dec.Decode(&doc); err != nil || doc == nil {
+		return mapping.Fields{}, false
 	}
+	return m.Extract(doc), true
 }
 
-func envOrDefault(key, def string) string {
-	value := strings.TrimSpace(os.Getenv(key))
-	if value == "" {
-		return def
+// splitBatch splits NDJSON bodies and JSON array bodies into their entries.
+// Other bodies are handled as a single payload.
+func splitBatch(contentType string, body []byte) ([]json.RawMessage, bool) {
+	if strings.Contains(contentType, "ndjson") {
+		var items []json.RawMessage
+		for _, line := range bytes.Split(body, []byte("\n")) {
+			line = bytes.TrimSpace(line)
+			if len(line) == 0 {
+				continue
+			}
+			items = append(items, json.RawMessage(line))
+		}
+		return items, true
 	}
-	return value
-}
 
-func envInt(key string, def int) int {
-	value := strings.TrimSpace(os.Getenv(key))
-	if value == "" {
-		return def
+	if !strings.Contains(contentType, "application/json") {
+		return nil, false
 	}
-	parsed, err := strconv.Atoi(value)
-	if err != nil {
-		return def
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		return nil, false
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(trimmed, &items); err != nil {
+		return nil, false
 	}
-	return parsed
+	return items, true
 }
@@ -1,29 +1,63 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"http-to-sentry-go/internal/ingest"
+	"http-to-sentry-go/mapping"
+	"http-to-sentry-go/timestamp"
+	"http-to-sentry-go/transport"
 )
 
-func TestRequireBearerWhenTokenSet(t *testing.T) {
+func TestCheckBearerWhenTokenSet(t *testing.T) {
 	cfg := config{authToken: "secret"}
 	req := httptest.NewRequest(http.MethodPost, "/ingest", nil)
-	rw := httptest.NewRecorder()
 
-	if requireBearer(rw, req, cfg) {
+	err := checkBearer(req, cfg)
+	if err == nil {
 		t.Fatalf("expected bearer auth to be required")
 	}
-	if rw.Code != http.StatusUnauthorized {
-		t.Fatalf("expected 401, got %d", rw.Code)
+	if status, _ := ingest.Status(err); status != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", status)
 	}
 
 	req.Header.Set("Authorization", "Bearer secret")
-	rw = httptest.NewRecorder()
-	if !requireBearer(rw, req, cfg) {
+	if checkBearer(req, cfg) != nil {
 		t.Fatalf("expected bearer auth to pass")
 	}
+
+	cfg.authSchemes = []string{"Splunk"}
+	if checkBearer(req, cfg) == nil {
+		t.Fatalf("expected bearer token to be refused for the Splunk scheme")
+	}
+	req.Header.Set("Authorization", "Splunk secret")
+	if checkBearer(req, cfg) != nil {
+		t.Fatalf("expected Splunk auth to pass")
+	}
+
+	cfg.authSchemes = []string{"Bearer", "ApiKey", "Basic"}
+	req.Header.Set("Authorization", "ApiKey "+base64.StdEncoding.EncodeToString([]byte("key-id:secret")))
+	if checkBearer(req, cfg) != nil {
+		t.Fatalf("expected ApiKey auth to pass")
+	}
+	req.SetBasicAuth("filebeat", "secret")
+	if checkBearer(req, cfg) != nil {
+		t.Fatalf("expected Basic auth to pass")
+	}
+	req.SetBasicAuth("filebeat", "wrong")
+	if checkBearer(req, cfg) == nil {
+		t.Fatalf("expected a wrong Basic password to be refused")
+	}
 }
 
 func TestHandleHealth(t *testing.T) {
@@ -39,14 +73,223 @@ func TestHandleHealth(t *testing.T) {
 	}
 }
 
+// captureCurrent captures through the global hub.
+func captureCurrent(_ context.Context, event *sentry.Event) (*sentry.EventID, error) {
+	return sentry.CaptureEvent(event), nil
+}
+
 func TestHandleIngestText(t *testing.T) {
 	cfg := config{maxBodyBytes: 1024}
 	body := strings.NewReader("hello")
 	req := httptest.NewRequest(http.MethodPost, "/ingest", body)
 	rw := httptest.NewRecorder()
 
-	handleIngest(rw, req, cfg)
+	handleIngest(rw, req, cfg, captureCurrent)
+	if rw.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", rw.Code)
+	}
+}
+
+type fakeTransport struct {
+	transport.Transport
+	status     int
+	retryAfter time.Duration
+}
+
+func (f fakeTransport) Backpressure() (int, time.Duration) {
+	return f.status, f.retryAfter
+}
+
+func TestCheckCapacityWhenRateLimited(t *testing.T) {
+	err := checkCapacity(fakeTransport{status: http.StatusTooManyRequests, retryAfter: 1500 * time.Millisecond})
+	if err == nil {
+		t.Fatalf("expected request to be rejected")
+	}
+	rw := httptest.NewRecorder()
+	ingest.WriteError(rw, err)
+	if rw.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rw.Code)
+	}
+	if got := rw.Header().Get("Retry-After"); got != "2" {
+		t.Fatalf("expected Retry-After 2, got %q", got)
+	}
+
+	if checkCapacity(fakeTransport{}) != nil {
+		t.Fatalf("expected request to pass")
+	}
+}
+
+func TestHandleIngestNDJSONBatch(t *testing.T) {
+	cfg := config{maxBodyBytes: 1024}
+	body := strings.NewReader("{\"message\":\"one\"}\n\nnot json\n{\"message\":\"two\",\"level\":\"error\"}\n")
+	req := httptest.NewRequest(http.MethodPost, "/ingest", body)
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	rw := httptest.NewRecorder()
+
+	handleIngest(rw, req, cfg, captureCurrent)
 	if rw.Code != http.StatusAccepted {
 		t.Fatalf("expected 202, got %d", rw.Code)
 	}
+
+	var resp struct {
+		Results []ingestResult `json:"results"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(resp.Results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(resp.Results))
+	}
+	if resp.Results[1].Error != "invalid json" {
+		t.Fatalf("expected second entry to be invalid, got %+v", resp.Results[1])
+	}
+}
+
+func TestHandleIngestAnswersCaptureFailures(t *testing.T) {
+	s, _ := newMockSink(t, &ingest.Error{
+		Status:     http.StatusServiceUnavailable,
+		RetryAfter: 5 * time.Second,
+		Err:        errors.New("spool: disk full"),
+	})
+	capture := func(_ context.Context, event *sentry.Event) (*sentry.EventID, error) {
+		return s.capture(event)
+	}
+
+	for _, body := range []string{`{"message":"one"}`, "{\"message\":\"one\"}\n{\"message\":\"two\"}\n"} {
+		req := httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		rw := httptest.NewRecorder()
+
+		handleIngest(rw, req, config{maxBodyBytes: 1024}, capture)
+		if rw.Code != http.StatusServiceUnavailable {
+			t.Fatalf("expected 503 for %q, got %d", body, rw.Code)
+		}
+		if got := rw.Header().Get("Retry-After"); got != "5" {
+			t.Fatalf("expected Retry-After 5, got %q", got)
+		}
+	}
+}
+
+func TestHandleIngestJSONArrayAllInvalid(t *testing.T) {
+	cfg := config{maxBodyBytes: 1024}
+	req := httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(`[1, "two"]`))
+	req.Header.Set("Content-Type", "application/json")
+	rw := httptest.NewRecorder()
+
+	handleIngest(rw, req, cfg, captureCurrent)
+	if rw.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rw.Code)
+	}
+}
+
+func TestBuildIngestEventUsesPayloadTimestamp(t *testing.T) {
+	cfg := config{timestamps: timestamp.Policy{MaxPast: 100 * 365 * 24 * time.Hour}}
+	req := httptest.NewRequest(http.MethodPost, "/ingest", nil)
+
+	event, err := buildIngestEvent(req, cfg, nil, mapping.Fields{Message: "hi", Timestamp: "2026-01-29T12:34:56Z"}, true)
+	if err != nil {
+		t.Fatalf("build event: %v", err)
+	}
+	if want := time.Date(2026, 1, 29, 12, 34, 56, 0, time.UTC); !event.Timestamp.Equal(want) {
+		t.Fatalf("expected %s, got %s", want, event.Timestamp)
+	}
+
+	cfg.timestamps = timestamp.Policy{MaxPast: time.Hour, Reject: true}
+	if _, err := buildIngestEvent(req, cfg, nil, mapping.Fields{Timestamp: "1000000000"}, true); err == nil {
+		t.Fatalf("expected out of range timestamp to be rejected")
+	}
+}
+
+func TestBuildIngestEventExtractsStacktrace(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/ingest", nil)
+	fields := mapping.Fields{
+		Message: "job failed",
+		Extra: map[string]interface{}{
+			"attempt": 3,
+			"trace":   "ValueError: bad amount\n    at charge (/app/pay.js:3:9)\n",
+		},
+	}
+
+	event, err := buildIngestEvent(req, config{}, nil, fields, true)
+	if err != nil {
+		t.Fatalf("build event: %v", err)
+	}
+	if len(event.Exception) != 1 || event.Exception[0].Type != "ValueError" {
+		t.Fatalf("expected exception from extra, got %+v", event.Exception)
+	}
+	if event.Message != "job failed" {
+		t.Fatalf("expected message to be kept, got %q", event.Message)
+	}
+}
+
+// mockTransport records events and fails their capture with err.
+type mockTransport struct {
+	*sentry.MockTransport
+	err error
+}
+
+func (m mockTransport) Backpressure() (int, time.Duration) { return 0, 0 }
+
+func (m mockTransport) Rejected(sentry.EventID) error { return m.err }
+
+func newMockSink(t *testing.T, err error) (*sink, *sentry.MockTransport) {
+	t.Helper()
+	mock := &sentry.MockTransport{}
+	client, cerr := sentry.NewClient(sentry.ClientOptions{Dsn: "https://key@o0.ingest.sentry.io/1", Transport: mock})
+	if cerr != nil {
+		t.Fatalf("client: %v", cerr)
+	}
+	return &sink{
+		hub:       sentry.NewHub(client, sentry.NewScope()),
+		transport: mockTransport{MockTransport: mock, err: err},
+	}, mock
+}
+
+func TestCaptureKeepsEventTraceContext(t *testing.T) {
+	s, mock := newMockSink(t, nil)
+
+	event := sentry.NewEvent()
+	event.Message = "traced"
+	event.Contexts["trace"] = sentry.Context{"trace_id": "5b8efff798038103d269b633813fc60c", "span_id": "eee19b7ec3c1b174"}
+	if _, err := s.capture(event); err != nil {
+		t.Fatalf("capture: %v", err)
+	}
+	if _, err := s.capture(&sentry.Event{Message: "untraced"}); err != nil {
+		t.Fatalf("capture: %v", err)
+	}
+
+	events := mock.Events()
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	if got := fmt.Sprint(events[0].Contexts["trace"]["trace_id"], events[0].Contexts["trace"]["span_id"]); got != "5b8efff798038103d269b633813fc60c eee19b7ec3c1b174" {
+		t.Fatalf("unexpected trace context %s", got)
+	}
+	if fmt.Sprint(events[1].Contexts["trace"]["trace_id"]) == "5b8efff798038103d269b633813fc60c" {
+		t.Fatalf("expected the trace not to leak into the hub scope")
+	}
+}
+
+func TestParsePayloadWithMapping(t *testing.T) {
+	m, err := mapping.Compile(mapping.Rules{
+		Message: mapping.Paths{"msg"},
+		Level:   mapping.Paths{"severity"},
+		Tags:    map[string]mapping.Paths{"service": {"svc.name"}},
+	})
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+
+	body := []byte(`{"msg":"disk full","severity":"CRITICAL","svc":{"name":"api"}}`)
+	fields, ok := parsePayload("application/json", body, m)
+	if !ok {
+		t.Fatalf("expected payload to parse")
+	}
+	event, err := buildIngestEvent(httptest.NewRequest(http.MethodPost, "/ingest", nil), config{}, body, fields, true)
+	if err != nil {
+		t.Fatalf("build event: %v", err)
+	}
+	if event.Message != "disk full" || event.Level != sentry.LevelFatal || event.Tags["service"] != "api" {
+		t.Fatalf("unexpected event: message=%q level=%q tags=%v", event.Message, event.Level, event.Tags)
+	}
 }
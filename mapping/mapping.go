@@ -0,0 +1,406 @@
+// Package mapping extracts Sentry event fields from arbitrary JSON documents
+// using declarative path expressions.
+//
+// A path is a dotted list of object keys and array indexes, optionally
+// starting with "$": "msg", "$.log.level", "errors[0].message" or
+// `$["@timestamp"]`. Every field takes either one path or a list of paths
+// that are tried in order until one resolves to a non-empty value.
+package mapping
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// Rules is the declarative mapping configuration.
+type Rules struct {
+	Message     Paths `json:"message"`
+	Level       Paths `json:"level"`
+	Timestamp   Paths `json:"timestamp"`
+	Release     Paths `json:"release"`
+	Environment Paths `json:"environment"`
+	// Fingerprint parts, each resolved from one path. The literal
+	// "{{ default }}" is passed through to Sentry.
+	Fingerprint []string `json:"fingerprint"`
+	// Tags maps tag names to paths.
+	Tags map[string]Paths `json:"tags"`
+	// Extra maps extra keys to paths. When empty, the whole document is
+	// stored as the "payload" extra.
+	Extra map[string]Paths `json:"extra"`
+	// User maps id, email, username, ip_address and name to paths.
+	User map[string]Paths `json:"user"`
+	// Request maps url, method, query_string, data, cookies, headers and env
+	// to paths. headers and env must resolve to objects.
+	Request map[string]Paths `json:"request"`
+	// Contexts maps context names to keys to paths.
+	Contexts map[string]map[string]Paths `json:"contexts"`
+}
+
+// Paths is a list of path expressions tried in order. In JSON it is either a
+// string or an array of strings.
+type Paths []string
+
+func (p *Paths) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*p = Paths{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("path must be a string or a list of strings")
+	}
+	*p = list
+	return nil
+}
+
+// Fields are the values extracted from one document.
+type Fields struct {
+	Message     string
+	Level       string
+	Timestamp   string
+	Release     string
+	Environment string
+	Fingerprint []string
+	Tags        map[string]string
+	Extra       map[string]interface{}
+	User        sentry.User
+	Request     *sentry.Request
+	Contexts    map[string]sentry.Context
+}
+
+var (
+	userKeys    = []string{"id", "email", "username", "ip_address", "name"}
+	requestKeys = []string{"url", "method", "query_string", "data", "cookies", "headers", "env"}
+)
+
+const defaultFingerprint = "{{ default }}"
+
+// Mapping is a compiled set of rules.
+type Mapping struct {
+	message     []path
+	level       []path
+	timestamp   []path
+	release     []path
+	environment []path
+	fingerprint []fingerprintPart
+	tags        map[string][]path
+	extra       map[string][]path
+	user        map[string][]path
+	request     map[string][]path
+	contexts    map[string]map[string][]path
+}
+
+type fingerprintPart struct {
+	literal string
+	path    path
+}
+
+// Load reads JSON rules from a file and compiles them.
+func Load(filename string) (*Mapping, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	// A misspelled key would otherwise leave its field silently unmapped.
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	var rules Rules
+	if err := dec.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	m, err := Compile(rules)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return m, nil
+}
+
+// Compile validates rules and parses their path expressions.
+func Compile(rules Rules) (*Mapping, error) {
+	if err := checkKeys("user", rules.User, userKeys); err != nil {
+		return nil, err
+	}
+	if err := checkKeys("request", rules.Request, requestKeys); err != nil {
+		return nil, err
+	}
+
+	m := &Mapping{}
+	var err error
+	compile := func(field string, paths Paths) []path {
+		if err != nil {
+			return nil
+		}
+		var compiled []path
+		compiled, err = compilePaths(field, paths)
+		return compiled
+	}
+
+	m.message = compile("message", rules.Message)
+	m.level = compile("level", rules.Level)
+	m.timestamp = compile("timestamp", rules.Timestamp)
+	m.release = compile("release", rules.Release)
+	m.environment = compile("environment", rules.Environment)
+	m.tags = compileMap("tags", rules.Tags, compile)
+	m.extra = compileMap("extra", rules.Extra, compile)
+	m.user = compileMap("user", rules.User, compile)
+	m.request = compileMap("request", rules.Request, compile)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, expr := range rules.Fingerprint {
+		if expr == defaultFingerprint {
+			m.fingerprint = append(m.fingerprint, fingerprintPart{literal: expr})
+			continue
+		}
+		p, err := parsePath(expr)
+		if err != nil {
+			return nil, fmt.Errorf("fingerprint: %w", err)
+		}
+		m.fingerprint = append(m.fingerprint, fingerprintPart{path: p})
+	}
+
+	if len(rules.Contexts) > 0 {
+		m.contexts = make(map[string]map[string][]path, len(rules.Contexts))
+		for name, keys := range rules.Contexts {
+			m.contexts[name] = compileMap("contexts."+name, keys, compile)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return m, nil
+}
+
+func compileMap(field string, rules map[string]Paths, compile func(string, Paths) []path) map[string][]path {
+	if len(rules) == 0 {
+		return nil
+	}
+	compiled := make(map[string][]path, len(rules))
+	for key, paths := range rules {
+		compiled[key] = compile(field+"."+key, paths)
+	}
+	return compiled
+}
+
+func checkKeys(field string, rules map[string]Paths, allowed []string) error {
+	for key := range rules {
+		if !contains(allowed, key) {
+			return fmt.Errorf("%s: unknown key %q (allowed: %s)", field, key, strings.Join(allowed, ", "))
+		}
+	}
+	return nil
+}
+
+func compilePaths(field string, paths Paths) ([]path, error) {
+	compiled := make([]path, 0, len(paths))
+	for _, expr := range paths {
+		p, err := parsePath(expr)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", field, err)
+		}
+		compiled = append(compiled, p)
+	}
+	return compiled, nil
+}
+
+// Extract resolves the rules against a decoded JSON document.
+func (m *Mapping) Extract(doc interface{}) Fields {
+	fields := Fields{
+		Message:     first(doc, m.message),
+		Level:       first(doc, m.level),
+		Timestamp:   first(doc, m.timestamp),
+		Release:     first(doc, m.release),
+		Environment: first(doc, m.environment),
+	}
+
+	for _, part := range m.fingerprint {
+		if part.literal != "" {
+			fields.Fingerprint = append(fields.Fingerprint, part.literal)
+			continue
+		}
+		if value, ok := part.path.lookup(doc); ok {
+			fields.Fingerprint = append(fields.Fingerprint, stringify(value))
+		}
+	}
+
+	if len(m.tags) > 0 {
+		fields.Tags = map[string]string{}
+		for name, paths := range m.tags {
+			if value := first(doc, paths); value != "" {
+				fields.Tags[name] = value
+			}
+		}
+	}
+
+	if len(m.extra) > 0 {
+		fields.Extra = map[string]interface{}{}
+		for name, paths := range m.extra {
+			if value, ok := firstValue(doc, paths); ok {
+				fields.Extra[name] = value
+			}
+		}
+	} else {
+		fields.Extra = map[string]interface{}{"payload": doc}
+	}
+
+	fields.User = sentry.User{
+		ID:        first(doc, m.user["id"]),
+		Email:     first(doc, m.user["email"]),
+		Username:  first(doc, m.user["username"]),
+		IPAddress: first(doc, m.user["ip_address"]),
+		Name:      first(doc, m.user["name"]),
+	}
+
+	if len(m.request) > 0 {
+		req := &sentry.Request{
+			URL:         first(doc, m.request["url"]),
+			Method:      first(doc, m.request["method"]),
+			QueryString: first(doc, m.request["query_string"]),
+			Data:        first(doc, m.request["data"]),
+			Cookies:     first(doc, m.request["cookies"]),
+			Headers:     object(doc, m.request["headers"]),
+			Env:         object(doc, m.request["env"]),
+		}
+		if req.URL != "" || req.Method != "" || len(req.Headers) > 0 || req.Data != "" {
+			fields.Request = req
+		}
+	}
+
+	for name, keys := range m.contexts {
+		ctx := sentry.Context{}
+		for key, paths := range keys {
+			if value, ok := firstValue(doc, paths); ok {
+				ctx[key] = value
+			}
+		}
+		if len(ctx) == 0 {
+			continue
+		}
+		if fields.Contexts == nil {
+			fields.Contexts = map[string]sentry.Context{}
+		}
+		fields.Contexts[name] = ctx
+	}
+
+	return fields
+}
+
+// Apply sets the fields found in the document on the event. Tags, extras
+// and contexts are merged into those already set. Level and timestamp are
+// left to the caller, which knows how to parse them.
+func (f Fields) Apply(event *sentry.Event) {
+	if f.Message != "" {
+		event.Message = f.Message
+	}
+	if f.Release != "" {
+		event.Release = f.Release
+	}
+	if f.Environment != "" {
+		event.Environment = f.Environment
+	}
+	if len(f.Fingerprint) > 0 {
+		event.Fingerprint = f.Fingerprint
+	}
+	if !f.User.IsEmpty() {
+		event.User = f.User
+	}
+	if f.Request != nil {
+		event.Request = f.Request
+	}
+	if event.Tags == nil {
+		event.Tags = map[string]string{}
+	}
+	for key, value := range f.Tags {
+		if key != "" && value != "" {
+			event.Tags[key] = value
+		}
+	}
+	if event.Extra == nil {
+		event.Extra = map[string]interface{}{}
+	}
+	for key, value := range f.Extra {
+		event.Extra[key] = value
+	}
+	if event.Contexts == nil {
+		event.Contexts = map[string]sentry.Context{}
+	}
+	for name, ctx := range f.Contexts {
+		event.Contexts[name] = ctx
+	}
+}
+
+func first(doc interface{}, paths []path) string {
+	value, ok := firstValue(doc, paths)
+	if !ok {
+		return ""
+	}
+	return stringify(value)
+}
+
+func object(doc interface{}, paths []path) map[string]string {
+	value, ok := firstValue(doc, paths)
+	if !ok {
+		return nil
+	}
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	out := make(map[string]string, len(obj))
+	for key, v := range obj {
+		out[key] = stringify(v)
+	}
+	return out
+}
+
+func firstValue(doc interface{}, paths []path) (interface{}, bool) {
+	for _, p := range paths {
+		value, ok := p.lookup(doc)
+		if !ok || value == nil {
+			continue
+		}
+		if s, isString := value.(string); isString && s == "" {
+			continue
+		}
+		return value, true
+	}
+	return nil, false
+}
+
+func stringify(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	}
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package mapping
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/getsentry/sentry-go"
+)
+
+func decode(t *testing.T, data string) interface{} {
+	t.Helper()
+	var doc interface{}
+	if err := json.Unmarshal([]byte(data), &doc); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	return doc
+}
+
+func TestExtractFields(t *testing.T) {
+	var rules Rules
+	err := json.Unmarshal([]byte(`{
+		"message": ["message", "msg"],
+		"level": "log.level",
+		"timestamp": "$[\"@timestamp\"]",
+		"release": "$.service.version",
+		"fingerprint": ["{{ default }}", "error.type"],
+		"tags": {"service": "service.name", "first_host": "hosts[0]"},
+		"user": {"id": "user.id", "email": "user.email"},
+		"request": {"url": "http.url", "headers": "http.headers"},
+		"contexts": {"trace": {"trace_id": "trace.id"}}
+	}`), &rules)
+	if err != nil {
+		t.Fatalf("unmarshal rules: %v", err)
+	}
+	m, err := Compile(rules)
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+
+	doc := decode(t, `{
+		"msg": "payment failed",
+		"@timestamp": "2026-01-29T12:34:56Z",
+		"log": {"level": "error"},
+		"service": {"name": "checkout", "version": "1.2.3"},
+		"error": {"type": "Timeout"},
+		"hosts": ["web-1", "web-2"],
+		"user": {"id": 42, "email": "a@example.com"},
+		"http": {"url": "https://example.com/pay", "headers": {"X-Id": "abc"}},
+		"trace": {"id": "0af7651916cd43dd8448eb211c80319c"}
+	}`)
+	fields := m.Extract(doc)
+
+	if fields.Message != "payment failed" || fields.Level != "error" || fields.Timestamp != "2026-01-29T12:34:56Z" {
+		t.Fatalf("unexpected scalar fields: %+v", fields)
+	}
+	if fields.Release != "1.2.3" || fields.Tags["service"] != "checkout" || fields.Tags["first_host"] != "web-1" {
+		t.Fatalf("unexpected release/tags: %q %v", fields.Release, fields.Tags)
+	}
+	if len(fields.Fingerprint) != 2 || fields.Fingerprint[1] != "Timeout" {
+		t.Fatalf("unexpected fingerprint: %v", fields.Fingerprint)
+	}
+	if fields.User.ID != "42" || fields.User.Email != "a@example.com" {
+		t.Fatalf("unexpected user: %+v", fields.User)
+	}
+	if fields.Request == nil || fields.Request.Headers["X-Id"] != "abc" {
+		t.Fatalf("unexpected request: %+v", fields.Request)
+	}
+	if fields.Contexts["trace"]["trace_id"] != "0af7651916cd43dd8448eb211c80319c" {
+		t.Fatalf("unexpected contexts: %v", fields.Contexts)
+	}
+	if fields.Extra["payload"] == nil {
+		t.Fatalf("expected whole document in extra")
+	}
+}
+
+func TestApplyMergesIntoEvent(t *testing.T) {
+	event := sentry.NewEvent()
+	event.Message = "raw line"
+	event.Release = "1.0.0"
+	event.Tags["job"] = "api"
+
+	Fields{
+		Environment: "production",
+		Tags:        map[string]string{"service": "checkout", "empty": ""},
+		Extra:       map[string]interface{}{"order": 42},
+		User:        sentry.User{ID: "7"},
+	}.Apply(event)
+
+	if event.Message != "raw line" || event.Release != "1.0.0" || event.Environment != "production" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	if event.Tags["job"] != "api" || event.Tags["service"] != "checkout" || len(event.Tags) != 2 {
+		t.Fatalf("unexpected tags %v", event.Tags)
+	}
+	if event.Extra["order"] != 42 || event.User.ID != "7" {
+		t.Fatalf("unexpected extra %v, user %+v", event.Extra, event.User)
+	}
+}
+
+func TestCompileRejectsInvalidRules(t *testing.T) {
+	if _, err := Compile(Rules{User: map[string]Paths{"phone": {"user.phone"}}}); err == nil {
+		t.Fatalf("expected unknown user key to be rejected")
+	}
+	if _, err := Compile(Rules{Message: Paths{"items[abc]"}}); err == nil {
+		t.Fatalf("expected bad index to be rejected")
+	}
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "mapping.json")
+	if err := os.WriteFile(filename, []byte(`{"message": "msg", "tag": {"service": "svc"}}`), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := Load(filename); err == nil || !strings.Contains(err.Error(), `"tag"`) {
+		t.Fatalf("expected the misspelled key to be rejected, got %v", err)
+	}
+
+	if err := os.WriteFile(filename, []byte(`{"message": "msg", "tags": {"service": "svc"}}`), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := Load(filename); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+}
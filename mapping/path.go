@@ -0,0 +1,86 @@
+package mapping
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// path is a parsed path expression. Each segment is either an object key or
+// an array index.
+type path []segment
+
+type segment struct {
+	key   string
+	index int
+	isKey bool
+}
+
+func parsePath(expr string) (path, error) {
+	s := strings.TrimSpace(expr)
+	s = strings.TrimPrefix(s, "$")
+	if s == "" {
+		return nil, fmt.Errorf("empty path %q", expr)
+	}
+
+	var p path
+	for len(s) > 0 {
+		switch s[0] {
+		case '.':
+			s = s[1:]
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid path %q: empty key", expr)
+			}
+			p = append(p, segment{key: s[:end], isKey: true})
+			s = s[end:]
+		case '[':
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path %q: unclosed bracket", expr)
+			}
+			inner := strings.TrimSpace(s[1:end])
+			s = s[end+1:]
+			if len(inner) >= 2 && (inner[0] == '"' || inner[0] == '\'') && inner[len(inner)-1] == inner[0] {
+				p = append(p, segment{key: inner[1 : len(inner)-1], isKey: true})
+				continue
+			}
+			index, err := strconv.Atoi(inner)
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid path %q: bad index %q", expr, inner)
+			}
+			p = append(p, segment{index: index})
+		default:
+			// A leading key without a dot, as in "log.level".
+			s = "." + s
+		}
+	}
+	return p, nil
+}
+
+// lookup resolves the path in a document decoded by encoding/json.
+func (p path) lookup(doc interface{}) (interface{}, bool) {
+	current := doc
+	for _, seg := range p {
+		if seg.isKey {
+			obj, ok := current.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			current, ok = obj[seg.key]
+			if !ok {
+				return nil, false
+			}
+			continue
+		}
+		list, ok := current.([]interface{})
+		if !ok || seg.index >= len(list) {
+			return nil, false
+		}
+		current = list[seg.index]
+	}
+	return current, true
+}
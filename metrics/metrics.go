@@ -0,0 +1,38 @@
+// Package metrics defines the counters, gauges and histograms the
+// forwarder exposes to Prometheus.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Default is the registry the package level metrics are registered in,
+// along with the Go runtime and process metrics.
+var Default = prometheus.NewRegistry()
+
+// factory registers the package level metrics in Default.
+var factory = promauto.With(Default)
+
+func init() {
+	Default.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler serves the Default registry.
+func Handler() http.Handler {
+	h := promhttp.HandlerFor(Default, promhttp.HandlerOpts{})
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
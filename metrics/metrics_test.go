@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandlerServesMetrics(t *testing.T) {
+	Requests.WithLabelValues(`a"b`, "400").Inc()
+	RequestBytes.WithLabelValues("test").Observe(100)
+	RequestBytes.WithLabelValues("test").Observe(5000)
+
+	rw := httptest.NewRecorder()
+	Handler().ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rw.Code)
+	}
+	if !strings.HasPrefix(rw.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("unexpected content type %q", rw.Header().Get("Content-Type"))
+	}
+	out := rw.Body.String()
+	for _, want := range []string{
+		"# TYPE http_to_sentry_requests_total counter\n",
+		`http_to_sentry_requests_total{route="a\"b",status="400"} 1` + "\n",
+		`http_to_sentry_request_body_bytes_bucket{route="test",le="256"} 1` + "\n",
+		`http_to_sentry_request_body_bytes_bucket{route="test",le="+Inf"} 2` + "\n",
+		`http_to_sentry_request_body_bytes_sum{route="test"} 5100` + "\n",
+		"# TYPE http_to_sentry_queue_depth gauge\n",
+		"go_goroutines ",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in output:\n%s", want, out)
+		}
+	}
+
+	rw = httptest.NewRecorder()
+	Handler().ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", rw.Code)
+	}
+}
@@ -0,0 +1,57 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// Event outcomes for the Events counter.
+const (
+	Captured    = "captured"
+	RateLimited = "rate_limited"
+	QueueFull   = "queue_full"
+	NoDSN       = "no_dsn"
+	Failed      = "error"
+	// QuotaExceeded is the TokenEvents outcome of events over a token's
+	// quota.
+	QuotaExceeded = "quota_exceeded"
+)
+
+// The ingest pipeline metrics.
+var (
+	Requests = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_to_sentry_requests_total",
+		Help: "Ingest requests by route and response status.",
+	}, []string{"route", "status"})
+	RequestBytes = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_to_sentry_request_body_bytes",
+		Help:    "Ingest request body sizes in bytes.",
+		Buckets: prometheus.ExponentialBuckets(256, 4, 8),
+	}, []string{"route"})
+	ParseFailures = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_to_sentry_parse_failures_total",
+		Help: "Payloads and batch entries that could not be parsed, by route.",
+	}, []string{"route"})
+	Backpressure = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_to_sentry_backpressure_total",
+		Help: "Ingest requests refused because Sentry rate limits events, the send queue is full or the spool fails, by status.",
+	}, []string{"status"})
+	Events = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_to_sentry_events_total",
+		Help: "Events by logger, level and outcome: captured, or the reason the event was dropped.",
+	}, []string{"logger", "level", "outcome"})
+	SendDuration = factory.NewHistogram(prometheus.HistogramOpts{
+		Name:    "http_to_sentry_send_duration_seconds",
+		Help:    "Latency of requests to Sentry.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+	})
+	SendFailures = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_to_sentry_send_failures_total",
+		Help: "Failed deliveries to Sentry by reason: network, rate_limited, server_error or rejected.",
+	}, []string{"reason"})
+	TokenEvents = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_to_sentry_token_events_total",
+		Help: "Events sent with scoped tokens, by token and outcome: captured or quota_exceeded.",
+	}, []string{"token", "outcome"})
+	QueueDepth = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "http_to_sentry_queue_depth",
+		Help: "Events waiting in send queues.",
+	})
+)
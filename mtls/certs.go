@@ -0,0 +1,145 @@
+package mtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// checkInterval is how often the files are checked for changes. They are
+// checked on handshakes, so an idle listener does not poll.
+const checkInterval = 5 * time.Second
+
+// ParseClientAuth parses a verification mode: none, optional or require.
+func ParseClientAuth(mode string) (tls.ClientAuthType, error) {
+	switch strings.ToLower(mode) {
+	case "", "none":
+		return tls.NoClientCert, nil
+	case "optional":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
+	}
+	return tls.NoClientCert, fmt.Errorf("must be none, optional or require, got %q", mode)
+}
+
+// LoadCAs reads a PEM bundle of client CA certificates.
+func LoadCAs(filename string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s: no PEM certificates found", filename)
+	}
+	return pool, nil
+}
+
+// Certificates serves the HTTPS listener's certificate and, with client
+// authentication, its client CA bundle. Changed files are read again; if
+// they do not load, for example while a certificate and key are replaced
+// one after the other, the previous ones stay in use.
+type Certificates struct {
+	certFile, keyFile, caFile string
+	clientAuth                tls.ClientAuthType
+
+	mu      sync.Mutex
+	checked time.Time
+	modTime [3]time.Time
+	config  *tls.Config
+}
+
+// Load reads the certificate, key and, unless caFile is empty, the client
+// CA bundle.
+func Load(certFile, keyFile, caFile string, clientAuth tls.ClientAuthType) (*Certificates, error) {
+	if clientAuth != tls.NoClientCert && caFile == "" {
+		return nil, errors.New("client authentication requires a client CA bundle")
+	}
+	c := &Certificates{certFile: certFile, keyFile: keyFile, caFile: caFile, clientAuth: clientAuth}
+	modTime, err := c.stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.load(modTime); err != nil {
+		return nil, err
+	}
+	c.checked = time.Now()
+	return c, nil
+}
+
+// TLSConfig returns the listener configuration.
+func (c *Certificates) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return c.current(time.Now()), nil
+		},
+	}
+}
+
+func (c *Certificates) current(now time.Time) *tls.Config {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.checked) < checkInterval {
+		return c.config
+	}
+	c.checked = now
+	modTime, err := c.stat()
+	if err != nil {
+		log.Printf("https: keeping loaded certificates: %v", err)
+		return c.config
+	}
+	if modTime == c.modTime {
+		return c.config
+	}
+	if err := c.load(modTime); err != nil {
+		log.Printf("https: keeping loaded certificates: %v", err)
+		return c.config
+	}
+	log.Printf("https: reloaded certificates")
+	return c.config
+}
+
+func (c *Certificates) stat() ([3]time.Time, error) {
+	var modTime [3]time.Time
+	for i, name := range []string{c.certFile, c.keyFile, c.caFile} {
+		if name == "" {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return modTime, err
+		}
+		modTime[i] = info.ModTime()
+	}
+	return modTime, nil
+}
+
+func (c *Certificates) load(modTime [3]time.Time) error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   c.clientAuth,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if c.caFile != "" {
+		config.ClientCAs, err = LoadCAs(c.caFile)
+		if err != nil {
+			return err
+		}
+	}
+	c.config = config
+	c.modTime = modTime
+	return nil
+}
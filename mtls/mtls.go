@@ -0,0 +1,117 @@
+// Package mtls implements client certificate authentication for the HTTPS
+// listener: the verified identity of a client, per-route allowlists and a
+// server certificate and client CA bundle that are read again from disk
+// when they change.
+package mtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Identity is the verified client certificate of a connection.
+type Identity struct {
+	// Subject is the distinguished name, e.g. "CN=billing,O=Example".
+	Subject    string
+	CommonName string
+	// SANs are the DNS, email, IP and URI subject alternative names.
+	SANs []string
+	// SPIFFEID is the spiffe:// URI SAN, if the certificate has one.
+	SPIFFEID string
+}
+
+// Identify returns the identity of the client certificate verified during
+// the handshake, or nil if the client did not present one.
+func Identify(state *tls.ConnectionState) *Identity {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return identityOf(state.VerifiedChains[0][0])
+}
+
+func identityOf(cert *x509.Certificate) *Identity {
+	id := &Identity{Subject: cert.Subject.String(), CommonName: cert.Subject.CommonName}
+	id.SANs = append(id.SANs, cert.DNSNames...)
+	id.SANs = append(id.SANs, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		id.SANs = append(id.SANs, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		id.SANs = append(id.SANs, uri.String())
+		if uri.Scheme == "spiffe" && id.SPIFFEID == "" {
+			id.SPIFFEID = uri.String()
+		}
+	}
+	return id
+}
+
+// String returns the name the identity is recorded under: the SPIFFE ID,
+// else the common name, else the subject.
+func (id *Identity) String() string {
+	switch {
+	case id.SPIFFEID != "":
+		return id.SPIFFEID
+	case id.CommonName != "":
+		return id.CommonName
+	}
+	return id.Subject
+}
+
+// Allowlist is the client certificate configuration of a route. Entries are
+// exact values or path.Match patterns such as "spiffe://example.org/ns/prod/*".
+type Allowlist struct {
+	// Subjects match the distinguished name or the common name.
+	Subjects []string `json:"subjects"`
+	// SANs match any DNS, email, IP or URI subject alternative name.
+	SANs []string `json:"sans"`
+	// SPIFFEIDs match the spiffe:// URI SAN.
+	SPIFFEIDs []string `json:"spiffe_ids"`
+}
+
+// Validate checks that the allowlist has entries and valid patterns.
+func (a *Allowlist) Validate() error {
+	if len(a.Subjects)+len(a.SANs)+len(a.SPIFFEIDs) == 0 {
+		return errors.New("at least one of subjects, sans or spiffe_ids is required")
+	}
+	for _, list := range [][]string{a.Subjects, a.SANs, a.SPIFFEIDs} {
+		for _, pattern := range list {
+			if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+				return fmt.Errorf("invalid entry %q", pattern)
+			}
+		}
+	}
+	for _, id := range a.SPIFFEIDs {
+		if !strings.HasPrefix(id, "spiffe://") {
+			return fmt.Errorf("spiffe_ids entry %q must start with spiffe://", id)
+		}
+	}
+	return nil
+}
+
+// Allows reports whether any entry matches id.
+func (a *Allowlist) Allows(id *Identity) bool {
+	if id == nil {
+		return false
+	}
+	return matchAny(a.Subjects, id.Subject, id.CommonName) ||
+		matchAny(a.SANs, id.SANs...) ||
+		id.SPIFFEID != "" && matchAny(a.SPIFFEIDs, id.SPIFFEID)
+}
+
+func matchAny(patterns []string, values ...string) bool {
+	for _, pattern := range patterns {
+		for _, value := range values {
+			if value == "" {
+				continue
+			}
+			if ok, _ := path.Match(pattern, value); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
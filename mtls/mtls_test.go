@@ -0,0 +1,149 @@
+package mtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newCert(t *testing.T, template *x509.Certificate) (*x509.Certificate, []byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestIdentityAndAllowlist(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/ns/prod/sa/billing")
+	cert, _, _ := newCert(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "billing", Organization: []string{"Example"}},
+		DNSNames: []string{"billing.internal.example.org"},
+		URIs:     []*url.URL{spiffe},
+	})
+	id := Identify(&tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}})
+	if id == nil || id.String() != "spiffe://example.org/ns/prod/sa/billing" || id.Subject != "CN=billing,O=Example" {
+		t.Fatalf("identity = %+v", id)
+	}
+	if Identify(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}) != nil {
+		t.Fatalf("unverified certificate should have no identity")
+	}
+	if Identify(nil) != nil {
+		t.Fatalf("plain HTTP should have no identity")
+	}
+
+	for name, tc := range map[string]struct {
+		allow Allowlist
+		want  bool
+	}{
+		"common name":    {Allowlist{Subjects: []string{"billing"}}, true},
+		"subject":        {Allowlist{Subjects: []string{"CN=billing,O=Example"}}, true},
+		"other subject":  {Allowlist{Subjects: []string{"search"}}, false},
+		"dns san":        {Allowlist{SANs: []string{"*.internal.example.org"}}, true},
+		"spiffe pattern": {Allowlist{SPIFFEIDs: []string{"spiffe://example.org/ns/prod/sa/*"}}, true},
+		"spiffe other":   {Allowlist{SPIFFEIDs: []string{"spiffe://example.org/ns/dev/sa/*"}}, false},
+	} {
+		if err := tc.allow.Validate(); err != nil {
+			t.Fatalf("%s: validate: %v", name, err)
+		}
+		if got := tc.allow.Allows(id); got != tc.want {
+			t.Fatalf("%s: allows = %t, want %t", name, got, tc.want)
+		}
+	}
+	if (&Allowlist{Subjects: []string{"*"}}).Allows(nil) {
+		t.Fatalf("allowlist should not allow a missing identity")
+	}
+
+	for name, allow := range map[string]Allowlist{
+		"empty":       {},
+		"pattern":     {SANs: []string{"[a-"}},
+		"blank":       {Subjects: []string{""}},
+		"spiffe form": {SPIFFEIDs: []string{"example.org/sa/billing"}},
+	} {
+		if err := allow.Validate(); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+func TestCertificatesReloadChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	write := func(name string, data []byte, modTime time.Time) {
+		if err := os.WriteFile(name, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(name, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now().Add(-time.Hour)
+	first, certPEM, keyPEM := newCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "first"}})
+	_, caPEM, _ := newCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "clients"}, IsCA: true, BasicConstraintsValid: true})
+	write(certFile, certPEM, start)
+	write(keyFile, keyPEM, start)
+	write(caFile, caPEM, start)
+
+	if _, err := Load(certFile, keyFile, "", tls.RequireAndVerifyClientCert); err == nil {
+		t.Fatalf("client authentication without a CA bundle should fail")
+	}
+	c, err := Load(certFile, keyFile, caFile, tls.RequireAndVerifyClientCert)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	served := func(now time.Time) string {
+		config := c.current(now)
+		if config.ClientAuth != tls.RequireAndVerifyClientCert || config.ClientCAs == nil {
+			t.Fatalf("client auth = %v, CAs = %v", config.ClientAuth, config.ClientCAs)
+		}
+		leaf, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.Subject.CommonName
+	}
+	now := time.Now()
+	if got := served(now.Add(checkInterval)); got != first.Subject.CommonName {
+		t.Fatalf("served %q", got)
+	}
+
+	// A new certificate with the old key does not load; the old pair stays.
+	_, secondCert, secondKey := newCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "second"}})
+	write(certFile, secondCert, start.Add(time.Minute))
+	if got := served(now.Add(2 * checkInterval)); got != "first" {
+		t.Fatalf("mismatched pair served %q", got)
+	}
+	write(keyFile, secondKey, start.Add(time.Minute))
+	if got := served(now.Add(2*checkInterval + time.Second)); got != "first" {
+		t.Fatalf("files were checked again before the interval: %q", got)
+	}
+	if got := served(now.Add(3 * checkInterval)); got != "second" {
+		t.Fatalf("served %q after rotation", got)
+	}
+}
@@ -0,0 +1,397 @@
+package otlp
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"http-to-sentry-go/pbwire"
+)
+
+// Record is a log record with its resource and instrumentation scope.
+// Attribute and body values are strings, bools, int64s, float64s, slices
+// and maps; bytes values are base64 strings.
+type Record struct {
+	Time           time.Time
+	ObservedTime   time.Time
+	SeverityNumber int
+	SeverityText   string
+	Body           interface{}
+	Attributes     map[string]interface{}
+	TraceID        string
+	SpanID         string
+	EventName      string
+	Resource       map[string]interface{}
+	Scope          string
+	ScopeVersion   string
+}
+
+// DecodeProtobuf decodes an ExportLogsServiceRequest in the protobuf
+// encoding.
+func DecodeProtobuf(data []byte) ([]Record, error) {
+	var records []Record
+	err := pbwire.Each(data, func(f pbwire.Field) error {
+		if f.Num != 1 || f.Type != protowire.BytesType {
+			return nil
+		}
+		decoded, err := decodeResourceLogs(f.Bytes)
+		records = append(records, decoded...)
+		return err
+	})
+	return records, err
+}
+
+func decodeResourceLogs(b []byte) ([]Record, error) {
+	var resource map[string]interface{}
+	var scopeLogs [][]byte
+	err := pbwire.Each(b, func(f pbwire.Field) error {
+		if f.Type != protowire.BytesType {
+			return nil
+		}
+		switch f.Num {
+		case 1:
+			return pbwire.Each(f.Bytes, func(f pbwire.Field) error {
+				if f.Num != 1 || f.Type != protowire.BytesType {
+					return nil
+				}
+				if resource == nil {
+					resource = map[string]interface{}{}
+				}
+				return decodeKeyValue(f.Bytes, resource)
+			})
+		case 2:
+			scopeLogs = append(scopeLogs, f.Bytes)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The resource may follow the scope logs on the wire, so records are
+	// decoded once it is known.
+	var records []Record
+	for _, sl := range scopeLogs {
+		var scope, version string
+		var logs [][]byte
+		err := pbwire.Each(sl, func(f pbwire.Field) error {
+			if f.Type != protowire.BytesType {
+				return nil
+			}
+			switch f.Num {
+			case 1:
+				return pbwire.Each(f.Bytes, func(f pbwire.Field) error {
+					switch {
+					case f.Num == 1 && f.Type == protowire.BytesType:
+						scope = f.String()
+					case f.Num == 2 && f.Type == protowire.BytesType:
+						version = f.String()
+					}
+					return nil
+				})
+			case 2:
+				logs = append(logs, f.Bytes)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, lr := range logs {
+			rec := Record{Resource: resource, Scope: scope, ScopeVersion: version}
+			if err := decodeLogRecord(lr, &rec); err != nil {
+				return nil, err
+			}
+			records = append(records, rec)
+		}
+	}
+	return records, nil
+}
+
+func decodeLogRecord(b []byte, rec *Record) error {
+	return pbwire.Each(b, func(f pbwire.Field) error {
+		switch {
+		case f.Num == 1 && f.Type == protowire.Fixed64Type:
+			rec.Time = unixNano(f.Uint)
+		case f.Num == 2 && f.Type == protowire.VarintType:
+			rec.SeverityNumber = int(f.Int())
+		case f.Num == 3 && f.Type == protowire.BytesType:
+			rec.SeverityText = f.String()
+		case f.Num == 5 && f.Type == protowire.BytesType:
+			v, err := decodeAnyValue(f.Bytes)
+			if err != nil {
+				return err
+			}
+			rec.Body = v
+		case f.Num == 6 && f.Type == protowire.BytesType:
+			if rec.Attributes == nil {
+				rec.Attributes = map[string]interface{}{}
+			}
+			return decodeKeyValue(f.Bytes, rec.Attributes)
+		case f.Num == 9 && f.Type == protowire.BytesType:
+			rec.TraceID = traceHex(f.Bytes)
+		case f.Num == 10 && f.Type == protowire.BytesType:
+			rec.SpanID = traceHex(f.Bytes)
+		case f.Num == 11 && f.Type == protowire.Fixed64Type:
+			rec.ObservedTime = unixNano(f.Uint)
+		case f.Num == 12 && f.Type == protowire.BytesType:
+			rec.EventName = f.String()
+		}
+		return nil
+	})
+}
+
+// decodeKeyValue decodes a KeyValue message into attrs.
+func decodeKeyValue(b []byte, attrs map[string]interface{}) error {
+	var key string
+	var value interface{}
+	err := pbwire.Each(b, func(f pbwire.Field) error {
+		if f.Type != protowire.BytesType {
+			return nil
+		}
+		switch f.Num {
+		case 1:
+			key = f.String()
+		case 2:
+			v, err := decodeAnyValue(f.Bytes)
+			if err != nil {
+				return err
+			}
+			value = v
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if key != "" {
+		attrs[key] = value
+	}
+	return nil
+}
+
+func decodeAnyValue(b []byte) (interface{}, error) {
+	var value interface{}
+	err := pbwire.Each(b, func(f pbwire.Field) error {
+		switch {
+		case f.Num == 1 && f.Type == protowire.BytesType:
+			value = f.String()
+		case f.Num == 2 && f.Type == protowire.VarintType:
+			value = f.Uint != 0
+		case f.Num == 3 && f.Type == protowire.VarintType:
+			value = f.Int()
+		case f.Num == 4 && f.Type == protowire.Fixed64Type:
+			value = math.Float64frombits(f.Uint)
+		case f.Num == 5 && f.Type == protowire.BytesType:
+			values := []interface{}{}
+			err := pbwire.Each(f.Bytes, func(f pbwire.Field) error {
+				if f.Num != 1 || f.Type != protowire.BytesType {
+					return nil
+				}
+				v, err := decodeAnyValue(f.Bytes)
+				values = append(values, v)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			value = values
+		case f.Num == 6 && f.Type == protowire.BytesType:
+			kv := map[string]interface{}{}
+			err := pbwire.Each(f.Bytes, func(f pbwire.Field) error {
+				if f.Num != 1 || f.Type != protowire.BytesType {
+					return nil
+				}
+				return decodeKeyValue(f.Bytes, kv)
+			})
+			if err != nil {
+				return err
+			}
+			value = kv
+		case f.Num == 7 && f.Type == protowire.BytesType:
+			value = base64.StdEncoding.EncodeToString(f.Bytes)
+		}
+		return nil
+	})
+	return value, err
+}
+
+// DecodeJSON decodes an ExportLogsServiceRequest in the OTLP JSON encoding.
+func DecodeJSON(data []byte) ([]Record, error) {
+	var req struct {
+		ResourceLogs []struct {
+			Resource struct {
+				Attributes []jsonKeyValue `json:"attributes"`
+			} `json:"resource"`
+			ScopeLogs []struct {
+				Scope struct {
+					Name    string `json:"name"`
+					Version string `json:"version"`
+				} `json:"scope"`
+				LogRecords []jsonLogRecord `json:"logRecords"`
+			} `json:"scopeLogs"`
+		} `json:"resourceLogs"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, err
+	}
+
+	var records []Record
+	for _, rl := range req.ResourceLogs {
+		resource, err := jsonAttributes(rl.Resource.Attributes)
+		if err != nil {
+			return nil, err
+		}
+		for _, sl := range rl.ScopeLogs {
+			for _, lr := range sl.LogRecords {
+				rec := Record{
+					SeverityNumber: lr.SeverityNumber,
+					SeverityText:   lr.SeverityText,
+					TraceID:        jsonTraceID(lr.TraceID),
+					SpanID:         jsonTraceID(lr.SpanID),
+					EventName:      lr.EventName,
+					Resource:       resource,
+					Scope:          sl.Scope.Name,
+					ScopeVersion:   sl.Scope.Version,
+				}
+				if rec.Time, err = jsonUnixNano(lr.TimeUnixNano); err != nil {
+					return nil, fmt.Errorf("timeUnixNano: %w", err)
+				}
+				if rec.ObservedTime, err = jsonUnixNano(lr.ObservedTimeUnixNano); err != nil {
+					return nil, fmt.Errorf("observedTimeUnixNano: %w", err)
+				}
+				if lr.Body != nil {
+					if rec.Body, err = lr.Body.value(); err != nil {
+						return nil, err
+					}
+				}
+				if rec.Attributes, err = jsonAttributes(lr.Attributes); err != nil {
+					return nil, err
+				}
+				records = append(records, rec)
+			}
+		}
+	}
+	return records, nil
+}
+
+type jsonLogRecord struct {
+	TimeUnixNano         json.Number    `json:"timeUnixNano"`
+	ObservedTimeUnixNano json.Number    `json:"observedTimeUnixNano"`
+	SeverityNumber       int            `json:"severityNumber"`
+	SeverityText         string         `json:"severityText"`
+	Body                 *jsonAnyValue  `json:"body"`
+	Attributes           []jsonKeyValue `json:"attributes"`
+	TraceID              string         `json:"traceId"`
+	SpanID               string         `json:"spanId"`
+	EventName            string         `json:"eventName"`
+}
+
+type jsonKeyValue struct {
+	Key   string       `json:"key"`
+	Value jsonAnyValue `json:"value"`
+}
+
+// jsonAnyValue is an AnyValue. 64-bit integers may be JSON strings or
+// numbers.
+type jsonAnyValue struct {
+	StringValue *string      `json:"stringValue"`
+	BoolValue   *bool        `json:"boolValue"`
+	IntValue    *json.Number `json:"intValue"`
+	DoubleValue *json.Number `json:"doubleValue"`
+	BytesValue  *string      `json:"bytesValue"`
+	ArrayValue  *struct {
+		Values []jsonAnyValue `json:"values"`
+	} `json:"arrayValue"`
+	KvlistValue *struct {
+		Values []jsonKeyValue `json:"values"`
+	} `json:"kvlistValue"`
+}
+
+func (v jsonAnyValue) value() (interface{}, error) {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue, nil
+	case v.BoolValue != nil:
+		return *v.BoolValue, nil
+	case v.IntValue != nil:
+		return strconv.ParseInt(v.IntValue.String(), 10, 64)
+	case v.DoubleValue != nil:
+		return strconv.ParseFloat(v.DoubleValue.String(), 64)
+	case v.BytesValue != nil:
+		return *v.BytesValue, nil
+	case v.ArrayValue != nil:
+		values := make([]interface{}, 0, len(v.ArrayValue.Values))
+		for _, item := range v.ArrayValue.Values {
+			value, err := item.value()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	case v.KvlistValue != nil:
+		return jsonAttributes(v.KvlistValue.Values)
+	}
+	return nil, nil
+}
+
+func jsonAttributes(kvs []jsonKeyValue) (map[string]interface{}, error) {
+	if len(kvs) == 0 {
+		return nil, nil
+	}
+	attrs := make(map[string]interface{}, len(kvs))
+	for _, kv := range kvs {
+		value, err := kv.Value.value()
+		if err != nil {
+			return nil, fmt.Errorf("attribute %q: %w", kv.Key, err)
+		}
+		if kv.Key != "" {
+			attrs[kv.Key] = value
+		}
+	}
+	return attrs, nil
+}
+
+func jsonUnixNano(n json.Number) (time.Time, error) {
+	if n == "" {
+		return time.Time{}, nil
+	}
+	ns, err := strconv.ParseUint(n.String(), 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return unixNano(ns), nil
+}
+
+// jsonTraceID normalizes a hex trace or span ID; invalid IDs are dropped.
+func jsonTraceID(id string) string {
+	b, err := hex.DecodeString(id)
+	if err != nil {
+		return ""
+	}
+	return traceHex(b)
+}
+
+// unixNano converts an OTLP timestamp; zero means unset.
+func unixNano(ns uint64) time.Time {
+	if ns == 0 || ns > math.MaxInt64 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(ns)).UTC()
+}
+
+// traceHex hex-encodes a trace or span ID, treating all-zero IDs as unset.
+func traceHex(id []byte) string {
+	for _, c := range id {
+		if c != 0 {
+			return hex.EncodeToString(id)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,266 @@
+// Package otlp receives OpenTelemetry logs over OTLP/HTTP, in the protobuf
+// and JSON encodings, and maps each log record to a Sentry event.
+package otlp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"google.golang.org/protobuf/encoding/protowire"
+	"http-to-sentry-go/internal/ingest"
+	"http-to-sentry-go/internal/logevent"
+	"http-to-sentry-go/reqbody"
+	"http-to-sentry-go/stacktrace"
+	"http-to-sentry-go/timestamp"
+)
+
+const (
+	contentTypeProtobuf = "application/x-protobuf"
+	contentTypeJSON     = "application/json"
+)
+
+// resourceTags are the resource attributes copied to event tags.
+var resourceTags = []string{
+	"service.name",
+	"service.namespace",
+	"service.instance.id",
+	"host.name",
+	"k8s.namespace.name",
+	"k8s.pod.name",
+	"k8s.deployment.name",
+	"cloud.region",
+}
+
+type Handler struct {
+	ingest.Options
+}
+
+// HandleLogs serves the OTLP/HTTP logs endpoint, /v1/logs by convention.
+// Records with timestamps the policy rejects are reported back as a partial
+// success.
+func (h Handler) HandleLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var decode func([]byte) ([]Record, error)
+	switch mediaType {
+	case contentTypeProtobuf:
+		decode = DecodeProtobuf
+	case contentTypeJSON:
+		decode = DecodeJSON
+	default:
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	maxBytes := h.BodyLimit(1048576)
+	body, err := reqbody.Read(w, r, maxBytes)
+	if err != nil {
+		status := reqbody.Status(err)
+		if status == http.StatusBadRequest {
+			h.ReportInvalid(1)
+		}
+		w.WriteHeader(status)
+		return
+	}
+	records, err := decode(body)
+	if err != nil {
+		h.ReportInvalid(1)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	rejected := 0
+	for _, rec := range records {
+		event, err := buildSentryEvent(rec, r, h.Timestamps)
+		if err != nil {
+			rejected++
+			continue
+		}
+		if _, err := h.CaptureEvent(r.Context(), event); err != nil {
+			h.ReportInvalid(rejected)
+			status, wait := ingest.Status(err)
+			ingest.SetRetryAfter(w, wait)
+			w.WriteHeader(status)
+			return
+		}
+	}
+	h.ReportInvalid(rejected)
+
+	w.Header().Set("Content-Type", mediaType)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(exportResponse(mediaType, rejected))
+}
+
+// exportResponse encodes an ExportLogsServiceResponse, with a partial
+// success when records were rejected.
+func exportResponse(mediaType string, rejected int) []byte {
+	message := fmt.Sprintf("%d log records have timestamps outside the accepted range", rejected)
+	if mediaType == contentTypeJSON {
+		if rejected == 0 {
+			return []byte("{}")
+		}
+		resp, _ := json.Marshal(map[string]interface{}{
+			"partialSuccess": map[string]string{
+				"rejectedLogRecords": fmt.Sprint(rejected),
+				"errorMessage":       message,
+			},
+		})
+		return resp
+	}
+
+	if rejected == 0 {
+		return nil
+	}
+	var partial []byte
+	partial = protowire.AppendTag(partial, 1, protowire.VarintType)
+	partial = protowire.AppendVarint(partial, uint64(rejected))
+	partial = protowire.AppendTag(partial, 2, protowire.BytesType)
+	partial = protowire.AppendString(partial, message)
+	resp := protowire.AppendTag(nil, 1, protowire.BytesType)
+	return protowire.AppendBytes(resp, partial)
+}
+
+// buildSentryEvent maps a log record. It fails only for timestamps the
+// policy rejects.
+func buildSentryEvent(rec Record, r *http.Request, policy timestamp.Policy) (*sentry.Event, error) {
+	event := logevent.New("otlp", r)
+	event.Level = mapLevel(rec.SeverityNumber, rec.SeverityText)
+
+	recorded := rec.Time
+	if recorded.IsZero() {
+		recorded = rec.ObservedTime
+	}
+	if !recorded.IsZero() {
+		event.Extra["otlp_timestamp"] = recorded.Format(time.RFC3339Nano)
+		ts, clamped, err := policy.Apply(recorded, event.Timestamp)
+		if errors.Is(err, timestamp.ErrOutOfRange) {
+			return nil, err
+		}
+		event.Timestamp = ts
+		if clamped {
+			event.Extra["otlp_timestamp_clamped"] = true
+		}
+	}
+
+	switch body := rec.Body.(type) {
+	case string:
+		event.Message = body
+	case nil:
+	default:
+		if data, err := json.Marshal(body); err == nil {
+			event.Message = string(data)
+		}
+		event.Extra["body"] = body
+	}
+	if event.Message == "" {
+		event.Message = rec.EventName
+	}
+	if event.Message == "" {
+		event.Message = "(empty message)"
+	}
+
+	if env := stringAttr(rec.Resource, "deployment.environment.name"); env != "" {
+		event.Environment = env
+	} else {
+		event.Environment = stringAttr(rec.Resource, "deployment.environment")
+	}
+	event.Release = stringAttr(rec.Resource, "service.version")
+	event.ServerName = stringAttr(rec.Resource, "host.name")
+
+	for _, key := range resourceTags {
+		logevent.AddTag(event.Tags, key, stringAttr(rec.Resource, key))
+	}
+	logevent.AddTag(event.Tags, "otel.scope.name", rec.Scope)
+	if len(rec.Resource) > 0 {
+		event.Contexts["resource"] = rec.Resource
+	}
+	if rec.TraceID != "" {
+		trace := sentry.Context{"trace_id": rec.TraceID}
+		if rec.SpanID != "" {
+			trace["span_id"] = rec.SpanID
+		}
+		event.Contexts["trace"] = trace
+	}
+
+	for key, value := range rec.Attributes {
+		if !strings.HasPrefix(key, "exception.") {
+			event.Extra[key] = value
+		}
+	}
+	if rec.SeverityText != "" {
+		event.Extra["severity_text"] = rec.SeverityText
+	}
+	if rec.SeverityNumber != 0 {
+		event.Extra["severity_number"] = rec.SeverityNumber
+	}
+	if rec.EventName != "" {
+		event.Extra["event_name"] = rec.EventName
+	}
+	if rec.ScopeVersion != "" {
+		event.Extra["otel.scope.version"] = rec.ScopeVersion
+	}
+
+	event.Exception = exceptions(rec.Attributes, event.Message)
+	return event, nil
+}
+
+// exceptions builds the event exceptions from the OpenTelemetry exception
+// attributes, or from a stack trace in the message.
+func exceptions(attrs map[string]interface{}, message string) []sentry.Exception {
+	typ := stringAttr(attrs, "exception.type")
+	value := stringAttr(attrs, "exception.message")
+	trace := stringAttr(attrs, "exception.stacktrace")
+
+	if trace != "" {
+		if excs, ok := stacktrace.Extract(trace); ok {
+			return excs
+		}
+	}
+	if typ != "" || value != "" {
+		exc := sentry.Exception{Type: typ, Value: value}
+		if exc.Type == "" {
+			exc.Type = "Exception"
+		}
+		if trace != "" {
+			exc.Value = strings.TrimSpace(exc.Value + "\n" + trace)
+		}
+		return []sentry.Exception{exc}
+	}
+	if excs, ok := stacktrace.Extract(message); ok {
+		return excs
+	}
+	return nil
+}
+
+// mapLevel maps the OpenTelemetry severity number, or the severity text when
+// the number is unset, to a Sentry level.
+func mapLevel(number int, text string) sentry.Level {
+	switch {
+	case number >= 21:
+		return sentry.LevelFatal
+	case number >= 17:
+		return sentry.LevelError
+	case number >= 13:
+		return sentry.LevelWarning
+	case number >= 9:
+		return sentry.LevelInfo
+	case number >= 1:
+		return sentry.LevelDebug
+	}
+	return logevent.Level(text)
+}
+
+func stringAttr(attrs map[string]interface{}, key string) string {
+	s, _ := attrs[key].(string)
+	return s
+}
@@ -0,0 +1,238 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"google.golang.org/protobuf/encoding/protowire"
+	"http-to-sentry-go/internal/ingest"
+	"http-to-sentry-go/timestamp"
+)
+
+func message(fields ...[]byte) []byte {
+	return bytes.Join(fields, nil)
+}
+
+func bytesField(num protowire.Number, b []byte) []byte {
+	return protowire.AppendBytes(protowire.AppendTag(nil, num, protowire.BytesType), b)
+}
+
+func varintField(num protowire.Number, v uint64) []byte {
+	return protowire.AppendVarint(protowire.AppendTag(nil, num, protowire.VarintType), v)
+}
+
+func fixed64Field(num protowire.Number, v uint64) []byte {
+	return protowire.AppendFixed64(protowire.AppendTag(nil, num, protowire.Fixed64Type), v)
+}
+
+func stringKeyValue(key, value string) []byte {
+	return message(bytesField(1, []byte(key)), bytesField(2, bytesField(1, []byte(value))))
+}
+
+func TestDecodeProtobuf(t *testing.T) {
+	when := time.Date(2026, 1, 29, 11, 41, 12, 0, time.UTC)
+	record := message(
+		fixed64Field(1, uint64(when.UnixNano())),
+		varintField(2, 17),
+		bytesField(3, []byte("ERROR")),
+		bytesField(5, bytesField(1, []byte("charge failed"))),
+		bytesField(6, message(bytesField(1, []byte("retries")), bytesField(2, varintField(3, 3)))),
+		bytesField(6, message(bytesField(1, []byte("ratio")), bytesField(2, fixed64Field(4, math.Float64bits(0.5))))),
+		bytesField(6, message(bytesField(1, []byte("ok")), bytesField(2, varintField(2, 1)))),
+		bytesField(9, bytes.Repeat([]byte{0xab}, 16)),
+		bytesField(10, bytes.Repeat([]byte{0xcd}, 8)),
+	)
+	req := bytesField(1, message(
+		bytesField(2, message(
+			bytesField(1, message(bytesField(1, []byte("checkout")), bytesField(2, []byte("1.2.0")))),
+			bytesField(2, record),
+		)),
+		// The resource may follow the scope logs.
+		bytesField(1, bytesField(1, stringKeyValue("service.name", "billing"))),
+	))
+
+	records, err := DecodeProtobuf(req)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(records))
+	}
+	rec := records[0]
+	if !rec.Time.Equal(when) || rec.SeverityNumber != 17 || rec.SeverityText != "ERROR" || rec.Body != "charge failed" {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+	if rec.Attributes["retries"] != int64(3) || rec.Attributes["ratio"] != 0.5 || rec.Attributes["ok"] != true {
+		t.Fatalf("unexpected attributes %+v", rec.Attributes)
+	}
+	if rec.TraceID != strings.Repeat("ab", 16) || rec.SpanID != strings.Repeat("cd", 8) {
+		t.Fatalf("unexpected trace %s/%s", rec.TraceID, rec.SpanID)
+	}
+	if rec.Resource["service.name"] != "billing" || rec.Scope != "checkout" || rec.ScopeVersion != "1.2.0" {
+		t.Fatalf("unexpected resource %+v, scope %s %s", rec.Resource, rec.Scope, rec.ScopeVersion)
+	}
+
+	if _, err := DecodeProtobuf([]byte{0x0a, 0x05, 0x01}); err == nil {
+		t.Fatalf("expected error for truncated message")
+	}
+}
+
+const jsonRequest = `{"resourceLogs":[{
+	"resource":{"attributes":[
+		{"key":"service.name","value":{"stringValue":"billing"}},
+		{"key":"service.version","value":{"stringValue":"2.4.1"}},
+		{"key":"deployment.environment","value":{"stringValue":"production"}}
+	]},
+	"scopeLogs":[{"scope":{"name":"checkout"},"logRecords":[{
+		"timeUnixNano":"1769686872000000000",
+		"severityText":"warn",
+		"body":{"kvlistValue":{"values":[{"key":"order","value":{"intValue":"42"}}]}},
+		"attributes":[{"key":"tags","value":{"arrayValue":{"values":[{"stringValue":"a"},{"doubleValue":1.5}]}}}],
+		"traceId":"5B8EFFF798038103D269B633813FC60C",
+		"spanId":"EEE19B7EC3C1B174"
+	}]}]
+}]}`
+
+func TestDecodeJSON(t *testing.T) {
+	records, err := DecodeJSON([]byte(jsonRequest))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(records))
+	}
+	rec := records[0]
+	if want := time.Date(2026, 1, 29, 11, 41, 12, 0, time.UTC); !rec.Time.Equal(want) {
+		t.Fatalf("expected %s, got %s", want, rec.Time)
+	}
+	if body, ok := rec.Body.(map[string]interface{}); !ok || body["order"] != int64(42) {
+		t.Fatalf("unexpected body %#v", rec.Body)
+	}
+	if tags, ok := rec.Attributes["tags"].([]interface{}); !ok || len(tags) != 2 || tags[1] != 1.5 {
+		t.Fatalf("unexpected attributes %#v", rec.Attributes)
+	}
+	if rec.TraceID != "5b8efff798038103d269b633813fc60c" || rec.SpanID != "eee19b7ec3c1b174" {
+		t.Fatalf("unexpected trace %s/%s", rec.TraceID, rec.SpanID)
+	}
+
+	if _, err := DecodeJSON([]byte(`{"resourceLogs":[{"scopeLogs":[{"logRecords":[{"timeUnixNano":"soon"}]}]}]}`)); err == nil {
+		t.Fatalf("expected error for invalid timestamp")
+	}
+}
+
+func TestBuildSentryEvent(t *testing.T) {
+	records, err := DecodeJSON([]byte(jsonRequest))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	rec := records[0]
+	rec.Attributes["exception.type"] = "ValueError"
+	rec.Attributes["exception.message"] = "bad order"
+	r := httptest.NewRequest(http.MethodPost, "/v1/logs", nil)
+	policy := timestamp.Policy{MaxPast: 100 * 365 * 24 * time.Hour}
+
+	event, err := buildSentryEvent(rec, r, policy)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if event.Logger != "otlp" || event.Level != sentry.LevelWarning || event.Message != `{"order":42}` {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	if event.Environment != "production" || event.Release != "2.4.1" || event.Tags["service.name"] != "billing" {
+		t.Fatalf("unexpected environment %q, release %q, tags %+v", event.Environment, event.Release, event.Tags)
+	}
+	if trace := event.Contexts["trace"]; trace["trace_id"] != rec.TraceID || trace["span_id"] != rec.SpanID {
+		t.Fatalf("unexpected trace context %+v", trace)
+	}
+	if len(event.Exception) != 1 || event.Exception[0].Type != "ValueError" || event.Exception[0].Value != "bad order" {
+		t.Fatalf("unexpected exception %+v", event.Exception)
+	}
+	if _, ok := event.Extra["exception.type"]; ok || event.Extra["tags"] == nil {
+		t.Fatalf("unexpected extra %+v", event.Extra)
+	}
+
+	rec.Time = time.Now().Add(-48 * time.Hour)
+	if _, err := buildSentryEvent(rec, r, timestamp.Policy{MaxPast: time.Hour, Reject: true}); err == nil {
+		t.Fatalf("expected rejected timestamp")
+	}
+}
+
+func TestMapLevel(t *testing.T) {
+	cases := []struct {
+		number int
+		text   string
+		want   sentry.Level
+	}{
+		{1, "", sentry.LevelDebug},
+		{9, "error", sentry.LevelInfo},
+		{13, "", sentry.LevelWarning},
+		{17, "", sentry.LevelError},
+		{24, "", sentry.LevelFatal},
+		{0, "CRITICAL", sentry.LevelFatal},
+		{0, "", sentry.LevelInfo},
+	}
+	for _, tc := range cases {
+		if got := mapLevel(tc.number, tc.text); got != tc.want {
+			t.Fatalf("%d/%q: expected %s, got %s", tc.number, tc.text, tc.want, got)
+		}
+	}
+}
+
+func TestHandleLogs(t *testing.T) {
+	var captured []*sentry.Event
+	invalid := 0
+	h := Handler{
+		Options: ingest.Options{
+			Capture: func(_ context.Context, event *sentry.Event) (*sentry.EventID, error) {
+				captured = append(captured, event)
+				return &event.EventID, nil
+			},
+			Timestamps: timestamp.Policy{MaxPast: time.Hour, Reject: true},
+			Invalid:    func(count int) { invalid += count },
+		},
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/logs", strings.NewReader(`{"resourceLogs":[{"scopeLogs":[{"logRecords":[
+		{"body":{"stringValue":"fresh"}},
+		{"timeUnixNano":"1000000000","body":{"stringValue":"stale"}}
+	]}]}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	rw := httptest.NewRecorder()
+	h.HandleLogs(rw, req)
+
+	if rw.Code != http.StatusOK || len(captured) != 1 || captured[0].Message != "fresh" || invalid != 1 {
+		t.Fatalf("unexpected result %d, %d captured, %d invalid", rw.Code, len(captured), invalid)
+	}
+	var resp struct {
+		PartialSuccess struct {
+			RejectedLogRecords string `json:"rejectedLogRecords"`
+		} `json:"partialSuccess"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil || resp.PartialSuccess.RejectedLogRecords != "1" {
+		t.Fatalf("unexpected response %s", rw.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/logs", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "text/plain")
+	rw = httptest.NewRecorder()
+	h.HandleLogs(rw, req)
+	if rw.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected 415, got %d", rw.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/logs", strings.NewReader("\x0a\x05"))
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	rw = httptest.NewRecorder()
+	h.HandleLogs(rw, req)
+	if rw.Code != http.StatusBadRequest || invalid != 2 {
+		t.Fatalf("expected 400 and an invalid count, got %d, %d", rw.Code, invalid)
+	}
+}
@@ -0,0 +1,63 @@
+// Package pbwire walks the fields of protobuf messages, for decoding the
+// small fixed schemas of log shipping protocols without generated code.
+package pbwire
+
+import (
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field is one decoded field. Bytes is set for length-delimited fields,
+// Uint for varint, fixed32 and fixed64 fields.
+type Field struct {
+	Num   protowire.Number
+	Type  protowire.Type
+	Bytes []byte
+	Uint  uint64
+}
+
+// Each calls fn for every field of the encoded message b, stopping at the
+// first error.
+func Each(b []byte, fn func(Field) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		f := Field{Num: num, Type: typ}
+		switch typ {
+		case protowire.BytesType:
+			f.Bytes, n = protowire.ConsumeBytes(b)
+		case protowire.VarintType:
+			f.Uint, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			f.Uint, n = protowire.ConsumeFixed64(b)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(b)
+			f.Uint = uint64(v)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// String returns a length-delimited field as a string.
+func (f Field) String() string {
+	return string(f.Bytes)
+}
+
+// Int returns a varint field as a signed integer.
+func (f Field) Int() int64 {
+	return int64(f.Uint)
+}
@@ -0,0 +1,32 @@
+package pbwire
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestEach(t *testing.T) {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, "name")
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, 300)
+	b = protowire.AppendTag(b, 3, protowire.Fixed32Type)
+	b = protowire.AppendFixed32(b, 7)
+
+	var fields []Field
+	if err := Each(b, func(f Field) error {
+		fields = append(fields, f)
+		return nil
+	}); err != nil {
+		t.Fatalf("each: %v", err)
+	}
+	if len(fields) != 3 || fields[0].String() != "name" || fields[1].Int() != 300 || fields[2].Uint != 7 {
+		t.Fatalf("unexpected fields %+v", fields)
+	}
+
+	if err := Each(b[:len(b)-1], func(Field) error { return nil }); err == nil {
+		t.Fatalf("expected error for truncated message")
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"http-to-sentry-go/fastly"
+	"http-to-sentry-go/metrics"
+)
+
+// generation is one loaded configuration with its routes and sinks.
+type generation struct {
+	cfg    config
+	routes []*route
+	// syslog and forward are the routes syslog messages and forwarded
+	// records are captured through.
+	syslog   *route
+	forward  *route
+	sinks    map[sinkKey]*sink
+	mux      *http.ServeMux
+	inflight sync.WaitGroup
+}
+
+func newGeneration(cfg config, existing map[sinkKey]*sink) (*generation, error) {
+	routes, sinks, err := buildRoutes(cfg, existing)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	for _, rt := range routes {
+		handler := rt.handler()
+		for _, pattern := range rt.patterns() {
+			mux.HandleFunc(pattern, handler)
+		}
+	}
+	mux.HandleFunc("/health", handleHealth)
+	mux.Handle(cfg.metricsPath, metrics.Handler())
+	mux.HandleFunc(fastlyChallengePath, fastly.ChallengeHandler(cfg.fastlyServiceID))
+
+	return &generation{
+		cfg:     cfg,
+		routes:  routes,
+		syslog:  listenerRoute(routes, cfg.syslogRoute),
+		forward: listenerRoute(routes, cfg.forwardRoute),
+		sinks:   sinks,
+		mux:     mux,
+	}, nil
+}
+
+func (g *generation) summary() string {
+	paths := make([]string, 0, len(g.routes))
+	for _, rt := range g.routes {
+		paths = append(paths, rt.path+"="+rt.parser)
+	}
+	return "routes=" + strings.Join(paths, ",")
+}
+
+// server dispatches requests to the active generation. A request runs to
+// completion against the generation it started on, so a reload never swaps
+// routes or closes sinks under an in-flight request.
+type server struct {
+	configFile string
+
+	mu      sync.RWMutex
+	current *generation
+	// reloading serializes reloads.
+	reloading sync.Mutex
+}
+
+func newServer(configFile string, cfg config) (*server, error) {
+	g, err := newGeneration(cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &server{configFile: configFile, current: g}, nil
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g, release := s.acquire()
+	defer release()
+
+	// Behind trusted proxies, handlers, tags and the request log see the
+	// client's address.
+	if len(g.cfg.trustedProxies) > 0 {
+		if ip := clientIP(r, g.cfg.trustedProxies); ip != nil {
+			r.RemoteAddr = ip.String()
+		}
+	}
+	g.mux.ServeHTTP(w, r)
+}
+
+// acquire returns the active generation. It is not retired before release
+// is called.
+func (s *server) acquire() (*generation, func()) {
+	s.mu.RLock()
+	g := s.current
+	g.inflight.Add(1)
+	s.mu.RUnlock()
+	return g, g.inflight.Done
+}
+
+// reload reads the configuration again and swaps it in. On error the current
+// configuration stays active. Sinks that are no longer used are closed once
+// the requests still running on the old generation have finished.
+func (s *server) reload() error {
+	s.reloading.Lock()
+	defer s.reloading.Unlock()
+
+	cfg, err := loadConfig(s.configFile)
+	if err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	old := s.current
+	s.mu.RUnlock()
+
+	cfg.tokenStore.KeepQuotas(old.cfg.tokenStore)
+	g, err := newGeneration(cfg, old.sinks)
+	if err != nil {
+		return err
+	}
+	for _, setting := range restartSettings(old.cfg, cfg) {
+		log.Printf("reload: %s changed; restart to apply", setting)
+	}
+
+	s.mu.Lock()
+	s.current = g
+	s.mu.Unlock()
+	log.Printf("reload: %s", g.summary())
+
+	old.inflight.Wait()
+	for key, sk := range old.sinks {
+		if g.sinks[key] != sk {
+			sk.retire(old.cfg.flushTimeout)
+		}
+	}
+	return nil
+}
+
+// close waits for in-flight requests and closes every sink.
+func (s *server) close() {
+	s.reloading.Lock()
+	defer s.reloading.Unlock()
+
+	s.mu.RLock()
+	g := s.current
+	s.mu.RUnlock()
+
+	g.inflight.Wait()
+	for _, sk := range g.sinks {
+		sk.close(g.cfg.flushTimeout)
+	}
+}
+
+// restartSettings lists the settings that differ between old and cfg but are
+// only applied at startup.
+func restartSettings(old, cfg config) []string {
+	var changed []string
+	if old.httpAddr != cfg.httpAddr {
+		changed = append(changed, "http.addr")
+	}
+	if old.httpsAddr != cfg.httpsAddr || old.httpsCertFile != cfg.httpsCertFile || old.httpsKeyFile != cfg.httpsKeyFile ||
+		old.httpsClientCAFile != cfg.httpsClientCAFile || old.httpsClientAuth != cfg.httpsClientAuth {
+		changed = append(changed, "https")
+	}
+	if old.syslogUDPAddr != cfg.syslogUDPAddr || old.syslogTCPAddr != cfg.syslogTCPAddr || old.syslogTLSAddr != cfg.syslogTLSAddr ||
+		old.syslogCertFile != cfg.syslogCertFile || old.syslogKeyFile != cfg.syslogKeyFile || old.syslogMaxBytes != cfg.syslogMaxBytes {
+		changed = append(changed, "syslog listeners")
+	}
+	if old.shutdownGrace != cfg.shutdownGrace {
+		changed = append(changed, "http.shutdown_timeout_ms")
+	}
+	if old.sentryQueueSize != cfg.sentryQueueSize {
+		changed = append(changed, "sentry.queue_size (existing sinks)")
+	}
+	if old.spoolSegmentBytes != cfg.spoolSegmentBytes {
+		changed = append(changed, "spool.segment_bytes (existing sinks)")
+	}
+	return changed
+}
@@ -0,0 +1,442 @@
+// Package reports receives the reports browsers send on their own:
+// Content-Security-Policy violation reports (report-uri) and Reporting API
+// batches (report-to) with csp-violation, deprecation, intervention, crash
+// and network-error reports.
+package reports
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"http-to-sentry-go/internal/ingest"
+	"http-to-sentry-go/internal/logevent"
+	"http-to-sentry-go/reqbody"
+	"http-to-sentry-go/timestamp"
+)
+
+// CSPReport is the body of a report-uri violation report.
+type CSPReport struct {
+	DocumentURI        string `json:"document-uri"`
+	Referrer           string `json:"referrer"`
+	ViolatedDirective  string `json:"violated-directive"`
+	EffectiveDirective string `json:"effective-directive"`
+	OriginalPolicy     string `json:"original-policy"`
+	Disposition        string `json:"disposition"`
+	BlockedURI         string `json:"blocked-uri"`
+	SourceFile         string `json:"source-file"`
+	LineNumber         int    `json:"line-number"`
+	ColumnNumber       int    `json:"column-number"`
+	StatusCode         int    `json:"status-code"`
+	ScriptSample       string `json:"script-sample"`
+}
+
+// Report is one report of a Reporting API batch.
+type Report struct {
+	Type      string          `json:"type"`
+	Age       int64           `json:"age"`
+	URL       string          `json:"url"`
+	UserAgent string          `json:"user_agent"`
+	Body      json.RawMessage `json:"body"`
+}
+
+// cspViolation is the body of a csp-violation report.
+type cspViolation struct {
+	DocumentURL        string `json:"documentURL"`
+	Referrer           string `json:"referrer"`
+	BlockedURL         string `json:"blockedURL"`
+	EffectiveDirective string `json:"effectiveDirective"`
+	OriginalPolicy     string `json:"originalPolicy"`
+	SourceFile         string `json:"sourceFile"`
+	Sample             string `json:"sample"`
+	Disposition        string `json:"disposition"`
+	StatusCode         int    `json:"statusCode"`
+	LineNumber         int    `json:"lineNumber"`
+	ColumnNumber       int    `json:"columnNumber"`
+}
+
+// messageBody is the body of deprecation, intervention and crash reports.
+type messageBody struct {
+	ID                 string `json:"id"`
+	Message            string `json:"message"`
+	SourceFile         string `json:"sourceFile"`
+	LineNumber         int    `json:"lineNumber"`
+	ColumnNumber       int    `json:"columnNumber"`
+	AnticipatedRemoval string `json:"anticipatedRemoval"`
+	Reason             string `json:"reason"`
+}
+
+// networkError is the body of a network-error (NEL) report.
+type networkError struct {
+	Type             string  `json:"type"`
+	Phase            string  `json:"phase"`
+	Method           string  `json:"method"`
+	Protocol         string  `json:"protocol"`
+	ServerIP         string  `json:"server_ip"`
+	StatusCode       int     `json:"status_code"`
+	ElapsedTime      int64   `json:"elapsed_time"`
+	Referrer         string  `json:"referrer"`
+	SamplingFraction float64 `json:"sampling_fraction"`
+}
+
+type Handler struct {
+	ingest.Options
+	// AllowedOrigins are the origins allowed to post reports. Empty allows
+	// every origin.
+	AllowedOrigins []string
+}
+
+// HandleReports serves report-uri and report-to endpoints, including the
+// CORS preflight browsers send before posting reports cross-origin.
+func (h Handler) HandleReports(w http.ResponseWriter, r *http.Request) {
+	if !h.allowOrigin(w, r.Header.Get("Origin")) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Max-Age", "86400")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodPost:
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	maxBytes := h.BodyLimit(65536)
+	body, err := reqbody.Read(w, r, maxBytes)
+	if err != nil {
+		status := reqbody.Status(err)
+		if status == http.StatusBadRequest {
+			h.ReportInvalid(1)
+		}
+		w.WriteHeader(status)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	now := time.Now()
+	var events []*sentry.Event
+	invalid := 0
+	switch mediaType {
+	case "application/csp-report", "application/json", "":
+		// Some browsers send report-uri reports as application/json, and
+		// report-to batches are JSON arrays, so the body decides.
+		if trimmed := strings.TrimSpace(string(body)); !strings.HasPrefix(trimmed, "[") {
+			event, err := h.cspReportEvent(body, r, now)
+			if err != nil {
+				invalid++
+			} else {
+				events = append(events, event)
+			}
+			break
+		}
+		fallthrough
+	case "application/reports+json":
+		events, invalid = h.batchEvents(body, r, now)
+	default:
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+	if len(events) == 0 && invalid > 0 {
+		h.ReportInvalid(invalid)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	for _, event := range events {
+		if _, err := h.CaptureEvent(r.Context(), event); err != nil {
+			h.ReportInvalid(invalid)
+			ingest.WriteError(w, err)
+			return
+		}
+	}
+	h.ReportInvalid(invalid)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// allowOrigin sets the CORS response headers and reports whether origin
+// may post reports. Requests without an Origin are not cross-origin and
+// always allowed.
+func (h Handler) allowOrigin(w http.ResponseWriter, origin string) bool {
+	if len(h.AllowedOrigins) == 0 {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		return true
+	}
+	w.Header().Add("Vary", "Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range h.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			return true
+		}
+	}
+	return false
+}
+
+func (h Handler) cspReportEvent(body []byte, r *http.Request, now time.Time) (*sentry.Event, error) {
+	var doc struct {
+		Report *CSPReport `json:"csp-report"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Report == nil {
+		return nil, errors.New(`missing "csp-report"`)
+	}
+	rep := doc.Report
+	directive := rep.EffectiveDirective
+	if directive == "" {
+		// CSP level 2 reports the directive with its source list.
+		directive, _, _ = strings.Cut(strings.TrimSpace(rep.ViolatedDirective), " ")
+	}
+	return buildCSPEvent(cspViolation{
+		DocumentURL:        rep.DocumentURI,
+		Referrer:           rep.Referrer,
+		BlockedURL:         rep.BlockedURI,
+		EffectiveDirective: directive,
+		OriginalPolicy:     rep.OriginalPolicy,
+		SourceFile:         rep.SourceFile,
+		Sample:             rep.ScriptSample,
+		Disposition:        rep.Disposition,
+		StatusCode:         rep.StatusCode,
+		LineNumber:         rep.LineNumber,
+		ColumnNumber:       rep.ColumnNumber,
+	}, "csp-violation", r.Header.Get("User-Agent"), r, now, now, h.Timestamps)
+}
+
+// batchEvents maps a Reporting API batch. It returns the events and the
+// number of reports that could not be mapped.
+func (h Handler) batchEvents(body []byte, r *http.Request, now time.Time) ([]*sentry.Event, int) {
+	var batch []Report
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return nil, 1
+	}
+	var events []*sentry.Event
+	invalid := 0
+	for _, rep := range batch {
+		event, err := buildReportEvent(rep, r, now, h.Timestamps)
+		if err != nil {
+			invalid++
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, invalid
+}
+
+// buildReportEvent maps one Reporting API report. The report was generated
+// age milliseconds before the batch was sent.
+func buildReportEvent(rep Report, r *http.Request, now time.Time, policy timestamp.Policy) (*sentry.Event, error) {
+	generated := now.Add(-time.Duration(rep.Age) * time.Millisecond)
+	userAgent := rep.UserAgent
+	if userAgent == "" {
+		userAgent = r.Header.Get("User-Agent")
+	}
+
+	switch rep.Type {
+	case "csp-violation":
+		var body cspViolation
+		if err := json.Unmarshal(rep.Body, &body); err != nil {
+			return nil, err
+		}
+		if body.DocumentURL == "" {
+			body.DocumentURL = rep.URL
+		}
+		return buildCSPEvent(body, rep.Type, userAgent, r, generated, now, policy)
+	case "network-error":
+		var body networkError
+		if err := json.Unmarshal(rep.Body, &body); err != nil {
+			return nil, err
+		}
+		return buildNELEvent(body, rep, userAgent, r, generated, now, policy)
+	}
+
+	var body messageBody
+	if len(rep.Body) > 0 {
+		if err := json.Unmarshal(rep.Body, &body); err != nil {
+			return nil, err
+		}
+	}
+	event := newEvent(rep.Type, rep.URL, "", userAgent, r)
+	switch rep.Type {
+	case "deprecation", "intervention":
+		event.Level = sentry.LevelWarning
+		event.Message = body.Message
+		logevent.AddTag(event.Tags, rep.Type+"_id", body.ID)
+		if body.AnticipatedRemoval != "" {
+			event.Extra["anticipated_removal"] = body.AnticipatedRemoval
+		}
+		addSourceLocation(event, body.SourceFile, body.LineNumber, body.ColumnNumber)
+		event.Fingerprint = []string{rep.Type, logevent.FirstNonEmpty(body.ID, body.Message)}
+	case "crash":
+		event.Level = sentry.LevelFatal
+		reason := logevent.FirstNonEmpty(body.Reason, "unknown")
+		event.Message = "Page crashed (" + reason + ")"
+		event.Tags["crash_reason"] = reason
+		event.Fingerprint = []string{"crash", reason, documentPath(rep.URL)}
+	default:
+		if rep.Type == "" {
+			return nil, errors.New("report type is required")
+		}
+		event.Level = sentry.LevelInfo
+		event.Message = rep.Type + " report"
+		event.Fingerprint = []string{rep.Type, documentPath(rep.URL)}
+	}
+	if event.Message == "" {
+		event.Message = rep.Type + " report"
+	}
+	if len(rep.Body) > 0 {
+		event.Extra["report_body"] = rep.Body
+	}
+	return applyTimestamp(event, generated, now, policy)
+}
+
+// buildCSPEvent maps a violation. Identical violations, the same directive
+// blocking the same origin, share a fingerprint wherever they occur.
+func buildCSPEvent(v cspViolation, reportType, userAgent string, r *http.Request, generated, now time.Time, policy timestamp.Policy) (*sentry.Event, error) {
+	if v.EffectiveDirective == "" && v.BlockedURL == "" {
+		return nil, errors.New("violation has no directive or blocked URL")
+	}
+	event := newEvent(reportType, v.DocumentURL, v.Referrer, userAgent, r)
+	event.Logger = "csp"
+	event.Level = sentry.LevelWarning
+	if v.Disposition == "report" {
+		event.Level = sentry.LevelInfo
+	}
+
+	blocked := blockedSource(v.BlockedURL)
+	event.Message = "Blocked '" + v.EffectiveDirective + "' from '" + blocked + "'"
+	logevent.AddTag(event.Tags, "blocked_uri", truncate(v.BlockedURL))
+	logevent.AddTag(event.Tags, "effective_directive", v.EffectiveDirective)
+	logevent.AddTag(event.Tags, "disposition", v.Disposition)
+	if v.StatusCode != 0 {
+		event.Tags["status_code"] = strconv.Itoa(v.StatusCode)
+	}
+	if v.OriginalPolicy != "" {
+		event.Extra["original_policy"] = v.OriginalPolicy
+	}
+	if v.Sample != "" {
+		event.Extra["sample"] = v.Sample
+	}
+	addSourceLocation(event, v.SourceFile, v.LineNumber, v.ColumnNumber)
+	event.Fingerprint = []string{"csp", v.EffectiveDirective, blocked}
+	return applyTimestamp(event, generated, now, policy)
+}
+
+func buildNELEvent(body networkError, rep Report, userAgent string, r *http.Request, generated, now time.Time, policy timestamp.Policy) (*sentry.Event, error) {
+	event := newEvent(rep.Type, rep.URL, body.Referrer, userAgent, r)
+	event.Level = sentry.LevelError
+	if body.Type == "ok" {
+		event.Level = sentry.LevelInfo
+	}
+	if event.Request != nil {
+		event.Request.Method = body.Method
+	}
+	event.Message = "Network error " + logevent.FirstNonEmpty(body.Type, "unknown") + " for " + rep.URL
+	logevent.AddTag(event.Tags, "nel_type", body.Type)
+	logevent.AddTag(event.Tags, "nel_phase", body.Phase)
+	logevent.AddTag(event.Tags, "server_ip", body.ServerIP)
+	logevent.AddTag(event.Tags, "protocol", body.Protocol)
+	if body.StatusCode != 0 {
+		event.Tags["status_code"] = strconv.Itoa(body.StatusCode)
+	}
+	event.Extra["elapsed_time_ms"] = body.ElapsedTime
+	event.Extra["sampling_fraction"] = body.SamplingFraction
+	host := rep.URL
+	if u, err := url.Parse(rep.URL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	event.Fingerprint = []string{"network-error", body.Type, host}
+	return applyTimestamp(event, generated, now, policy)
+}
+
+// newEvent creates an event for a report about documentURL.
+func newEvent(reportType, documentURL, referrer, userAgent string, r *http.Request) *sentry.Event {
+	event := logevent.New("reports", r)
+	event.Tags["report_type"] = reportType
+	if documentURL != "" {
+		event.Request = &sentry.Request{URL: documentURL, Headers: map[string]string{}}
+		if u, err := url.Parse(documentURL); err == nil {
+			event.Request.QueryString = u.RawQuery
+			logevent.AddTag(event.Tags, "document_host", u.Host)
+		}
+		if referrer != "" {
+			event.Request.Headers["Referer"] = referrer
+		}
+		if userAgent != "" {
+			event.Request.Headers["User-Agent"] = userAgent
+		}
+	}
+	return event
+}
+
+func applyTimestamp(event *sentry.Event, generated, now time.Time, policy timestamp.Policy) (*sentry.Event, error) {
+	ts, clamped, err := policy.Apply(generated, now)
+	if errors.Is(err, timestamp.ErrOutOfRange) {
+		return nil, err
+	}
+	event.Timestamp = ts
+	if clamped {
+		event.Extra["report_timestamp_clamped"] = true
+	}
+	return event, nil
+}
+
+func addSourceLocation(event *sentry.Event, file string, line, column int) {
+	if file == "" {
+		return
+	}
+	event.Extra["source_file"] = file
+	if line != 0 {
+		event.Extra["line_number"] = line
+	}
+	if column != 0 {
+		event.Extra["column_number"] = column
+	}
+}
+
+// blockedSource reduces a blocked URL to its origin, so violations of one
+// third party group together; keywords such as "inline" and "eval", and
+// schemes such as "data", are kept as they are.
+func blockedSource(blocked string) string {
+	if blocked == "" {
+		return "self"
+	}
+	u, err := url.Parse(blocked)
+	if err != nil || u.Host == "" {
+		scheme, _, _ := strings.Cut(blocked, ":")
+		return scheme
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// documentPath returns the URL without its query and fragment.
+func documentPath(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	u.RawQuery, u.Fragment = "", ""
+	return u.String()
+}
+
+// truncate shortens a tag value to the 200 characters Sentry accepts.
+func truncate(value string) string {
+	if len(value) <= 200 {
+		return value
+	}
+	return value[:200]
+}
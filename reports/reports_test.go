@@ -0,0 +1,163 @@
+package reports
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"http-to-sentry-go/internal/ingest"
+	"http-to-sentry-go/timestamp"
+)
+
+type recorder struct {
+	events  []*sentry.Event
+	invalid int
+}
+
+func (r *recorder) handler() Handler {
+	return Handler{
+		Options: ingest.Options{
+			Capture: func(_ context.Context, event *sentry.Event) (*sentry.EventID, error) {
+				r.events = append(r.events, event)
+				return &event.EventID, nil
+			},
+			Timestamps: timestamp.Policy{MaxPast: time.Hour},
+			Invalid:    func(count int) { r.invalid += count },
+		},
+	}
+}
+
+func post(h Handler, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/reports", strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Origin", "https://shop.example.com")
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	rw := httptest.NewRecorder()
+	h.HandleReports(rw, req)
+	return rw
+}
+
+func TestCSPReportURI(t *testing.T) {
+	rec := &recorder{}
+	h := rec.handler()
+
+	report := func(blocked string) string {
+		return `{"csp-report":{"document-uri":"https://shop.example.com/checkout?step=2","referrer":"https://shop.example.com/cart","violated-directive":"script-src-elem 'self'","original-policy":"script-src 'self'; report-uri /reports","disposition":"enforce","blocked-uri":"` + blocked + `","line-number":12,"source-file":"https://shop.example.com/checkout","status-code":200}}`
+	}
+	for _, blocked := range []string{"https://evil.example/a.js", "https://evil.example/b.js?v=2", "inline"} {
+		rw := post(h, "application/csp-report", report(blocked))
+		if rw.Code != http.StatusNoContent {
+			t.Fatalf("status = %d, want 204", rw.Code)
+		}
+		if rw.Header().Get("Access-Control-Allow-Origin") != "*" {
+			t.Fatalf("missing CORS header: %v", rw.Header())
+		}
+	}
+	if len(rec.events) != 3 {
+		t.Fatalf("captured %d events, want 3", len(rec.events))
+	}
+
+	event := rec.events[0]
+	if event.Logger != "csp" || event.Level != sentry.LevelWarning || event.Message != "Blocked 'script-src-elem' from 'https://evil.example'" {
+		t.Fatalf("event = %s %s %q", event.Logger, event.Level, event.Message)
+	}
+	if event.Tags["blocked_uri"] != "https://evil.example/a.js" || event.Tags["effective_directive"] != "script-src-elem" || event.Tags["report_type"] != "csp-violation" {
+		t.Fatalf("tags = %v", event.Tags)
+	}
+	if event.Request == nil || event.Request.URL != "https://shop.example.com/checkout?step=2" || event.Request.Headers["Referer"] != "https://shop.example.com/cart" {
+		t.Fatalf("request = %+v", event.Request)
+	}
+	if strings.Join(event.Fingerprint, "|") != strings.Join(rec.events[1].Fingerprint, "|") {
+		t.Fatalf("same origin should group: %v vs %v", event.Fingerprint, rec.events[1].Fingerprint)
+	}
+	if strings.Join(event.Fingerprint, "|") == strings.Join(rec.events[2].Fingerprint, "|") {
+		t.Fatalf("inline violation should not group with %v", event.Fingerprint)
+	}
+	if rec.events[2].Message != "Blocked 'script-src-elem' from 'inline'" {
+		t.Fatalf("inline message = %q", rec.events[2].Message)
+	}
+}
+
+func TestReportingAPIBatch(t *testing.T) {
+	rec := &recorder{}
+	h := rec.handler()
+
+	body := `[
+{"type":"csp-violation","age":1000,"url":"https://shop.example.com/","user_agent":"Chrome","body":{"documentURL":"https://shop.example.com/","blockedURL":"https://cdn.evil.example/x.js","effectiveDirective":"script-src-elem","disposition":"report","statusCode":200}},
+{"type":"deprecation","age":0,"url":"https://shop.example.com/","body":{"id":"UnloadHandler","message":"Unload event listeners are deprecated","sourceFile":"https://shop.example.com/app.js","lineNumber":3,"columnNumber":7,"anticipatedRemoval":"2026-06-01"}},
+{"type":"intervention","age":0,"url":"https://shop.example.com/","body":{"id":"HeavyAdIntervention","message":"Ad was removed"}},
+{"type":"crash","age":0,"url":"https://shop.example.com/video?id=3","body":{"reason":"oom"}},
+{"type":"network-error","age":500,"url":"https://api.example.com/v1/cart","body":{"type":"tcp.timed_out","phase":"connection","method":"POST","server_ip":"192.0.2.1","protocol":"h2","status_code":0,"elapsed_time":30000,"sampling_fraction":1}},
+{"age":0,"url":"https://shop.example.com/"}
+]`
+	rw := post(h, "application/reports+json", body)
+	if rw.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want 204", rw.Code)
+	}
+	if rec.invalid != 1 {
+		t.Fatalf("invalid = %d, want 1", rec.invalid)
+	}
+	if len(rec.events) != 5 {
+		t.Fatalf("captured %d events, want 5", len(rec.events))
+	}
+
+	csp := rec.events[0]
+	if csp.Level != sentry.LevelInfo || csp.Tags["disposition"] != "report" || csp.Request.Headers["User-Agent"] != "Chrome" {
+		t.Fatalf("csp event = %s %v %+v", csp.Level, csp.Tags, csp.Request)
+	}
+	if age := time.Since(csp.Timestamp); age < time.Second || age > time.Minute {
+		t.Fatalf("csp timestamp should be the report's age ago, got %s", csp.Timestamp)
+	}
+
+	deprecation := rec.events[1]
+	if deprecation.Logger != "reports" || deprecation.Level != sentry.LevelWarning || deprecation.Tags["deprecation_id"] != "UnloadHandler" || deprecation.Extra["source_file"] != "https://shop.example.com/app.js" {
+		t.Fatalf("deprecation event = %s %v %v", deprecation.Level, deprecation.Tags, deprecation.Extra)
+	}
+	if intervention := rec.events[2]; intervention.Tags["intervention_id"] != "HeavyAdIntervention" || intervention.Message != "Ad was removed" {
+		t.Fatalf("intervention event = %v %q", intervention.Tags, intervention.Message)
+	}
+	crash := rec.events[3]
+	if crash.Level != sentry.LevelFatal || crash.Message != "Page crashed (oom)" || strings.Join(crash.Fingerprint, "|") != "crash|oom|https://shop.example.com/video" {
+		t.Fatalf("crash event = %s %q %v", crash.Level, crash.Message, crash.Fingerprint)
+	}
+	nel := rec.events[4]
+	if nel.Level != sentry.LevelError || nel.Tags["nel_type"] != "tcp.timed_out" || nel.Request.Method != "POST" || strings.Join(nel.Fingerprint, "|") != "network-error|tcp.timed_out|api.example.com" {
+		t.Fatalf("nel event = %s %v %+v %v", nel.Level, nel.Tags, nel.Request, nel.Fingerprint)
+	}
+}
+
+func TestPreflightAndOrigins(t *testing.T) {
+	rec := &recorder{}
+	h := rec.handler()
+	h.AllowedOrigins = []string{"https://shop.example.com"}
+
+	for origin, want := range map[string]int{
+		"https://shop.example.com": http.StatusNoContent,
+		"https://evil.example":     http.StatusForbidden,
+	} {
+		req := httptest.NewRequest(http.MethodOptions, "/reports", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		rw := httptest.NewRecorder()
+		h.HandleReports(rw, req)
+		if rw.Code != want {
+			t.Fatalf("%s: status = %d, want %d", origin, rw.Code, want)
+		}
+		if want == http.StatusNoContent {
+			if rw.Header().Get("Access-Control-Allow-Origin") != origin || !strings.Contains(rw.Header().Get("Access-Control-Allow-Methods"), "POST") {
+				t.Fatalf("%s: preflight headers = %v", origin, rw.Header())
+			}
+		}
+	}
+
+	if rw := post(h, "text/plain", "hello"); rw.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("text/plain: status = %d, want 415", rw.Code)
+	}
+	if rw := post(h, "application/csp-report", `{"other":1}`); rw.Code != http.StatusBadRequest || rec.invalid != 1 {
+		t.Fatalf("bad report: status = %d, invalid = %d", rw.Code, rec.invalid)
+	}
+}
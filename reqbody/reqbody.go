@@ -0,0 +1,80 @@
+// Package reqbody reads size-limited request bodies, decompressing them
+// according to Content-Encoding.
+package reqbody
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrTooLarge is returned when the body, compressed or decompressed,
+	// exceeds the limit.
+	ErrTooLarge = errors.New("request body too large")
+	// ErrUnsupportedEncoding is returned for unknown Content-Encoding values.
+	ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+)
+
+// Read reads the request body, decompressing gzip and deflate encoded
+// bodies. maxBytes bounds both the encoded and the decoded size.
+func Read(w http.ResponseWriter, r *http.Request, maxBytes int) ([]byte, error) {
+	data, err := readAll(http.MaxBytesReader(w, r.Body, int64(maxBytes)), maxBytes)
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return nil, ErrTooLarge
+		}
+		return nil, err
+	}
+	return Decode(r.Header.Get("Content-Encoding"), data, maxBytes)
+}
+
+// Decode decompresses data encoded with the given Content-Encoding.
+func Decode(encoding string, data []byte, maxBytes int) ([]byte, error) {
+	var zr io.ReadCloser
+	var err error
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return data, nil
+	case "gzip", "x-gzip":
+		zr, err = gzip.NewReader(bytes.NewReader(data))
+	case "deflate":
+		zr, err = zlib.NewReader(bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedEncoding, encoding)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return readAll(zr, maxBytes)
+}
+
+func readAll(r io.Reader, maxBytes int) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, int64(maxBytes)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxBytes {
+		return nil, ErrTooLarge
+	}
+	return data, nil
+}
+
+// Status returns the response status for an error returned by Read.
+func Status(err error) int {
+	switch {
+	case errors.Is(err, ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrUnsupportedEncoding):
+		return http.StatusUnsupportedMediaType
+	default:
+		return http.StatusBadRequest
+	}
+}
@@ -0,0 +1,51 @@
+package reqbody
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestRead(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(gzipped(t, "hello")))
+	req.Header.Set("Content-Encoding", "gzip")
+	data, err := Read(httptest.NewRecorder(), req, 1024)
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("unexpected body %q, %v", data, err)
+	}
+
+	// A small compressed body may not expand past the limit.
+	req = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(gzipped(t, strings.Repeat("a", 4096))))
+	req.Header.Set("Content-Encoding", "gzip")
+	if _, err := Read(httptest.NewRecorder(), req, 1024); !errors.Is(err, ErrTooLarge) || Status(err) != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected too large, got %v", err)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat("a", 2048)))
+	if _, err := Read(httptest.NewRecorder(), req, 1024); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected too large, got %v", err)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello"))
+	req.Header.Set("Content-Encoding", "br")
+	if _, err := Read(httptest.NewRecorder(), req, 1024); Status(err) != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected unsupported encoding, got %v", err)
+	}
+}
@@ -0,0 +1,889 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"http-to-sentry-go/cloudflare"
+	"http-to-sentry-go/drain"
+	"http-to-sentry-go/elastic"
+	"http-to-sentry-go/fastly"
+	"http-to-sentry-go/firehose"
+	"http-to-sentry-go/hec"
+	"http-to-sentry-go/internal/ingest"
+	"http-to-sentry-go/jwt"
+	"http-to-sentry-go/loki"
+	"http-to-sentry-go/mapping"
+	"http-to-sentry-go/metrics"
+	"http-to-sentry-go/mtls"
+	"http-to-sentry-go/otlp"
+	"http-to-sentry-go/reports"
+	"http-to-sentry-go/signature"
+	"http-to-sentry-go/spool"
+	"http-to-sentry-go/tokens"
+	"http-to-sentry-go/transport"
+)
+
+// routeConfig describes one ingest endpoint. Empty fields inherit the global
+// settings.
+type routeConfig struct {
+	Name        string   `json:"name"`
+	Path        string   `json:"path"`
+	Parser      string   `json:"parser"`
+	SentryDSN   string   `json:"sentry_dsn"`
+	Environment string   `json:"environment"`
+	Release     string   `json:"release"`
+	AuthTokens  []string `json:"auth_tokens"`
+	// AllowedCIDRs restricts the client addresses of the route.
+	AllowedCIDRs []string       `json:"allowed_cidrs"`
+	MaxBodyBytes int            `json:"max_body_bytes"`
+	Mapping      *mapping.Rules `json:"mapping"`
+	JSONLines    bool           `json:"json_lines"`
+	MinLevel     string         `json:"min_level"`
+	Match        string         `json:"match"`
+	// HMAC, if set, replaces the bearer token check with a request
+	// signature check.
+	HMAC *signature.Rules `json:"hmac"`
+	// ClientCert, if set, replaces the bearer token check with a verified
+	// client certificate matching the allowlist.
+	ClientCert *mtls.Allowlist `json:"client_cert"`
+	// Scope, if set, only accepts JWTs granting it.
+	Scope string `json:"scope"`
+}
+
+// route is a registered ingest endpoint bound to a Sentry sink.
+type route struct {
+	name   string
+	path   string
+	parser string
+	// cfg is the global config with the route's overrides applied.
+	cfg  config
+	key  sinkKey
+	sink *sink
+	// tokens are the scoped tokens allowed on the route, by name.
+	tokens map[string]*routeToken
+}
+
+// routeToken is a scoped token on a route, bound to the route's sink or,
+// if the token has its own Sentry DSN, to a sink of its own.
+type routeToken struct {
+	token *tokens.Token
+	key   sinkKey
+	sink  *sink
+}
+
+// sinkKey identifies a sink. Routes with the same DSN, environment and
+// release share a sink, and a reload keeps sinks whose key did not change.
+type sinkKey struct {
+	dsn      string
+	env      string
+	release  string
+	spoolDir string
+}
+
+// spoolPath returns the spool directory below root of the sink with key, or
+// "" if root is empty. It is derived from the DSN, environment and release,
+// so a sink whose settings change on reload never opens, or delivers to
+// another project, the events its predecessor spooled.
+func (k sinkKey) spoolPath(root string) string {
+	if root == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(k.dsn + "\x00" + k.env + "\x00" + k.release))
+	return filepath.Join(root, "sinks", hex.EncodeToString(sum[:8]))
+}
+
+// sink is a Sentry client with its transport and, when spooling, the
+// background sender delivering the spool.
+type sink struct {
+	key       sinkKey
+	hub       *sentry.Hub
+	transport transport.Transport
+	spool     *spool.Spool
+	stop      context.CancelFunc
+	done      chan struct{}
+}
+
+// parsers builds the handler for each supported route parser from the
+// route's settings and the options every handler takes.
+var parsers = map[string]func(cfg config, opts ingest.Options) http.HandlerFunc{
+	"generic": func(cfg config, opts ingest.Options) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			handleIngest(w, r, cfg, opts.Capture)
+		}
+	},
+	"fastly": func(cfg config, opts ingest.Options) http.HandlerFunc {
+		return fastly.Handler{Options: opts}.HandleEvents
+	},
+	"cloudflare": func(cfg config, opts ingest.Options) http.HandlerFunc {
+		return cloudflare.Handler{Options: opts}.HandleLogpush
+	},
+	"reports": func(cfg config, opts ingest.Options) http.HandlerFunc {
+		h := reports.Handler{Options: opts, AllowedOrigins: cfg.reportsOrigins}
+		return h.HandleReports
+	},
+	"otlp": func(cfg config, opts ingest.Options) http.HandlerFunc {
+		return otlp.Handler{Options: opts}.HandleLogs
+	},
+	"loki": func(cfg config, opts ingest.Options) http.HandlerFunc {
+		h := loki.Handler{Options: opts}
+		if cfg.lokiJSONLines {
+			h.ParseLine = func(line string) (mapping.Fields, bool) {
+				return decodeFields([]byte(line), cfg.mapping)
+			}
+		}
+		return h.HandlePush
+	},
+	"hec": func(cfg config, opts ingest.Options) http.HandlerFunc {
+		return hec.Handler{Options: opts}.HandleCollector
+	},
+	"elastic": func(cfg config, opts ingest.Options) http.HandlerFunc {
+		h := elastic.Handler{Options: opts, Version: cfg.elasticVersion}
+		return h.Handle
+	},
+	"firehose": func(cfg config, opts ingest.Options) http.HandlerFunc {
+		return firehose.Handler{Options: opts}.HandleRecords
+	},
+	"heroku": func(cfg config, opts ingest.Options) http.HandlerFunc {
+		h := drain.Heroku{Options: opts, Filter: cfg.drainFilter}
+		return h.HandleLogplex
+	},
+	"vercel": func(cfg config, opts ingest.Options) http.HandlerFunc {
+		h := drain.Vercel{
+			Options:     opts,
+			Filter:      cfg.drainFilter,
+			Secrets:     cfg.vercelSecrets,
+			VerifyToken: cfg.vercelVerifyToken,
+		}
+		return h.HandleLogs
+	},
+	"netlify": func(cfg config, opts ingest.Options) http.HandlerFunc {
+		h := drain.Netlify{Options: opts, Filter: cfg.drainFilter}
+		return h.HandleLogs
+	},
+}
+
+// handlerOptions are the options of the route's handler.
+func (c config) handlerOptions(capture ingest.CaptureFunc) ingest.Options {
+	return ingest.Options{
+		MaxBodyBytes: c.maxBodyBytes,
+		Capture:      capture,
+		Timestamps:   c.timestamps,
+		Invalid: func(count int) {
+			metrics.ParseFailures.WithLabelValues(c.route).Add(float64(count))
+		},
+	}
+}
+
+// drainParsers are the parsers filtering lines by min_level and match.
+var drainParsers = map[string]bool{
+	"heroku":  true,
+	"vercel":  true,
+	"netlify": true,
+}
+
+// authSchemes are the Authorization schemes of parsers whose clients do not
+// send bearer tokens.
+var authSchemes = map[string][]string{
+	"hec":     {"Splunk"},
+	"elastic": {"Bearer", "ApiKey", "Basic"},
+	// Logplex cannot send headers; the token goes in the drain URL's
+	// userinfo.
+	"heroku": {"Basic", "Bearer"},
+	// Browsers post reports without credentials; the token goes in the
+	// report-uri or report-to URL.
+	"reports": {"Query"},
+	// Firehose sends the access key in X-Amz-Firehose-Access-Key.
+	"firehose": {"Firehose"},
+}
+
+// ownAuthParsers admit requests themselves through ingest.Options.Authorize,
+// as their clients expect errors in the protocol's response format.
+var ownAuthParsers = map[string]bool{
+	"firehose": true,
+}
+
+// fastlyChallengePath serves the Fastly logging endpoint challenge.
+const fastlyChallengePath = "/.well-known/fastly/logging/challenge"
+
+// extraPatterns returns the patterns a route serves besides its path.
+var extraPatterns = map[string]func(path string) []string{
+	// The collector endpoints live below the route path.
+	"hec": func(path string) []string {
+		return []string{strings.TrimSuffix(path, "/") + "/"}
+	},
+	// Clients probe the version at the root of the route's prefix and may
+	// name the index in the bulk path.
+	"elastic": func(path string) []string {
+		base := strings.TrimSuffix(path, "/_bulk")
+		return []string{base + "/{$}", base + "/{index}/_bulk"}
+	},
+}
+
+func loadRoutes(filename string) ([]routeConfig, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Routes []routeConfig `json:"routes"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return file.Routes, nil
+}
+
+// defaultRoutes are the routes configured by HTTP_PATH, HTTP_OTLP_PATH,
+// HTTP_LOKI_PATH, HTTP_HEC_PATH, HTTP_ELASTIC_PATH, HTTP_FIREHOSE_PATH,
+// HTTP_HEROKU_PATH, HTTP_VERCEL_PATH, HTTP_NETLIFY_PATH,
+// HTTP_CLOUDFLARE_PATH, HTTP_REPORTS_PATH and HTTP_FASTLY_PATH.
+func defaultRoutes(cfg config) []routeConfig {
+	routes := []routeConfig{{Path: cfg.httpPath, Parser: "generic"}}
+	if cfg.otlpPath != "" {
+		routes = append(routes, routeConfig{Path: cfg.otlpPath, Parser: "otlp"})
+	}
+	if cfg.lokiPath != "" {
+		routes = append(routes, routeConfig{Path: cfg.lokiPath, Parser: "loki"})
+	}
+	if cfg.hecPath != "" {
+		routes = append(routes, routeConfig{Path: cfg.hecPath, Parser: "hec"})
+	}
+	if cfg.elasticPath != "" {
+		routes = append(routes, routeConfig{Path: cfg.elasticPath, Parser: "elastic"})
+	}
+	if cfg.firehosePath != "" {
+		routes = append(routes, routeConfig{Path: cfg.firehosePath, Parser: "firehose"})
+	}
+	if cfg.herokuPath != "" {
+		routes = append(routes, routeConfig{Path: cfg.herokuPath, Parser: "heroku"})
+	}
+	if cfg.vercelPath != "" {
+		routes = append(routes, routeConfig{Path: cfg.vercelPath, Parser: "vercel"})
+	}
+	if cfg.netlifyPath != "" {
+		routes = append(routes, routeConfig{Path: cfg.netlifyPath, Parser: "netlify"})
+	}
+	if cfg.cloudflarePath != "" {
+		routes = append(routes, routeConfig{Path: cfg.cloudflarePath, Parser: "cloudflare"})
+	}
+	if cfg.reportsPath != "" {
+		routes = append(routes, routeConfig{Path: cfg.reportsPath, Parser: "reports"})
+	}
+	if cfg.fastlyServiceID != "" {
+		routes = append(routes, routeConfig{Path: cfg.fastlyPath, Parser: "fastly"})
+	}
+	return routes
+}
+
+// planRoutes validates the default and configured routes and resolves each
+// route's settings and sink key without creating any sinks.
+func planRoutes(cfg config) ([]*route, error) {
+	configs := append(defaultRoutes(cfg), cfg.routes...)
+
+	var routes []*route
+	seenPaths := map[string]bool{}
+	seenNames := map[string]bool{}
+	reserved := map[string]bool{
+		"/health":           true,
+		fastlyChallengePath: true,
+		cfg.metricsPath:     true,
+	}
+
+	for i, rc := range configs {
+		if !strings.HasPrefix(rc.Path, "/") {
+			return nil, fmt.Errorf("route %d: path %q must start with /", i, rc.Path)
+		}
+		if reserved[rc.Path] {
+			return nil, fmt.Errorf("route %d: path %q is reserved", i, rc.Path)
+		}
+		if seenPaths[rc.Path] {
+			return nil, fmt.Errorf("route %d: duplicate path %q", i, rc.Path)
+		}
+		seenPaths[rc.Path] = true
+
+		name := rc.Name
+		if name == "" {
+			name = routeName(rc.Path)
+		}
+		if !validRouteName(name) {
+			return nil, fmt.Errorf("route %d: name %q may only contain letters, digits, '-', '_' and '.'", i, name)
+		}
+		if seenNames[name] {
+			return nil, fmt.Errorf("route %d: duplicate name %q", i, name)
+		}
+		seenNames[name] = true
+
+		parser := rc.Parser
+		if parser == "" {
+			parser = "generic"
+		}
+		if _, ok := parsers[parser]; !ok {
+			return nil, fmt.Errorf("route %q: unknown parser %q", name, parser)
+		}
+
+		if rc.JSONLines && parser != "loki" {
+			return nil, fmt.Errorf("route %q: json_lines requires the loki parser", name)
+		}
+		if base, ok := strings.CutSuffix(rc.Path, "/_bulk"); parser == "elastic" && (!ok || base == "") {
+			return nil, fmt.Errorf("route %q: path %q of the elastic parser must be a prefix followed by /_bulk, like /es/_bulk", name, rc.Path)
+		}
+		if extra := extraPatterns[parser]; extra != nil {
+			for _, pattern := range extra(rc.Path) {
+				if pattern == rc.Path {
+					continue
+				}
+				if reserved[pattern] || seenPaths[pattern] {
+					return nil, fmt.Errorf("route %q: path %q is reserved or used by another route", name, pattern)
+				}
+				seenPaths[pattern] = true
+			}
+		}
+		if (rc.MinLevel != "" || rc.Match != "") && !drainParsers[parser] {
+			return nil, fmt.Errorf("route %q: min_level and match require the heroku, vercel or netlify parser", name)
+		}
+
+		routeCfg := cfg
+		routeCfg.route = name
+		routeCfg.authSchemes = authSchemes[parser]
+		if rc.JSONLines {
+			routeCfg.lokiJSONLines = true
+		}
+		if len(rc.AuthTokens) > 0 {
+			routeCfg.authTokens = rc.AuthTokens
+		}
+		if len(rc.AllowedCIDRs) > 0 {
+			routeCfg.allowedCIDRs = nil
+			for _, cidr := range rc.AllowedCIDRs {
+				network, err := parseCIDR(cidr)
+				if err != nil {
+					return nil, fmt.Errorf("route %q: allowed_cidrs: %w", name, err)
+				}
+				routeCfg.allowedCIDRs = append(routeCfg.allowedCIDRs, network)
+			}
+		}
+		if parser != "fastly" {
+			routeCfg.fastlyRanges = nil
+		}
+		if rc.MaxBodyBytes > 0 {
+			routeCfg.maxBodyBytes = rc.MaxBodyBytes
+		}
+		if rc.MinLevel != "" {
+			level, err := drain.ParseLevel(rc.MinLevel)
+			if err != nil {
+				return nil, fmt.Errorf("route %q: min_level: %w", name, err)
+			}
+			routeCfg.drainFilter.MinLevel = level
+		}
+		if rc.Match != "" {
+			re, err := regexp.Compile(rc.Match)
+			if err != nil {
+				return nil, fmt.Errorf("route %q: match: %w", name, err)
+			}
+			routeCfg.drainFilter.Match = re
+		}
+		if rc.HMAC != nil {
+			v, err := signature.Compile(*rc.HMAC)
+			if err != nil {
+				return nil, fmt.Errorf("route %q: hmac: %w", name, err)
+			}
+			routeCfg.signature = v
+		}
+		if rc.ClientCert != nil {
+			if cfg.httpsClientAuth == tls.NoClientCert {
+				return nil, fmt.Errorf("route %q: client_cert requires https.client_ca_file (HTTPS_CLIENT_CA_FILE)", name)
+			}
+			if err := rc.ClientCert.Validate(); err != nil {
+				return nil, fmt.Errorf("route %q: client_cert: %w", name, err)
+			}
+			routeCfg.clientCert = rc.ClientCert
+		}
+		if rc.Scope != "" {
+			switch {
+			case cfg.jwt == nil:
+				return nil, fmt.Errorf("route %q: scope requires jwt.jwks_file (JWT_JWKS_FILE)", name)
+			case routeCfg.signature != nil || routeCfg.clientCert != nil:
+				return nil, fmt.Errorf("route %q: scope is only checked on routes taking bearer tokens", name)
+			}
+			routeCfg.jwtScope = rc.Scope
+		}
+		if rc.Mapping != nil {
+			m, err := mapping.Compile(*rc.Mapping)
+			if err != nil {
+				return nil, fmt.Errorf("route %q: mapping: %w", name, err)
+			}
+			routeCfg.mapping = m
+		}
+
+		if rc.SentryDSN != "" {
+			if _, err := sentry.NewDsn(rc.SentryDSN); err != nil {
+				return nil, fmt.Errorf("route %q: sentry_dsn: %w", name, err)
+			}
+		}
+		key := sinkKey{dsn: cfg.sentryDSN, env: cfg.sentryEnvironment, release: cfg.sentryRelease}
+		if rc.SentryDSN != "" {
+			key.dsn = rc.SentryDSN
+		}
+		if rc.Environment != "" {
+			key.env = rc.Environment
+		}
+		if rc.Release != "" {
+			key.release = rc.Release
+		}
+		key.spoolDir = key.spoolPath(cfg.spoolDir)
+
+		rt := &route{name: name, path: rc.Path, parser: parser, cfg: routeCfg, key: key}
+		// Routes that check signatures or client certificates do not take
+		// scoped tokens.
+		if routeCfg.signature == nil && routeCfg.clientCert == nil {
+			rt.tokens = planTokens(cfg, rt)
+		}
+		routes = append(routes, rt)
+	}
+
+	for _, t := range cfg.tokenStore.Tokens() {
+		for _, name := range t.Routes {
+			if !seenNames[name] {
+				return nil, fmt.Errorf("token %q: unknown route %q", t.Name, name)
+			}
+		}
+	}
+	if err := checkPatterns(cfg, routes); err != nil {
+		return nil, err
+	}
+	if cfg.syslogRoute != "" && !seenNames[cfg.syslogRoute] {
+		return nil, fmt.Errorf("syslog.route (SYSLOG_ROUTE): unknown route %q", cfg.syslogRoute)
+	}
+	if cfg.forwardRoute != "" && !seenNames[cfg.forwardRoute] {
+		return nil, fmt.Errorf("forward.route (FORWARD_ROUTE): unknown route %q", cfg.forwardRoute)
+	}
+	return routes, nil
+}
+
+// patterns returns the mux patterns the route is served on.
+func (rt *route) patterns() []string {
+	patterns := []string{rt.path}
+	if extra := extraPatterns[rt.parser]; extra != nil {
+		for _, pattern := range extra(rt.path) {
+			if pattern != rt.path {
+				patterns = append(patterns, pattern)
+			}
+		}
+	}
+	return patterns
+}
+
+// checkPatterns registers the built-in paths and every route's patterns on
+// a scratch mux, so paths http.ServeMux panics on (malformed wildcards,
+// patterns conflicting with another route's) fail validation instead of
+// startup or a reload.
+func checkPatterns(cfg config, routes []*route) error {
+	mux := http.NewServeMux()
+	for _, pattern := range []string{"/health", cfg.metricsPath, fastlyChallengePath} {
+		if err := registerPattern(mux, pattern); err != nil {
+			return err
+		}
+	}
+	for _, rt := range routes {
+		for _, pattern := range rt.patterns() {
+			if err := registerPattern(mux, pattern); err != nil {
+				return fmt.Errorf("route %q: %w", rt.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func registerPattern(mux *http.ServeMux, pattern string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("path %q: %v", pattern, r)
+		}
+	}()
+	mux.HandleFunc(pattern, func(http.ResponseWriter, *http.Request) {})
+	return nil
+}
+
+// listenerRoute returns the route named name, or the HTTP_PATH route if
+// name is empty. planRoutes checked that it exists.
+func listenerRoute(routes []*route, name string) *route {
+	for _, rt := range routes {
+		if rt.name == name {
+			return rt
+		}
+	}
+	return routes[0]
+}
+
+// planTokens resolves the settings and sink key of each scoped token
+// allowed on rt.
+func planTokens(cfg config, rt *route) map[string]*routeToken {
+	planned := map[string]*routeToken{}
+	for _, t := range cfg.tokenStore.Tokens() {
+		if !t.Allows(rt.name) {
+			continue
+		}
+		key := rt.key
+		if t.SentryDSN != "" && t.SentryDSN != key.dsn {
+			key.dsn = t.SentryDSN
+			key.spoolDir = key.spoolPath(cfg.spoolDir)
+		}
+		planned[t.Name] = &routeToken{token: t, key: key}
+	}
+	return planned
+}
+
+// buildRoutes plans the routes and binds each to a sink. Sinks in existing
+// are reused when their key matches; the others are created. The returned
+// map holds every sink the routes use.
+func buildRoutes(cfg config, existing map[sinkKey]*sink) ([]*route, map[sinkKey]*sink, error) {
+	routes, err := planRoutes(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sinks := map[sinkKey]*sink{}
+	var created []*sink
+	bind := func(key sinkKey) (*sink, error) {
+		s, ok := sinks[key]
+		if !ok {
+			s, ok = existing[key]
+		}
+		if !ok {
+			s, err = newSink(cfg, key)
+			if err != nil {
+				return nil, err
+			}
+			created = append(created, s)
+		}
+		sinks[key] = s
+		return s, nil
+	}
+	for _, rt := range routes {
+		s, err := bind(rt.key)
+		if err != nil {
+			closeSinks(created, 0)
+			return nil, nil, fmt.Errorf("route %q: %w", rt.name, err)
+		}
+		rt.sink = s
+		for name, t := range rt.tokens {
+			s, err := bind(t.key)
+			if err != nil {
+				closeSinks(created, 0)
+				return nil, nil, fmt.Errorf("route %q: token %q: %w", rt.name, name, err)
+			}
+			t.sink = s
+		}
+	}
+	return routes, sinks, nil
+}
+
+// newSink creates a sink and, when spooling, starts its background sender.
+func newSink(cfg config, key sinkKey) (*sink, error) {
+	s := &sink{key: key, done: make(chan struct{})}
+	var sender *transport.Sender
+	if key.spoolDir != "" && key.dsn != "" {
+		var err error
+		sender, err = transport.NewSender(key.dsn, 0)
+		if err != nil {
+			return nil, fmt.Errorf("sentry sender: %w", err)
+		}
+		sp, err := spool.Open(key.spoolDir, cfg.spoolSegmentBytes)
+		if err != nil {
+			return nil, fmt.Errorf("spool open: %w", err)
+		}
+		s.spool = sp
+		s.transport = &transport.Spooled{Spool: sp}
+	} else {
+		s.transport = transport.NewHTTP(cfg.sentryQueueSize)
+	}
+
+	if key.dsn == "" {
+		log.Printf("sentry DSN is empty for environment %q; events will be dropped", key.env)
+	}
+	client, err := sentry.NewClient(sentry.ClientOptions{
+		Dsn:         key.dsn,
+		Environment: key.env,
+		Release:     key.release,
+		Transport:   s.transport,
+	})
+	if err != nil {
+		if s.spool != nil {
+			_ = s.spool.Close()
+		}
+		return nil, fmt.Errorf("sentry init: %w", err)
+	}
+	s.hub = sentry.NewHub(client, sentry.NewScope())
+
+	ctx, stop := context.WithCancel(context.Background())
+	s.stop = stop
+	go func() {
+		defer close(s.done)
+		if s.spool != nil {
+			s.spool.Run(ctx, sender.Send)
+		}
+	}()
+	return s, nil
+}
+
+// close flushes the sink, stops its sender and closes the spool. Events
+// still in the spool are delivered by the next sink with the same settings,
+// usually after the next start.
+func (s *sink) close(flushTimeout time.Duration) {
+	if flushTimeout > 0 {
+		s.hub.Flush(flushTimeout)
+	}
+	s.stop()
+	<-s.done
+	if s.spool != nil {
+		_ = s.spool.Close()
+	}
+	s.transport.Close()
+}
+
+// retire closes a sink a reload no longer uses. The spool directory is
+// named after the sink's settings, so nothing would open it again: its
+// events are delivered first, for up to flushTimeout.
+func (s *sink) retire(flushTimeout time.Duration) {
+	if s.spool != nil && flushTimeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+		err := s.spool.Drain(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("spool %s: events not delivered within %s stay there until a sink with the same DSN, environment and release opens it", s.key.spoolDir, flushTimeout)
+		}
+	}
+	s.close(flushTimeout)
+}
+
+// capture sends an event through the sink. It fails if the transport did
+// not accept the event.
+func (s *sink) capture(event *sentry.Event) (*sentry.EventID, error) {
+	hub := s.hub
+	// The scope replaces the trace context of error events with its own
+	// propagation context, so an event's trace is carried on a cloned scope.
+	if pc, ok := propagationContext(event.Contexts["trace"]); ok {
+		hub = hub.Clone()
+		hub.Scope().SetPropagationContext(pc)
+	}
+	eventID := hub.CaptureEvent(event)
+	if eventID == nil {
+		return nil, nil
+	}
+	if err := s.transport.Rejected(*eventID); err != nil {
+		return nil, err
+	}
+	return eventID, nil
+}
+
+func closeSinks(sinks []*sink, flushTimeout time.Duration) {
+	for _, s := range sinks {
+		s.close(flushTimeout)
+	}
+}
+
+func (rt *route) handler() http.HandlerFunc {
+	opts := rt.cfg.handlerOptions(rt.capture)
+	if ownAuthParsers[rt.parser] {
+		opts.Authorize = rt.admit
+	}
+	next := parsers[rt.parser](rt.cfg, opts)
+	return func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		body := &countingBody{ReadCloser: r.Body}
+		r.Body = body
+		defer func() {
+			size := r.ContentLength
+			if size < 0 {
+				size = body.n
+			}
+			metrics.Requests.WithLabelValues(rt.name, strconv.Itoa(sw.status)).Inc()
+			metrics.RequestBytes.WithLabelValues(rt.name).Observe(float64(size))
+		}()
+
+		if !requireClientIP(sw, r, rt.cfg) {
+			return
+		}
+		if rt.cfg.clientCert != nil && !requireClientCert(sw, mtls.Identify(r.TLS), rt.cfg.clientCert) {
+			return
+		}
+		if rt.cfg.signature != nil && !requireSignature(sw, r, rt.cfg) {
+			return
+		}
+		if !ownAuthParsers[rt.parser] {
+			var err error
+			if r, err = rt.admit(r); err != nil {
+				ingest.WriteError(sw, err)
+				return
+			}
+		}
+		next(sw, r)
+	}
+}
+
+// capture sends an event through the route's sink, or the sink of the
+// scoped token the request used. Events carry the identity of the caller
+// in ctx; events sent with a scoped token also carry its defaults and count
+// against its quota. Once the quota is used up, for instance in the middle
+// of a batch, capture fails with 429 until the next minute.
+func (rt *route) capture(ctx context.Context, event *sentry.Event) (*sentry.EventID, error) {
+	s := rt.sink
+	if c := callerFrom(ctx); c != nil {
+		if rtok := c.token; rtok != nil {
+			now := time.Now()
+			if !rtok.token.Take(now) {
+				metrics.TokenEvents.WithLabelValues(rtok.token.Name, metrics.QuotaExceeded).Inc()
+				_, reset := rtok.token.Exhausted(now)
+				return nil, &ingest.Error{
+					Status:     http.StatusTooManyRequests,
+					RetryAfter: reset,
+					Err:        fmt.Errorf("token %s: event quota exceeded", rtok.token.Name),
+				}
+			}
+			rtok.token.Apply(event)
+			metrics.TokenEvents.WithLabelValues(rtok.token.Name, metrics.Captured).Inc()
+			s = rtok.sink
+		}
+		c.apply(event)
+	}
+	return s.capture(event)
+}
+
+// admit checks the request's credentials, the scoped token's quota and the
+// capacity of the transport its events go to. It returns the request with
+// the caller attached.
+func (rt *route) admit(r *http.Request) (*http.Request, error) {
+	tr := rt.sink.transport
+	var t *routeToken
+	var claims jwt.Claims
+	if rt.cfg.signature == nil && rt.cfg.clientCert == nil {
+		var err error
+		t, claims, err = rt.authenticate(r)
+		if err != nil {
+			return r, err
+		}
+		if t != nil {
+			setRequestToken(r, t.token.Name)
+			if err := checkQuota(t.token); err != nil {
+				return r, err
+			}
+			tr = t.sink.transport
+		}
+	}
+	if err := checkCapacity(tr); err != nil {
+		return r, err
+	}
+	id := mtls.Identify(r.TLS)
+	if t != nil || id != nil || claims != nil {
+		r = withCaller(r, newCaller(t, id, claims, rt.cfg.jwtClaims))
+	}
+	return r, nil
+}
+
+// authenticate checks the request's credentials. A credential shaped like
+// a JWT is validated against the JWKS and must grant the route's scope.
+// Scoped tokens are tried next; a scoped token that is not allowed on the
+// route is forbidden. Otherwise the route's or the global tokens apply. It
+// returns the scoped token or the JWT claims, if either was used.
+func (rt *route) authenticate(r *http.Request) (*routeToken, jwt.Claims, error) {
+	store := rt.cfg.tokenStore
+	for _, scheme := range rt.cfg.schemes() {
+		credential, ok := authCredential(r, scheme)
+		if !ok {
+			continue
+		}
+		if rt.cfg.jwt != nil && jwt.Looks(credential) {
+			claims, err := rt.cfg.jwt.Validate(credential)
+			if err != nil {
+				return nil, nil, &ingest.Error{Status: http.StatusUnauthorized, Err: err}
+			}
+			if rt.cfg.jwtScope != "" && !claims.HasScope(rt.cfg.jwtScope) {
+				return nil, nil, &ingest.Error{Status: http.StatusForbidden, Err: errors.New("token lacks scope " + rt.cfg.jwtScope)}
+			}
+			return nil, claims, nil
+		}
+		if t := store.Lookup(credential); t != nil {
+			if rtok := rt.tokens[t.Name]; rtok != nil {
+				return rtok, nil, nil
+			}
+			return nil, nil, &ingest.Error{Status: http.StatusForbidden, Err: fmt.Errorf("token %s is not allowed on this route", t.Name)}
+		}
+	}
+	// Routes with a scope only take JWTs. With a token store or a JWKS,
+	// requests without a credential are not accepted.
+	if rt.cfg.jwtScope != "" || (len(store.Tokens()) > 0 || rt.cfg.jwt != nil) && len(rt.cfg.tokens()) == 0 {
+		return nil, nil, errUnauthorized
+	}
+	return nil, nil, checkBearer(r, rt.cfg)
+}
+
+// countingBody counts the request body bytes read.
+type countingBody struct {
+	io.ReadCloser
+	n        int64
+	replayed bool
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.replayed {
+		b.n += int64(n)
+	}
+	return n, err
+}
+
+// replay serves data, a body already read and counted, to the next reader
+// without counting it again.
+func (b *countingBody) replay(data []byte) {
+	b.ReadCloser = io.NopCloser(bytes.NewReader(data))
+	b.replayed = true
+}
+
+// routeName derives a route name from its path, e.g. "/logs/api" -> "logs-api".
+func routeName(path string) string {
+	name := strings.Map(func(r rune) rune {
+		if isRouteNameRune(r) {
+			return r
+		}
+		return '-'
+	}, strings.Trim(path, "/"))
+	if name == "" || name == "." || name == ".." {
+		return "root"
+	}
+	return name
+}
+
+// validRouteName reports whether name is safe to use as a directory name.
+func validRouteName(name string) bool {
+	if name == "" || name == "." || name == ".." {
+		return false
+	}
+	for _, r := range name {
+		if !isRouteNameRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func isRouteNameRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.'
+}
@@ -0,0 +1,738 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"http-to-sentry-go/jwt"
+	"http-to-sentry-go/metrics"
+	"http-to-sentry-go/mtls"
+	"http-to-sentry-go/signature"
+	"http-to-sentry-go/tokens"
+)
+
+func TestBuildRoutesCreatesSinkPerDSN(t *testing.T) {
+	cfg := config{
+		sentryDSN:    "http://public@127.0.0.1:1/1",
+		httpPath:     "/ingest",
+		metricsPath:  "/metrics",
+		maxBodyBytes: 1024,
+		routes: []routeConfig{
+			{Path: "/billing", SentryDSN: "http://public@127.0.0.1:1/2", AuthTokens: []string{"billing-token"}},
+			{Path: "/search", MaxBodyBytes: 4096},
+		},
+	}
+
+	routes, sinks, err := buildRoutes(cfg, nil)
+	if err != nil {
+		t.Fatalf("build routes: %v", err)
+	}
+	defer func() {
+		for _, s := range sinks {
+			s.close(0)
+		}
+	}()
+
+	if len(routes) != 3 {
+		t.Fatalf("expected 3 routes, got %d", len(routes))
+	}
+	if len(sinks) != 2 {
+		t.Fatalf("expected 2 sinks, got %d", len(sinks))
+	}
+	if routes[2].sink != routes[0].sink {
+		t.Fatalf("expected /search to share the default sink")
+	}
+	if routes[2].cfg.maxBodyBytes != 4096 {
+		t.Fatalf("expected route body limit override, got %d", routes[2].cfg.maxBodyBytes)
+	}
+
+	unauthorized := testutil.ToFloat64(metrics.Requests.WithLabelValues("billing", "401"))
+	accepted := testutil.ToFloat64(metrics.Requests.WithLabelValues("billing", "202"))
+
+	req := httptest.NewRequest(http.MethodPost, "/billing", strings.NewReader("hello"))
+	rw := httptest.NewRecorder()
+	routes[1].handler()(rw, req)
+	if rw.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without route token, got %d", rw.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/billing", strings.NewReader("hello"))
+	req.Header.Set("Authorization", "Bearer billing-token")
+	rw = httptest.NewRecorder()
+	routes[1].handler()(rw, req)
+	if rw.Code != http.StatusAccepted {
+		t.Fatalf("expected 202 with route token, got %d", rw.Code)
+	}
+	if n := testutil.ToFloat64(metrics.Requests.WithLabelValues("billing", "401")) - unauthorized; n != 1 {
+		t.Fatalf("expected one 401 counted for billing, got %v", n)
+	}
+	if n := testutil.ToFloat64(metrics.Requests.WithLabelValues("billing", "202")) - accepted; n != 1 {
+		t.Fatalf("expected one 202 counted for billing, got %v", n)
+	}
+}
+
+func TestBuildRoutesRejectsInvalidConfig(t *testing.T) {
+	cases := map[string][]routeConfig{
+		"duplicate path": {{Path: "/ingest"}},
+		"unknown parser": {{Path: "/x", Parser: "carrier-pigeon"}},
+		"bad name":       {{Path: "/x", Name: "../escape"}},
+		"reserved path":  {{Path: "/metrics"}},
+		"json lines":     {{Path: "/x", JSONLines: true}},
+		"elastic path":   {{Path: "/x", Parser: "elastic"}},
+		"elastic root":   {{Path: "/_bulk", Parser: "elastic"}},
+		"elastic probe":  {{Path: "/es/{$}"}, {Path: "/es/_bulk", Parser: "elastic"}},
+		"drain filter":   {{Path: "/x", MinLevel: "error"}},
+		"min level":      {{Path: "/x", Parser: "heroku", MinLevel: "loud"}},
+		"match":          {{Path: "/x", Parser: "netlify", Match: "("}},
+		"hmac":           {{Path: "/x", HMAC: &signature.Rules{Header: "X-Signature"}}},
+		"client cert":    {{Path: "/x", ClientCert: &mtls.Allowlist{Subjects: []string{"billing"}}}},
+		"scope":          {{Path: "/x", Scope: "logs:write"}},
+		"allowed cidrs":  {{Path: "/x", AllowedCIDRs: []string{"10.0.0.0/33"}}},
+		"bad pattern":    {{Path: "/logs/{id"}},
+		"conflict":       {{Path: "/hec", Parser: "hec"}, {Path: "/hec/", Name: "hecdup"}},
+	}
+	for name, routes := range cases {
+		_, err := planRoutes(config{httpPath: "/ingest", metricsPath: "/metrics", routes: routes})
+		if err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+
+	store, err := tokens.New([]tokens.Token{{Name: "ci", Token: "t", Routes: []string{"missing"}}})
+	if err != nil {
+		t.Fatalf("tokens: %v", err)
+	}
+	if _, err := planRoutes(config{httpPath: "/ingest", metricsPath: "/metrics", tokenStore: store}); err == nil {
+		t.Fatalf("token route: expected error")
+	}
+	if _, err := planRoutes(config{httpPath: "/ingest", metricsPath: "/metrics", syslogRoute: "missing"}); err == nil {
+		t.Fatalf("syslog route: expected error")
+	}
+	if _, err := planRoutes(config{httpPath: "/ingest", metricsPath: "/metrics", forwardRoute: "missing"}); err == nil {
+		t.Fatalf("forward route: expected error")
+	}
+}
+
+func TestListenerRoute(t *testing.T) {
+	routes, err := planRoutes(config{
+		httpPath:    "/ingest",
+		metricsPath: "/metrics",
+		routes:      []routeConfig{{Path: "/infra", SentryDSN: "https://key@o0.ingest.sentry.io/2"}},
+	})
+	if err != nil {
+		t.Fatalf("plan routes: %v", err)
+	}
+	if rt := listenerRoute(routes, ""); rt.path != "/ingest" {
+		t.Fatalf("expected the HTTP_PATH route by default, got %s", rt.path)
+	}
+	if rt := listenerRoute(routes, "infra"); rt.path != "/infra" || rt.key.dsn != "https://key@o0.ingest.sentry.io/2" {
+		t.Fatalf("expected the infra route, got %s", rt.path)
+	}
+}
+
+func TestHECRouteServesSubtreeWithSplunkAuth(t *testing.T) {
+	g, err := newGeneration(config{
+		sentryDSN:       "http://public@127.0.0.1:1/1",
+		sentryQueueSize: 10,
+		httpPath:        "/ingest",
+		hecPath:         "/services/collector",
+		metricsPath:     "/metrics",
+		authToken:       "secret",
+		maxBodyBytes:    1024,
+	}, nil)
+	if err != nil {
+		t.Fatalf("new generation: %v", err)
+	}
+	defer func() {
+		for _, s := range g.sinks {
+			s.close(0)
+		}
+	}()
+
+	for auth, want := range map[string]int{
+		"Bearer secret": http.StatusUnauthorized,
+		"Splunk secret": http.StatusOK,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/services/collector/raw", strings.NewReader("hello"))
+		req.Header.Set("Authorization", auth)
+		rw := httptest.NewRecorder()
+		g.mux.ServeHTTP(rw, req)
+		if rw.Code != want {
+			t.Fatalf("%s: expected %d, got %d", auth, want, rw.Code)
+		}
+	}
+}
+
+func TestElasticRouteServesIndexPathAndVersionProbe(t *testing.T) {
+	g, err := newGeneration(config{
+		sentryDSN:       "http://public@127.0.0.1:1/1",
+		sentryQueueSize: 10,
+		httpPath:        "/ingest",
+		elasticPath:     "/es/_bulk",
+		metricsPath:     "/metrics",
+		maxBodyBytes:    1024,
+	}, nil)
+	if err != nil {
+		t.Fatalf("new generation: %v", err)
+	}
+	defer func() {
+		for _, s := range g.sinks {
+			s.close(0)
+		}
+	}()
+
+	rw := httptest.NewRecorder()
+	g.mux.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/es/", nil))
+	if rw.Code != http.StatusOK || !strings.Contains(rw.Body.String(), `"number"`) {
+		t.Fatalf("unexpected version probe %d %s", rw.Code, rw.Body.String())
+	}
+
+	body := "{\"create\":{}}\n{\"message\":\"hello\"}\n"
+	rw = httptest.NewRecorder()
+	g.mux.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/es/logs-app/_bulk", strings.NewReader(body)))
+	if rw.Code != http.StatusOK || !strings.Contains(rw.Body.String(), `"_index":"logs-app"`) {
+		t.Fatalf("unexpected bulk response %d %s", rw.Code, rw.Body.String())
+	}
+}
+
+func TestFirehoseRouteChecksAccessKeyHeader(t *testing.T) {
+	g, err := newGeneration(config{
+		sentryDSN:       "http://public@127.0.0.1:1/1",
+		sentryQueueSize: 10,
+		httpPath:        "/ingest",
+		firehosePath:    "/firehose",
+		metricsPath:     "/metrics",
+		authToken:       "secret",
+		maxBodyBytes:    1024,
+	}, nil)
+	if err != nil {
+		t.Fatalf("new generation: %v", err)
+	}
+	defer func() {
+		for _, s := range g.sinks {
+			s.close(0)
+		}
+	}()
+
+	for key, want := range map[string]int{
+		"wrong":  http.StatusUnauthorized,
+		"secret": http.StatusOK,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/firehose", strings.NewReader(`{"requestId":"r1","records":[]}`))
+		req.Header.Set("X-Amz-Firehose-Request-Id", "r1")
+		req.Header.Set("X-Amz-Firehose-Access-Key", key)
+		rw := httptest.NewRecorder()
+		g.mux.ServeHTTP(rw, req)
+		if rw.Code != want || !strings.Contains(rw.Body.String(), `"requestId":"r1"`) {
+			t.Fatalf("%s: expected %d with the request ID, got %d %s", key, want, rw.Code, rw.Body.String())
+		}
+	}
+}
+
+func TestFirehoseRouteTakesScopedTokens(t *testing.T) {
+	store, err := tokens.New([]tokens.Token{
+		{Name: "aws", Token: "tok-aws", EventsPerMinute: 1},
+	})
+	if err != nil {
+		t.Fatalf("tokens: %v", err)
+	}
+	g, err := newGeneration(config{
+		sentryDSN:       "http://public@127.0.0.1:1/1",
+		sentryQueueSize: 10,
+		httpPath:        "/ingest",
+		firehosePath:    "/firehose",
+		metricsPath:     "/metrics",
+		maxBodyBytes:    1024,
+		tokenStore:      store,
+	}, nil)
+	if err != nil {
+		t.Fatalf("new generation: %v", err)
+	}
+	defer func() {
+		for _, s := range g.sinks {
+			s.close(0)
+		}
+	}()
+
+	for i, tc := range []struct {
+		key  string
+		want int
+	}{
+		{"tok-aws", http.StatusOK},
+		{"tok-aws", http.StatusTooManyRequests},
+		{"tok-unknown", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/firehose", strings.NewReader(`{"requestId":"r1","records":[{"data":"Ym9vbQ=="}]}`))
+		req.Header.Set("X-Amz-Firehose-Request-Id", "r1")
+		req.Header.Set("X-Amz-Firehose-Access-Key", tc.key)
+		rw := httptest.NewRecorder()
+		g.mux.ServeHTTP(rw, req)
+		if rw.Code != tc.want || !strings.Contains(rw.Body.String(), `"requestId":"r1"`) {
+			t.Fatalf("%d: expected %d with the request ID, got %d %s", i, tc.want, rw.Code, rw.Body.String())
+		}
+		if rw.Code == http.StatusTooManyRequests && rw.Header().Get("Retry-After") == "" {
+			t.Fatalf("%d: 429 without Retry-After", i)
+		}
+	}
+}
+
+func TestHerokuRouteAcceptsTokenInDrainURL(t *testing.T) {
+	g, err := newGeneration(config{
+		sentryDSN:       "http://public@127.0.0.1:1/1",
+		sentryQueueSize: 10,
+		httpPath:        "/ingest",
+		herokuPath:      "/heroku",
+		metricsPath:     "/metrics",
+		authToken:       "secret",
+		maxBodyBytes:    1024,
+	}, nil)
+	if err != nil {
+		t.Fatalf("new generation: %v", err)
+	}
+	defer func() {
+		for _, s := range g.sinks {
+			s.close(0)
+		}
+	}()
+
+	msg := "<190>1 2026-01-29T11:41:12+00:00 host app web.1 - hello"
+	for password, want := range map[string]int{
+		"wrong":  http.StatusUnauthorized,
+		"secret": http.StatusNoContent,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/heroku", strings.NewReader(strconv.Itoa(len(msg))+" "+msg))
+		req.SetBasicAuth("drain", password)
+		req.Header.Set("Logplex-Msg-Count", "1")
+		rw := httptest.NewRecorder()
+		g.mux.ServeHTTP(rw, req)
+		if rw.Code != want {
+			t.Fatalf("%s: expected %d, got %d %s", password, want, rw.Code, rw.Body.String())
+		}
+	}
+}
+
+func TestReportsRouteTakesTokenFromQuery(t *testing.T) {
+	g, err := newGeneration(config{
+		sentryDSN:       "http://public@127.0.0.1:1/1",
+		sentryQueueSize: 10,
+		httpPath:        "/ingest",
+		reportsPath:     "/reports",
+		metricsPath:     "/metrics",
+		authToken:       "secret",
+		maxBodyBytes:    1024,
+	}, nil)
+	if err != nil {
+		t.Fatalf("new generation: %v", err)
+	}
+	defer func() {
+		for _, s := range g.sinks {
+			s.close(0)
+		}
+	}()
+
+	report := `{"csp-report":{"document-uri":"https://shop.example.com/","effective-directive":"img-src","blocked-uri":"https://evil.example/x.png"}}`
+	for target, want := range map[string]int{
+		"/reports":              http.StatusUnauthorized,
+		"/reports?token=wrong":  http.StatusUnauthorized,
+		"/reports?token=secret": http.StatusNoContent,
+	} {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(report))
+		req.Header.Set("Content-Type", "application/csp-report")
+		rw := httptest.NewRecorder()
+		g.mux.ServeHTTP(rw, req)
+		if rw.Code != want {
+			t.Fatalf("%s: expected %d, got %d", target, want, rw.Code)
+		}
+	}
+}
+
+func TestHMACRouteReplacesBearerToken(t *testing.T) {
+	g, err := newGeneration(config{
+		sentryDSN:       "http://public@127.0.0.1:1/1",
+		sentryQueueSize: 10,
+		httpPath:        "/ingest",
+		metricsPath:     "/metrics",
+		authToken:       "secret",
+		maxBodyBytes:    1024,
+		routes: []routeConfig{{
+			Path: "/hooks",
+			HMAC: &signature.Rules{Header: "X-Hub-Signature-256", Prefix: "sha256=", Secrets: []string{"old", "new"}},
+		}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("new generation: %v", err)
+	}
+	defer func() {
+		for _, s := range g.sinks {
+			s.close(0)
+		}
+	}()
+
+	body := `{"message":"deploy failed","level":"error"}`
+	mac := hmac.New(sha256.New, []byte("old"))
+	mac.Write([]byte(body))
+	received := requestBytes(t, "hooks")
+	for name, tc := range map[string]struct {
+		signature string
+		bearer    bool
+		want      int
+	}{
+		"signed":       {"sha256=" + hex.EncodeToString(mac.Sum(nil)), false, http.StatusAccepted},
+		"bad":          {"sha256=00", false, http.StatusUnauthorized},
+		"bearer alone": {"", true, http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/hooks", strings.NewReader(body))
+		req.ContentLength = -1
+		if tc.signature != "" {
+			req.Header.Set("X-Hub-Signature-256", tc.signature)
+		}
+		if tc.bearer {
+			req.Header.Set("Authorization", "Bearer secret")
+		}
+		rw := httptest.NewRecorder()
+		g.mux.ServeHTTP(rw, req)
+		if rw.Code != tc.want {
+			t.Fatalf("%s: expected %d, got %d %s", name, tc.want, rw.Code, rw.Body.String())
+		}
+	}
+	// The bodies have no Content-Length, so they are counted as read: once
+	// each, although the signed one is read again by the parser.
+	if n := requestBytes(t, "hooks") - received; n != float64(3*len(body)) {
+		t.Fatalf("expected %d request bytes, got %v", 3*len(body), n)
+	}
+}
+
+// requestBytes returns the sum of the request sizes observed for route.
+func requestBytes(t *testing.T, route string) float64 {
+	t.Helper()
+	families, err := metrics.Default.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != "http_to_sentry_request_body_bytes" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "route" && label.GetValue() == route {
+					return m.GetHistogram().GetSampleSum()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func TestScopedTokensSelectRouteSinkAndQuota(t *testing.T) {
+	store, err := tokens.New([]tokens.Token{
+		{Name: "billing-team", Token: "tok-billing", Routes: []string{"billing"}, SentryDSN: "http://public@127.0.0.1:1/3", EventsPerMinute: 1},
+		{Name: "search-team", Token: "tok-search", Routes: []string{"search"}},
+	})
+	if err != nil {
+		t.Fatalf("tokens: %v", err)
+	}
+	g, err := newGeneration(config{
+		sentryDSN:       "http://public@127.0.0.1:1/1",
+		sentryQueueSize: 10,
+		httpPath:        "/ingest",
+		metricsPath:     "/metrics",
+		maxBodyBytes:    1024,
+		tokenStore:      store,
+		routes:          []routeConfig{{Path: "/billing"}, {Path: "/search"}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("new generation: %v", err)
+	}
+	defer func() {
+		for _, s := range g.sinks {
+			s.close(0)
+		}
+	}()
+
+	if len(g.sinks) != 2 {
+		t.Fatalf("expected a sink for the token's DSN, got %d sinks", len(g.sinks))
+	}
+	billing := g.routes[1].tokens["billing-team"]
+	if billing == nil || billing.sink == g.routes[1].sink || billing.token.Name != "billing-team" {
+		t.Fatalf("billing token not bound to its own sink: %+v", billing)
+	}
+
+	quota := testutil.ToFloat64(metrics.TokenEvents.WithLabelValues("billing-team", metrics.QuotaExceeded))
+	for i, tc := range []struct {
+		path, token string
+		want        int
+	}{
+		{"/billing", "tok-billing", http.StatusAccepted},
+		{"/billing", "tok-billing", http.StatusTooManyRequests},
+		{"/billing", "tok-search", http.StatusForbidden},
+		{"/search", "tok-search", http.StatusAccepted},
+		{"/search", "tok-unknown", http.StatusUnauthorized},
+		{"/search", "", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(`{"message":"boom"}`))
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		rw := httptest.NewRecorder()
+		g.mux.ServeHTTP(rw, req)
+		if rw.Code != tc.want {
+			t.Fatalf("%d: %s with %q: expected %d, got %d", i, tc.path, tc.token, tc.want, rw.Code)
+		}
+		if rw.Code == http.StatusTooManyRequests && rw.Header().Get("Retry-After") == "" {
+			t.Fatalf("%d: 429 without Retry-After", i)
+		}
+	}
+	if n := testutil.ToFloat64(metrics.TokenEvents.WithLabelValues("billing-team", metrics.QuotaExceeded)) - quota; n != 1 {
+		t.Fatalf("expected one quota_exceeded counted, got %v", n)
+	}
+}
+
+func TestScopedTokenQuotaRejectsRestOfBatch(t *testing.T) {
+	store, err := tokens.New([]tokens.Token{
+		{Name: "batch-team", Token: "tok-batch", EventsPerMinute: 2},
+	})
+	if err != nil {
+		t.Fatalf("tokens: %v", err)
+	}
+	g, err := newGeneration(config{
+		sentryDSN:       "http://public@127.0.0.1:1/1",
+		sentryQueueSize: 10,
+		httpPath:        "/ingest",
+		metricsPath:     "/metrics",
+		maxBodyBytes:    1024,
+		tokenStore:      store,
+	}, nil)
+	if err != nil {
+		t.Fatalf("new generation: %v", err)
+	}
+	defer func() {
+		for _, s := range g.sinks {
+			s.close(0)
+		}
+	}()
+
+	req := httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader("{\"message\":\"one\"}\n{\"message\":\"two\"}\n{\"message\":\"three\"}\n"))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("Authorization", "Bearer tok-batch")
+	rw := httptest.NewRecorder()
+	g.mux.ServeHTTP(rw, req)
+	if rw.Code != http.StatusTooManyRequests || rw.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 429 with Retry-After, got %d %q", rw.Code, rw.Header().Get("Retry-After"))
+	}
+
+	var resp struct {
+		Results []ingestResult `json:"results"`
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(resp.Results) != 3 || resp.Results[1].EventID == "" || !strings.Contains(resp.Results[2].Error, "quota exceeded") {
+		t.Fatalf("expected the third entry to be rejected, got %+v", resp.Results)
+	}
+}
+
+func TestClientCertRouteChecksAllowlist(t *testing.T) {
+	g, err := newGeneration(config{
+		sentryDSN:         "http://public@127.0.0.1:1/1",
+		sentryQueueSize:   10,
+		httpPath:          "/ingest",
+		metricsPath:       "/metrics",
+		authToken:         "secret",
+		maxBodyBytes:      1024,
+		httpsClientCAFile: "ca.crt",
+		httpsClientAuth:   tls.VerifyClientCertIfGiven,
+		routes: []routeConfig{{
+			Path:       "/internal",
+			ClientCert: &mtls.Allowlist{SPIFFEIDs: []string{"spiffe://example.org/ns/prod/*"}},
+		}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("new generation: %v", err)
+	}
+	defer func() {
+		for _, s := range g.sinks {
+			s.close(0)
+		}
+	}()
+
+	client := func(spiffeID string) *tls.ConnectionState {
+		u, err := url.Parse(spiffeID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: "worker"}, URIs: []*url.URL{u}}
+		return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	}
+	for name, tc := range map[string]struct {
+		path   string
+		tls    *tls.ConnectionState
+		bearer bool
+		want   int
+	}{
+		"allowed":         {"/internal", client("spiffe://example.org/ns/prod/billing"), false, http.StatusAccepted},
+		"other namespace": {"/internal", client("spiffe://example.org/ns/dev/billing"), false, http.StatusForbidden},
+		"bearer only":     {"/internal", nil, true, http.StatusUnauthorized},
+		"optional":        {"/ingest", client("spiffe://example.org/ns/dev/billing"), true, http.StatusAccepted},
+	} {
+		req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(`{"message":"boom"}`))
+		req.TLS = tc.tls
+		if tc.bearer {
+			req.Header.Set("Authorization", "Bearer secret")
+		}
+		rw := httptest.NewRecorder()
+		g.mux.ServeHTTP(rw, req)
+		if rw.Code != tc.want {
+			t.Fatalf("%s: expected %d, got %d", name, tc.want, rw.Code)
+		}
+	}
+}
+
+func TestJWTRouteChecksScopeAndCopiesClaims(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64 := base64.RawURLEncoding
+	jwks := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(jwks, []byte(`{"keys":[{"kty":"OKP","crv":"Ed25519","kid":"k1","x":"`+b64.EncodeToString(public)+`"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	v, err := jwt.New(jwks, "https://gateway.internal", nil, 0)
+	if err != nil {
+		t.Fatalf("jwks: %v", err)
+	}
+	token := func(claims string) string {
+		signed := b64.EncodeToString([]byte(`{"alg":"EdDSA","kid":"k1"}`)) + "." + b64.EncodeToString([]byte(claims))
+		return signed + "." + b64.EncodeToString(ed25519.Sign(private, []byte(signed)))
+	}
+	exp := strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)
+	writer := token(`{"iss":"https://gateway.internal","exp":` + exp + `,"sub":"svc-billing","tenant":"acme","scope":"logs:write"}`)
+	reader := token(`{"iss":"https://gateway.internal","exp":` + exp + `,"scope":"logs:read"}`)
+	expired := token(`{"iss":"https://gateway.internal","exp":1700000000,"scope":"logs:write"}`)
+
+	g, err := newGeneration(config{
+		sentryDSN:       "http://public@127.0.0.1:1/1",
+		sentryQueueSize: 10,
+		httpPath:        "/ingest",
+		metricsPath:     "/metrics",
+		authToken:       "secret",
+		maxBodyBytes:    1024,
+		jwt:             v,
+		routes:          []routeConfig{{Path: "/gateway", Scope: "logs:write"}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("new generation: %v", err)
+	}
+	defer func() {
+		for _, s := range g.sinks {
+			s.close(0)
+		}
+	}()
+
+	for name, tc := range map[string]struct {
+		path, credential string
+		want             int
+	}{
+		"scope":        {"/gateway", writer, http.StatusAccepted},
+		"other scope":  {"/gateway", reader, http.StatusForbidden},
+		"expired":      {"/gateway", expired, http.StatusUnauthorized},
+		"static token": {"/gateway", "secret", http.StatusUnauthorized},
+		"no scope":     {"/ingest", reader, http.StatusAccepted},
+		"static":       {"/ingest", "secret", http.StatusAccepted},
+		"none":         {"/ingest", "", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(`{"message":"boom"}`))
+		if tc.credential != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.credential)
+		}
+		rw := httptest.NewRecorder()
+		g.mux.ServeHTTP(rw, req)
+		if rw.Code != tc.want {
+			t.Fatalf("%s: expected %d, got %d %s", name, tc.want, rw.Code, rw.Body.String())
+		}
+	}
+
+	claims, err := v.Validate(writer)
+	if err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	event := sentry.NewEvent()
+	event.User.Email = "ops@example.com"
+	newCaller(nil, nil, claims, jwtClaims{tags: []string{"tenant", "service"}, userID: "sub", email: "email"}).apply(event)
+	if event.Tags["tenant"] != "acme" || event.User.ID != "svc-billing" || event.User.Email != "ops@example.com" {
+		t.Fatalf("event = %v %+v", event.Tags, event.User)
+	}
+	if _, ok := event.Tags["service"]; ok {
+		t.Fatalf("missing claim should not be tagged: %v", event.Tags)
+	}
+}
+
+func TestIPAllowlistsBehindTrustedProxy(t *testing.T) {
+	proxies, _ := parseCIDR("10.0.0.0/8")
+	edge, _ := parseCIDR("151.101.0.0/16")
+	srv, err := newServer("", config{
+		sentryDSN:       "http://public@127.0.0.1:1/1",
+		sentryQueueSize: 10,
+		httpPath:        "/ingest",
+		fastlyPath:      "/fastly",
+		fastlyServiceID: "svc",
+		metricsPath:     "/metrics",
+		maxBodyBytes:    1024,
+		trustedProxies:  []*net.IPNet{proxies},
+		fastlyRanges:    []*net.IPNet{edge},
+		routes:          []routeConfig{{Path: "/office", AllowedCIDRs: []string{"192.0.2.0/24"}}},
+	})
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	defer func() {
+		for _, s := range srv.current.sinks {
+			s.close(0)
+		}
+	}()
+
+	for name, tc := range map[string]struct {
+		path, remote, forwardedFor string
+		body                       string
+		want                       int
+	}{
+		"office via proxy":  {"/office", "10.0.0.2:4000", "192.0.2.10", `{"message":"boom"}`, http.StatusAccepted},
+		"outside via proxy": {"/office", "10.0.0.2:4000", "203.0.113.9", `{"message":"boom"}`, http.StatusForbidden},
+		"spoofed header":    {"/office", "203.0.113.9:4000", "192.0.2.10", `{"message":"boom"}`, http.StatusForbidden},
+		"open route":        {"/ingest", "203.0.113.9:4000", "", `{"message":"boom"}`, http.StatusAccepted},
+		"fastly edge":       {"/fastly", "10.0.0.2:4000", "151.101.2.3", `{"service_id":"svc"}`, http.StatusAccepted},
+		"not fastly":        {"/fastly", "203.0.113.9:4000", "", `{"service_id":"svc"}`, http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body))
+		req.RemoteAddr = tc.remote
+		if tc.forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+		}
+		rw := httptest.NewRecorder()
+		srv.ServeHTTP(rw, req)
+		if rw.Code != tc.want {
+			t.Fatalf("%s: expected %d, got %d %s", name, tc.want, rw.Code, rw.Body.String())
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Package signature verifies HMAC request signatures computed over the raw
+// body, as sent by webhook sources that sign requests instead of sending a
+// bearer token.
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"http-to-sentry-go/timestamp"
+)
+
+var (
+	// ErrMissing is returned when the request has no signature or no
+	// timestamp.
+	ErrMissing = errors.New("missing signature")
+	// ErrMismatch is returned when no secret produces the signature.
+	ErrMismatch = errors.New("signature mismatch")
+	// ErrExpired is returned when the signed timestamp is outside the
+	// replay window.
+	ErrExpired = errors.New("signature timestamp outside the replay window")
+)
+
+// Rules is the declarative signature configuration of a route.
+type Rules struct {
+	// Header carries the signature, e.g. "X-Hub-Signature-256".
+	Header string `json:"header"`
+	// Prefix is stripped from the header value, e.g. "sha256=".
+	Prefix string `json:"prefix"`
+	// Algorithm is sha1, sha256 (the default) or sha512.
+	Algorithm string `json:"algorithm"`
+	// Encoding of the signature: hex (the default), base64 or base64url.
+	Encoding string `json:"encoding"`
+	// Secrets are the active secrets. A signature made with any of them is
+	// accepted, so secrets can be rotated without downtime.
+	Secrets []string `json:"secrets"`
+	// TimestampHeader, if set, carries the time the request was signed, in
+	// epoch seconds or RFC 3339.
+	TimestampHeader string `json:"timestamp_header"`
+	// ReplayWindowMS is how far the signed timestamp may be from now.
+	// Defaults to 5 minutes.
+	ReplayWindowMS int64 `json:"replay_window_ms"`
+	// Payload is the signed content, with {timestamp} and {body}
+	// placeholders. Defaults to "{timestamp}.{body}" with a timestamp
+	// header and "{body}" without.
+	Payload string `json:"payload"`
+}
+
+// Verifier checks request signatures.
+type Verifier struct {
+	header          string
+	prefix          string
+	hash            func() hash.Hash
+	decode          func(string) ([]byte, error)
+	secrets         [][]byte
+	timestampHeader string
+	window          time.Duration
+	payloadPrefix   string
+	payloadSuffix   string
+	// now is replaceable in tests.
+	now func() time.Time
+}
+
+// Compile validates rules and returns a Verifier.
+func Compile(rules Rules) (*Verifier, error) {
+	v := &Verifier{
+		header:          strings.TrimSpace(rules.Header),
+		prefix:          rules.Prefix,
+		timestampHeader: strings.TrimSpace(rules.TimestampHeader),
+		window:          5 * time.Minute,
+		now:             time.Now,
+	}
+	if v.header == "" {
+		return nil, errors.New("header is required")
+	}
+
+	switch strings.ToLower(rules.Algorithm) {
+	case "sha1":
+		v.hash = sha1.New
+	case "", "sha256":
+		v.hash = sha256.New
+	case "sha512":
+		v.hash = sha512.New
+	default:
+		return nil, fmt.Errorf("algorithm must be sha1, sha256 or sha512, got %q", rules.Algorithm)
+	}
+
+	switch strings.ToLower(rules.Encoding) {
+	case "", "hex":
+		v.decode = hex.DecodeString
+	case "base64":
+		v.decode = base64.StdEncoding.DecodeString
+	case "base64url":
+		v.decode = func(s string) ([]byte, error) {
+			return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+		}
+	default:
+		return nil, fmt.Errorf("encoding must be hex, base64 or base64url, got %q", rules.Encoding)
+	}
+
+	for _, secret := range rules.Secrets {
+		if secret == "" {
+			return nil, errors.New("secrets must not be empty")
+		}
+		v.secrets = append(v.secrets, []byte(secret))
+	}
+	if len(v.secrets) == 0 {
+		return nil, errors.New("at least one secret is required")
+	}
+
+	switch {
+	case rules.ReplayWindowMS < 0:
+		return nil, fmt.Errorf("replay_window_ms must not be negative, got %d", rules.ReplayWindowMS)
+	case rules.ReplayWindowMS > 0:
+		v.window = time.Duration(rules.ReplayWindowMS) * time.Millisecond
+	}
+
+	payload := rules.Payload
+	if payload == "" {
+		payload = "{body}"
+		if v.timestampHeader != "" {
+			payload = "{timestamp}.{body}"
+		}
+	}
+	var ok bool
+	v.payloadPrefix, v.payloadSuffix, ok = strings.Cut(payload, "{body}")
+	if !ok || strings.Contains(v.payloadSuffix, "{body}") {
+		return nil, errors.New("payload must contain {body} once")
+	}
+	if strings.Contains(payload, "{timestamp}") && v.timestampHeader == "" {
+		return nil, errors.New("payload uses {timestamp} but timestamp_header is not set")
+	}
+	return v, nil
+}
+
+// Verify checks the signature in header against body, the raw request body.
+func (v *Verifier) Verify(header http.Header, body []byte) error {
+	value := strings.TrimSpace(header.Get(v.header))
+	value = strings.TrimPrefix(value, v.prefix)
+	if value == "" {
+		return ErrMissing
+	}
+	signature, err := v.decode(value)
+	if err != nil || len(signature) == 0 {
+		return ErrMismatch
+	}
+
+	var ts string
+	if v.timestampHeader != "" {
+		ts = strings.TrimSpace(header.Get(v.timestampHeader))
+		if ts == "" {
+			return ErrMissing
+		}
+		signed, err := timestamp.Parse(ts)
+		if err != nil {
+			return ErrExpired
+		}
+		if skew := v.now().Sub(signed); math.Abs(float64(skew)) > float64(v.window) {
+			return ErrExpired
+		}
+	}
+
+	prefix := strings.ReplaceAll(v.payloadPrefix, "{timestamp}", ts)
+	suffix := strings.ReplaceAll(v.payloadSuffix, "{timestamp}", ts)
+	for _, secret := range v.secrets {
+		mac := hmac.New(v.hash, secret)
+		mac.Write([]byte(prefix))
+		mac.Write(body)
+		mac.Write([]byte(suffix))
+		if hmac.Equal(signature, mac.Sum(nil)) {
+			return nil
+		}
+	}
+	return ErrMismatch
+}
@@ -0,0 +1,126 @@
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func sign(h func() hash.Hash, secret, payload string) []byte {
+	mac := hmac.New(h, []byte(secret))
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+func TestVerifyBodySignature(t *testing.T) {
+	body := []byte(`{"action":"opened"}`)
+	for name, tc := range map[string]struct {
+		rules Rules
+		value string
+	}{
+		"github sha256": {
+			Rules{Header: "X-Hub-Signature-256", Prefix: "sha256=", Secrets: []string{"old", "new"}},
+			"sha256=" + hex.EncodeToString(sign(sha256.New, "new", string(body))),
+		},
+		"sha1 hex": {
+			Rules{Header: "X-Signature", Algorithm: "sha1", Secrets: []string{"s"}},
+			hex.EncodeToString(sign(sha1.New, "s", string(body))),
+		},
+		"sha512 base64": {
+			Rules{Header: "X-Signature", Algorithm: "SHA512", Encoding: "base64", Secrets: []string{"s"}},
+			base64.StdEncoding.EncodeToString(sign(sha512.New, "s", string(body))),
+		},
+		"base64url": {
+			Rules{Header: "X-Signature", Encoding: "base64url", Secrets: []string{"s"}},
+			base64.RawURLEncoding.EncodeToString(sign(sha256.New, "s", string(body))),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			v, err := Compile(tc.rules)
+			if err != nil {
+				t.Fatalf("compile: %v", err)
+			}
+			header := http.Header{}
+			header.Set(tc.rules.Header, tc.value)
+			if err := v.Verify(header, body); err != nil {
+				t.Fatalf("verify: %v", err)
+			}
+			if err := v.Verify(header, []byte(`{"action":"closed"}`)); !errors.Is(err, ErrMismatch) {
+				t.Fatalf("tampered body: got %v, want ErrMismatch", err)
+			}
+			if err := v.Verify(http.Header{}, body); !errors.Is(err, ErrMissing) {
+				t.Fatalf("no header: got %v, want ErrMissing", err)
+			}
+		})
+	}
+}
+
+func TestVerifyTimestampReplayWindow(t *testing.T) {
+	now := time.Date(2026, 1, 29, 11, 41, 12, 0, time.UTC)
+	v, err := Compile(Rules{
+		Header:          "X-Slack-Signature",
+		Prefix:          "v0=",
+		Secrets:         []string{"s"},
+		TimestampHeader: "X-Slack-Request-Timestamp",
+		ReplayWindowMS:  60000,
+		Payload:         "v0:{timestamp}:{body}",
+	})
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	v.now = func() time.Time { return now }
+
+	body := "token=x&text=hello"
+	request := func(signedAt time.Time, payloadTS string) http.Header {
+		ts := strconv.FormatInt(signedAt.Unix(), 10)
+		header := http.Header{}
+		header.Set("X-Slack-Request-Timestamp", ts)
+		header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(sign(sha256.New, "s", "v0:"+payloadTS+":"+body)))
+		return header
+	}
+
+	if err := v.Verify(request(now.Add(-30*time.Second), strconv.FormatInt(now.Add(-30*time.Second).Unix(), 10)), []byte(body)); err != nil {
+		t.Fatalf("fresh request: %v", err)
+	}
+	old := now.Add(-2 * time.Minute)
+	if err := v.Verify(request(old, strconv.FormatInt(old.Unix(), 10)), []byte(body)); !errors.Is(err, ErrExpired) {
+		t.Fatalf("replayed request: got %v, want ErrExpired", err)
+	}
+	// A fresh timestamp header does not revive a signature made for
+	// another timestamp.
+	if err := v.Verify(request(now, strconv.FormatInt(old.Unix(), 10)), []byte(body)); !errors.Is(err, ErrMismatch) {
+		t.Fatalf("swapped timestamp: got %v, want ErrMismatch", err)
+	}
+	header := request(now, strconv.FormatInt(now.Unix(), 10))
+	header.Del("X-Slack-Request-Timestamp")
+	if err := v.Verify(header, []byte(body)); !errors.Is(err, ErrMissing) {
+		t.Fatalf("no timestamp: got %v, want ErrMissing", err)
+	}
+}
+
+func TestCompileRejectsInvalidRules(t *testing.T) {
+	for name, rules := range map[string]Rules{
+		"no header":       {Secrets: []string{"s"}},
+		"no secret":       {Header: "X-Signature"},
+		"empty secret":    {Header: "X-Signature", Secrets: []string{""}},
+		"algorithm":       {Header: "X-Signature", Secrets: []string{"s"}, Algorithm: "md5"},
+		"encoding":        {Header: "X-Signature", Secrets: []string{"s"}, Encoding: "base32"},
+		"payload":         {Header: "X-Signature", Secrets: []string{"s"}, Payload: "{timestamp}"},
+		"timestamp unset": {Header: "X-Signature", Secrets: []string{"s"}, Payload: "{timestamp}.{body}"},
+		"negative window": {Header: "X-Signature", Secrets: []string{"s"}, ReplayWindowMS: -1},
+		"body twice":      {Header: "X-Signature", Secrets: []string{"s"}, Payload: "{body}{body}"},
+	} {
+		if _, err := Compile(rules); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !unix
+
+package spool
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// lockDir creates dir's lock file exclusively and unlock removes it.
+// Without flock a lock file left by a crash must be removed by hand.
+func lockDir(dir string) (unlock func() error, err error) {
+	name := filepath.Join(dir, lockName)
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		return nil, ErrLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() error {
+		_ = f.Close()
+		return os.Remove(name)
+	}, nil
+}
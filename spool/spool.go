@@ -0,0 +1,524 @@
+// Package spool implements a segmented write-ahead log that keeps events on
+// disk until they have been delivered.
+//
+// Records are appended to the active segment and fsynced before Append
+// returns. Run drains records in order, persists its position in a cursor
+// file and removes a segment once every record in it has been delivered, so
+// undelivered events survive restarts. Delivery is at-least-once.
+package spool
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	segmentExt          = ".seg"
+	cursorName          = "cursor"
+	lockName            = "lock"
+	headerSize          = 8
+	maxRecordBytes      = 64 << 20
+	defaultSegmentBytes = 8 << 20
+	minBackoff          = time.Second
+	maxBackoff          = time.Minute
+	drainPoll           = 20 * time.Millisecond
+)
+
+// ErrRejected marks a send error as permanent. Run drops the record instead of
+// retrying it.
+var ErrRejected = errors.New("spool: record rejected")
+
+// ErrClosed is returned by Append after Close.
+var ErrClosed = errors.New("spool: closed")
+
+// ErrLocked is returned by Open when another spool has dir open.
+var ErrLocked = errors.New("spool: directory is in use")
+
+var errEmpty = errors.New("spool: empty")
+
+type Spool struct {
+	dir          string
+	segmentBytes int64
+	unlock       func() error
+
+	mu         sync.Mutex
+	active     *os.File
+	activeID   uint64
+	activeSize int64
+	closed     bool
+	lastErr    error
+	notify     chan struct{}
+	// segments lists the segment IDs on disk in order, the active one
+	// last, so Run does not read the directory for every record.
+	segments []uint64
+	// appends counts the records appended since Open; Run sets drained
+	// to appends+1 once everything appended before it looked was
+	// delivered.
+	appends uint64
+	drained uint64
+
+	// reader state, owned by Run
+	cursor   position
+	reader   *os.File
+	readerID uint64
+}
+
+type position struct {
+	segment uint64
+	offset  int64
+}
+
+// Open opens or creates the spool in dir. Existing segments are kept for
+// delivery and a fresh active segment is started. The directory is locked
+// until Close, so two spools never deliver the same records; Open fails
+// with ErrLocked while another one has it open.
+func Open(dir string, segmentBytes int64) (*Spool, error) {
+	if segmentBytes <= 0 {
+		segmentBytes = defaultSegmentBytes
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	unlock, err := lockDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	s, err := open(dir, segmentBytes)
+	if err != nil {
+		_ = unlock()
+		return nil, err
+	}
+	s.unlock = unlock
+	return s, nil
+}
+
+func open(dir string, segmentBytes int64) (*Spool, error) {
+	ids, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := readCursor(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Spool{
+		dir:          dir,
+		segmentBytes: segmentBytes,
+		segments:     ids,
+		notify:       make(chan struct{}, 1),
+		cursor:       cursor,
+	}
+
+	next := uint64(1)
+	if len(ids) > 0 {
+		next = ids[len(ids)-1] + 1
+	}
+	if s.cursor.segment >= next {
+		s.cursor = position{}
+	}
+	if err := s.openSegment(next); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Append durably writes one record to the spool.
+func (s *Spool) Append(data []byte) error {
+	if len(data) > maxRecordBytes {
+		return fmt.Errorf("spool: record of %d bytes exceeds limit", len(data))
+	}
+
+	record := make([]byte, headerSize+len(data))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(data))
+	copy(record[headerSize:], data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+
+	_, err := s.active.Write(record)
+	if err == nil {
+		err = s.active.Sync()
+	}
+	if err != nil {
+		// Drop the partial record so the segment stays readable.
+		_ = s.active.Truncate(s.activeSize)
+		s.lastErr = err
+		return err
+	}
+	s.activeSize += int64(len(record))
+	s.lastErr = nil
+	s.appends++
+
+	if s.activeSize >= s.segmentBytes {
+		if err := s.rotate(); err != nil {
+			s.lastErr = err
+			return err
+		}
+	}
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Err returns the error of the last failed Append, or nil once an Append
+// succeeds again.
+func (s *Spool) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastErr
+}
+
+// Close closes the active segment and unlocks the directory. Records
+// already appended stay on disk.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	err := s.active.Close()
+	if lerr := s.unlock(); err == nil {
+		err = lerr
+	}
+	return err
+}
+
+// Drain waits until Run has delivered every record in the spool, or ctx is
+// done.
+func (s *Spool) Drain(ctx context.Context) error {
+	ticker := time.NewTicker(drainPoll)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		drained := s.drained == s.appends+1
+		s.mu.Unlock()
+		if drained {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Run delivers spooled records with send until ctx is done. Failed sends are
+// retried with exponential backoff, or after the delay reported by errors
+// implementing RetryAfter() time.Duration; errors wrapping ErrRejected drop
+// the record.
+func (s *Spool) Run(ctx context.Context, send func(context.Context, []byte) error) {
+	defer s.closeReader()
+
+	backoff := minBackoff
+	for {
+		s.mu.Lock()
+		appends := s.appends
+		s.mu.Unlock()
+		data, next, err := s.next()
+		if errors.Is(err, errEmpty) {
+			s.mu.Lock()
+			s.drained = appends + 1
+			s.mu.Unlock()
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.notify:
+			}
+			continue
+		}
+		if err != nil {
+			log.Printf("spool: read: %v", err)
+			if !sleep(ctx, backoff) {
+				return
+			}
+			continue
+		}
+
+		if err := send(ctx, data); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if !errors.Is(err, ErrRejected) {
+				wait := backoff
+				var limited interface{ RetryAfter() time.Duration }
+				if errors.As(err, &limited) && limited.RetryAfter() > 0 {
+					wait = limited.RetryAfter()
+				} else {
+					backoff *= 2
+					if backoff > maxBackoff {
+						backoff = maxBackoff
+					}
+				}
+				log.Printf("spool: send failed, retrying in %s: %v", wait, err)
+				if !sleep(ctx, wait) {
+					return
+				}
+				continue
+			}
+			log.Printf("spool: dropping record: %v", err)
+		}
+
+		backoff = minBackoff
+		s.cursor = next
+		if err := writeCursor(s.dir, s.cursor); err != nil {
+			log.Printf("spool: write cursor: %v", err)
+		}
+	}
+}
+
+// next returns the record at the cursor and the position following it. Fully
+// consumed sealed segments are removed along the way.
+func (s *Spool) next() ([]byte, position, error) {
+	for {
+		s.mu.Lock()
+		activeID, activeSize := s.activeID, s.activeSize
+		ids := append([]uint64(nil), s.segments...)
+		s.mu.Unlock()
+
+		for _, id := range ids {
+			if id < s.cursor.segment && id != activeID {
+				s.removeSegment(id)
+			}
+		}
+		if s.cursor.segment == 0 || !containsID(ids, s.cursor.segment) {
+			first, ok := firstAtLeast(ids, s.cursor.segment)
+			if !ok {
+				return nil, position{}, errEmpty
+			}
+			s.cursor = position{segment: first}
+		}
+
+		limit := int64(-1)
+		if s.cursor.segment == activeID {
+			limit = activeSize
+		}
+		data, n, err := s.readRecord(s.cursor, limit)
+		if err == nil {
+			return data, position{segment: s.cursor.segment, offset: s.cursor.offset + n}, nil
+		}
+		if s.cursor.segment == activeID {
+			if errors.Is(err, io.EOF) {
+				return nil, position{}, errEmpty
+			}
+			return nil, position{}, err
+		}
+		if !errors.Is(err, io.EOF) {
+			log.Printf("spool: segment %d is corrupt at offset %d: %v", s.cursor.segment, s.cursor.offset, err)
+			if err := s.reloadSegments(); err != nil {
+				return nil, position{}, err
+			}
+		}
+
+		// Sealed segment fully consumed.
+		s.removeSegment(s.cursor.segment)
+		s.cursor = position{segment: s.cursor.segment + 1}
+	}
+}
+
+func (s *Spool) readRecord(pos position, limit int64) ([]byte, int64, error) {
+	if limit >= 0 && pos.offset+headerSize > limit {
+		return nil, 0, io.EOF
+	}
+	f, err := s.openReader(pos.segment)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var header [headerSize]byte
+	if _, err := f.ReadAt(header[:], pos.offset); err != nil {
+		return nil, 0, err
+	}
+	size := int64(binary.BigEndian.Uint32(header[0:4]))
+	sum := binary.BigEndian.Uint32(header[4:8])
+	if size > maxRecordBytes {
+		return nil, 0, fmt.Errorf("record length %d exceeds limit", size)
+	}
+	if limit >= 0 && pos.offset+headerSize+size > limit {
+		return nil, 0, io.EOF
+	}
+
+	data := make([]byte, size)
+	if _, err := f.ReadAt(data, pos.offset+headerSize); err != nil {
+		return nil, 0, fmt.Errorf("truncated record: %v", err)
+	}
+	if crc32.ChecksumIEEE(data) != sum {
+		return nil, 0, errors.New("checksum mismatch")
+	}
+	return data, headerSize + size, nil
+}
+
+func (s *Spool) openReader(id uint64) (*os.File, error) {
+	if s.reader != nil && s.readerID == id {
+		return s.reader, nil
+	}
+	s.closeReader()
+	f, err := os.Open(s.segmentPath(id))
+	if err != nil {
+		return nil, err
+	}
+	s.reader = f
+	s.readerID = id
+	return f, nil
+}
+
+func (s *Spool) closeReader() {
+	if s.reader != nil {
+		_ = s.reader.Close()
+		s.reader = nil
+	}
+}
+
+func (s *Spool) removeSegment(id uint64) {
+	if s.reader != nil && s.readerID == id {
+		s.closeReader()
+	}
+	if err := os.Remove(s.segmentPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("spool: remove segment %d: %v", id, err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, v := range s.segments {
+		if v == id {
+			s.segments = append(s.segments[:i], s.segments[i+1:]...)
+			break
+		}
+	}
+}
+
+// reloadSegments lists the segments on disk again, for when they no longer
+// match the ones the spool knows about.
+func (s *Spool) reloadSegments() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids, err := listSegments(s.dir)
+	if err != nil {
+		return err
+	}
+	s.segments = ids
+	return nil
+}
+
+func (s *Spool) rotate() error {
+	if err := s.active.Close(); err != nil {
+		return err
+	}
+	return s.openSegment(s.activeID + 1)
+}
+
+func (s *Spool) openSegment(id uint64) error {
+	f, err := os.OpenFile(s.segmentPath(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	s.active = f
+	s.activeID = id
+	s.activeSize = info.Size()
+	s.segments = append(s.segments, id)
+	return nil
+}
+
+func (s *Spool) segmentPath(id uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", id, segmentExt))
+}
+
+func listSegments(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uint64, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func readCursor(dir string) (position, error) {
+	data, err := os.ReadFile(filepath.Join(dir, cursorName))
+	if errors.Is(err, os.ErrNotExist) {
+		return position{}, nil
+	}
+	if err != nil {
+		return position{}, err
+	}
+	var pos position
+	if _, err := fmt.Sscanf(string(data), "%d %d", &pos.segment, &pos.offset); err != nil {
+		log.Printf("spool: ignoring unreadable cursor: %v", err)
+		return position{}, nil
+	}
+	return pos, nil
+}
+
+func writeCursor(dir string, pos position) error {
+	tmp := filepath.Join(dir, cursorName+".tmp")
+	if err := os.WriteFile(tmp, []byte(fmt.Sprintf("%d %d\n", pos.segment, pos.offset)), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, cursorName))
+}
+
+func containsID(ids []uint64, id uint64) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func firstAtLeast(ids []uint64, id uint64) (uint64, bool) {
+	for _, v := range ids {
+		if v >= id {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,191 @@
+package spool
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRunDeliversAndRemovesSegments(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 64)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer s.Close()
+
+	for i := 0; i < 5; i++ {
+		if err := s.Append([]byte("event-" + strconv.Itoa(i))); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	got := make(chan string, 5)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Run(ctx, func(_ context.Context, data []byte) error {
+			got <- string(data)
+			return nil
+		})
+	}()
+
+	for i := 0; i < 5; i++ {
+		select {
+		case v := <-got:
+			if v != "event-"+strconv.Itoa(i) {
+				t.Fatalf("unexpected record %d: %q", i, v)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for record %d", i)
+		}
+	}
+	cancel()
+	<-done
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if len(segments) != 1 {
+		t.Fatalf("expected only the active segment to remain, got %v", segments)
+	}
+	if len(s.segments) != 1 || s.segments[0] != s.activeID {
+		t.Fatalf("expected only the active segment to be listed, got %v", s.segments)
+	}
+}
+
+func TestRunSkipsSegmentsRemovedFromDisk(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 1)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer s.Close()
+
+	// Each record fills a segment of its own.
+	for i := 0; i < 3; i++ {
+		if err := s.Append([]byte("event-" + strconv.Itoa(i))); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+	if err := os.Remove(s.segmentPath(s.segments[1])); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	got := make(chan string, 3)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Run(ctx, func(_ context.Context, data []byte) error {
+			got <- string(data)
+			return nil
+		})
+	}()
+	for _, want := range []string{"event-0", "event-2"} {
+		select {
+		case v := <-got:
+			if v != want {
+				t.Fatalf("expected %q, got %q", want, v)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+	cancel()
+	<-done
+
+	if len(s.segments) != 1 || s.segments[0] != s.activeID {
+		t.Fatalf("expected only the active segment to be listed, got %v", s.segments)
+	}
+}
+
+func TestUndeliveredRecordsSurviveReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	_ = s.Append([]byte("first"))
+	_ = s.Append([]byte("second"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.Run(ctx, func(_ context.Context, data []byte) error {
+		if string(data) == "second" {
+			cancel()
+			return errors.New("sentry unreachable")
+		}
+		return nil
+	})
+	_ = s.Close()
+
+	s, err = Open(dir, 0)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer s.Close()
+
+	ctx, cancel = context.WithCancel(context.Background())
+	var got []string
+	s.Run(ctx, func(_ context.Context, data []byte) error {
+		got = append(got, string(data))
+		cancel()
+		return nil
+	})
+	if len(got) != 1 || got[0] != "second" {
+		t.Fatalf("expected only the undelivered record, got %v", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, cursorName)); err != nil {
+		t.Fatalf("expected cursor file: %v", err)
+	}
+}
+
+func TestOpenLocksDirectory(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if _, err := Open(dir, 0); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked while the spool is open, got %v", err)
+	}
+	_ = s.Close()
+
+	s, err = Open(dir, 0)
+	if err != nil {
+		t.Fatalf("reopen after close: %v", err)
+	}
+	_ = s.Close()
+}
+
+func TestDrainWaitsForDelivery(t *testing.T) {
+	s, err := Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer s.Close()
+	_ = s.Append([]byte("pending"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected Drain to wait for Run, got %v", err)
+	}
+
+	runCtx, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Run(runCtx, func(context.Context, []byte) error { return nil })
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.Drain(ctx); err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+	stop()
+	<-done
+}
@@ -0,0 +1,101 @@
+package stacktrace
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+)
+
+var (
+	goroutineHeader = regexp.MustCompile(`^goroutine \d+ \[[^\]]*\]:$`)
+	goLocation      = regexp.MustCompile(`^(\S.*\.(?:go|s):\d+)(?: \+0x[0-9a-f]+)?$`)
+)
+
+// parseGo parses the first goroutine of a panic or goroutine dump:
+//
+//	panic: runtime error: index out of range [5] with length 3
+//
+//	goroutine 1 [running]:
+//	main.lookup(...)
+//		/app/main.go:12
+//	main.main()
+//		/app/main.go:8 +0x1d
+func parseGo(lines []string) ([]sentry.Exception, bool) {
+	start := -1
+	for i, line := range lines {
+		if goroutineHeader.MatchString(strings.TrimSpace(line)) {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return nil, false
+	}
+
+	typ, value := "panic", ""
+	for _, line := range lines[:start] {
+		line = strings.TrimSpace(line)
+		if value != "" {
+			break
+		}
+		switch {
+		case strings.HasPrefix(line, "panic: "):
+			value = strings.TrimPrefix(line, "panic: ")
+		case strings.HasPrefix(line, "fatal error: "):
+			typ, value = "fatal error", strings.TrimPrefix(line, "fatal error: ")
+		}
+	}
+	if value == "" {
+		typ, value = "goroutine dump", strings.TrimSpace(lines[start])
+	}
+
+	var frames []sentry.Frame
+	function := ""
+	for _, line := range lines[start+1:] {
+		line = strings.TrimSpace(line)
+		if line == "" || goroutineHeader.MatchString(line) {
+			break
+		}
+		if m := goLocation.FindStringSubmatch(line); m != nil && function != "" {
+			file, lineno, _ := splitLocation(m[1])
+			frames = append(frames, goFrame(function, file, lineno))
+			function = ""
+			continue
+		}
+		function = line
+	}
+	if len(frames) == 0 {
+		return nil, false
+	}
+	return []sentry.Exception{newException(typ, "", value, reverseFrames(frames))}, true
+}
+
+func goFrame(function, file string, lineno int) sentry.Frame {
+	function = strings.TrimPrefix(function, "created by ")
+	if i := strings.Index(function, " in goroutine "); i >= 0 {
+		function = function[:i]
+	}
+	if strings.HasSuffix(function, ")") {
+		if i := strings.LastIndexByte(function, '('); i > 0 {
+			function = function[:i]
+		}
+	}
+
+	module := ""
+	slash := strings.LastIndexByte(function, '/')
+	if dot := strings.IndexByte(function[slash+1:], '.'); dot >= 0 {
+		module = function[:slash+1+dot]
+		function = function[slash+2+dot:]
+	}
+
+	// Standard library packages have no dot in their first path element.
+	root := strings.SplitN(module, "/", 2)[0]
+	frame := sentry.Frame{
+		Function: function,
+		Module:   module,
+		Lineno:   lineno,
+		InApp:    module == "main" || strings.Contains(root, "."),
+	}
+	return fileFrame(frame, file)
+}
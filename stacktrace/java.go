@@ -0,0 +1,95 @@
+package stacktrace
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+)
+
+var (
+	javaFrame  = regexp.MustCompile(`^\s*at (?:[^\s(/]*/)*([^\s(/]+)\.([^\s.(]+)\(([^)]*)\)`)
+	javaHeader = regexp.MustCompile(`^(?:Exception in thread "[^"]*" )?(?:Caused by: )?([A-Za-z_$][\w$]*(?:\.[A-Za-z_$][\w$]*)+)(?::\s?(.*))?$`)
+	javaMore   = regexp.MustCompile(`^\s*\.\.\. \d+ (?:more|common frames omitted)$`)
+)
+
+// parseJava parses Java and Kotlin stack traces with their causes:
+//
+//	java.lang.IllegalStateException: order is closed
+//		at com.example.Orders.charge(Orders.java:42)
+//		at com.example.Main.main(Main.java:10)
+//	Caused by: java.io.IOException: disk full
+//		at com.example.Store.write(Store.java:7)
+//		... 2 more
+func parseJava(lines []string) ([]sentry.Exception, bool) {
+	start := -1
+	for i := 1; i < len(lines); i++ {
+		if javaFrame.MatchString(lines[i]) && javaHeader.MatchString(strings.TrimSpace(lines[i-1])) {
+			start = i - 1
+			break
+		}
+	}
+	if start < 0 {
+		return nil, false
+	}
+
+	// Collected in printed order: the thrown exception first, then causes.
+	var exceptions []sentry.Exception
+	var current *sentry.Exception
+	suppressed := false
+	for _, line := range lines[start:] {
+		trimmed := strings.TrimSpace(line)
+		if current == nil || strings.HasPrefix(line, "Caused by: ") {
+			m := javaHeader.FindStringSubmatch(trimmed)
+			if m == nil {
+				break
+			}
+			module, typ := "", m[1]
+			if dot := strings.LastIndexByte(typ, '.'); dot >= 0 {
+				module, typ = typ[:dot], typ[dot+1:]
+			}
+			exceptions = append(exceptions, newException(typ, module, m[2], nil))
+			current = &exceptions[len(exceptions)-1]
+			suppressed = false
+			continue
+		}
+		// Suppressed exceptions and their causes are indented; skip them.
+		if strings.HasPrefix(trimmed, "Suppressed: ") || strings.HasPrefix(trimmed, "Caused by: ") {
+			suppressed = true
+			continue
+		}
+		if m := javaFrame.FindStringSubmatch(line); m != nil {
+			if !suppressed {
+				current.Stacktrace.Frames = append(current.Stacktrace.Frames, javaFrameFor(m[1], m[2], m[3]))
+			}
+			continue
+		}
+		if javaMore.MatchString(line) {
+			continue
+		}
+		break
+	}
+
+	for i := range exceptions {
+		reverseFrames(exceptions[i].Stacktrace.Frames)
+	}
+	for i, j := 0, len(exceptions)-1; i < j; i, j = i+1, j-1 {
+		exceptions[i], exceptions[j] = exceptions[j], exceptions[i]
+	}
+	return exceptions, true
+}
+
+func javaFrameFor(class, method, source string) sentry.Frame {
+	frame := sentry.Frame{
+		Function: method,
+		Module:   class,
+		InApp:    !hasAnyPrefix(class, "java.", "javax.", "jdk.", "sun.", "com.sun.", "kotlin.", "kotlinx.", "scala."),
+	}
+	if source == "Native Method" || source == "Unknown Source" || source == "" {
+		return frame
+	}
+	file, lineno, _ := splitLocation(source)
+	frame.Filename = file
+	frame.Lineno = lineno
+	return frame
+}
@@ -0,0 +1,88 @@
+package stacktrace
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+)
+
+var (
+	nodeHeader   = regexp.MustCompile(`^(?:Uncaught )?([A-Za-z_$][\w$.]*(?:Error|Exception))(?: \[[^\]]+\])?(?::\s?(.*))?$`)
+	nodeLocation = regexp.MustCompile(`^(.+):\d+:\d+$`)
+)
+
+// parseNode parses a Node.js error stack:
+//
+//	TypeError: Cannot read properties of undefined (reading 'id')
+//	    at handler (/app/src/orders.js:12:18)
+//	    at process.processTicksAndRejections (node:internal/process/task_queues:95:5)
+func parseNode(lines []string) ([]sentry.Exception, bool) {
+	start := -1
+	for i := 1; i < len(lines); i++ {
+		if _, ok := nodeFrame(lines[i]); ok {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return nil, false
+	}
+
+	// The message may span several lines; the header is the nearest line
+	// above the frames that looks like "TypeError: message".
+	typ, value := "Error", strings.TrimSpace(lines[0])
+	for i := start - 1; i >= 0; i-- {
+		if m := nodeHeader.FindStringSubmatch(strings.TrimSpace(lines[i])); m != nil {
+			typ, value = m[1], m[2]
+			break
+		}
+	}
+
+	var frames []sentry.Frame
+	for _, line := range lines[start:] {
+		frame, ok := nodeFrame(line)
+		if !ok {
+			break
+		}
+		frames = append(frames, frame)
+	}
+	return []sentry.Exception{newException(typ, "", value, reverseFrames(frames))}, true
+}
+
+// nodeFrame parses "at function (file:line:column)" and "at file:line:column".
+func nodeFrame(line string) (sentry.Frame, bool) {
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "at ") {
+		return sentry.Frame{}, false
+	}
+	trimmed = strings.TrimPrefix(trimmed, "at ")
+
+	function, location := "", trimmed
+	if strings.HasSuffix(trimmed, ")") {
+		i := strings.Index(trimmed, " (")
+		if i < 0 {
+			return sentry.Frame{}, false
+		}
+		function, location = trimmed[:i], trimmed[i+2:len(trimmed)-1]
+	}
+	function = strings.TrimPrefix(function, "async ")
+	if !nodeLocation.MatchString(location) {
+		// Frames without a source location, as in "at Array.map (<anonymous>)"
+		// or "at async Promise.all (index 0)".
+		if function == "" || location != "native" && location != "<anonymous>" && !strings.HasPrefix(location, "index ") {
+			return sentry.Frame{}, false
+		}
+		return sentry.Frame{Function: function, Filename: location}, true
+	}
+
+	file, lineno, column := splitLocation(location)
+	file = strings.TrimPrefix(file, "file://")
+	frame := sentry.Frame{
+		Function: function,
+		Lineno:   lineno,
+		Colno:    column,
+		InApp:    !strings.HasPrefix(file, "node:") && !strings.Contains(file, "node_modules"),
+	}
+	return fileFrame(frame, file), true
+}
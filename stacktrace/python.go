@@ -0,0 +1,73 @@
+package stacktrace
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+)
+
+var (
+	pythonFrame     = regexp.MustCompile(`^\s*File "([^"]+)", line (\d+)(?:, in (.+))?$`)
+	pythonException = regexp.MustCompile(`^([A-Za-z_][\w.]*)(?::\s?(.*))?$`)
+	pythonMarker    = regexp.MustCompile(`^\s*[\^~\s]+$`)
+)
+
+// parsePython parses tracebacks, including chained exceptions:
+//
+//	Traceback (most recent call last):
+//	  File "/app/jobs.py", line 10, in run
+//	    charge(order)
+//	ValueError: amount must be positive
+func parsePython(lines []string) ([]sentry.Exception, bool) {
+	var exceptions []sentry.Exception
+	for i := 0; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) != "Traceback (most recent call last):" {
+			continue
+		}
+
+		var frames []sentry.Frame
+		for i++; i < len(lines); i++ {
+			line := lines[i]
+			if m := pythonFrame.FindStringSubmatch(line); m != nil {
+				lineno, _ := strconv.Atoi(m[2])
+				frames = append(frames, pythonFrameFor(m[1], lineno, m[3]))
+				continue
+			}
+			if strings.TrimSpace(line) == "" || pythonMarker.MatchString(line) {
+				continue
+			}
+			if line[0] == ' ' || line[0] == '\t' {
+				if n := len(frames); n > 0 && frames[n-1].ContextLine == "" {
+					frames[n-1].ContextLine = strings.TrimSpace(line)
+				}
+				continue
+			}
+			break
+		}
+		if len(frames) == 0 || i >= len(lines) {
+			continue
+		}
+
+		m := pythonException.FindStringSubmatch(strings.TrimSpace(lines[i]))
+		if m == nil {
+			continue
+		}
+		module, typ := "", m[1]
+		if dot := strings.LastIndexByte(typ, '.'); dot >= 0 {
+			module, typ = typ[:dot], typ[dot+1:]
+		}
+		exceptions = append(exceptions, newException(typ, module, m[2], frames))
+	}
+	return exceptions, len(exceptions) > 0
+}
+
+func pythonFrameFor(file string, lineno int, function string) sentry.Frame {
+	frame := sentry.Frame{
+		Function: function,
+		Lineno:   lineno,
+		InApp:    !strings.Contains(file, "site-packages") && !strings.Contains(file, "dist-packages") && !strings.Contains(file, "/lib/python"),
+	}
+	return fileFrame(frame, file)
+}
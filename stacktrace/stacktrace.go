@@ -0,0 +1,124 @@
+// Package stacktrace finds stack traces in log text and converts them to
+// Sentry exceptions, so events group by code location instead of by message.
+//
+// Supported formats are Go panics and goroutine dumps, Python tracebacks,
+// Java and Kotlin stack traces (including "Caused by" chains) and Node.js
+// error stacks.
+package stacktrace
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// parsers are tried in order. The Java parser runs before the Node.js parser
+// because both use "at" frame lines.
+var parsers = []func(lines []string) ([]sentry.Exception, bool){
+	parseGo,
+	parsePython,
+	parseJava,
+	parseNode,
+}
+
+// Extract returns the first stack trace found in text as Sentry exceptions,
+// ordered like Sentry expects: the root cause first, the reported exception
+// last, each with its frames ordered from the outermost call to the
+// innermost. ok is false if text contains no supported stack trace.
+func Extract(text string) ([]sentry.Exception, bool) {
+	if !strings.Contains(text, "\n") {
+		return nil, false
+	}
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for _, parse := range parsers {
+		if exceptions, ok := parse(lines); ok {
+			return exceptions, true
+		}
+	}
+	return nil, false
+}
+
+// Attach sets the event's exceptions from a stack trace found in the
+// message, or else in a top level string extra, checked in key order.
+func Attach(event *sentry.Event) {
+	if exceptions, ok := Extract(event.Message); ok {
+		event.Exception = exceptions
+		return
+	}
+	keys := make([]string, 0, len(event.Extra))
+	for key := range event.Extra {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		text, isString := event.Extra[key].(string)
+		if !isString {
+			continue
+		}
+		if exceptions, ok := Extract(text); ok {
+			event.Exception = exceptions
+			return
+		}
+	}
+}
+
+func newException(typ, module, value string, frames []sentry.Frame) sentry.Exception {
+	return sentry.Exception{
+		Type:       typ,
+		Module:     module,
+		Value:      strings.TrimSpace(value),
+		Stacktrace: &sentry.Stacktrace{Frames: frames},
+	}
+}
+
+// splitLocation splits "file:line" or "file:line:column".
+func splitLocation(location string) (file string, line, column int) {
+	file = location
+	var numbers []int
+	for len(numbers) < 2 {
+		i := strings.LastIndexByte(file, ':')
+		if i < 0 {
+			break
+		}
+		n, err := strconv.Atoi(file[i+1:])
+		if err != nil {
+			break
+		}
+		numbers = append(numbers, n)
+		file = file[:i]
+	}
+	switch len(numbers) {
+	case 1:
+		line = numbers[0]
+	case 2:
+		line, column = numbers[1], numbers[0]
+	}
+	return file, line, column
+}
+
+// fileFrame sets the file name fields of frame.
+func fileFrame(frame sentry.Frame, file string) sentry.Frame {
+	frame.Filename = file
+	if strings.HasPrefix(file, "/") || len(file) > 2 && file[1] == ':' {
+		frame.AbsPath = file
+	}
+	return frame
+}
+
+func reverseFrames(frames []sentry.Frame) []sentry.Frame {
+	for i, j := 0, len(frames)-1; i < j; i, j = i+1, j-1 {
+		frames[i], frames[j] = frames[j], frames[i]
+	}
+	return frames
+}
+
+func hasAnyPrefix(s string, prefixes ...string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
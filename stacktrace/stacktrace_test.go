@@ -0,0 +1,139 @@
+package stacktrace
+
+import (
+	"testing"
+
+	"github.com/getsentry/sentry-go"
+)
+
+func lastFrame(t *testing.T, exc sentry.Exception) sentry.Frame {
+	t.Helper()
+	if exc.Stacktrace == nil || len(exc.Stacktrace.Frames) == 0 {
+		t.Fatalf("expected frames for %s", exc.Type)
+	}
+	return exc.Stacktrace.Frames[len(exc.Stacktrace.Frames)-1]
+}
+
+func TestExtractGoPanic(t *testing.T) {
+	text := "panic: runtime error: index out of range [5] with length 3\n\n" +
+		"goroutine 1 [running]:\n" +
+		"github.com/acme/shop/orders.(*Store).Lookup(0xc000010000, 0x5)\n" +
+		"\t/app/orders/store.go:42 +0x1d\n" +
+		"main.main()\n" +
+		"\t/app/main.go:8 +0x25\n" +
+		"\n" +
+		"goroutine 6 [chan receive]:\n" +
+		"main.worker()\n" +
+		"\t/app/main.go:20\n"
+
+	exceptions, ok := Extract(text)
+	if !ok || len(exceptions) != 1 {
+		t.Fatalf("expected one exception, got %v", exceptions)
+	}
+	exc := exceptions[0]
+	if exc.Type != "panic" || exc.Value != "runtime error: index out of range [5] with length 3" {
+		t.Fatalf("unexpected exception %q %q", exc.Type, exc.Value)
+	}
+	if len(exc.Stacktrace.Frames) != 2 {
+		t.Fatalf("expected frames of the first goroutine only, got %d", len(exc.Stacktrace.Frames))
+	}
+	frame := lastFrame(t, exc)
+	if frame.Module != "github.com/acme/shop/orders" || frame.Function != "(*Store).Lookup" || frame.AbsPath != "/app/orders/store.go" || frame.Lineno != 42 || !frame.InApp {
+		t.Fatalf("unexpected frame %+v", frame)
+	}
+}
+
+func TestExtractPythonChainedTraceback(t *testing.T) {
+	text := `Traceback (most recent call last):
+  File "/app/db.py", line 3, in connect
+    raise OSError("refused")
+OSError: refused
+
+During handling of the above exception, another exception occurred:
+
+Traceback (most recent call last):
+  File "/usr/lib/python3.12/site-packages/click/core.py", line 10, in invoke
+    return callback()
+  File "/app/jobs.py", line 12, in run
+    connect()
+    ^^^^^^^^^
+app.errors.DatabaseError: cannot connect`
+
+	exceptions, ok := Extract(text)
+	if !ok || len(exceptions) != 2 {
+		t.Fatalf("expected two exceptions, got %v", exceptions)
+	}
+	if exceptions[0].Type != "OSError" || exceptions[1].Type != "DatabaseError" || exceptions[1].Module != "app.errors" || exceptions[1].Value != "cannot connect" {
+		t.Fatalf("unexpected exceptions %+v", exceptions)
+	}
+	frames := exceptions[1].Stacktrace.Frames
+	if frames[0].InApp || !frames[1].InApp || frames[1].Function != "run" || frames[1].Lineno != 12 || frames[1].ContextLine != "connect()" {
+		t.Fatalf("unexpected frames %+v", frames)
+	}
+}
+
+func TestExtractJavaCausedBy(t *testing.T) {
+	text := "Exception in thread \"main\" java.lang.IllegalStateException: order is closed\n" +
+		"\tat com.example.Orders.charge(Orders.java:42)\n" +
+		"\tat java.base/java.lang.Thread.run(Thread.java:833)\n" +
+		"\tSuppressed: java.lang.RuntimeException: ignored\n" +
+		"\t\tat com.example.Cleanup.close(Cleanup.java:5)\n" +
+		"Caused by: java.io.IOException: disk full\n" +
+		"\tat com.example.Store.write(Store.kt:7)\n" +
+		"\t... 2 more\n"
+
+	exceptions, ok := Extract(text)
+	if !ok || len(exceptions) != 2 {
+		t.Fatalf("expected two exceptions, got %v", exceptions)
+	}
+	cause, thrown := exceptions[0], exceptions[1]
+	if cause.Type != "IOException" || cause.Module != "java.io" || thrown.Type != "IllegalStateException" || thrown.Value != "order is closed" {
+		t.Fatalf("unexpected exceptions %+v", exceptions)
+	}
+	if len(thrown.Stacktrace.Frames) != 2 {
+		t.Fatalf("expected suppressed frames to be skipped, got %+v", thrown.Stacktrace.Frames)
+	}
+	frame := lastFrame(t, thrown)
+	if frame.Module != "com.example.Orders" || frame.Function != "charge" || frame.Filename != "Orders.java" || frame.Lineno != 42 || !frame.InApp {
+		t.Fatalf("unexpected frame %+v", frame)
+	}
+	if thrown.Stacktrace.Frames[0].InApp || thrown.Stacktrace.Frames[0].Module != "java.lang.Thread" {
+		t.Fatalf("unexpected frame %+v", thrown.Stacktrace.Frames[0])
+	}
+}
+
+func TestExtractNodeStack(t *testing.T) {
+	text := "TypeError: Cannot read properties of undefined (reading 'id')\n" +
+		"    at handler (/app/src/orders.js:12:18)\n" +
+		"    at /app/src/router.js:4:3\n" +
+		"    at process.processTicksAndRejections (node:internal/process/task_queues:95:5)\n"
+
+	exceptions, ok := Extract(text)
+	if !ok || len(exceptions) != 1 {
+		t.Fatalf("expected one exception, got %v", exceptions)
+	}
+	exc := exceptions[0]
+	if exc.Type != "TypeError" || exc.Value != "Cannot read properties of undefined (reading 'id')" {
+		t.Fatalf("unexpected exception %q %q", exc.Type, exc.Value)
+	}
+	frames := exc.Stacktrace.Frames
+	if len(frames) != 3 || frames[0].InApp || frames[1].Filename != "/app/src/router.js" {
+		t.Fatalf("unexpected frames %+v", frames)
+	}
+	frame := lastFrame(t, exc)
+	if frame.Function != "handler" || frame.Lineno != 12 || frame.Colno != 18 || !frame.InApp {
+		t.Fatalf("unexpected frame %+v", frame)
+	}
+}
+
+func TestExtractIgnoresPlainMessages(t *testing.T) {
+	for _, text := range []string{
+		"order 42 failed",
+		"line one\nline two",
+		"meeting moved\n  at noon (room 4)",
+	} {
+		if exceptions, ok := Extract(text); ok {
+			t.Fatalf("expected no stack trace in %q, got %+v", text, exceptions)
+		}
+	}
+}
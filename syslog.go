@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"http-to-sentry-go/internal/logevent"
+	"http-to-sentry-go/metrics"
+	"http-to-sentry-go/stacktrace"
+	"http-to-sentry-go/syslog"
+	"http-to-sentry-go/timestamp"
+)
+
+// startSyslog opens the configured syslog listeners and serves them until
+// ctx is done. Messages are captured through the syslog route of the active
+// configuration generation.
+func startSyslog(ctx context.Context, cfg config, srv *server, wg *sync.WaitGroup) error {
+	if cfg.syslogUDPAddr == "" && cfg.syslogTCPAddr == "" && cfg.syslogTLSAddr == "" {
+		return nil
+	}
+
+	s := &syslog.Server{
+		MaxMessageBytes: cfg.syslogMaxBytes,
+		Handle:          srv.captureSyslog,
+		Invalid: func(remote net.Addr, err error) {
+			metrics.ParseFailures.WithLabelValues("syslog").Inc()
+			log.Printf("syslog %s: %v", remote, err)
+		},
+		Allow: func(ip net.IP) bool {
+			g, release := srv.acquire()
+			defer release()
+			return ipAllowed(g.cfg.syslogAllowed, ip)
+		},
+	}
+
+	serve := func(name string, run func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := run(); err != nil && !errors.Is(err, net.ErrClosed) {
+				log.Printf("syslog %s server error: %v", name, err)
+			}
+		}()
+	}
+
+	if cfg.syslogUDPAddr != "" {
+		conn, err := net.ListenPacket("udp", cfg.syslogUDPAddr)
+		if err != nil {
+			return err
+		}
+		serve("udp", func() error { return s.ServeUDP(ctx, conn) })
+	}
+	if cfg.syslogTCPAddr != "" {
+		ln, err := net.Listen("tcp", cfg.syslogTCPAddr)
+		if err != nil {
+			return err
+		}
+		serve("tcp", func() error { return s.ServeStream(ctx, ln, "tcp") })
+	}
+	if cfg.syslogTLSAddr != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.syslogCertFile, cfg.syslogKeyFile)
+		if err != nil {
+			return err
+		}
+		ln, err := tls.Listen("tcp", cfg.syslogTLSAddr, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		})
+		if err != nil {
+			return err
+		}
+		serve("tls", func() error { return s.ServeStream(ctx, ln, "tls") })
+	}
+	return nil
+}
+
+func syslogSummary(cfg config) string {
+	var listeners []string
+	for _, l := range []struct{ transport, addr string }{
+		{"udp", cfg.syslogUDPAddr},
+		{"tcp", cfg.syslogTCPAddr},
+		{"tls", cfg.syslogTLSAddr},
+	} {
+		if l.addr != "" {
+			listeners = append(listeners, l.transport+"://"+l.addr)
+		}
+	}
+	return strings.Join(listeners, ",")
+}
+
+// captureSyslog captures one syslog message with the active configuration.
+func (s *server) captureSyslog(msg *syslog.Message, remote net.Addr, transport string) {
+	g, release := s.acquire()
+	defer release()
+
+	rt := g.syslog
+	event, err := buildSyslogEvent(rt.cfg, msg, remote, transport)
+	if err != nil {
+		metrics.ParseFailures.WithLabelValues("syslog").Inc()
+		log.Printf("syslog %s: %v", remote, err)
+		return
+	}
+	if _, err := rt.capture(context.Background(), event); err != nil {
+		log.Printf("syslog %s: dropping message: %v", remote, err)
+	}
+}
+
+// buildSyslogEvent maps a syslog message to an event. It fails only for
+// timestamps the configured policy rejects.
+func buildSyslogEvent(cfg config, msg *syslog.Message, remote net.Addr, transport string) (*sentry.Event, error) {
+	event := sentry.NewEvent()
+	event.Logger = "syslog"
+	event.Level = logevent.SeverityLevel(msg.Severity)
+	event.Message = msg.Message
+	if event.Message == "" {
+		event.Message = "(empty message)"
+	}
+	event.Timestamp = time.Now()
+
+	event.Tags = map[string]string{
+		"remote_addr": remote.String(),
+		"transport":   transport,
+		"facility":    syslog.FacilityName(msg.Facility),
+		"severity":    syslog.SeverityName(msg.Severity),
+	}
+	for key, value := range map[string]string{
+		"hostname": msg.Hostname,
+		"app_name": msg.AppName,
+		"msg_id":   msg.MsgID,
+	} {
+		if value != "" {
+			event.Tags[key] = value
+		}
+	}
+	if msg.Hostname != "" {
+		event.ServerName = msg.Hostname
+	}
+
+	if msg.ProcID != "" {
+		event.Extra["proc_id"] = msg.ProcID
+	}
+	if len(msg.StructuredData) > 0 {
+		event.Extra["structured_data"] = msg.StructuredData
+	}
+	if !msg.Timestamp.IsZero() {
+		event.Extra["syslog_timestamp"] = msg.Timestamp.Format(time.RFC3339Nano)
+		ts, clamped, err := cfg.timestamps.Apply(msg.Timestamp, event.Timestamp)
+		if errors.Is(err, timestamp.ErrOutOfRange) {
+			return nil, err
+		}
+		event.Timestamp = ts
+		if clamped {
+			event.Extra["syslog_timestamp_clamped"] = true
+		}
+	}
+
+	stacktrace.Attach(event)
+	return event, nil
+}
+
+// ipAllowed reports whether ip is in one of the networks. An empty list
+// allows every address.
+func ipAllowed(networks []*net.IPNet, ip net.IP) bool {
+	if len(networks) == 0 {
+		return true
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
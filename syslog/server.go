@@ -0,0 +1,248 @@
+package syslog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultMaxMessageBytes = 65536
+
+var errTooLarge = errors.New("syslog: message too large")
+
+// Server receives syslog messages over UDP, TCP and TLS.
+type Server struct {
+	// Handle is called for every parsed message. transport is "udp", "tcp"
+	// or "tls".
+	Handle func(msg *Message, remote net.Addr, transport string)
+	// Invalid, if set, is called for every message that could not be read or
+	// parsed.
+	Invalid func(remote net.Addr, err error)
+	// Allow, if set, reports whether messages from ip are accepted. Other
+	// datagrams are dropped and other connections closed.
+	Allow func(ip net.IP) bool
+	// MaxMessageBytes bounds one message. Defaults to 64 KiB.
+	MaxMessageBytes int
+}
+
+func (s *Server) maxBytes() int {
+	if s.MaxMessageBytes > 0 {
+		return s.MaxMessageBytes
+	}
+	return defaultMaxMessageBytes
+}
+
+func (s *Server) allowed(addr net.Addr) bool {
+	if s.Allow == nil {
+		return true
+	}
+	return s.Allow(addrIP(addr))
+}
+
+func (s *Server) handle(data []byte, remote net.Addr, transport string) {
+	msg, err := Parse(data)
+	if err != nil {
+		s.invalid(remote, err)
+		return
+	}
+	s.Handle(msg, remote, transport)
+}
+
+func (s *Server) invalid(remote net.Addr, err error) {
+	if s.Invalid != nil {
+		s.Invalid(remote, err)
+	}
+}
+
+// ServeUDP reads one message per datagram from conn until ctx is done.
+func (s *Server) ServeUDP(ctx context.Context, conn net.PacketConn) error {
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+
+	buf := make([]byte, s.maxBytes()+1)
+	for {
+		n, remote, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+		if !s.allowed(remote) {
+			continue
+		}
+		if n > s.maxBytes() {
+			s.invalid(remote, errTooLarge)
+			continue
+		}
+		s.handle(buf[:n], remote, "udp")
+	}
+}
+
+// ServeStream accepts connections from ln until ctx is done. Messages use
+// octet-counting framing ("LEN MSG") or are terminated by a newline
+// (RFC 6587). On shutdown, open connections are closed once the messages
+// already received are handled.
+func (s *Server) ServeStream(ctx context.Context, ln net.Listener, transport string) error {
+	var mu sync.Mutex
+	conns := map[net.Conn]bool{}
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for conn := range conns {
+			_ = conn.SetReadDeadline(time.Now())
+		}
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(50 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		if !s.allowed(conn.RemoteAddr()) {
+			_ = conn.Close()
+			continue
+		}
+
+		mu.Lock()
+		if ctx.Err() != nil {
+			mu.Unlock()
+			_ = conn.Close()
+			return nil
+		}
+		conns[conn] = true
+		mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				mu.Lock()
+				delete(conns, conn)
+				mu.Unlock()
+				_ = conn.Close()
+			}()
+			s.serveConn(conn, transport)
+		}()
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn, transport string) {
+	r := bufio.NewReader(conn)
+	for {
+		frame, err := readFrame(r, s.maxBytes())
+		switch {
+		case err == nil:
+			if len(frame) > 0 {
+				s.handle(frame, conn.RemoteAddr(), transport)
+			}
+		case errors.Is(err, errTooLarge):
+			s.invalid(conn.RemoteAddr(), err)
+		case errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrUnexpectedEOF):
+			return
+		default:
+			var netErr net.Error
+			if !errors.As(err, &netErr) || !netErr.Timeout() {
+				log.Printf("syslog %s %s: %v", transport, conn.RemoteAddr(), err)
+			}
+			return
+		}
+	}
+}
+
+// readFrame reads one octet-counted or newline-terminated message. Messages
+// longer than limit are skipped and reported as errTooLarge.
+func readFrame(r *bufio.Reader, limit int) ([]byte, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if first[0] >= '1' && first[0] <= '9' {
+		digits, err := r.ReadSlice(' ')
+		if err != nil {
+			if errors.Is(err, bufio.ErrBufferFull) {
+				return nil, fmt.Errorf("syslog: invalid octet count")
+			}
+			return nil, err
+		}
+		n, err := strconv.Atoi(string(digits[:len(digits)-1]))
+		if err != nil {
+			return nil, fmt.Errorf("syslog: invalid octet count %q", digits)
+		}
+		if n > limit {
+			if _, err := r.Discard(n); err != nil {
+				return nil, err
+			}
+			return nil, errTooLarge
+		}
+		frame := make([]byte, n)
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return nil, err
+		}
+		return frame, nil
+	}
+
+	var frame []byte
+	tooLarge := false
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if !tooLarge && len(frame)+len(chunk) > limit+1 {
+			tooLarge, frame = true, nil
+		}
+		if !tooLarge {
+			frame = append(frame, chunk...)
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil && !(errors.Is(err, io.EOF) && len(frame) > 0) {
+			return nil, err
+		}
+		if tooLarge {
+			return nil, errTooLarge
+		}
+		return bytes.TrimRight(frame, "\r\n"), nil
+	}
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.TCPAddr:
+		return a.IP
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
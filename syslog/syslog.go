@@ -0,0 +1,271 @@
+// Package syslog parses RFC 5424 and RFC 3164 (BSD) syslog messages.
+package syslog
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Message is a parsed syslog message. Fields missing from the message are
+// empty.
+type Message struct {
+	Facility int
+	Severity int
+	// Timestamp is zero if the message has none.
+	Timestamp time.Time
+	Hostname  string
+	AppName   string
+	ProcID    string
+	MsgID     string
+	// StructuredData maps SD-IDs to their parameters (RFC 5424 only).
+	StructuredData map[string]map[string]string
+	Message        string
+}
+
+// ErrInvalidPriority is returned for messages with a malformed <PRI> prefix.
+var ErrInvalidPriority = errors.New("syslog: invalid priority")
+
+// defaultPriority is user.notice, which RFC 3164 assigns to messages without
+// a priority.
+const defaultPriority = 13
+
+var facilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+var severities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// FacilityName returns the keyword for a facility code, e.g. "local0".
+func FacilityName(facility int) string {
+	if facility < 0 || facility >= len(facilities) {
+		return strconv.Itoa(facility)
+	}
+	return facilities[facility]
+}
+
+// SeverityName returns the keyword for a severity code, e.g. "warning".
+func SeverityName(severity int) string {
+	if severity < 0 || severity >= len(severities) {
+		return strconv.Itoa(severity)
+	}
+	return severities[severity]
+}
+
+// Parse parses one message. RFC 5424 messages are recognized by their
+// version field; everything else is parsed leniently as RFC 3164.
+func Parse(data []byte) (*Message, error) {
+	return parse(string(data), time.Now(), true)
+}
+
+// ParseLogplex parses one Heroku Logplex message: RFC 5424 without the
+// STRUCTURED-DATA field.
+func ParseLogplex(data []byte) (*Message, error) {
+	return parse(string(data), time.Now(), false)
+}
+
+func parse(s string, now time.Time, structured bool) (*Message, error) {
+	s = strings.TrimRight(s, "\r\n\x00")
+	if !utf8.ValidString(s) {
+		s = strings.ToValidUTF8(s, "\ufffd")
+	}
+
+	pri := defaultPriority
+	if strings.HasPrefix(s, "<") {
+		end := strings.IndexByte(s, '>')
+		if end < 2 || end > 4 {
+			return nil, ErrInvalidPriority
+		}
+		var err error
+		pri, err = strconv.Atoi(s[1:end])
+		if err != nil || pri > 191 {
+			return nil, ErrInvalidPriority
+		}
+		s = s[end+1:]
+	}
+
+	msg := &Message{Facility: pri / 8, Severity: pri % 8}
+	if strings.HasPrefix(s, "1 ") {
+		if err := parse5424(msg, s[2:], structured); err != nil {
+			return nil, err
+		}
+		return msg, nil
+	}
+	parse3164(msg, s, now)
+	return msg, nil
+}
+
+// parse5424 parses the part after "<PRI>1 ":
+//
+//	TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]
+//
+// Without structured, the STRUCTURED-DATA field is absent.
+func parse5424(msg *Message, s string, structured bool) error {
+	var fields [5]string
+	for i := range fields {
+		end := strings.IndexByte(s, ' ')
+		if end < 0 {
+			return fmt.Errorf("syslog: truncated RFC 5424 header")
+		}
+		fields[i], s = s[:end], s[end+1:]
+		if fields[i] == "-" {
+			fields[i] = ""
+		}
+	}
+	if fields[0] != "" {
+		t, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return fmt.Errorf("syslog: invalid timestamp %q", fields[0])
+		}
+		msg.Timestamp = t
+	}
+	msg.Hostname, msg.AppName, msg.ProcID, msg.MsgID = fields[1], fields[2], fields[3], fields[4]
+
+	rest := s
+	if structured {
+		var err error
+		if rest, err = parseStructuredData(msg, s); err != nil {
+			return err
+		}
+		rest = strings.TrimPrefix(rest, " ")
+	}
+	msg.Message = strings.TrimPrefix(rest, "\ufeff")
+	return nil
+}
+
+// parseStructuredData parses "-" or one or more [SD-ID name="value" ...]
+// elements and returns the remaining text.
+func parseStructuredData(msg *Message, s string) (string, error) {
+	if s == "-" || strings.HasPrefix(s, "- ") {
+		return s[1:], nil
+	}
+	if !strings.HasPrefix(s, "[") {
+		return "", fmt.Errorf("syslog: invalid structured data")
+	}
+
+	msg.StructuredData = map[string]map[string]string{}
+	for strings.HasPrefix(s, "[") {
+		s = s[1:]
+		end := strings.IndexAny(s, " ]")
+		if end <= 0 {
+			return "", fmt.Errorf("syslog: invalid structured data element")
+		}
+		id := s[:end]
+		params := map[string]string{}
+		s = s[end:]
+		for strings.HasPrefix(s, " ") {
+			s = s[1:]
+			eq := strings.Index(s, `="`)
+			if eq <= 0 {
+				return "", fmt.Errorf("syslog: invalid structured data parameter in %q", id)
+			}
+			name := s[:eq]
+			value, rest, ok := unquoteParam(s[eq+2:])
+			if !ok {
+				return "", fmt.Errorf("syslog: unterminated structured data value in %q", id)
+			}
+			params[name] = value
+			s = rest
+		}
+		if !strings.HasPrefix(s, "]") {
+			return "", fmt.Errorf("syslog: unterminated structured data element %q", id)
+		}
+		s = s[1:]
+		msg.StructuredData[id] = params
+	}
+	return s, nil
+}
+
+// unquoteParam reads a parameter value up to the closing quote, resolving
+// the \" \\ and \] escapes.
+func unquoteParam(s string) (value, rest string, ok bool) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			if i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\' || s[i+1] == ']') {
+				i++
+				b.WriteByte(s[i])
+				continue
+			}
+			b.WriteByte(c)
+		case '"':
+			return b.String(), s[i+1:], true
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", false
+}
+
+// parse3164 parses the part after "<PRI>":
+//
+//	Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG
+//
+// Devices deviate from this in many ways, so every part is optional.
+func parse3164(msg *Message, s string, now time.Time) {
+	if t, rest, ok := parse3164Timestamp(s, now); ok {
+		msg.Timestamp = t
+		s = rest
+	}
+
+	// A hostname is only present if another word follows that looks like a
+	// tag; otherwise the first word is already the tag.
+	if sp := strings.IndexByte(s, ' '); sp > 0 && !strings.ContainsAny(s[:sp], ":[") {
+		if _, _, _, ok := parseTag(s[sp+1:]); ok {
+			msg.Hostname, s = s[:sp], s[sp+1:]
+		}
+	}
+
+	if tag, pid, rest, ok := parseTag(s); ok {
+		msg.AppName, msg.ProcID, s = tag, pid, rest
+	}
+	msg.Message = s
+}
+
+// parse3164Timestamp parses the "Jan  2 15:04:05" timestamp, which has no
+// year and no zone, and the RFC 3339 timestamps some senders use instead.
+// The year is chosen so that the timestamp is not far in the future.
+func parse3164Timestamp(s string, now time.Time) (time.Time, string, bool) {
+	const layout = "Jan _2 15:04:05"
+	if len(s) > len(layout) && s[len(layout)] == ' ' {
+		if t, err := time.Parse(layout, s[:len(layout)]); err == nil {
+			t = t.AddDate(now.Year(), 0, 0)
+			if t.After(now.AddDate(0, 1, 0)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+			return t, s[len(layout)+1:], true
+		}
+	}
+	if sp := strings.IndexByte(s, ' '); sp > 0 {
+		if t, err := time.Parse(time.RFC3339Nano, s[:sp]); err == nil {
+			return t, s[sp+1:], true
+		}
+	}
+	return time.Time{}, s, false
+}
+
+// parseTag parses "tag: ", "tag[pid]: " and returns the rest of s.
+func parseTag(s string) (tag, pid, rest string, ok bool) {
+	end := strings.IndexAny(s, ":[ ")
+	if end <= 0 || end > 48 {
+		return "", "", "", false
+	}
+	tag, s = s[:end], s[end:]
+	if strings.HasPrefix(s, "[") {
+		rb := strings.IndexByte(s, ']')
+		if rb < 0 {
+			return "", "", "", false
+		}
+		pid, s = s[1:rb], s[rb+1:]
+	}
+	if !strings.HasPrefix(s, ":") {
+		return "", "", "", false
+	}
+	return tag, pid, strings.TrimPrefix(s[1:], " "), true
+}
@@ -0,0 +1,172 @@
+package syslog
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseRFC5424(t *testing.T) {
+	line := `<165>1 2026-01-29T11:41:12.003Z web-1 billing 4242 ID47 [exampleSDID@32473 iut="3" eventSource="App\]lication"][meta sequence="1"] ` + "\ufeff" + `charge failed`
+	msg, err := parse(line, time.Now(), true)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if msg.Facility != 20 || msg.Severity != 5 {
+		t.Fatalf("unexpected priority %d/%d", msg.Facility, msg.Severity)
+	}
+	if msg.Hostname != "web-1" || msg.AppName != "billing" || msg.ProcID != "4242" || msg.MsgID != "ID47" {
+		t.Fatalf("unexpected header %+v", msg)
+	}
+	if want := time.Date(2026, 1, 29, 11, 41, 12, 3000000, time.UTC); !msg.Timestamp.Equal(want) {
+		t.Fatalf("expected %s, got %s", want, msg.Timestamp)
+	}
+	if msg.StructuredData["exampleSDID@32473"]["eventSource"] != "App]lication" || msg.StructuredData["meta"]["sequence"] != "1" {
+		t.Fatalf("unexpected structured data %+v", msg.StructuredData)
+	}
+	if msg.Message != "charge failed" {
+		t.Fatalf("unexpected message %q", msg.Message)
+	}
+
+	msg, err = parse("<14>1 - - - - - -", time.Now(), true)
+	if err != nil || msg.Message != "" || !msg.Timestamp.IsZero() || msg.StructuredData != nil {
+		t.Fatalf("unexpected nil-value message %+v, %v", msg, err)
+	}
+}
+
+func TestParseLogplex(t *testing.T) {
+	msg, err := ParseLogplex([]byte(`<158>1 2026-01-29T11:41:12.500000+00:00 host heroku router - at=error code=H12 desc="Request timeout"`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if msg.AppName != "heroku" || msg.ProcID != "router" || msg.Message != `at=error code=H12 desc="Request timeout"` || msg.StructuredData != nil {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+}
+
+func TestParseRFC3164(t *testing.T) {
+	now := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		line                         string
+		host, app, pid, message      string
+		year                         int
+		facility, severity, hasStamp int
+	}{
+		{"<34>Oct 11 22:14:15 mymachine su[230]: 'su root' failed", "mymachine", "su", "230", "'su root' failed", 2025, 4, 2, 1},
+		{"<13>Jan  1 10:00:00 sshd: session opened", "", "sshd", "", "session opened", 2026, 1, 5, 1},
+		{"<30>router kernel: link down", "router", "kernel", "", "link down", 0, 3, 6, 0},
+		{"plain text without priority", "", "", "", "plain text without priority", 0, 1, 5, 0},
+	}
+	for _, tc := range cases {
+		msg, err := parse(tc.line, now, true)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.line, err)
+		}
+		if msg.Hostname != tc.host || msg.AppName != tc.app || msg.ProcID != tc.pid || msg.Message != tc.message {
+			t.Fatalf("%q: unexpected message %+v", tc.line, msg)
+		}
+		if msg.Facility != tc.facility || msg.Severity != tc.severity {
+			t.Fatalf("%q: unexpected priority %d/%d", tc.line, msg.Facility, msg.Severity)
+		}
+		if (tc.hasStamp == 1) != !msg.Timestamp.IsZero() || tc.hasStamp == 1 && msg.Timestamp.Year() != tc.year {
+			t.Fatalf("%q: unexpected timestamp %s", tc.line, msg.Timestamp)
+		}
+	}
+
+	if _, err := parse("<999>1 - - - - - -", now, true); !errors.Is(err, ErrInvalidPriority) {
+		t.Fatalf("expected invalid priority, got %v", err)
+	}
+}
+
+func TestReadFrameOctetCountingAndNewlines(t *testing.T) {
+	input := "10 <13>hello\n" + "2 <1" + "<13>line one\r\n" + "\n" + "30 " + strings.Repeat("x", 30) + "<13>tail"
+	r := bufio.NewReader(strings.NewReader(input))
+
+	var frames []string
+	var tooLarge int
+	for {
+		frame, err := readFrame(r, 20)
+		if errors.Is(err, errTooLarge) {
+			tooLarge++
+			continue
+		}
+		if err != nil {
+			break
+		}
+		frames = append(frames, string(frame))
+	}
+	want := []string{"<13>hello\n", "<1", "<13>line one", "", "<13>tail"}
+	if strings.Join(frames, "|") != strings.Join(want, "|") || tooLarge != 1 {
+		t.Fatalf("unexpected frames %q (too large: %d)", frames, tooLarge)
+	}
+}
+
+func TestServeStreamAndUDP(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	received := make(chan struct{}, 10)
+	s := &Server{
+		Handle: func(msg *Message, remote net.Addr, transport string) {
+			mu.Lock()
+			got = append(got, transport+":"+msg.Message)
+			mu.Unlock()
+			received <- struct{}{}
+		},
+		Allow: func(ip net.IP) bool { return ip.IsLoopback() },
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen udp: %v", err)
+	}
+	done := make(chan struct{}, 2)
+	go func() { _ = s.ServeStream(ctx, ln, "tcp"); done <- struct{}{} }()
+	go func() { _ = s.ServeUDP(ctx, pc); done <- struct{}{} }()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("21 <13>1 - - - - - - one<13>two\n")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	udp, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("dial udp: %v", err)
+	}
+	defer udp.Close()
+	if _, err := udp.Write([]byte("<13>three")); err != nil {
+		t.Fatalf("write udp: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-received:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out, got %v", got)
+		}
+	}
+	cancel()
+	<-done
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	joined := strings.Join(got, ",")
+	for _, want := range []string{"tcp:one", "tcp:two", "udp:three"} {
+		if !strings.Contains(joined, want) {
+			t.Fatalf("expected %s in %v", want, got)
+		}
+	}
+}
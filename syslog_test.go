@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"http-to-sentry-go/syslog"
+	"http-to-sentry-go/timestamp"
+)
+
+func TestBuildSyslogEvent(t *testing.T) {
+	msg, err := syslog.Parse([]byte(`<163>1 2026-01-29T11:41:12Z web-1 billing 42 - [origin ip="10.0.0.1"] charge failed`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	cfg := config{timestamps: timestamp.Policy{MaxPast: 100 * 365 * 24 * time.Hour}}
+	remote := &net.UDPAddr{IP: net.ParseIP("192.0.2.7"), Port: 514}
+
+	event, err := buildSyslogEvent(cfg, msg, remote, "udp")
+	if err != nil {
+		t.Fatalf("build event: %v", err)
+	}
+	if event.Level != sentry.LevelError || event.Logger != "syslog" || event.Message != "charge failed" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	if event.Tags["facility"] != "local4" || event.Tags["app_name"] != "billing" || event.Tags["hostname"] != "web-1" || event.Tags["transport"] != "udp" {
+		t.Fatalf("unexpected tags %+v", event.Tags)
+	}
+	if sd, ok := event.Extra["structured_data"].(map[string]map[string]string); !ok || sd["origin"]["ip"] != "10.0.0.1" {
+		t.Fatalf("unexpected extra %+v", event.Extra)
+	}
+	if want := time.Date(2026, 1, 29, 11, 41, 12, 0, time.UTC); !event.Timestamp.Equal(want) {
+		t.Fatalf("expected %s, got %s", want, event.Timestamp)
+	}
+}
+
+func TestIPAllowed(t *testing.T) {
+	var networks []*net.IPNet
+	for _, cidr := range []string{"10.0.0.0/8", "192.0.2.7", "2001:db8::/32"} {
+		network, err := parseCIDR(cidr)
+		if err != nil {
+			t.Fatalf("parse %s: %v", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+
+	for ip, want := range map[string]bool{
+		"10.1.2.3":    true,
+		"192.0.2.7":   true,
+		"192.0.2.8":   false,
+		"2001:db8::1": true,
+		"::1":         false,
+	} {
+		if got := ipAllowed(networks, net.ParseIP(ip)); got != want {
+			t.Fatalf("%s: expected %t, got %t", ip, want, got)
+		}
+	}
+	if !ipAllowed(nil, net.ParseIP("203.0.113.1")) {
+		t.Fatalf("expected an empty list to allow every address")
+	}
+	if _, err := parseCIDR("10.0.0.0/33"); err == nil {
+		t.Fatalf("expected invalid CIDR error")
+	}
+}
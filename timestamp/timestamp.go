@@ -0,0 +1,158 @@
+// Package timestamp parses the timestamp formats found in log payloads and
+// bounds them against clock skew.
+package timestamp
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrOutOfRange is returned by Policy.Resolve for timestamps outside the
+// configured bounds when the policy rejects them.
+var ErrOutOfRange = errors.New("timestamp out of range")
+
+// ErrUnknownFormat is returned for values that match no supported format.
+var ErrUnknownFormat = errors.New("unknown timestamp format")
+
+// layouts are tried in order. Fractional seconds are accepted after the
+// seconds field even where a layout does not spell them out.
+var layouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05Z0700",  // Fastly strftime %Y-%m-%dT%H:%M:%S%z
+	"2006-01-02T15:04:05 -0700", // ISO 8601 with separated offset
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05Z0700",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05 MST",
+	"02/Jan/2006:15:04:05 -0700", // Apache/Nginx common log format
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC850,
+	time.RubyDate,
+	time.UnixDate,
+	time.ANSIC,
+}
+
+// localLayouts carry no zone and are interpreted as UTC.
+var localLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+}
+
+// Parse parses RFC 3339, Fastly's +0000 strftime form, common log format,
+// RFC 1123 style dates and epoch seconds, milliseconds, microseconds or
+// nanoseconds.
+func Parse(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+	if value == "" {
+		return time.Time{}, ErrUnknownFormat
+	}
+
+	if t, ok := parseEpoch(value); ok {
+		return t, nil
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	for _, layout := range localLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, ErrUnknownFormat
+}
+
+// parseEpoch interprets a number by magnitude as seconds, milliseconds,
+// microseconds or nanoseconds since the Unix epoch.
+func parseEpoch(value string) (time.Time, bool) {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f <= 0 || math.IsInf(f, 0) || math.IsNaN(f) {
+		return time.Time{}, false
+	}
+	switch {
+	case f < 1e11:
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(math.Round(frac*1e9))).UTC(), true
+	case f < 1e14:
+		return time.UnixMicro(int64(f * 1e3)).UTC(), true
+	case f < 1e17:
+		return time.UnixMicro(int64(f)).UTC(), true
+	case f < 1e20:
+		return time.Unix(0, int64(f)).UTC(), true
+	}
+	return time.Time{}, false
+}
+
+// Policy bounds parsed timestamps relative to the time an event is received.
+type Policy struct {
+	// MaxPast is how far in the past a timestamp may be. Zero disables the
+	// bound.
+	MaxPast time.Duration
+	// MaxFuture is how far in the future a timestamp may be. Zero disables
+	// the bound.
+	MaxFuture time.Duration
+	// Reject makes Resolve fail for out-of-range timestamps instead of
+	// clamping them to the nearest bound.
+	Reject bool
+}
+
+// Resolve parses value and applies the policy. It returns now for an empty
+// value. The returned bool reports whether the timestamp was clamped.
+func (p Policy) Resolve(value string, now time.Time) (time.Time, bool, error) {
+	if strings.TrimSpace(value) == "" {
+		return now, false, nil
+	}
+	t, err := Parse(value)
+	if err != nil {
+		return now, false, err
+	}
+	return p.Apply(t, now)
+}
+
+// Apply applies the policy to an already parsed timestamp.
+func (p Policy) Apply(t, now time.Time) (time.Time, bool, error) {
+	var bound time.Time
+	switch {
+	case p.MaxPast > 0 && t.Before(now.Add(-p.MaxPast)):
+		bound = now.Add(-p.MaxPast)
+	case p.MaxFuture > 0 && t.After(now.Add(p.MaxFuture)):
+		bound = now.Add(p.MaxFuture)
+	default:
+		return t, false, nil
+	}
+	if p.Reject {
+		return now, false, ErrOutOfRange
+	}
+	return bound, true, nil
+}
+
+// Value is a timestamp as it appears in JSON, either a string or a number.
+type Value string
+
+func (v *Value) UnmarshalJSON(data []byte) error {
+	s := strings.TrimSpace(string(data))
+	if s == "null" {
+		*v = ""
+		return nil
+	}
+	if strings.HasPrefix(s, `"`) {
+		var str string
+		if err := json.Unmarshal(data, &str); err != nil {
+			return err
+		}
+		*v = Value(str)
+		return nil
+	}
+	if _, err := strconv.ParseFloat(s, 64); err != nil {
+		return errors.New("timestamp must be a string or a number")
+	}
+	*v = Value(s)
+	return nil
+}
@@ -0,0 +1,77 @@
+package timestamp
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseFormats(t *testing.T) {
+	want := time.Date(2026, 1, 29, 11, 41, 12, 0, time.UTC)
+	cases := []string{
+		"2026-01-29T11:41:12Z",
+		"2026-01-29T11:41:12+00:00",
+		"2026-01-29T11:41:12+0000",
+		"2026-01-29T12:41:12+0100",
+		"2026-01-29 11:41:12",
+		"29/Jan/2026:11:41:12 +0000",
+		"[29/Jan/2026:11:41:12 +0000]",
+		"Thu, 29 Jan 2026 11:41:12 GMT",
+		"1769686872",
+		"1769686872000",
+		"1769686872000000",
+		"1769686872000000000",
+	}
+	for _, value := range cases {
+		got, err := Parse(value)
+		if err != nil {
+			t.Fatalf("parse %q: %v", value, err)
+		}
+		if !got.Equal(want) {
+			t.Fatalf("parse %q: expected %s, got %s", value, want, got)
+		}
+	}
+
+	got, err := Parse("1769686872.250")
+	if err != nil || !got.Equal(want.Add(250*time.Millisecond)) {
+		t.Fatalf("fractional epoch: got %s, %v", got, err)
+	}
+	if _, err := Parse("yesterday"); !errors.Is(err, ErrUnknownFormat) {
+		t.Fatalf("expected ErrUnknownFormat, got %v", err)
+	}
+}
+
+func TestPolicyClampsAndRejects(t *testing.T) {
+	now := time.Date(2026, 1, 29, 12, 0, 0, 0, time.UTC)
+	policy := Policy{MaxPast: time.Hour, MaxFuture: time.Minute}
+
+	got, clamped, err := policy.Resolve("2026-01-29T14:00:00Z", now)
+	if err != nil || !clamped || !got.Equal(now.Add(time.Minute)) {
+		t.Fatalf("expected clamp to max future, got %s %t %v", got, clamped, err)
+	}
+	got, clamped, err = policy.Resolve("2026-01-29T11:30:00Z", now)
+	if err != nil || clamped || !got.Equal(now.Add(-30*time.Minute)) {
+		t.Fatalf("expected timestamp in range, got %s %t %v", got, clamped, err)
+	}
+
+	policy.Reject = true
+	if _, _, err := policy.Resolve("2020-01-01T00:00:00Z", now); !errors.Is(err, ErrOutOfRange) {
+		t.Fatalf("expected ErrOutOfRange, got %v", err)
+	}
+}
+
+func TestValueAcceptsNumbers(t *testing.T) {
+	var v struct {
+		Timestamp Value `json:"timestamp"`
+	}
+	if err := json.Unmarshal([]byte(`{"timestamp":1769686872}`), &v); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if v.Timestamp != "1769686872" {
+		t.Fatalf("unexpected value %q", v.Timestamp)
+	}
+	if err := json.Unmarshal([]byte(`{"timestamp":true}`), &v); err == nil {
+		t.Fatalf("expected error for boolean timestamp")
+	}
+}
@@ -0,0 +1,235 @@
+// Package tokens implements the store of scoped API tokens. Each token has
+// a name identifying its sender, the routes it may use, defaults stamped
+// onto its events, an optional Sentry DSN and an events-per-minute quota.
+package tokens
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// Token is one entry of the token file.
+type Token struct {
+	// Name identifies the sender in tags, logs and metrics.
+	Name  string `json:"name"`
+	Token string `json:"token"`
+	// Routes are the names of the routes the token may use. Empty allows
+	// every route.
+	Routes []string `json:"routes"`
+	// Tags are added to the token's events unless the event sets them.
+	Tags map[string]string `json:"tags"`
+	// Environment is the environment of events that do not set one.
+	Environment string `json:"environment"`
+	// SentryDSN, if set, sends the token's events to this project instead
+	// of the route's.
+	SentryDSN string `json:"sentry_dsn"`
+	// EventsPerMinute limits the events accepted with the token. Zero is
+	// unlimited.
+	EventsPerMinute int `json:"events_per_minute"`
+
+	quota *quota
+}
+
+// quota counts a token's events in fixed one minute windows.
+type quota struct {
+	limit int
+
+	mu     sync.Mutex
+	window time.Time
+	used   int
+}
+
+// Store holds the tokens of a token file.
+type Store struct {
+	tokens []*Token
+}
+
+// Load reads a token file: {"tokens": [...]}.
+func Load(filename string) (*Store, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Tokens []Token `json:"tokens"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	store, err := New(file.Tokens)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return store, nil
+}
+
+// New validates tokens and returns a store holding them.
+func New(tokens []Token) (*Store, error) {
+	s := &Store{}
+	names := map[string]bool{}
+	secrets := map[string]bool{}
+	var errs []error
+	for i := range tokens {
+		t := tokens[i]
+		switch {
+		case !validName(t.Name):
+			errs = append(errs, fmt.Errorf("token %d: name %q may only contain letters, digits, '-', '_' and '.'", i, t.Name))
+		case names[t.Name]:
+			errs = append(errs, fmt.Errorf("token %d: duplicate name %q", i, t.Name))
+		}
+		names[t.Name] = true
+		switch {
+		case t.Token == "":
+			errs = append(errs, fmt.Errorf("token %q: token is required", t.Name))
+		case secrets[t.Token]:
+			errs = append(errs, fmt.Errorf("token %q: token is used by another entry", t.Name))
+		}
+		secrets[t.Token] = true
+		if t.EventsPerMinute < 0 {
+			errs = append(errs, fmt.Errorf("token %q: events_per_minute must not be negative", t.Name))
+		}
+		if t.SentryDSN != "" {
+			if _, err := sentry.NewDsn(t.SentryDSN); err != nil {
+				errs = append(errs, fmt.Errorf("token %q: sentry_dsn: %w", t.Name, err))
+			}
+		}
+		if t.EventsPerMinute > 0 {
+			t.quota = &quota{limit: t.EventsPerMinute}
+		}
+		s.tokens = append(s.tokens, &t)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return s, nil
+}
+
+// Tokens returns the tokens in file order.
+func (s *Store) Tokens() []*Token {
+	if s == nil {
+		return nil
+	}
+	return s.tokens
+}
+
+// Lookup returns the token whose secret is credential. Every token is
+// compared in constant time.
+func (s *Store) Lookup(credential string) *Token {
+	if s == nil || credential == "" {
+		return nil
+	}
+	var found *Token
+	for _, t := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(credential), []byte(t.Token)) == 1 {
+			found = t
+		}
+	}
+	return found
+}
+
+// KeepQuotas carries the quota windows of old over to the tokens of s with
+// the same name and limit, so a reload does not reset quotas.
+func (s *Store) KeepQuotas(old *Store) {
+	if s == nil || old == nil {
+		return
+	}
+	for _, t := range s.tokens {
+		for _, o := range old.tokens {
+			if o.Name == t.Name && o.quota != nil && t.quota != nil && o.quota.limit == t.quota.limit {
+				t.quota = o.quota
+			}
+		}
+	}
+}
+
+// Allows reports whether the token may use the route.
+func (t *Token) Allows(route string) bool {
+	if len(t.Routes) == 0 {
+		return true
+	}
+	for _, r := range t.Routes {
+		if r == route {
+			return true
+		}
+	}
+	return false
+}
+
+// Take consumes one event of the token's quota. It reports false when the
+// quota of the current minute is used up.
+func (t *Token) Take(now time.Time) bool {
+	q := t.quota
+	if q == nil {
+		return true
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.roll(now)
+	if q.used >= q.limit {
+		return false
+	}
+	q.used++
+	return true
+}
+
+// Exhausted reports whether the quota of the current minute is used up and
+// returns the time until it resets.
+func (t *Token) Exhausted(now time.Time) (bool, time.Duration) {
+	q := t.quota
+	if q == nil {
+		return false, 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.roll(now)
+	if q.used < q.limit {
+		return false, 0
+	}
+	return true, q.window.Add(time.Minute).Sub(now)
+}
+
+func (q *quota) roll(now time.Time) {
+	if window := now.Truncate(time.Minute); !window.Equal(q.window) {
+		q.window = window
+		q.used = 0
+	}
+}
+
+// Apply stamps the token's name, tags and environment onto event. Values
+// the event already has are kept.
+func (t *Token) Apply(event *sentry.Event) {
+	if event.Tags == nil {
+		event.Tags = map[string]string{}
+	}
+	for key, value := range t.Tags {
+		if _, ok := event.Tags[key]; !ok {
+			event.Tags[key] = value
+		}
+	}
+	event.Tags["token"] = t.Name
+	if event.Environment == "" {
+		event.Environment = t.Environment
+	}
+}
+
+func validName(name string) bool {
+	if name == "" || name == "." || name == ".." {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,121 @@
+package tokens
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+func TestLoadValidatesTokens(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		name := filepath.Join(dir, "tokens.json")
+		if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+
+	store, err := Load(write(`{"tokens":[{"name":"ci","token":"a","routes":["ingest"],"tags":{"team":"build"},"environment":"staging","events_per_minute":10}]}`))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := store.Tokens(); len(got) != 1 || got[0].Name != "ci" || got[0].Tags["team"] != "build" {
+		t.Fatalf("tokens = %+v", got)
+	}
+
+	for name, content := range map[string]string{
+		"unknown field":  `{"tokens":[{"name":"ci","token":"a","scope":"all"}]}`,
+		"bad name":       `{"tokens":[{"name":"../ci","token":"a"}]}`,
+		"duplicate name": `{"tokens":[{"name":"ci","token":"a"},{"name":"ci","token":"b"}]}`,
+		"reused token":   `{"tokens":[{"name":"ci","token":"a"},{"name":"cd","token":"a"}]}`,
+		"no token":       `{"tokens":[{"name":"ci"}]}`,
+		"negative quota": `{"tokens":[{"name":"ci","token":"a","events_per_minute":-1}]}`,
+		"bad dsn":        `{"tokens":[{"name":"ci","token":"a","sentry_dsn":"not a dsn"}]}`,
+	} {
+		if _, err := Load(write(content)); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+func TestLookupAndAllows(t *testing.T) {
+	store, err := New([]Token{
+		{Name: "ci", Token: "secret-ci", Routes: []string{"ingest", "otlp"}},
+		{Name: "ops", Token: "secret-ops"},
+	})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	if tok := store.Lookup("secret-ci"); tok == nil || tok.Name != "ci" {
+		t.Fatalf("lookup ci = %+v", tok)
+	}
+	if tok := store.Lookup("secret"); tok != nil {
+		t.Fatalf("prefix matched %q", tok.Name)
+	}
+	if tok := store.Lookup(""); tok != nil {
+		t.Fatalf("empty credential matched %q", tok.Name)
+	}
+	ci, ops := store.Tokens()[0], store.Tokens()[1]
+	if !ci.Allows("otlp") || ci.Allows("loki") || !ops.Allows("loki") {
+		t.Fatalf("allows: ci otlp=%t loki=%t, ops loki=%t", ci.Allows("otlp"), ci.Allows("loki"), ops.Allows("loki"))
+	}
+	var nilStore *Store
+	if nilStore.Lookup("secret-ci") != nil || len(nilStore.Tokens()) != 0 {
+		t.Fatalf("nil store should hold no tokens")
+	}
+}
+
+func TestQuotaWindowSurvivesReload(t *testing.T) {
+	now := time.Date(2026, 3, 2, 10, 15, 20, 0, time.UTC)
+	store, err := New([]Token{{Name: "ci", Token: "a", EventsPerMinute: 2}})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	tok := store.Tokens()[0]
+	if !tok.Take(now) || !tok.Take(now) {
+		t.Fatalf("quota should allow 2 events")
+	}
+	if tok.Take(now) {
+		t.Fatalf("third event should exceed the quota")
+	}
+	if exhausted, reset := tok.Exhausted(now); !exhausted || reset != 40*time.Second {
+		t.Fatalf("exhausted = %t, reset = %s", exhausted, reset)
+	}
+
+	reloaded, err := New([]Token{{Name: "ci", Token: "b", EventsPerMinute: 2}})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	reloaded.KeepQuotas(store)
+	if reloaded.Tokens()[0].Take(now) {
+		t.Fatalf("reload should keep the used quota")
+	}
+	if !reloaded.Tokens()[0].Take(now.Add(40 * time.Second)) {
+		t.Fatalf("quota should reset in the next minute")
+	}
+
+	unlimited := &Token{Name: "ops"}
+	if !unlimited.Take(now) {
+		t.Fatalf("token without quota should be unlimited")
+	}
+}
+
+func TestApplyKeepsEventValues(t *testing.T) {
+	tok := &Token{Name: "ci", Tags: map[string]string{"team": "build", "service": "runner"}, Environment: "staging"}
+
+	event := &sentry.Event{Tags: map[string]string{"service": "api"}}
+	tok.Apply(event)
+	if event.Tags["token"] != "ci" || event.Tags["team"] != "build" || event.Tags["service"] != "api" || event.Environment != "staging" {
+		t.Fatalf("event = %v %q", event.Tags, event.Environment)
+	}
+
+	event = &sentry.Event{Environment: "production"}
+	tok.Apply(event)
+	if event.Environment != "production" || event.Tags["service"] != "runner" {
+		t.Fatalf("event = %v %q", event.Tags, event.Environment)
+	}
+}
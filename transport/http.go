@@ -0,0 +1,169 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"http-to-sentry-go/internal/ingest"
+	"http-to-sentry-go/metrics"
+)
+
+const defaultQueueSize = 1000
+
+var errQueueFull = errors.New("sentry transport: send queue is full")
+
+// HTTP is an asynchronous sentry.Transport like the SDK's HTTPTransport, but
+// it exposes Sentry rate limits and queue saturation through Backpressure so
+// endpoints can answer 429/503 instead of acknowledging dropped events.
+type HTTP struct {
+	// QueueSize bounds the number of events waiting to be sent.
+	QueueSize int
+	// Timeout is the HTTP request timeout. Defaults to 30 seconds.
+	Timeout time.Duration
+
+	sender  *Sender
+	queue   chan []byte
+	pending sync.WaitGroup
+	done    chan struct{}
+	once    sync.Once
+
+	outcomes
+}
+
+func NewHTTP(queueSize int) *HTTP {
+	return &HTTP{QueueSize: queueSize}
+}
+
+func (t *HTTP) Configure(options sentry.ClientOptions) {
+	if t.QueueSize <= 0 {
+		t.QueueSize = defaultQueueSize
+	}
+	t.queue = make(chan []byte, t.QueueSize)
+	t.done = make(chan struct{})
+
+	if options.Dsn == "" {
+		return
+	}
+	sender, err := NewSender(options.Dsn, t.Timeout)
+	if err != nil {
+		log.Printf("sentry transport: %v", err)
+		return
+	}
+	t.sender = sender
+	go t.worker()
+}
+
+// SendEvent queues event for sending. Events of a category Sentry rate
+// limits and events the full queue cannot take are dropped and reported by
+// Rejected.
+func (t *HTTP) SendEvent(event *sentry.Event) {
+	if t.sender == nil {
+		countEvent(event, metrics.NoDSN)
+		return
+	}
+	if wait := t.sender.Limits().RetryAfter(category(event.Type), time.Now()); wait > 0 {
+		countEvent(event, metrics.RateLimited)
+		t.reject(event, &ingest.Error{
+			Status:     http.StatusTooManyRequests,
+			RetryAfter: wait,
+			Err:        &RateLimitedError{Wait: wait},
+		})
+		return
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("sentry transport: encode event %s: %v", event.EventID, err)
+		countEvent(event, metrics.Failed)
+		return
+	}
+
+	t.pending.Add(1)
+	select {
+	case t.queue <- body:
+		metrics.QueueDepth.Inc()
+		countEvent(event, metrics.Captured)
+	default:
+		t.pending.Done()
+		countEvent(event, metrics.QueueFull)
+		t.reject(event, &ingest.Error{
+			Status:     http.StatusServiceUnavailable,
+			RetryAfter: time.Second,
+			Err:        errQueueFull,
+		})
+	}
+}
+
+// Backpressure returns 429 while Sentry rate limits events and 503 while the
+// send queue is full.
+func (t *HTTP) Backpressure() (int, time.Duration) {
+	if t.sender == nil {
+		return 0, 0
+	}
+	if wait := t.sender.Limits().RetryAfter(CategoryError, time.Now()); wait > 0 {
+		return http.StatusTooManyRequests, wait
+	}
+	if len(t.queue) >= cap(t.queue) {
+		return http.StatusServiceUnavailable, time.Second
+	}
+	return 0, 0
+}
+
+func (t *HTTP) Flush(timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return t.FlushWithContext(ctx)
+}
+
+func (t *HTTP) FlushWithContext(ctx context.Context) bool {
+	flushed := make(chan struct{})
+	go func() {
+		t.pending.Wait()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (t *HTTP) Close() {
+	t.once.Do(func() {
+		if t.done != nil {
+			close(t.done)
+		}
+	})
+}
+
+func (t *HTTP) worker() {
+	for {
+		select {
+		case <-t.done:
+			return
+		case body := <-t.queue:
+			metrics.QueueDepth.Dec()
+			t.send(body)
+			t.pending.Done()
+		}
+	}
+}
+
+func (t *HTTP) send(body []byte) {
+	err := t.sender.Send(context.Background(), body)
+	var limited *RateLimitedError
+	switch {
+	case err == nil:
+	case errors.As(err, &limited):
+		log.Printf("sentry transport: dropping event: %v", err)
+	default:
+		log.Printf("sentry transport: send failed: %v", err)
+	}
+}
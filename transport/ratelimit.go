@@ -0,0 +1,124 @@
+package transport
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// CategoryError is the rate limit category of error and message events.
+	CategoryError = "error"
+	// CategoryTransaction and CategoryMonitor are the categories of
+	// transactions and cron check-ins.
+	CategoryTransaction = "transaction"
+	CategoryMonitor     = "monitor"
+	// categoryAll is the empty category, which limits every category.
+	categoryAll = ""
+
+	defaultRetryAfter = 60 * time.Second
+)
+
+// category returns the rate limit category of events of type typ.
+func category(typ string) string {
+	switch typ {
+	case "transaction":
+		return CategoryTransaction
+	case "check_in":
+		return CategoryMonitor
+	}
+	return CategoryError
+}
+
+// Limits tracks the rate limit windows Sentry announced per data category.
+type Limits struct {
+	mu        sync.Mutex
+	deadlines map[string]time.Time
+}
+
+func NewLimits() *Limits {
+	return &Limits{deadlines: map[string]time.Time{}}
+}
+
+// Update records the limits announced by a Sentry response. The
+// X-Sentry-Rate-Limits header takes precedence over Retry-After, which only
+// applies to 429 responses.
+func (l *Limits) Update(status int, header http.Header, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if value := header.Get("X-Sentry-Rate-Limits"); value != "" {
+		for _, limit := range strings.Split(value, ",") {
+			fields := strings.Split(strings.TrimSpace(limit), ":")
+			seconds, err := strconv.ParseFloat(fields[0], 64)
+			if err != nil || seconds < 0 {
+				seconds = defaultRetryAfter.Seconds()
+			}
+			deadline := now.Add(time.Duration(math.Ceil(seconds)) * time.Second)
+
+			categories := []string{categoryAll}
+			if len(fields) > 1 && fields[1] != "" {
+				categories = strings.Split(fields[1], ";")
+			}
+			for _, category := range categories {
+				l.extend(category, deadline)
+			}
+		}
+		return
+	}
+
+	if status == http.StatusTooManyRequests {
+		l.extend(categoryAll, now.Add(parseRetryAfter(header.Get("Retry-After"), now)))
+	}
+}
+
+// RetryAfter returns how long events of category are still rate limited, or
+// zero if they may be sent.
+func (l *Limits) RetryAfter(category string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	deadline := l.deadlines[category]
+	if all := l.deadlines[categoryAll]; all.After(deadline) {
+		deadline = all
+	}
+	if !deadline.After(now) {
+		return 0
+	}
+	return deadline.Sub(now)
+}
+
+func (l *Limits) extend(category string, deadline time.Time) {
+	if deadline.After(l.deadlines[category]) {
+		l.deadlines[category] = deadline
+	}
+}
+
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return defaultRetryAfter
+}
+
+// RateLimitedError is returned by Sender.Send while Sentry rate limits
+// events.
+type RateLimitedError struct {
+	Wait time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return "sentry rate limited for " + e.Wait.String()
+}
+
+// RetryAfter lets spool.Run wait out the rate limit instead of backing off.
+func (e *RateLimitedError) RetryAfter() time.Duration {
+	return e.Wait
+}
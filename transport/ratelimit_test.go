@@ -0,0 +1,42 @@
+package transport
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLimitsParsesSentryHeader(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	header := http.Header{}
+	header.Set("X-Sentry-Rate-Limits", "60:error;transaction:key, 2700:default;attachment:organization")
+
+	l := NewLimits()
+	l.Update(http.StatusOK, header, now)
+
+	if got := l.RetryAfter(CategoryError, now); got != 60*time.Second {
+		t.Fatalf("expected 60s for error, got %s", got)
+	}
+	if got := l.RetryAfter("attachment", now); got != 2700*time.Second {
+		t.Fatalf("expected 2700s for attachment, got %s", got)
+	}
+	if got := l.RetryAfter("log_item", now); got != 0 {
+		t.Fatalf("expected log_item not to be limited, got %s", got)
+	}
+}
+
+func TestLimitsFallsBackToRetryAfter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	header := http.Header{}
+	header.Set("Retry-After", "30")
+
+	l := NewLimits()
+	l.Update(http.StatusTooManyRequests, header, now)
+
+	if got := l.RetryAfter(CategoryError, now); got != 30*time.Second {
+		t.Fatalf("expected 30s, got %s", got)
+	}
+	if got := l.RetryAfter(CategoryError, now.Add(31*time.Second)); got != 0 {
+		t.Fatalf("expected limit to expire, got %s", got)
+	}
+}
@@ -0,0 +1,225 @@
+// Package transport delivers events to Sentry and reports the outcome to the
+// caller, which the SDK transports do not.
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"http-to-sentry-go/internal/ingest"
+	"http-to-sentry-go/metrics"
+	"http-to-sentry-go/spool"
+)
+
+const (
+	defaultTimeout = 30 * time.Second
+	userAgent      = "http-to-sentry-go"
+)
+
+// Transport is a sentry.Transport that can ask ingest endpoints to push back
+// on their senders.
+type Transport interface {
+	sentry.Transport
+	// Backpressure returns the HTTP status and retry delay to answer new
+	// requests with, or a zero status while events are accepted.
+	Backpressure() (int, time.Duration)
+	// Rejected returns why the event with id was not accepted, once, or nil
+	// if it was. The SDK calls SendEvent on the capturing goroutine, so the
+	// outcome is known when CaptureEvent returns.
+	Rejected(id sentry.EventID) error
+}
+
+// outcomes keeps the errors of events a transport did not accept until the
+// capturer collects them with Rejected.
+type outcomes struct {
+	errs sync.Map
+}
+
+func (o *outcomes) reject(event *sentry.Event, err error) {
+	o.errs.Store(event.EventID, err)
+}
+
+func (o *outcomes) Rejected(id sentry.EventID) error {
+	if err, ok := o.errs.LoadAndDelete(id); ok {
+		return err.(error)
+	}
+	return nil
+}
+
+// Sender posts serialized events to the Sentry envelope endpoint.
+type Sender struct {
+	dsn    *sentry.Dsn
+	client *http.Client
+	limits *Limits
+}
+
+func NewSender(dsn string, timeout time.Duration) (*Sender, error) {
+	parsed, err := sentry.NewDsn(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return &Sender{
+		dsn:    parsed,
+		client: &http.Client{Timeout: timeout},
+		limits: NewLimits(),
+	}, nil
+}
+
+// Limits returns the rate limits Sentry announced to this sender.
+func (s *Sender) Limits() *Limits {
+	return s.limits
+}
+
+// Send delivers one JSON-encoded event. While Sentry rate limits the
+// event's category it returns a *RateLimitedError without sending. Responses
+// that will never succeed on retry are reported as spool.ErrRejected.
+func (s *Sender) Send(ctx context.Context, event []byte) error {
+	var meta eventMeta
+	if err := json.Unmarshal(event, &meta); err != nil {
+		metrics.SendFailures.WithLabelValues("rejected").Inc()
+		return fmt.Errorf("%w: %v", spool.ErrRejected, err)
+	}
+	cat := category(meta.Type)
+	if wait := s.limits.RetryAfter(cat, time.Now()); wait > 0 {
+		metrics.SendFailures.WithLabelValues("rate_limited").Inc()
+		return &RateLimitedError{Wait: wait}
+	}
+
+	envelope, err := buildEnvelope(meta, event)
+	if err != nil {
+		metrics.SendFailures.WithLabelValues("rejected").Inc()
+		return fmt.Errorf("%w: %v", spool.ErrRejected, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.dsn.GetAPIURL().String(), envelope)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("User-Agent", userAgent+"/"+sentry.SDKVersion)
+	auth := "Sentry sentry_version=7, sentry_client=" + userAgent + "/" + sentry.SDKVersion + ", sentry_key=" + s.dsn.GetPublicKey()
+	if secret := s.dsn.GetSecretKey(); secret != "" {
+		auth += ", sentry_secret=" + secret
+	}
+	req.Header.Set("X-Sentry-Auth", auth)
+
+	start := time.Now()
+	resp, err := s.client.Do(req)
+	if err != nil {
+		metrics.SendFailures.WithLabelValues("network").Inc()
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	now := time.Now()
+	metrics.SendDuration.Observe(now.Sub(start).Seconds())
+	s.limits.Update(resp.StatusCode, resp.Header, now)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests:
+		metrics.SendFailures.WithLabelValues("rate_limited").Inc()
+		return &RateLimitedError{Wait: s.limits.RetryAfter(cat, now)}
+	case resp.StatusCode >= 500:
+		metrics.SendFailures.WithLabelValues("server_error").Inc()
+		return fmt.Errorf("sentry responded %d", resp.StatusCode)
+	default:
+		metrics.SendFailures.WithLabelValues("rejected").Inc()
+		return fmt.Errorf("%w: sentry responded %d", spool.ErrRejected, resp.StatusCode)
+	}
+}
+
+// countEvent records the outcome of an event handed to a transport.
+func countEvent(event *sentry.Event, outcome string) {
+	metrics.Events.WithLabelValues(event.Logger, string(event.Level), outcome).Inc()
+}
+
+// eventMeta holds the fields of an encoded event its envelope needs.
+type eventMeta struct {
+	EventID string `json:"event_id"`
+	Type    string `json:"type"`
+}
+
+func buildEnvelope(meta eventMeta, event []byte) (*bytes.Buffer, error) {
+	itemType := meta.Type
+	if itemType == "" {
+		itemType = "event"
+	}
+
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	if err := enc.Encode(map[string]interface{}{
+		"event_id": meta.EventID,
+		"sent_at":  time.Now().UTC(),
+	}); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(map[string]interface{}{
+		"type":   itemType,
+		"length": len(event),
+	}); err != nil {
+		return nil, err
+	}
+	b.Write(event)
+	b.WriteByte('\n')
+	return &b, nil
+}
+
+// Spooled is a sentry.Transport that appends events to a spool instead of
+// sending them. Spool.Run with a Sender drains the spool.
+type Spooled struct {
+	Spool *spool.Spool
+
+	outcomes
+}
+
+func (t *Spooled) Configure(sentry.ClientOptions) {}
+
+func (t *Spooled) SendEvent(event *sentry.Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("spool: encode event %s: %v", event.EventID, err)
+		countEvent(event, metrics.Failed)
+		return
+	}
+	if err := t.Spool.Append(body); err != nil {
+		log.Printf("spool: append event %s: %v", event.EventID, err)
+		countEvent(event, metrics.Failed)
+		t.reject(event, &ingest.Error{
+			Status:     http.StatusServiceUnavailable,
+			RetryAfter: 5 * time.Second,
+			Err:        fmt.Errorf("spool: %w", err),
+		})
+		return
+	}
+	countEvent(event, metrics.Captured)
+}
+
+// Backpressure rejects requests while the spool cannot persist events. Sentry
+// rate limits are absorbed by the spool.
+func (t *Spooled) Backpressure() (int, time.Duration) {
+	if t.Spool.Err() != nil {
+		return http.StatusServiceUnavailable, 5 * time.Second
+	}
+	return 0, 0
+}
+
+// Flush reports success immediately: spooled events are already on disk.
+func (t *Spooled) Flush(time.Duration) bool { return true }
+
+func (t *Spooled) FlushWithContext(context.Context) bool { return true }
+
+func (t *Spooled) Close() {}
@@ -0,0 +1,173 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"http-to-sentry-go/internal/ingest"
+	"http-to-sentry-go/spool"
+)
+
+func TestSenderPostsEnvelope(t *testing.T) {
+	var body string
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		auth = r.Header.Get("X-Sentry-Auth")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	sender, err := NewSender(strings.Replace(srv.URL, "http://", "http://public@", 1)+"/42", 0)
+	if err != nil {
+		t.Fatalf("new sender: %v", err)
+	}
+	if err := sender.Send(context.Background(), []byte(`{"event_id":"abc","message":"hello"}`)); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 envelope lines, got %q", body)
+	}
+	if !strings.Contains(lines[1], `"type":"event"`) || lines[2] != `{"event_id":"abc","message":"hello"}` {
+		t.Fatalf("unexpected envelope: %q", body)
+	}
+	if !strings.Contains(auth, "sentry_key=public") {
+		t.Fatalf("unexpected auth header: %q", auth)
+	}
+}
+
+func TestSenderRejectsClientErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	sender, err := NewSender(strings.Replace(srv.URL, "http://", "http://public@", 1)+"/42", 0)
+	if err != nil {
+		t.Fatalf("new sender: %v", err)
+	}
+	err = sender.Send(context.Background(), []byte(`{"event_id":"abc"}`))
+	if !errors.Is(err, spool.ErrRejected) {
+		t.Fatalf("expected ErrRejected, got %v", err)
+	}
+}
+
+func TestSenderHonorsRateLimits(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("X-Sentry-Rate-Limits", "120:error:key")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	sender, err := NewSender(strings.Replace(srv.URL, "http://", "http://public@", 1)+"/42", 0)
+	if err != nil {
+		t.Fatalf("new sender: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		err = sender.Send(context.Background(), []byte(`{"event_id":"abc"}`))
+		var limited *RateLimitedError
+		if !errors.As(err, &limited) || limited.RetryAfter() <= 0 {
+			t.Fatalf("expected RateLimitedError, got %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected sender to stop calling sentry while limited, got %d calls", calls)
+	}
+}
+
+func TestSpooledRejectsEventsItCannotAppend(t *testing.T) {
+	sp, err := spool.Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("open spool: %v", err)
+	}
+	tr := &Spooled{Spool: sp}
+
+	event := &sentry.Event{EventID: "a", Message: "kept"}
+	tr.SendEvent(event)
+	if err := tr.Rejected(event.EventID); err != nil {
+		t.Fatalf("expected event to be spooled, got %v", err)
+	}
+
+	_ = sp.Close()
+	event = &sentry.Event{EventID: "b", Message: "lost"}
+	tr.SendEvent(event)
+	err = tr.Rejected(event.EventID)
+	var rejected *ingest.Error
+	if !errors.As(err, &rejected) || rejected.Status != http.StatusServiceUnavailable || rejected.RetryAfter <= 0 {
+		t.Fatalf("expected a 503 with a retry delay, got %v", err)
+	}
+	if !errors.Is(err, spool.ErrClosed) {
+		t.Fatalf("expected the append error to be wrapped, got %v", err)
+	}
+	if err := tr.Rejected(event.EventID); err != nil {
+		t.Fatalf("expected the outcome to be reported once, got %v", err)
+	}
+}
+
+func TestHTTPRejectsRateLimitedCategories(t *testing.T) {
+	sender, err := NewSender("https://public@o0.ingest.sentry.io/42", 0)
+	if err != nil {
+		t.Fatalf("new sender: %v", err)
+	}
+	sender.Limits().Update(http.StatusOK, http.Header{"X-Sentry-Rate-Limits": {"60:transaction:key"}}, time.Now())
+	tr := &HTTP{sender: sender, queue: make(chan []byte, 1)}
+
+	transaction := &sentry.Event{EventID: "a", Type: "transaction"}
+	tr.SendEvent(transaction)
+	var rejected *ingest.Error
+	if err := tr.Rejected(transaction.EventID); !errors.As(err, &rejected) || rejected.Status != http.StatusTooManyRequests || rejected.RetryAfter <= 0 {
+		t.Fatalf("expected the transaction to be rate limited, got %v", err)
+	}
+
+	event := &sentry.Event{EventID: "b", Message: "error"}
+	tr.SendEvent(event)
+	if err := tr.Rejected(event.EventID); err != nil {
+		t.Fatalf("expected the error event to be queued, got %v", err)
+	}
+
+	event = &sentry.Event{EventID: "c", Message: "error"}
+	tr.SendEvent(event)
+	if err := tr.Rejected(event.EventID); !errors.As(err, &rejected) || rejected.Status != http.StatusServiceUnavailable {
+		t.Fatalf("expected the full queue to refuse the event, got %v", err)
+	}
+}
+
+func TestSenderChecksEventCategory(t *testing.T) {
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	sender, err := NewSender(strings.Replace(srv.URL, "http://", "http://public@", 1)+"/42", 0)
+	if err != nil {
+		t.Fatalf("new sender: %v", err)
+	}
+	sender.Limits().Update(http.StatusOK, http.Header{"X-Sentry-Rate-Limits": {"60:error:key"}}, time.Now())
+
+	var limited *RateLimitedError
+	if err := sender.Send(context.Background(), []byte(`{"event_id":"abc"}`)); !errors.As(err, &limited) {
+		t.Fatalf("expected the error event to be rate limited, got %v", err)
+	}
+	if err := sender.Send(context.Background(), []byte(`{"event_id":"def","type":"transaction"}`)); err != nil {
+		t.Fatalf("send transaction: %v", err)
+	}
+	if !strings.Contains(body, `"type":"transaction"`) {
+		t.Fatalf("expected a transaction item, got %q", body)
+	}
+}